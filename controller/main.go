@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	appsv1 "k8s.io/api/apps/v1"
@@ -27,7 +28,15 @@ type controller struct {
 	queue                 workqueue.RateLimitingInterface
 }
 
+var (
+	provisionerName = flag.String("provisioner", "nfs-test-storage", "nfs provisioner名称,与StorageClass.provisioner一致")
+	nfsRoot         = flag.String("nfs-root", "", "NFS导出目录在本机的挂载点,为空时不启动nfs provisioner")
+	nfsServer       = flag.String("nfs-server", "", "写入PV的NFS服务器地址")
+	nfsPath         = flag.String("nfs-path", "", "NFS服务器上导出目录的路径,默认与nfs-root相同")
+)
+
 func main() {
+	flag.Parse()
 	clientset := initClient()
 
 	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
@@ -37,6 +46,18 @@ func main() {
 	ctx := context.Background()
 	c := newController(clientset, deploymentInformer)
 
+	if *nfsRoot != "" {
+		exportPath := *nfsPath
+		if exportPath == "" {
+			exportPath = *nfsRoot
+		}
+		p := newNFSProvisioner(clientset, *provisionerName, *nfsRoot, *nfsServer, exportPath,
+			factory.Core().V1().PersistentVolumeClaims(),
+			factory.Core().V1().PersistentVolumes(),
+			factory.Storage().V1().StorageClasses())
+		go p.run(ctx)
+	}
+
 	factory.Start(ctx.Done())
 
 	c.run(ctx)
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+)
+
+func newTestDeployment(ns, name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "service_test_pod"}},
+			},
+		},
+	}
+}
+
+/*
+   使用fake clientset构造controller,cached中的Deployment直接写入informer缓存
+*/
+func newTestController(t *testing.T, cached []*appsv1.Deployment, objects ...runtime.Object) (*controller, *fake.Clientset) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	deploymentInformer := factory.Apps().V1().Deployments()
+	c := newController(clientset, deploymentInformer)
+	for _, dep := range cached {
+		if err := deploymentInformer.Informer().GetIndexer().Add(dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, clientset
+}
+
+func TestSyncDeployment(t *testing.T) {
+	tests := []struct {
+		name        string
+		cached      []*appsv1.Deployment
+		objects     []runtime.Object
+		reactor     k8stesting.ReactionFunc
+		ns          string
+		wantService bool
+	}{
+		{
+			name:        "create service",
+			cached:      []*appsv1.Deployment{newTestDeployment("csi", "service-test")},
+			ns:          "csi",
+			wantService: true,
+		},
+		{
+			name:   "ignore other namespace",
+			cached: []*appsv1.Deployment{newTestDeployment("default", "service-test")},
+			ns:     "default",
+		},
+		{
+			name: "deployment not found",
+			ns:   "csi",
+		},
+		{
+			name:   "service already exists",
+			cached: []*appsv1.Deployment{newTestDeployment("csi", "service-test")},
+			objects: []runtime.Object{
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-test", Namespace: "csi"}},
+			},
+			ns:          "csi",
+			wantService: true,
+		},
+		{
+			name:   "create conflict",
+			cached: []*appsv1.Deployment{newTestDeployment("csi", "service-test")},
+			reactor: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.NewConflict(corev1.Resource("services"), "service-test", nil)
+			},
+			ns: "csi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clientset := newTestController(t, tt.cached, tt.objects...)
+			if tt.reactor != nil {
+				clientset.PrependReactor("create", "services", tt.reactor)
+			}
+			if err := c.syncDeployment(tt.ns, "service-test"); err != nil {
+				t.Fatalf("syncDeployment() error = %v", err)
+			}
+			svc, err := clientset.CoreV1().Services(tt.ns).Get(context.TODO(), "service-test", metav1.GetOptions{})
+			if !tt.wantService {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected no service, got %v (err %v)", svc, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get service: %v", err)
+			}
+			if len(tt.objects) == 0 && svc.Spec.Selector["app"] != "service_test_pod" {
+				t.Errorf("selector = %v", svc.Spec.Selector)
+			}
+		})
+	}
+}
+
+func TestProcessItem(t *testing.T) {
+	dep := newTestDeployment("csi", "service-test")
+	c, clientset := newTestController(t, []*appsv1.Deployment{dep})
+	c.handleAdd(dep)
+	if !c.processItem(context.TODO()) {
+		t.Fatal("processItem() = false")
+	}
+	if _, err := clientset.CoreV1().Services("csi").Get(context.TODO(), "service-test", metav1.GetOptions{}); err != nil {
+		t.Errorf("service not created: %v", err)
+	}
+	if c.queue.Len() != 0 {
+		t.Errorf("queue length = %d, want 0", c.queue.Len())
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	storageinformers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	annProvisionedBy     = "pv.kubernetes.io/provisioned-by"
+	annSelectedNode      = "volume.kubernetes.io/selected-node"
+	paramArchiveOnDelete = "archiveOnDelete"
+	archivedDirPrefix    = "archived-"
+)
+
+/*
+   NFS目录动态供应器
+   监听StorageClass.provisioner为name的PVC,在NFS导出目录下创建子目录并创建对应的NFS PV
+   PV被释放后按回收策略处理: Retain保留目录, Delete根据archiveOnDelete参数归档或删除目录
+*/
+type nfsProvisioner struct {
+	clientset  kubernetes.Interface
+	name       string //provisioner名称,与StorageClass.provisioner一致
+	root       string //NFS导出目录在本机的挂载点
+	server     string //写入PV的NFS服务器地址
+	exportPath string //NFS服务器上导出目录的路径,对应root
+
+	pvcLister   corelisters.PersistentVolumeClaimLister
+	pvLister    corelisters.PersistentVolumeLister
+	classLister storagelisters.StorageClassLister
+	cacheSynced []cache.InformerSynced
+	queue       workqueue.RateLimitingInterface
+}
+
+/*
+   队列中的元素,kind区分PVC与PV
+*/
+type provisionItem struct {
+	kind string
+	key  string
+}
+
+func newNFSProvisioner(clientset kubernetes.Interface, name, root, server, exportPath string,
+	pvcInformer coreinformers.PersistentVolumeClaimInformer,
+	pvInformer coreinformers.PersistentVolumeInformer,
+	classInformer storageinformers.StorageClassInformer) *nfsProvisioner {
+	p := &nfsProvisioner{
+		clientset:   clientset,
+		name:        name,
+		root:        root,
+		server:      server,
+		exportPath:  exportPath,
+		pvcLister:   pvcInformer.Lister(),
+		pvLister:    pvInformer.Lister(),
+		classLister: classInformer.Lister(),
+		cacheSynced: []cache.InformerSynced{
+			pvcInformer.Informer().HasSynced,
+			pvInformer.Informer().HasSynced,
+			classInformer.Informer().HasSynced,
+		},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "nfs-provisioner"),
+	}
+
+	pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.enqueue("pvc", obj) },
+		UpdateFunc: func(_, obj interface{}) { p.enqueue("pvc", obj) },
+	})
+	pvInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.enqueue("pv", obj) },
+		UpdateFunc: func(_, obj interface{}) { p.enqueue("pv", obj) },
+	})
+
+	return p
+}
+
+func (p *nfsProvisioner) enqueue(kind string, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	p.queue.Add(provisionItem{kind: kind, key: key})
+}
+
+func (p *nfsProvisioner) run(ctx context.Context) {
+	defer p.queue.ShutDown()
+	fmt.Printf("starting nfs provisioner %s, root %s\n", p.name, p.root)
+	if !cache.WaitForCacheSync(ctx.Done(), p.cacheSynced...) {
+		fmt.Print("waiting for cache to be synced\n")
+		return
+	}
+	go wait.Until(func() { p.runWorker(ctx) }, time.Second, ctx.Done())
+	<-ctx.Done()
+}
+
+func (p *nfsProvisioner) runWorker(ctx context.Context) {
+	for p.processItem(ctx) {
+	}
+}
+
+func (p *nfsProvisioner) processItem(ctx context.Context) bool {
+	obj, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer p.queue.Done(obj)
+
+	item := obj.(provisionItem)
+	var err error
+	switch item.kind {
+	case "pvc":
+		err = p.syncClaim(ctx, item.key)
+	case "pv":
+		err = p.syncVolume(ctx, item.key)
+	}
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("syncing %s %s: %w", item.kind, item.key, err))
+		p.queue.AddRateLimited(obj)
+		return true
+	}
+	p.queue.Forget(obj)
+	return true
+}
+
+/*
+   为未绑定的PVC创建目录与PV
+*/
+func (p *nfsProvisioner) syncClaim(ctx context.Context, key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	claim, err := p.pvcLister.PersistentVolumeClaims(ns).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if claim.Spec.VolumeName != "" || claim.Status.Phase != corev1.ClaimPending {
+		return nil
+	}
+	class, err := p.claimClass(claim)
+	if err != nil || class == nil {
+		return err
+	}
+	if class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer &&
+		claim.Annotations[annSelectedNode] == "" {
+		//等待调度器选定节点后再创建
+		return nil
+	}
+
+	pvName := "pvc-" + string(claim.UID)
+	if _, err := p.pvLister.Get(pvName); err == nil {
+		return nil
+	}
+	//没有容量的PV无法通过校验,重试也不会成功,记录事件后不再入队
+	request, ok := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok || request.IsZero() {
+		return p.claimFailed(ctx, claim, "PVC未指定spec.resources.requests.storage,无法确定PV容量")
+	}
+	dir := strings.Join([]string{claim.Namespace, claim.Name, pvName}, "-")
+	fullPath := filepath.Join(p.root, dir)
+	if err := os.MkdirAll(fullPath, 0777); err != nil {
+		return fmt.Errorf("create directory %s: %w", fullPath, err)
+	}
+	//MkdirAll受umask影响,显式放开权限以便任意uid的Pod写入
+	if err := os.Chmod(fullPath, 0777); err != nil {
+		return fmt.Errorf("chmod directory %s: %w", fullPath, err)
+	}
+
+	pv := p.newVolume(pvName, path.Join(p.exportPath, dir), claim, class)
+	if _, err := p.clientset.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("create pv %s: %w", pvName, err)
+	}
+	fmt.Printf("provisioned pv %s for pvc %s at %s\n", pvName, key, fullPath)
+	return nil
+}
+
+/*
+   在PVC上记录ProvisioningFailed事件,kubectl describe pvc时可以看到失败原因
+*/
+func (p *nfsProvisioner) claimFailed(ctx context.Context, claim *corev1.PersistentVolumeClaim, message string) error {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", claim.Name, now.UnixNano()),
+			Namespace: claim.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            "PersistentVolumeClaim",
+			APIVersion:      "v1",
+			Namespace:       claim.Namespace,
+			Name:            claim.Name,
+			UID:             claim.UID,
+			ResourceVersion: claim.ResourceVersion,
+		},
+		Reason:         "ProvisioningFailed",
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: p.name},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := p.clientset.CoreV1().Events(claim.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		//事件只用于提示,创建失败不影响处理结果
+		utilruntime.HandleError(fmt.Errorf("record event for pvc %s/%s: %w", claim.Namespace, claim.Name, err))
+	}
+	fmt.Printf("skip pvc %s/%s: %s\n", claim.Namespace, claim.Name, message)
+	return nil
+}
+
+/*
+   获取PVC对应的StorageClass,不是由本provisioner处理的返回nil
+*/
+func (p *nfsProvisioner) claimClass(claim *corev1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName == "" {
+		return nil, nil
+	}
+	class, err := p.classLister.Get(*claim.Spec.StorageClassName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if class.Provisioner != p.name {
+		return nil, nil
+	}
+	return class, nil
+}
+
+func (p *nfsProvisioner) newVolume(name, nfsPath string, claim *corev1.PersistentVolumeClaim, class *storagev1.StorageClass) *corev1.PersistentVolume {
+	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
+	if class.ReclaimPolicy != nil {
+		reclaimPolicy = *class.ReclaimPolicy
+	}
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{annProvisionedBy: p.name},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: claim.Spec.AccessModes,
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: claim.Spec.Resources.Requests[corev1.ResourceStorage],
+			},
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              class.Name,
+			MountOptions:                  class.MountOptions,
+			VolumeMode:                    claim.Spec.VolumeMode,
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  claim.Namespace,
+				Name:       claim.Name,
+				UID:        claim.UID,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: p.server,
+					Path:   nfsPath,
+				},
+			},
+		},
+	}
+}
+
+/*
+   处理被释放的PV,回收策略为Delete时归档或删除目录,然后删除PV
+*/
+func (p *nfsProvisioner) syncVolume(ctx context.Context, name string) error {
+	pv, err := p.pvLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if pv.Annotations[annProvisionedBy] != p.name || pv.Status.Phase != corev1.VolumeReleased ||
+		pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete || pv.Spec.NFS == nil {
+		return nil
+	}
+
+	dir, err := p.localPath(pv.Spec.NFS.Path)
+	if err != nil {
+		return err
+	}
+	if p.archiveOnDelete(pv) {
+		archived := filepath.Join(filepath.Dir(dir), archivedDirPrefix+filepath.Base(dir))
+		if err := os.Rename(dir, archived); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("archive directory %s: %w", dir, err)
+		}
+		fmt.Printf("archived %s to %s\n", dir, archived)
+	} else {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("remove directory %s: %w", dir, err)
+		}
+		fmt.Printf("removed %s\n", dir)
+	}
+
+	if err := p.clientset.CoreV1().PersistentVolumes().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete pv %s: %w", name, err)
+	}
+	return nil
+}
+
+/*
+   archiveOnDelete默认为true,只有显式设置为false时才删除目录
+   StorageClass已被删除时同样按归档处理,避免误删数据
+*/
+func (p *nfsProvisioner) archiveOnDelete(pv *corev1.PersistentVolume) bool {
+	class, err := p.classLister.Get(pv.Spec.StorageClassName)
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(class.Parameters[paramArchiveOnDelete], "false")
+}
+
+/*
+   将PV中的NFS路径转换为本机路径,并确保在root目录之下
+*/
+func (p *nfsProvisioner) localPath(nfsPath string) (string, error) {
+	prefix := strings.TrimSuffix(path.Clean(p.exportPath), "/") + "/"
+	rel := strings.TrimPrefix(path.Clean(nfsPath), prefix)
+	if rel == path.Clean(nfsPath) || rel == "" || rel == ".." || strings.Contains(rel, "/") {
+		return "", fmt.Errorf("nfs path %s is not a directory under %s", nfsPath, p.exportPath)
+	}
+	return filepath.Join(p.root, rel), nil
+}
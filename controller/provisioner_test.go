@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestClass(name, provisioner string, mode storagev1.VolumeBindingMode, reclaim corev1.PersistentVolumeReclaimPolicy, archive string) *storagev1.StorageClass {
+	class := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: name},
+		Provisioner:       provisioner,
+		VolumeBindingMode: &mode,
+		ReclaimPolicy:     &reclaim,
+	}
+	if archive != "" {
+		class.Parameters = map[string]string{paramArchiveOnDelete: archive}
+	}
+	return class
+}
+
+func newTestClaim(className string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pvc",
+			Namespace:   "test-namespace",
+			UID:         "1234",
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+			StorageClassName: &className,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+}
+
+/*
+   使用临时目录作为NFS挂载点,cached中的对象直接写入informer缓存
+*/
+func newTestProvisioner(t *testing.T, cached ...runtime.Object) (*nfsProvisioner, *fake.Clientset) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(cached...)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	pvcInformer := factory.Core().V1().PersistentVolumeClaims()
+	pvInformer := factory.Core().V1().PersistentVolumes()
+	classInformer := factory.Storage().V1().StorageClasses()
+	p := newNFSProvisioner(clientset, "nfs-test-storage", t.TempDir(), "192.168.2.111", "/home/nfs",
+		pvcInformer, pvInformer, classInformer)
+	for _, obj := range cached {
+		var err error
+		switch obj.(type) {
+		case *corev1.PersistentVolumeClaim:
+			err = pvcInformer.Informer().GetIndexer().Add(obj)
+		case *corev1.PersistentVolume:
+			err = pvInformer.Informer().GetIndexer().Add(obj)
+		case *storagev1.StorageClass:
+			err = classInformer.Informer().GetIndexer().Add(obj)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p, clientset
+}
+
+func TestSyncClaim(t *testing.T) {
+	const pvName = "pvc-1234"
+	const dir = "test-namespace-test-pvc-pvc-1234"
+	tests := []struct {
+		name          string
+		class         *storagev1.StorageClass
+		annotations   map[string]string
+		wantProvision bool
+	}{
+		{
+			name:          "immediate",
+			class:         newTestClass("test-storage-class", "nfs-test-storage", storagev1.VolumeBindingImmediate, corev1.PersistentVolumeReclaimRetain, ""),
+			wantProvision: true,
+		},
+		{
+			name:  "wait for first consumer without node",
+			class: newTestClass("test-storage-class", "nfs-test-storage", storagev1.VolumeBindingWaitForFirstConsumer, corev1.PersistentVolumeReclaimRetain, ""),
+		},
+		{
+			name:          "wait for first consumer with selected node",
+			class:         newTestClass("test-storage-class", "nfs-test-storage", storagev1.VolumeBindingWaitForFirstConsumer, corev1.PersistentVolumeReclaimRetain, ""),
+			annotations:   map[string]string{annSelectedNode: "node1"},
+			wantProvision: true,
+		},
+		{
+			name:  "other provisioner",
+			class: newTestClass("test-storage-class", "example.com/other", storagev1.VolumeBindingImmediate, corev1.PersistentVolumeReclaimRetain, ""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, clientset := newTestProvisioner(t, tt.class, newTestClaim("test-storage-class", tt.annotations))
+			if err := p.syncClaim(context.TODO(), "test-namespace/test-pvc"); err != nil {
+				t.Fatalf("syncClaim() error = %v", err)
+			}
+			pv, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+			_, statErr := os.Stat(filepath.Join(p.root, dir))
+			if !tt.wantProvision {
+				if !errors.IsNotFound(err) || !os.IsNotExist(statErr) {
+					t.Fatalf("unexpected provisioning: pv err %v, dir err %v", err, statErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pv not created: %v", err)
+			}
+			if statErr != nil {
+				t.Fatalf("directory not created: %v", statErr)
+			}
+			capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+			switch {
+			case capacity.String() != "1Gi":
+				t.Errorf("capacity = %s", capacity.String())
+			case len(pv.Spec.AccessModes) != 1 || pv.Spec.AccessModes[0] != corev1.ReadWriteMany:
+				t.Errorf("access modes = %v", pv.Spec.AccessModes)
+			case pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain:
+				t.Errorf("reclaim policy = %s", pv.Spec.PersistentVolumeReclaimPolicy)
+			case pv.Spec.NFS == nil || pv.Spec.NFS.Server != "192.168.2.111" || pv.Spec.NFS.Path != "/home/nfs/"+dir:
+				t.Errorf("nfs source = %+v", pv.Spec.NFS)
+			case pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.UID != "1234":
+				t.Errorf("claimRef = %+v", pv.Spec.ClaimRef)
+			case pv.Spec.StorageClassName != "test-storage-class":
+				t.Errorf("storage class = %s", pv.Spec.StorageClassName)
+			}
+		})
+	}
+}
+
+func TestSyncClaimWithoutStorageRequest(t *testing.T) {
+	for name, requests := range map[string]corev1.ResourceList{
+		"missing": nil,
+		"zero":    {corev1.ResourceStorage: resource.MustParse("0")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			claim := newTestClaim("test-storage-class", nil)
+			claim.Spec.Resources.Requests = requests
+			class := newTestClass("test-storage-class", "nfs-test-storage", storagev1.VolumeBindingImmediate, corev1.PersistentVolumeReclaimRetain, "")
+			p, clientset := newTestProvisioner(t, class, claim)
+			//返回nil,不会重新入队
+			if err := p.syncClaim(context.TODO(), "test-namespace/test-pvc"); err != nil {
+				t.Fatalf("syncClaim() error = %v", err)
+			}
+			if _, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), "pvc-1234", metav1.GetOptions{}); !errors.IsNotFound(err) {
+				t.Errorf("pv created: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(p.root, "test-namespace-test-pvc-pvc-1234")); !os.IsNotExist(err) {
+				t.Errorf("directory created: %v", err)
+			}
+			events, err := clientset.CoreV1().Events("test-namespace").List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events.Items) != 1 || events.Items[0].Reason != "ProvisioningFailed" || events.Items[0].InvolvedObject.UID != "1234" {
+				t.Errorf("events = %+v", events.Items)
+			}
+		})
+	}
+}
+
+func TestSyncVolume(t *testing.T) {
+	const dir = "test-namespace-test-pvc-pvc-1234"
+	newReleasedPV := func(reclaim corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc-1234",
+				Annotations: map[string]string{annProvisionedBy: "nfs-test-storage"},
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: reclaim,
+				StorageClassName:              "test-storage-class",
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					NFS: &corev1.NFSVolumeSource{Server: "192.168.2.111", Path: "/home/nfs/" + dir},
+				},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		}
+	}
+	tests := []struct {
+		name         string
+		reclaim      corev1.PersistentVolumeReclaimPolicy
+		archive      string
+		wantDir      bool
+		wantArchived bool
+		wantPV       bool
+	}{
+		{name: "retain", reclaim: corev1.PersistentVolumeReclaimRetain, archive: "false", wantDir: true, wantPV: true},
+		{name: "delete", reclaim: corev1.PersistentVolumeReclaimDelete, archive: "false"},
+		{name: "archive", reclaim: corev1.PersistentVolumeReclaimDelete, archive: "true", wantArchived: true},
+		{name: "archive by default", reclaim: corev1.PersistentVolumeReclaimDelete, wantArchived: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := newTestClass("test-storage-class", "nfs-test-storage", storagev1.VolumeBindingImmediate, tt.reclaim, tt.archive)
+			p, clientset := newTestProvisioner(t, class, newReleasedPV(tt.reclaim))
+			if err := os.Mkdir(filepath.Join(p.root, dir), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.syncVolume(context.TODO(), "pvc-1234"); err != nil {
+				t.Fatalf("syncVolume() error = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(p.root, dir)); (err == nil) != tt.wantDir {
+				t.Errorf("directory exists = %v, want %v", err == nil, tt.wantDir)
+			}
+			if _, err := os.Stat(filepath.Join(p.root, archivedDirPrefix+dir)); (err == nil) != tt.wantArchived {
+				t.Errorf("archived directory exists = %v, want %v", err == nil, tt.wantArchived)
+			}
+			_, err := clientset.CoreV1().PersistentVolumes().Get(context.TODO(), "pvc-1234", metav1.GetOptions{})
+			if (err == nil) != tt.wantPV {
+				t.Errorf("pv exists = %v, want %v", err == nil, tt.wantPV)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	p := &nfsProvisioner{root: "/mnt/nfs", exportPath: "/home/nfs"}
+	tests := []struct {
+		nfsPath string
+		want    string
+		wantErr bool
+	}{
+		{nfsPath: "/home/nfs/ns-pvc-pv", want: "/mnt/nfs/ns-pvc-pv"},
+		{nfsPath: "/home/nfs/ns-pvc-pv/", want: "/mnt/nfs/ns-pvc-pv"},
+		{nfsPath: "/home/nfs", wantErr: true},
+		{nfsPath: "/home/nfs/../etc", wantErr: true},
+		{nfsPath: "/home/nfs/a/b", wantErr: true},
+		{nfsPath: "/data/ns-pvc-pv", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := p.localPath(tt.nfsPath)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("localPath(%q) = %q, %v; want %q, err %v", tt.nfsPath, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+   审计日志默认路径,每个profile一个文件: $XDG_STATE_HOME/k8s-client/audit/<profile>.jsonl,
+   未设置XDG_STATE_HOME时为~/.local/state/k8s-client/audit/<profile>.jsonl,未使用profile时为default.jsonl
+*/
+func defaultAuditLog(profileName string) string {
+	if profileName == "" {
+		profileName = "default"
+	}
+	dir := getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join("audit", profileName+".jsonl")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "k8s-client", "audit", profileName+".jsonl")
+}
+
+//Secret的data、stringData以及last-applied-configuration注解在审计日志中的替换值
+const auditRedacted = "REDACTED"
+
+//exec、cp、port-forward等通过子资源建立的连接不是对象变更,不记录
+var auditIgnoredSubresources = map[string]bool{
+	"exec":        true,
+	"attach":      true,
+	"portforward": true,
+	"proxy":       true,
+	"log":         true,
+}
+
+/*
+   审计日志中的一条记录,每行一个json
+   Before为变更前的对象(创建时为空),After为ApiServer返回的对象(删除时为空),两者都去掉了managedFields,Secret的内容已脱敏
+   UndoOf不为空时表示该记录由audit undo产生,Token不为空时表示该记录由serve中使用该token的请求产生
+*/
+type auditEntry struct {
+	ID          string          `json:"id"`
+	Time        time.Time       `json:"time"`
+	Context     string          `json:"context,omitempty"`
+	User        string          `json:"user,omitempty"`
+	Verb        string          `json:"verb"`
+	APIVersion  string          `json:"apiVersion"`
+	Kind        string          `json:"kind,omitempty"`
+	Resource    string          `json:"resource"`
+	Subresource string          `json:"subresource,omitempty"`
+	Namespace   string          `json:"namespace,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Code        int             `json:"code,omitempty"`
+	Result      string          `json:"result"`
+	Message     string          `json:"message,omitempty"`
+	UndoOf      string          `json:"undoOf,omitempty"`
+	Token       string          `json:"token,omitempty"`
+}
+
+const (
+	auditSuccess = "Success"
+	auditFailure = "Failure"
+)
+
+func (e auditEntry) object() string {
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + name
+	}
+	if e.Subresource != "" {
+		name += "/" + e.Subresource
+	}
+	return name
+}
+
+/*
+   从请求路径解析出的资源,ObjectPath为去掉子资源后对象本身的路径
+*/
+type auditTarget struct {
+	Group       string
+	Version     string
+	Resource    string
+	Subresource string
+	Namespace   string
+	Name        string
+	ObjectPath  string
+}
+
+func (t auditTarget) apiVersion() string {
+	if t.Group == "" {
+		return t.Version
+	}
+	return t.Group + "/" + t.Version
+}
+
+/*
+   解析/api/v1/namespaces/{ns}/{resource}/{name}/{subresource}与/apis/{group}/{version}/...格式的路径
+   namespaces/{name}/finalize与namespaces/{name}/status是Namespace自身的子资源
+*/
+func parseResourcePath(path string) (auditTarget, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var t auditTarget
+	var rest []string
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		t.Version, rest = parts[1], parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		t.Group, t.Version, rest = parts[1], parts[2], parts[3:]
+	default:
+		return t, false
+	}
+	if rest[0] == "namespaces" && len(rest) >= 3 && !(len(rest) == 3 && (rest[2] == "finalize" || rest[2] == "status")) {
+		t.Namespace, rest = rest[1], rest[2:]
+	}
+	t.Resource = rest[0]
+	if len(rest) > 1 {
+		t.Name = rest[1]
+	}
+	if len(rest) > 2 {
+		t.Subresource = strings.Join(rest[2:], "/")
+	}
+	objectLen := len(parts) - len(rest) + 1
+	if t.Name != "" {
+		objectLen++
+	}
+	t.ObjectPath = "/" + strings.Join(parts[:objectLen], "/")
+	return t, true
+}
+
+func auditVerb(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return ""
+}
+
+type auditUndoKey struct{}
+
+/*
+   在ctx中记录正在撤销的审计记录,撤销产生的变更会在UndoOf中引用它
+*/
+func withAuditUndo(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, auditUndoKey{}, id)
+}
+
+func auditUndoFrom(ctx context.Context) string {
+	id, _ := ctx.Value(auditUndoKey{}).(string)
+	return id
+}
+
+type auditTokenKey struct{}
+
+/*
+   在ctx中记录serve中通过认证的token名称,请求产生的变更会在Token中记录它
+*/
+func withAuditToken(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, auditTokenKey{}, name)
+}
+
+func auditTokenFrom(ctx context.Context) string {
+	name, _ := ctx.Value(auditTokenKey{}).(string)
+	return name
+}
+
+/*
+   为rest.Config添加审计,AuditLog为空时不记录
+   context与user为kubeconfig中的context名称及其使用的user
+*/
+func (opts clientOptions) applyAudit(config *rest.Config, context, user string) {
+	if opts.AuditLog == "" {
+		return
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &auditTransport{next: rt, path: opts.AuditLog, context: context, user: user}
+	})
+}
+
+/*
+   记录所有创建、更新、patch与删除请求,dryRun请求不会修改对象,不记录
+   变更前先用同样的认证信息获取对象作为Before,写审计日志失败时只输出警告,不影响请求本身
+*/
+type auditTransport struct {
+	next    http.RoundTripper
+	path    string
+	context string
+	user    string
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	verb := auditVerb(req.Method)
+	target, ok := parseResourcePath(req.URL.Path)
+	if verb == "" || !ok || auditIgnoredSubresources[target.Subresource] || len(req.URL.Query()["dryRun"]) > 0 {
+		return t.next.RoundTrip(req)
+	}
+	entry := auditEntry{
+		ID:          newAuditID(),
+		Time:        time.Now().UTC(),
+		Context:     t.context,
+		User:        t.user,
+		Verb:        verb,
+		APIVersion:  target.apiVersion(),
+		Resource:    target.Resource,
+		Subresource: target.Subresource,
+		Namespace:   target.Namespace,
+		Name:        target.Name,
+		UndoOf:      auditUndoFrom(req.Context()),
+		Token:       auditTokenFrom(req.Context()),
+	}
+	if target.Name == "" && verb != "create" {
+		entry.Verb = verb + "collection"
+	}
+	if target.Name != "" {
+		entry.Before = t.get(req, target.ObjectPath)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		entry.Result = auditFailure
+		entry.Message = err.Error()
+		t.record(entry)
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Code = resp.StatusCode
+	entry.Result = auditSuccess
+	if resp.StatusCode >= 300 {
+		entry.Result = auditFailure
+	}
+	object, status := decodeAuditObject(resp.Header.Get("Content-Type"), body)
+	if status != nil && entry.Result == auditFailure {
+		entry.Message = status.Message
+	}
+	if verb != "delete" && entry.Result == auditSuccess {
+		entry.After = object
+	}
+	entry.Kind = auditKind(entry.Before)
+	if entry.Kind == "" {
+		entry.Kind = auditKind(entry.After)
+	}
+	if entry.Name == "" && entry.After != nil {
+		var meta meta_v1.PartialObjectMetadata
+		if json.Unmarshal(entry.After, &meta) == nil {
+			entry.Name = meta.Name
+			if entry.Namespace == "" {
+				entry.Namespace = meta.Namespace
+			}
+		}
+	}
+	t.record(entry)
+	return resp, nil
+}
+
+/*
+   获取变更前的对象,失败(如对象不存在)时返回nil
+*/
+func (t *auditTransport) get(req *http.Request, path string) json.RawMessage {
+	u := *req.URL
+	u.Path, u.RawPath, u.RawQuery = path, "", ""
+	get, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil
+	}
+	get.Header = req.Header.Clone()
+	get.Header.Del("Content-Type")
+	get.Header.Set("Accept", runtime.ContentTypeJSON)
+	resp, err := t.next.RoundTrip(get)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	object, _ := decodeAuditObject(resp.Header.Get("Content-Type"), body)
+	return object
+}
+
+func (t *auditTransport) record(entry auditEntry) {
+	if err := appendAuditEntry(t.path, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 写入审计日志失败: %v\n", err)
+	}
+}
+
+/*
+   将响应体转换为脱敏后的json对象,响应为Status时返回Status
+   使用-protobuf时内置资源类型的响应为protobuf编码,先解码为对象再转换为json
+*/
+func decodeAuditObject(contentType string, body []byte) (json.RawMessage, *meta_v1.Status) {
+	if strings.HasPrefix(contentType, runtime.ContentTypeProtobuf) {
+		obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(body, nil, nil)
+		if err != nil {
+			return nil, nil
+		}
+		if status, ok := obj.(*meta_v1.Status); ok {
+			return nil, status
+		}
+		if gvk != nil {
+			obj.GetObjectKind().SetGroupVersionKind(*gvk)
+		}
+		if body, err = json.Marshal(obj); err != nil {
+			return nil, nil
+		}
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, nil
+	}
+	if object["kind"] == "Status" {
+		var status meta_v1.Status
+		if err := json.Unmarshal(body, &status); err != nil {
+			return nil, nil
+		}
+		return nil, &status
+	}
+	redactAuditObject(object)
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, nil
+	}
+	return data, nil
+}
+
+/*
+   去掉managedFields,Secret的每个值替换为REDACTED,只保留键名
+*/
+func redactAuditObject(object map[string]interface{}) {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	delete(metadata, "managedFields")
+	if object["kind"] != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, _ := object[field].(map[string]interface{})
+		for key := range values {
+			values[key] = auditRedacted
+		}
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if _, ok := annotations[lastAppliedAnnotation]; ok {
+		annotations[lastAppliedAnnotation] = auditRedacted
+	}
+}
+
+//kubectl apply保存上一次配置的注解,Secret的该注解中包含明文
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+func auditKind(object json.RawMessage) string {
+	if object == nil {
+		return ""
+	}
+	var meta meta_v1.TypeMeta
+	if err := json.Unmarshal(object, &meta); err != nil {
+		return ""
+	}
+	return meta.Kind
+}
+
+func newAuditID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+//同一进程中多个客户端(如多集群执行)共用一个审计日志
+var auditMu sync.Mutex
+
+func appendAuditEntry(path string, entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+/*
+   读取审计日志,文件不存在时返回空列表
+*/
+func readAuditLog(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取审计日志失败: %w", err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var entry auditEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return nil, manifestError(fmt.Sprintf("%s:%d", path, line), err)
+			}
+			entries = append(entries, entry)
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取审计日志失败: %w", err)
+		}
+	}
+}
+
+func findAuditEntry(entries []auditEntry, id string) (auditEntry, error) {
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return auditEntry{}, &clientError{Kind: ErrNotFound, Action: "查找审计记录", Err: fmt.Errorf("没有记录: %s", id)}
+}
+
+/*
+   审计日志查询条件,为空的条件不过滤
+   Kind可以是类型(不区分大小写)、简称或资源名,如deployment、deploy、deployments
+*/
+type auditFilter struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Verb      string
+	Token     string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f auditFilter) match(e auditEntry) bool {
+	if f.Kind != "" {
+		kind := strings.ToLower(f.Kind)
+		if alias, ok := kindAliases[kind]; ok {
+			kind = alias
+		}
+		if !strings.EqualFold(e.Kind, kind) && e.Resource != kind {
+			return false
+		}
+	}
+	switch {
+	case f.Name != "" && e.Name != f.Name,
+		f.Namespace != "" && e.Namespace != f.Namespace,
+		f.Verb != "" && e.Verb != f.Verb,
+		f.Token != "" && e.Token != f.Token,
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+func filterAuditEntries(entries []auditEntry, f auditFilter) []auditEntry {
+	var matched []auditEntry
+	for _, e := range entries {
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+func printAuditEntries(out io.Writer, entries []auditEntry) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tCONTEXT\tUSER\tVERB\tKIND\tOBJECT\tRESULT\tUNDO-OF")
+	for _, e := range entries {
+		result := e.Result
+		if e.Result == auditFailure {
+			result = fmt.Sprintf("%s(%d): %s", e.Result, e.Code, e.Message)
+		}
+		user := orNone(e.User)
+		if e.Token != "" {
+			user += "(token " + e.Token + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Time.UTC().Format(time.RFC3339), orNone(e.Context), user, e.Verb, orNone(e.Kind), e.object(), result, orNone(e.UndoOf))
+	}
+	w.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+/*
+   audit query [-object 类型[/名称]] [-namespace ns] [-verb v] [-since 1h | -since-time t] [-until t] [-o table|json]
+   audit undo [-force] [-yes] <记录ID>
+*/
+func runAudit(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("用法: audit query|undo [参数]")
+	}
+	if clientOpts.AuditLog == "" {
+		return usageError("审计日志未启用,请通过-audit-log指定路径")
+	}
+	switch args[0] {
+	case "query":
+		return runAuditQuery(args[1:], out)
+	case "undo":
+		return runAuditUndo(ctx, args[1:], out)
+	}
+	return usageError("未知的audit子命令: %s", args[0])
+}
+
+func runAuditQuery(args []string, out io.Writer) error {
+	fs := newFlagSet("audit query", out)
+	object := fs.String("object", "", "对象,格式为 类型[/名称]")
+	namespace := fs.String("namespace", "", "命名空间")
+	verb := fs.String("verb", "", "操作: create|update|patch|delete")
+	token := fs.String("token", "", "serve中发起变更的token名称")
+	since := fs.Duration("since", 0, "只输出最近一段时间内的记录,如1h")
+	sinceTime := fs.String("since-time", "", "只输出该时间之后的记录,RFC3339格式")
+	until := fs.String("until", "", "只输出该时间之前的记录,RFC3339格式")
+	output := fs.String("o", activeProfile.output("table", "json"), "输出格式: table|json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError("多余的参数: %s", strings.Join(fs.Args(), " "))
+	}
+	if *output != "table" && *output != "json" {
+		return usageError("不支持的输出格式: %s", *output)
+	}
+	filter := auditFilter{Namespace: *namespace, Verb: *verb, Token: *token}
+	if *object != "" {
+		filter.Kind = *object
+		if i := strings.Index(*object, "/"); i >= 0 {
+			filter.Kind, filter.Name = (*object)[:i], (*object)[i+1:]
+		}
+	}
+	if *since > 0 && *sinceTime != "" {
+		return usageError("-since与-since-time只能指定一个")
+	}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+	for _, t := range []struct {
+		value string
+		into  *time.Time
+		flag  string
+	}{{*sinceTime, &filter.Since, "-since-time"}, {*until, &filter.Until, "-until"}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return usageError("%s格式应为RFC3339: %v", t.flag, err)
+		}
+		*t.into = parsed
+	}
+
+	entries, err := readAuditLog(clientOpts.AuditLog)
+	if err != nil {
+		return err
+	}
+	entries = filterAuditEntries(entries, filter)
+	if *output == "json" {
+		for _, e := range entries {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, string(data))
+		}
+		return nil
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "没有匹配的审计记录")
+		return nil
+	}
+	printAuditEntries(out, entries)
+	return nil
+}
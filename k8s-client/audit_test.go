@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/apimachinery/pkg/types"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseResourcePath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want auditTarget
+	}{
+		{"/api/v1/namespaces/test-namespace/pods", auditTarget{Version: "v1", Resource: "pods", Namespace: TestNamespace, ObjectPath: "/api/v1/namespaces/test-namespace/pods"}},
+		{"/api/v1/namespaces/test-namespace/pods/p/eviction", auditTarget{Version: "v1", Resource: "pods", Namespace: TestNamespace, Name: "p", Subresource: "eviction", ObjectPath: "/api/v1/namespaces/test-namespace/pods/p"}},
+		{"/apis/apps/v1/namespaces/test-namespace/deployments/test-nginx/scale", auditTarget{Group: "apps", Version: "v1", Resource: "deployments", Namespace: TestNamespace, Name: "test-nginx", Subresource: "scale", ObjectPath: "/apis/apps/v1/namespaces/test-namespace/deployments/test-nginx"}},
+		{"/api/v1/namespaces/test-namespace", auditTarget{Version: "v1", Resource: "namespaces", Name: TestNamespace, ObjectPath: "/api/v1/namespaces/test-namespace"}},
+		{"/api/v1/namespaces/test-namespace/finalize", auditTarget{Version: "v1", Resource: "namespaces", Name: TestNamespace, Subresource: "finalize", ObjectPath: "/api/v1/namespaces/test-namespace"}},
+		{"/apis/rbac.authorization.k8s.io/v1/clusterroles/reader", auditTarget{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles", Name: "reader", ObjectPath: "/apis/rbac.authorization.k8s.io/v1/clusterroles/reader"}},
+	} {
+		got, ok := parseResourcePath(tc.path)
+		if !ok || got != tc.want {
+			t.Errorf("parseResourcePath(%s) = %+v, %v, want %+v", tc.path, got, ok, tc.want)
+		}
+	}
+	for _, path := range []string{"/version", "/api/v1", "/apis/apps/v1"} {
+		if _, ok := parseResourcePath(path); ok {
+			t.Errorf("parseResourcePath(%s) should fail", path)
+		}
+	}
+}
+
+/*
+   按路径保存对象的ApiServer,patch请求总是返回冲突
+*/
+type auditServer struct {
+	mu      sync.Mutex
+	objects map[string]map[string]interface{}
+	version int
+}
+
+func (s *auditServer) writeStatus(w http.ResponseWriter, code int, reason meta_v1.StatusReason, message string) {
+	status := meta_v1.Status{TypeMeta: meta_v1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: meta_v1.StatusSuccess, Code: int32(code), Reason: reason, Message: message}
+	if code >= 300 {
+		status.Status = meta_v1.StatusFailure
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *auditServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	path := r.URL.Path
+	switch r.Method {
+	case http.MethodGet:
+		obj, ok := s.objects[path]
+		if !ok {
+			s.writeStatus(w, http.StatusNotFound, meta_v1.StatusReasonNotFound, path+" not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj)
+		return
+	case http.MethodPost:
+		metadata := body["metadata"].(map[string]interface{})
+		path += "/" + metadata["name"].(string)
+		metadata["uid"] = "uid-" + metadata["name"].(string)
+	case http.MethodPut:
+		metadata := body["metadata"].(map[string]interface{})
+		metadata["uid"] = s.objects[path]["metadata"].(map[string]interface{})["uid"]
+	case http.MethodPatch:
+		s.writeStatus(w, http.StatusConflict, meta_v1.StatusReasonConflict, "the object has been modified")
+		return
+	case http.MethodDelete:
+		delete(s.objects, path)
+		s.writeStatus(w, http.StatusOK, "", "")
+		return
+	}
+	s.version++
+	metadata := body["metadata"].(map[string]interface{})
+	metadata["resourceVersion"] = strconv.Itoa(s.version)
+	metadata["managedFields"] = []interface{}{map[string]interface{}{"manager": "k8s-client"}}
+	s.objects[path] = body
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestAuditTransport(t *testing.T) {
+	secretPath := "/api/v1/namespaces/test-namespace/secrets/" + TestDockerConfigJsonKey
+	configMapPath := "/api/v1/namespaces/test-namespace/configmaps/test-config"
+	s := &auditServer{objects: map[string]map[string]interface{}{
+		secretPath: {
+			"kind": "Secret", "apiVersion": "v1",
+			"metadata": map[string]interface{}{"name": TestDockerConfigJsonKey, "namespace": TestNamespace, "uid": "uid-secret", "resourceVersion": "1",
+				"annotations": map[string]interface{}{lastAppliedAnnotation: `{"data":{"password":"MTIzNDU2"}}`}},
+			"data": map[string]interface{}{"password": "MTIzNDU2"},
+		},
+		configMapPath: {
+			"kind": "ConfigMap", "apiVersion": "v1",
+			"metadata": map[string]interface{}{"name": "test-config", "namespace": TestNamespace, "uid": "uid-test-config", "resourceVersion": "1"},
+			"data":     map[string]interface{}{"a": "1"},
+		},
+	}, version: 1}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	opts := defaultClientOptions()
+	opts.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	config := &rest.Config{Host: server.URL}
+	opts.apply(config)
+	opts.applyAudit(config, "kind-test", "admin")
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	deployment := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace}}
+	if _, err := clientSet.AppsV1().Deployments(TestNamespace).Create(ctx, deployment, meta_v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	//dryRun与读请求不记录
+	if _, err := clientSet.AppsV1().Deployments(TestNamespace).Create(ctx, deployment, meta_v1.CreateOptions{DryRun: []string{meta_v1.DryRunAll}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().Secrets(TestNamespace).Get(ctx, TestDockerConfigJsonKey, meta_v1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	secret := &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: TestDockerConfigJsonKey, Namespace: TestNamespace},
+		StringData: map[string]string{"password": "654321"},
+	}
+	if _, err := clientSet.CoreV1().Secrets(TestNamespace).Update(withAuditUndo(ctx, "0a1b2c3d"), secret, meta_v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().ConfigMaps(TestNamespace).Patch(ctx, "test-config", types.MergePatchType, []byte(`{"data":{"a":"2"}}`), meta_v1.PatchOptions{}); errorKindOf(wrapError("patch", err)) != ErrConflict {
+		t.Errorf("patch err = %v, want conflict", err)
+	}
+	//serve中的请求记录token名称
+	if err := clientSet.CoreV1().ConfigMaps(TestNamespace).Delete(withAuditToken(ctx, "portal"), "test-config", meta_v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readAuditLog(opts.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	for _, e := range entries {
+		if e.ID == "" || e.Time.IsZero() {
+			t.Errorf("entry without id or time: %+v", e)
+		}
+		e.ID, e.Time = "", time.Time{}
+		data, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(append(data, '\n'))
+	}
+	assertGolden(t, "audit_log.golden", out.Bytes())
+	if strings.Contains(out.String(), "MTIzNDU2") || strings.Contains(out.String(), "654321") {
+		t.Error("审计日志中包含Secret明文")
+	}
+}
+
+func TestDefaultAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	setTestEnv(t, map[string]string{"XDG_STATE_HOME": dir})
+	if got, want := defaultAuditLog("prod"), filepath.Join(dir, "k8s-client", "audit", "prod.jsonl"); got != want {
+		t.Errorf("defaultAuditLog(prod) = %s, want %s", got, want)
+	}
+	path := defaultAuditLog("")
+	if filepath.Base(path) != "default.jsonl" {
+		t.Errorf("defaultAuditLog() = %s", path)
+	}
+
+	//目录不存在时自动创建
+	if err := appendAuditEntry(path, auditEntry{ID: "1", Verb: "delete"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readAuditLog(path); err != nil || len(entries) != 1 {
+		t.Errorf("entries = %v, err = %v", entries, err)
+	}
+
+	//profile中的auditLog优先
+	config := writeToolConfig(t, "profiles:\n  prod:\n    namespace: prod\n  ci:\n    auditLog: ./ci.jsonl\n")
+	for name, want := range map[string]string{"prod": defaultAuditLog("prod"), "ci": "./ci.jsonl"} {
+		if p, err := loadProfile(config, name, true); err != nil || p.AuditLog != want {
+			t.Errorf("profile %s auditLog = %s, err = %v, want %s", name, p.AuditLog, err, want)
+		}
+	}
+}
+
+func TestDecodeAuditObjectProtobuf(t *testing.T) {
+	var buf bytes.Buffer
+	pod := &core_v1.Pod{TypeMeta: meta_v1.TypeMeta{Kind: "Pod", APIVersion: "v1"}, ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx-a", Namespace: TestNamespace}}
+	if err := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme).Encode(pod, &buf); err != nil {
+		t.Fatal(err)
+	}
+	object, status := decodeAuditObject(runtime.ContentTypeProtobuf, buf.Bytes())
+	if status != nil || auditKind(object) != "Pod" || !strings.Contains(string(object), `"name":"test-nginx-a"`) {
+		t.Errorf("object = %s, status = %v", object, status)
+	}
+}
+
+func newAuditEntries() []auditEntry {
+	at := func(minute int) time.Time {
+		return time.Date(2022, 1, 2, 3, minute, 0, 0, time.UTC)
+	}
+	return []auditEntry{
+		{ID: "00000001", Time: at(0), Context: "kind-test", User: "admin", Verb: "create", APIVersion: "apps/v1", Kind: "Deployment", Resource: "deployments", Namespace: TestNamespace, Name: "test-nginx", Code: 201, Result: auditSuccess},
+		{ID: "00000002", Time: at(5), Context: "kind-test", User: "admin", Verb: "update", APIVersion: "apps/v1", Kind: "Deployment", Resource: "deployments", Subresource: "scale", Namespace: TestNamespace, Name: "test-nginx", Code: 200, Result: auditSuccess},
+		{ID: "00000003", Time: at(10), Context: "kind-prod", User: "ops", Verb: "patch", APIVersion: "v1", Kind: "ConfigMap", Resource: "configmaps", Namespace: TestNamespace, Name: "test-config", Code: 409, Result: auditFailure, Message: "the object has been modified"},
+		{ID: "00000004", Time: at(15), Context: "kind-test", User: "admin", Verb: "delete", APIVersion: "v1", Kind: "Namespace", Resource: "namespaces", Name: "other", Code: 200, Result: auditSuccess},
+		{ID: "00000005", Time: at(20), Context: "kind-test", User: "admin", Verb: "update", APIVersion: "apps/v1", Kind: "Deployment", Resource: "deployments", Namespace: TestNamespace, Name: "test-nginx", Code: 200, Result: auditSuccess, UndoOf: "00000002"},
+	}
+}
+
+func TestFilterAuditEntries(t *testing.T) {
+	entries := newAuditEntries()
+	for _, tc := range []struct {
+		filter auditFilter
+		want   []string
+	}{
+		{auditFilter{}, []string{"00000001", "00000002", "00000003", "00000004", "00000005"}},
+		{auditFilter{Kind: "deploy", Name: "test-nginx"}, []string{"00000001", "00000002", "00000005"}},
+		{auditFilter{Kind: "configmaps"}, []string{"00000003"}},
+		{auditFilter{Namespace: TestNamespace, Verb: "update"}, []string{"00000002", "00000005"}},
+		{auditFilter{Since: time.Date(2022, 1, 2, 3, 5, 0, 0, time.UTC), Until: time.Date(2022, 1, 2, 3, 15, 0, 0, time.UTC)}, []string{"00000002", "00000003", "00000004"}},
+	} {
+		var got []string
+		for _, e := range filterAuditEntries(entries, tc.filter) {
+			got = append(got, e.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("filter %+v = %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	var out bytes.Buffer
+	printAuditEntries(&out, entries)
+	assertGolden(t, "audit_query.golden", out.Bytes())
+}
+
+func auditJSON(t *testing.T, obj *unstructured.Unstructured) json.RawMessage {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newAuditDeployment(uid, resourceVersion string, replicas int64, image string) *unstructured.Unstructured {
+	obj := newUnstructured("apps/v1", "Deployment", TestNamespace, "test-nginx")
+	obj.SetUID(types.UID(uid))
+	obj.SetResourceVersion(resourceVersion)
+	unstructured.SetNestedField(obj.Object, replicas, "spec", "replicas")
+	unstructured.SetNestedSlice(obj.Object, []interface{}{map[string]interface{}{"name": "nginx", "image": image}}, "spec", "template", "spec", "containers")
+	return obj
+}
+
+func TestUndo(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	services := schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	configMap := newUnstructured("v1", "ConfigMap", TestNamespace, "test-config")
+	configMap.SetUID("uid-test-config")
+	service := newUnstructured("v1", "Service", TestNamespace, "test-nginx")
+	service.SetUID("uid-service")
+	service.SetResourceVersion("7")
+	unstructured.SetNestedField(service.Object, "10.96.0.10", "spec", "clusterIP")
+
+	newClient := func() *fakedynamic.FakeDynamicClient {
+		return fakedynamic.NewSimpleDynamicClient(runtime.NewScheme(), newAuditDeployment("uid-1", "3", 3, "nginx:1.21"), configMap.DeepCopy())
+	}
+	base := auditEntry{ID: "00000002", Time: time.Date(2022, 1, 2, 3, 5, 0, 0, time.UTC), Context: "kind-test", Namespace: TestNamespace, Result: auditSuccess}
+	patch := base
+	patch.Verb, patch.APIVersion, patch.Kind, patch.Resource, patch.Name = "patch", "apps/v1", "Deployment", "deployments", "test-nginx"
+	patch.Before = auditJSON(t, newAuditDeployment("uid-1", "2", 1, "nginx:1.20"))
+	patch.After = auditJSON(t, newAuditDeployment("uid-1", "3", 3, "nginx:1.21"))
+	create := base
+	create.ID, create.Verb, create.APIVersion, create.Kind, create.Resource, create.Name = "00000003", "create", "v1", "ConfigMap", "configmaps", "test-config"
+	create.After = auditJSON(t, configMap)
+	deleted := base
+	deleted.ID, deleted.Verb, deleted.APIVersion, deleted.Kind, deleted.Resource, deleted.Name = "00000004", "delete", "v1", "Service", "services", "test-nginx"
+	deleted.Before = auditJSON(t, service)
+
+	ctx := context.TODO()
+	client := newClient()
+	var out bytes.Buffer
+	for _, entry := range []auditEntry{patch, create, deleted} {
+		plan, err := planUndo(ctx, client, entry, false)
+		if err != nil {
+			t.Fatalf("planUndo(%s): %v", entry.Verb, err)
+		}
+		printUndoPlan(&out, plan)
+		if err := executeUndo(ctx, client, plan, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertGolden(t, "audit_undo.golden", out.Bytes())
+
+	deployment, err := client.Resource(deployments).Namespace(TestNamespace).Get(ctx, "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas"); replicas != 1 {
+		t.Errorf("replicas = %d, want 1", replicas)
+	}
+	if _, err := client.Resource(configMaps).Namespace(TestNamespace).Get(ctx, "test-config", meta_v1.GetOptions{}); err == nil {
+		t.Error("创建的ConfigMap应被删除")
+	}
+	recreated, err := client.Resource(services).Namespace(TestNamespace).Get(ctx, "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recreated.GetUID() != "" || recreated.GetResourceVersion() == "7" {
+		t.Errorf("重新创建的Service不应保留uid与resourceVersion: %v", recreated.Object["metadata"])
+	}
+
+	//记录之后对象又被修改、记录失败、Secret与不支持的子资源
+	failed := patch
+	failed.Result = auditFailure
+	secret := create
+	secret.Kind = "Secret"
+	eviction := patch
+	eviction.Verb, eviction.Subresource = "create", "eviction"
+	modified := newClient()
+	for _, tc := range []struct {
+		entry auditEntry
+		force bool
+		want  errorKind
+	}{
+		{failed, false, ErrUsage},
+		{secret, false, ErrUsage},
+		{eviction, false, ErrUsage},
+	} {
+		if _, err := planUndo(ctx, modified, tc.entry, tc.force); errorKindOf(err) != tc.want {
+			t.Errorf("planUndo(%s %s) error = %v, want %s", tc.entry.Verb, tc.entry.Kind, err, tc.want)
+		}
+	}
+	//已经撤销过的记录: ConfigMap已删除,Service已重新创建
+	if _, err := planUndo(ctx, client, create, false); errorKindOf(err) != ErrNotFound {
+		t.Errorf("planUndo error = %v, want not found", err)
+	}
+	if _, err := planUndo(ctx, client, deleted, false); errorKindOf(err) != ErrConflict {
+		t.Errorf("planUndo error = %v, want conflict", err)
+	}
+	//伸缩记录只恢复副本数,镜像在记录之后的变更保留
+	scaled := patch
+	scaled.ID, scaled.Verb, scaled.Subresource = "00000005", "update", "scale"
+	scale := newUnstructured("autoscaling/v1", "Scale", TestNamespace, "test-nginx")
+	unstructured.SetNestedField(scale.Object, int64(3), "spec", "replicas")
+	scaled.After = auditJSON(t, scale)
+	scaleClient := newClient()
+	plan, err := planUndo(ctx, scaleClient, scaled, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	printUndoPlan(&out, plan)
+	if err := executeUndo(ctx, scaleClient, plan, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "将 test-namespace/test-nginx/scale 的副本数从 3 恢复为 1") {
+		t.Errorf("output = %s", out.String())
+	}
+	deployment, err = scaleClient.Resource(deployments).Namespace(TestNamespace).Get(ctx, "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	if replicas != 1 || containers[0].(map[string]interface{})["image"] != "nginx:1.21" {
+		t.Errorf("replicas = %d, containers = %v", replicas, containers)
+	}
+	//副本数在记录之后又被修改
+	if _, err := planUndo(ctx, scaleClient, scaled, false); errorKindOf(err) != ErrConflict {
+		t.Errorf("planUndo error = %v, want conflict", err)
+	}
+
+	changed := patch
+	changed.After = auditJSON(t, newAuditDeployment("uid-1", "2", 3, "nginx:1.21"))
+	if _, err := planUndo(ctx, modified, changed, false); errorKindOf(err) != ErrConflict {
+		t.Errorf("planUndo error = %v, want conflict", err)
+	}
+	if _, err := planUndo(ctx, modified, changed, true); err != nil {
+		t.Errorf("planUndo with force: %v", err)
+	}
+}
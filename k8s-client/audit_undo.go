@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"os"
+	sigs_yaml "sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+/*
+   撤销一条审计记录需要执行的操作
+   创建的记录删除该对象,更新与patch的记录将对象恢复为Before,删除的记录重新创建Before
+   伸缩的记录只通过scale子资源恢复Before中的副本数Replicas
+*/
+type undoPlan struct {
+	Entry    auditEntry
+	Action   string
+	Current  *unstructured.Unstructured
+	Target   *unstructured.Unstructured
+	Replicas int64
+}
+
+func undoError(format string, a ...interface{}) error {
+	return &clientError{Kind: ErrUsage, Action: "撤销审计记录", Err: fmt.Errorf(format, a...)}
+}
+
+func undoConflict(format string, a ...interface{}) error {
+	return &clientError{Kind: ErrConflict, Action: "撤销审计记录", Err: fmt.Errorf(format, a...)}
+}
+
+func auditObject(data json.RawMessage) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+/*
+   根据审计记录与对象的当前状态确定撤销操作
+   记录之后对象又被修改或重新创建时返回冲突,force为true时仍然覆盖
+*/
+func planUndo(ctx context.Context, client dynamic.Interface, entry auditEntry, force bool) (*undoPlan, error) {
+	switch {
+	case entry.Result != auditSuccess:
+		return nil, undoError("记录 %s 的操作没有成功,没有需要撤销的变更", entry.ID)
+	case entry.Subresource != "" && entry.Subresource != "scale":
+		return nil, undoError("不支持撤销子资源 %s 的操作", entry.Subresource)
+	case entry.Kind == "Secret":
+		return nil, undoError("Secret的内容在审计日志中已脱敏,无法恢复")
+	case entry.Name == "":
+		return nil, undoError("不支持撤销 %s", entry.Verb)
+	}
+	gv, err := schema.ParseGroupVersion(entry.APIVersion)
+	if err != nil {
+		return nil, undoError("记录中的apiVersion无效: %v", err)
+	}
+	resource := client.Resource(gv.WithResource(entry.Resource)).Namespace(entry.Namespace)
+	current, err := resource.Get(ctx, entry.Name, meta_v1.GetOptions{})
+	if err != nil && !k8s_errors.IsNotFound(err) {
+		return nil, wrapError("获取对象", err)
+	}
+	if err != nil {
+		current = nil
+	}
+
+	plan := &undoPlan{Entry: entry, Current: current}
+	if entry.Subresource == "scale" {
+		return planScaleUndo(plan, force)
+	}
+	switch entry.Verb {
+	case "create":
+		if current == nil {
+			return nil, &clientError{Kind: ErrNotFound, Action: "撤销审计记录", Err: fmt.Errorf("%s 已不存在,无需撤销", entry.object())}
+		}
+		after, err := auditObject(entry.After)
+		if err != nil {
+			return nil, undoError("记录中没有创建的对象")
+		}
+		if after.GetUID() != current.GetUID() && !force {
+			return nil, undoConflict("%s 已被删除后重新创建,使用-force删除当前对象", entry.object())
+		}
+		plan.Action = "delete"
+		plan.Target = current
+	case "update", "patch":
+		if current == nil {
+			return nil, &clientError{Kind: ErrNotFound, Action: "撤销审计记录", Err: fmt.Errorf("%s 已被删除,无法恢复", entry.object())}
+		}
+		before, err := auditObject(entry.Before)
+		if err != nil {
+			return nil, undoError("记录中没有变更前的对象")
+		}
+		if !force {
+			if before.GetUID() != current.GetUID() {
+				return nil, undoConflict("%s 已被删除后重新创建,使用-force覆盖", entry.object())
+			}
+			if after, err := auditObject(entry.After); err == nil && after.GetResourceVersion() != current.GetResourceVersion() {
+				return nil, undoConflict("%s 在记录之后又被修改(resourceVersion %s -> %s),使用-force覆盖", entry.object(), after.GetResourceVersion(), current.GetResourceVersion())
+			}
+		}
+		before.SetUID(current.GetUID())
+		before.SetResourceVersion(current.GetResourceVersion())
+		plan.Action = "update"
+		plan.Target = before
+	case "delete":
+		if current != nil {
+			return nil, undoConflict("%s 仍然存在(可能正在等待finalizer),无法重新创建", entry.object())
+		}
+		before, err := auditObject(entry.Before)
+		if err != nil {
+			return nil, undoError("记录中没有删除前的对象")
+		}
+		for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp", "deletionGracePeriodSeconds", "selfLink"} {
+			unstructured.RemoveNestedField(before.Object, "metadata", field)
+		}
+		unstructured.RemoveNestedField(before.Object, "status")
+		plan.Action = "create"
+		plan.Target = before
+	default:
+		return nil, undoError("不支持撤销 %s", entry.Verb)
+	}
+	return plan, nil
+}
+
+/*
+   伸缩记录的Before为变更前的工作负载,After为伸缩后的Scale
+   只有副本数在记录之后又被修改才算冲突,其他字段的变更不影响撤销
+*/
+func planScaleUndo(plan *undoPlan, force bool) (*undoPlan, error) {
+	entry, current := plan.Entry, plan.Current
+	if current == nil {
+		return nil, &clientError{Kind: ErrNotFound, Action: "撤销审计记录", Err: fmt.Errorf("%s 已被删除,无法恢复", entry.object())}
+	}
+	before, err := auditObject(entry.Before)
+	if err != nil {
+		return nil, undoError("记录中没有伸缩前的对象")
+	}
+	replicas, found, err := unstructured.NestedInt64(before.Object, "spec", "replicas")
+	if err != nil || !found {
+		return nil, undoError("记录中没有伸缩前的副本数")
+	}
+	if !force {
+		if before.GetUID() != current.GetUID() {
+			return nil, undoConflict("%s 已被删除后重新创建,使用-force覆盖", entry.object())
+		}
+		currentReplicas, _, _ := unstructured.NestedInt64(current.Object, "spec", "replicas")
+		if after, err := auditObject(entry.After); err == nil {
+			if scaled, found, _ := unstructured.NestedInt64(after.Object, "spec", "replicas"); found && scaled != currentReplicas {
+				return nil, undoConflict("%s 的副本数在记录之后又被修改(%d -> %d),使用-force覆盖", entry.object(), scaled, currentReplicas)
+			}
+		}
+	}
+	plan.Action = "scale"
+	plan.Replicas = replicas
+	return plan, nil
+}
+
+/*
+   对象的yaml,去掉每次变更都会变化的字段,用于对比
+*/
+func objectLines(obj *unstructured.Unstructured) []string {
+	if obj == nil {
+		return nil
+	}
+	obj = obj.DeepCopy()
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	data, err := sigs_yaml.Marshal(obj.Object)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func printUndoPlan(out io.Writer, plan *undoPlan) {
+	e := plan.Entry
+	fmt.Fprintf(out, "撤销记录 %s: %s %s %s (%s, %s)\n", e.ID, e.Verb, orNone(e.Kind), e.object(), orNone(e.Context), e.Time.UTC().Format(time.RFC3339))
+	switch plan.Action {
+	case "delete":
+		fmt.Fprintf(out, "将删除 %s\n", e.object())
+	case "update":
+		fmt.Fprintf(out, "将恢复 %s 为变更前的状态:\n", e.object())
+	case "create":
+		fmt.Fprintf(out, "将重新创建 %s\n", e.object())
+	case "scale":
+		current, _, _ := unstructured.NestedInt64(plan.Current.Object, "spec", "replicas")
+		fmt.Fprintf(out, "将 %s 的副本数从 %d 恢复为 %d\n", e.object(), current, plan.Replicas)
+	}
+	if plan.Action == "update" {
+		diff := diffLines(objectLines(plan.Current), objectLines(plan.Target))
+		if len(diff) == 0 {
+			fmt.Fprintln(out, "  当前状态与变更前一致")
+		}
+		for _, line := range diff {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+	}
+}
+
+/*
+   执行撤销,产生的变更在审计日志中通过UndoOf引用原记录
+*/
+func executeUndo(ctx context.Context, client dynamic.Interface, plan *undoPlan, out io.Writer) error {
+	e := plan.Entry
+	ctx = withAuditUndo(ctx, e.ID)
+	gv, _ := schema.ParseGroupVersion(e.APIVersion)
+	resource := client.Resource(gv.WithResource(e.Resource)).Namespace(e.Namespace)
+	switch plan.Action {
+	case "delete":
+		uid := plan.Target.GetUID()
+		if err := resource.Delete(ctx, e.Name, meta_v1.DeleteOptions{Preconditions: &meta_v1.Preconditions{UID: &uid}}); err != nil {
+			return wrapError("删除对象", err)
+		}
+		fmt.Fprintf(out, "%s 已删除\n", e.object())
+	case "update":
+		if _, err := resource.Update(ctx, plan.Target, meta_v1.UpdateOptions{}); err != nil {
+			return wrapError("恢复对象", err)
+		}
+		fmt.Fprintf(out, "%s 已恢复为变更前的状态\n", e.object())
+	case "create":
+		if _, err := resource.Create(ctx, plan.Target, meta_v1.CreateOptions{}); err != nil {
+			return wrapError("重新创建对象", err)
+		}
+		fmt.Fprintf(out, "%s 已重新创建\n", e.object())
+	case "scale":
+		patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, plan.Replicas)
+		if _, err := resource.Patch(ctx, e.Name, types.MergePatchType, []byte(patch), meta_v1.PatchOptions{}, "scale"); err != nil {
+			return wrapError("恢复副本数", err)
+		}
+		fmt.Fprintf(out, "%s 的副本数已恢复为 %d\n", e.object(), plan.Replicas)
+	}
+	return nil
+}
+
+func runAuditUndo(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("audit undo", out)
+	force := fs.Bool("force", false, "对象在记录之后又被修改或重新创建时仍然撤销")
+	yes := fs.Bool("yes", false, "跳过确认")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: audit undo [-force] [-yes] <记录ID>")
+	}
+	entries, err := readAuditLog(clientOpts.AuditLog)
+	if err != nil {
+		return err
+	}
+	entry, err := findAuditEntry(entries, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	//在记录对应的集群上撤销
+	if entry.Context != "" {
+		ctx = withKubeContext(ctx, entry.Context)
+	}
+	client, err := initDynamicClient(ctx)
+	if err != nil {
+		return err
+	}
+	plan, err := planUndo(ctx, client, entry, *force)
+	if err != nil {
+		return err
+	}
+	printUndoPlan(out, plan)
+	if !*yes && !confirm(os.Stdin, out, "\n确认撤销", entry.ID) {
+		return &clientError{Kind: ErrUsage, Action: "撤销审计记录", Err: fmt.Errorf("未确认,已取消")}
+	}
+	return executeUndo(ctx, client, plan, out)
+}
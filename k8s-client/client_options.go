@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+   客户端参数,写在工具配置文件的profile中,优先级: 命令行参数 > profile > 默认值
+   RequestTimeout限制单个请求,watch、日志跟随与exec等长连接不受限制
+   Timeout限制整个命令,为0时不限制
+   MaxRetries为ApiServer限流(429)或繁忙(503/504且带Retry-After)时的重试次数,RetryBackoff为首次重试前的等待时间,之后每次翻倍
+   Protobuf为true时内置资源类型使用protobuf编码,可以加快大列表的传输与解码,动态客户端仍使用json
+   Contexts或AllContexts不为空时,命令在kubeconfig的多个context上并发执行,最多同时执行Parallel个
+   AuditLog为审计日志路径,所有创建、更新、patch与删除请求都会追加到其中,为空时不记录
+*/
+type clientOptions struct {
+	QPS            float32          `json:"qps,omitempty"`
+	Burst          int              `json:"burst,omitempty"`
+	RequestTimeout meta_v1.Duration `json:"requestTimeout,omitempty"`
+	Timeout        meta_v1.Duration `json:"timeout,omitempty"`
+	MaxRetries     int              `json:"maxRetries,omitempty"`
+	RetryBackoff   meta_v1.Duration `json:"retryBackoff,omitempty"`
+	Protobuf       bool             `json:"protobuf,omitempty"`
+	Contexts       []string         `json:"contexts,omitempty"`
+	AllContexts    bool             `json:"allContexts,omitempty"`
+	Parallel       int              `json:"parallel,omitempty"`
+	AuditLog       string           `json:"auditLog,omitempty"`
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		QPS:            5,
+		Burst:          10,
+		RequestTimeout: meta_v1.Duration{Duration: 30 * time.Second},
+		MaxRetries:     3,
+		RetryBackoff:   meta_v1.Duration{Duration: 500 * time.Millisecond},
+		Parallel:       4,
+		AuditLog:       defaultAuditLog(""),
+	}
+}
+
+//当前命令使用的客户端参数,由run解析全局参数后设置
+var clientOpts = defaultClientOptions()
+
+/*
+   解析命令名之前的全局参数,返回客户端参数、profile与剩余参数
+*/
+func parseGlobalFlags(args []string, out io.Writer) (clientOptions, profile, []string, error) {
+	defaults := defaultClientOptions()
+	fs := newFlagSet("k8s-client", out)
+	profileConfig := fs.String("config", "", "工具配置文件,默认为环境变量"+envConfig+"或~/.config/k8s-client/config.yaml")
+	profileName := fs.String("profile", "", "使用配置文件中的profile,默认为环境变量"+envProfile+"或配置文件中的currentProfile")
+	kubeConfig := fs.String("kubeconfig", "", "kubeconfig路径,默认"+defaultKubeConfig)
+	kubeContext := fs.String("context", "", "kubeconfig中的context,默认使用current-context")
+	namespace := fs.String("namespace", "", "默认命名空间,默认"+TestNamespace)
+	manifestDirs := fs.String("manifest-dirs", "", "查找清单文件的目录,逗号分隔,默认"+defaultManifestDir)
+	output := fs.String("output", "", "支持-o的命令的默认输出格式")
+	fieldManager := fs.String("field-manager", "", "创建、更新与patch请求的fieldManager,默认"+defaultFieldManager)
+	readOnly := fs.Bool("read-only", false, "拒绝所有变更请求")
+	qps := fs.Float64("qps", float64(defaults.QPS), "每秒请求数")
+	burst := fs.Int("burst", defaults.Burst, "突发请求数")
+	requestTimeout := fs.Duration("request-timeout", defaults.RequestTimeout.Duration, "单个请求的超时时间,0表示不限制")
+	timeout := fs.Duration("timeout", defaults.Timeout.Duration, "整个命令的超时时间,0表示不限制")
+	maxRetries := fs.Int("max-retries", defaults.MaxRetries, "ApiServer限流或繁忙时的重试次数")
+	retryBackoff := fs.Duration("retry-backoff", defaults.RetryBackoff.Duration, "首次重试前的等待时间,之后每次翻倍")
+	protobuf := fs.Bool("protobuf", defaults.Protobuf, "内置资源类型使用protobuf编码")
+	contexts := fs.String("contexts", "", "在kubeconfig中的多个context上执行,逗号分隔")
+	allContexts := fs.Bool("all-contexts", false, "在kubeconfig中的所有context上执行")
+	parallel := fs.Int("parallel", defaults.Parallel, "多集群执行时的最大并发数")
+	auditLog := fs.String("audit-log", defaults.AuditLog, "审计日志路径,为空时不记录")
+	fs.Usage = func() {
+		printUsage(out)
+		fmt.Fprintln(out, "全局参数:")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return defaults, defaultProfile(), nil, err
+	}
+	p, err := resolveProfile(*profileConfig, *profileName)
+	if err != nil {
+		return defaults, defaultProfile(), nil, err
+	}
+	opts := p.clientOptions
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "qps":
+			opts.QPS = float32(*qps)
+		case "burst":
+			opts.Burst = *burst
+		case "request-timeout":
+			opts.RequestTimeout.Duration = *requestTimeout
+		case "timeout":
+			opts.Timeout.Duration = *timeout
+		case "max-retries":
+			opts.MaxRetries = *maxRetries
+		case "retry-backoff":
+			opts.RetryBackoff.Duration = *retryBackoff
+		case "protobuf":
+			opts.Protobuf = *protobuf
+		case "contexts":
+			opts.Contexts = splitList(*contexts)
+		case "all-contexts":
+			opts.AllContexts = *allContexts
+		case "parallel":
+			opts.Parallel = *parallel
+		case "audit-log":
+			opts.AuditLog = *auditLog
+		case "kubeconfig":
+			p.Kubeconfig = *kubeConfig
+		case "context":
+			p.Context = *kubeContext
+		case "namespace":
+			p.Namespace = *namespace
+		case "manifest-dirs":
+			p.ManifestDirs = splitList(*manifestDirs)
+		case "output":
+			p.Output = *output
+		case "field-manager":
+			p.FieldManager = *fieldManager
+		case "read-only":
+			p.ReadOnly = *readOnly
+		}
+	})
+	if err := opts.validate(); err != nil {
+		return defaults, defaultProfile(), nil, err
+	}
+	if err := p.validate(); err != nil {
+		return defaults, defaultProfile(), nil, err
+	}
+	p.clientOptions = opts
+	return opts, p, fs.Args(), nil
+}
+
+/*
+   确定配置文件与profile名称并读取profile,再用环境变量覆盖
+   通过参数或环境变量指定的配置文件必须存在
+*/
+func resolveProfile(path, name string) (profile, error) {
+	required := true
+	if path == "" {
+		path = getenv(envConfig)
+	}
+	if path == "" {
+		path, required = defaultToolConfigPath(), false
+	}
+	if name == "" {
+		name = getenv(envProfile)
+	}
+	p, err := loadProfile(expandHome(path), name, required)
+	if err != nil {
+		return p, err
+	}
+	return p, p.applyEnv()
+}
+
+func (opts clientOptions) validate() error {
+	switch {
+	case opts.QPS <= 0:
+		return usageError("qps必须大于0")
+	case opts.Burst <= 0:
+		return usageError("burst必须大于0")
+	case opts.RequestTimeout.Duration < 0, opts.Timeout.Duration < 0, opts.RetryBackoff.Duration < 0:
+		return usageError("超时时间与重试间隔不能为负数")
+	case opts.MaxRetries < 0:
+		return usageError("max-retries不能为负数")
+	case opts.Parallel <= 0:
+		return usageError("parallel必须大于0")
+	}
+	return nil
+}
+
+/*
+   整个命令使用的ctx,Timeout为0时只能被取消
+*/
+func (opts clientOptions) context() (context.Context, context.CancelFunc) {
+	if opts.Timeout.Duration > 0 {
+		return context.WithTimeout(context.Background(), opts.Timeout.Duration)
+	}
+	return context.WithCancel(context.Background())
+}
+
+/*
+   将参数应用到rest.Config
+*/
+func (opts clientOptions) apply(config *rest.Config) {
+	config.QPS = opts.QPS
+	config.Burst = opts.Burst
+	if opts.Protobuf {
+		config.ContentType = runtime.ContentTypeProtobuf
+		config.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &clientTransport{next: rt, opts: opts}
+	})
+}
+
+/*
+   为每个请求设置超时,并在限流时按退避时间重试
+   rest.Request遇到带Retry-After的429与5xx响应时会自己再重试最多10次,且无法通过rest.Config关闭,
+   返回给client-go的响应去掉Retry-After,重试只由这里按MaxRetries执行
+*/
+type clientTransport struct {
+	next http.RoundTripper
+	opts clientOptions
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req)
+		if err != nil || attempt >= t.opts.MaxRetries || !retryable(resp) || (req.Body != nil && req.GetBody == nil) {
+			if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError) {
+				resp.Header.Del("Retry-After")
+			}
+			return resp, err
+		}
+		wait := t.backoff(attempt, resp)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func (t *clientTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.opts.RequestTimeout.Duration <= 0 || streamingRequest(req) {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.RequestTimeout.Duration)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	//读取完响应体之后才能取消ctx
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+/*
+   首次等待RetryBackoff,之后每次翻倍,ApiServer返回的Retry-After更长时以其为准
+*/
+func (t *clientTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := time.Duration(float64(t.opts.RetryBackoff.Duration) * math.Pow(2, float64(attempt)))
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		if retryAfter := time.Duration(seconds) * time.Second; retryAfter > wait {
+			wait = retryAfter
+		}
+	}
+	return wait
+}
+
+func retryable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+/*
+   watch、日志跟随以及exec/cp/port-forward的升级连接会持续很久,不设置单个请求的超时
+*/
+func streamingRequest(req *http.Request) bool {
+	query := req.URL.Query()
+	if watch := query.Get("watch"); watch == "true" || watch == "1" {
+		return true
+	}
+	if query.Get("follow") == "true" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
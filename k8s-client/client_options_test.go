@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	path := writeToolConfig(t, "currentProfile: dev\nprofiles:\n  dev:\n    qps: 20\n    requestTimeout: 10s\n    protobuf: true\n")
+	opts, p, args, err := parseGlobalFlags([]string{"-config", path, "-qps", "30", "-timeout", "1m", "list", "pvc"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultClientOptions()
+	want.QPS = 30
+	want.RequestTimeout.Duration = 10 * time.Second
+	want.Timeout.Duration = time.Minute
+	want.Protobuf = true
+	want.AuditLog = defaultAuditLog("dev")
+	if !reflect.DeepEqual(opts, want) || !reflect.DeepEqual(p.clientOptions, want) {
+		t.Errorf("opts = %+v, profile = %+v, want %+v", opts, p.clientOptions, want)
+	}
+	if !reflect.DeepEqual(args, []string{"list", "pvc"}) {
+		t.Errorf("args = %v", args)
+	}
+
+	//默认配置文件不存在时使用默认值
+	if opts, _, _, err := parseGlobalFlags([]string{"list"}, ioutil.Discard); err != nil || !reflect.DeepEqual(opts, defaultClientOptions()) {
+		t.Errorf("opts = %+v, err = %v", opts, err)
+	}
+
+	bad := writeToolConfig(t, "currentProfile: dev\nprofiles:\n  dev:\n    qsp: 20\n")
+	for _, tc := range []struct {
+		args []string
+		want errorKind
+	}{
+		{[]string{"-config", bad, "list"}, ErrInvalidManifest},
+		{[]string{"-config", writeToolConfig(t, "currentProfile: dev\nprofiles:\n  dev:\n    burst: 0\n"), "list"}, ErrUsage},
+		{[]string{"-qps", "0", "list"}, ErrUsage},
+		{[]string{"-max-retries", "-1", "list"}, ErrUsage},
+		{[]string{"-qps", "x", "list"}, ErrUsage},
+	} {
+		if _, _, _, err := parseGlobalFlags(tc.args, ioutil.Discard); errorKindOf(err) != tc.want {
+			t.Errorf("parseGlobalFlags(%v) error = %v, want %s", tc.args, err, tc.want)
+		}
+	}
+}
+
+/*
+   前throttled个请求返回429,retryAfter不为空时附带Retry-After,记录每次请求的请求体与Accept
+*/
+type throttlingServer struct {
+	mu         sync.Mutex
+	throttled  int
+	retryAfter string
+	bodies     []string
+	accept     string
+}
+
+func (s *throttlingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+	s.accept = r.Header.Get("Accept")
+	if len(s.bodies) <= s.throttled {
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{},"items":[{"metadata":{"name":"test-nginx-a"}}]}`))
+}
+
+func TestClientTransportRetry(t *testing.T) {
+	s := &throttlingServer{throttled: 2}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	opts := defaultClientOptions()
+	opts.RetryBackoff.Duration = time.Millisecond
+	opts.Protobuf = true
+	config := &rest.Config{Host: server.URL}
+	opts.apply(config)
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods, err := clientSet.CoreV1().Pods(TestNamespace).List(context.TODO(), meta_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || len(s.bodies) != 3 {
+		t.Errorf("pods = %d, requests = %d", len(pods.Items), len(s.bodies))
+	}
+	if !strings.HasPrefix(s.accept, "application/vnd.kubernetes.protobuf") {
+		t.Errorf("Accept = %q", s.accept)
+	}
+
+	//重试时重新发送请求体,超过重试次数后返回429
+	s = &throttlingServer{throttled: 5}
+	throttled := httptest.NewServer(s)
+	defer throttled.Close()
+	transport := &clientTransport{next: http.DefaultTransport, opts: opts}
+	req, _ := http.NewRequest(http.MethodPost, throttled.URL, strings.NewReader("body"))
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || !reflect.DeepEqual(s.bodies, []string{"body", "body", "body", "body"}) {
+		t.Errorf("status = %d, bodies = %v", resp.StatusCode, s.bodies)
+	}
+}
+
+/*
+   ApiServer一直返回429与Retry-After时,client-go不再叠加自己的重试,总请求数为MaxRetries+1
+*/
+func TestClientTransportRetryAfter(t *testing.T) {
+	s := &throttlingServer{throttled: 100, retryAfter: "0"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	opts := defaultClientOptions()
+	opts.RetryBackoff.Duration = time.Millisecond
+	config := &rest.Config{Host: server.URL}
+	opts.apply(config)
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clientSet.CoreV1().Pods(TestNamespace).List(context.TODO(), meta_v1.ListOptions{})
+	if !k8s_errors.IsTooManyRequests(err) {
+		t.Errorf("err = %v, want TooManyRequests", err)
+	}
+	if len(s.bodies) != opts.MaxRetries+1 {
+		t.Errorf("requests = %d, want %d", len(s.bodies), opts.MaxRetries+1)
+	}
+}
+
+func TestClientTransportTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	opts := defaultClientOptions()
+	opts.RequestTimeout.Duration = 10 * time.Millisecond
+	transport := &clientTransport{next: http.DefaultTransport, opts: opts}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/pods", nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if errorKindOf(wrapError("获取Pod列表", context.DeadlineExceeded)) != ErrTimeout {
+		t.Error("超时错误应分类为Timeout")
+	}
+
+	//watch请求不受单个请求超时限制
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/v1/pods?watch=true", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("body = %q, err = %v", body, err)
+	}
+}
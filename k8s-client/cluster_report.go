@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+/*
+   集群资源清单与容量报告
+*/
+type clusterReport struct {
+	Nodes      []nodeReport      `json:"nodes"`
+	Namespaces []namespaceReport `json:"namespaces"`
+	Storage    []storageReport   `json:"storage"`
+}
+
+/*
+   节点信息,Requests/Limits为调度到该节点且未结束的Pod之和
+*/
+type nodeReport struct {
+	Name              string   `json:"name"`
+	Roles             []string `json:"roles"`
+	Version           string   `json:"version"`
+	Ready             bool     `json:"ready"`
+	Conditions        []string `json:"conditions,omitempty"` //异常的状况,如MemoryPressure
+	Unschedulable     bool     `json:"unschedulable,omitempty"`
+	Taints            []string `json:"taints,omitempty"`
+	Pods              int      `json:"pods"`
+	AllocatableCPU    string   `json:"allocatableCPU"`
+	AllocatableMemory string   `json:"allocatableMemory"`
+	AllocatablePods   string   `json:"allocatablePods"`
+	RequestsCPU       string   `json:"requestsCPU"`
+	RequestsMemory    string   `json:"requestsMemory"`
+	LimitsCPU         string   `json:"limitsCPU"`
+	LimitsMemory      string   `json:"limitsMemory"`
+	CPURequestPct     int64    `json:"cpuRequestPercent"`
+	MemoryRequestPct  int64    `json:"memoryRequestPercent"`
+}
+
+type namespaceReport struct {
+	Name         string `json:"name"`
+	Phase        string `json:"phase"`
+	Deployments  int    `json:"deployments"`
+	StatefulSets int    `json:"statefulSets"`
+	DaemonSets   int    `json:"daemonSets"`
+	Jobs         int    `json:"jobs"`
+	CronJobs     int    `json:"cronJobs"`
+	Pods         int    `json:"pods"`
+	Services     int    `json:"services"`
+	PVCs         int    `json:"pvcs"`
+}
+
+/*
+   按StorageClass汇总的PV与PVC,PVC容量为请求的容量
+*/
+type storageReport struct {
+	StorageClass string `json:"storageClass"`
+	PVs          int    `json:"pvs"`
+	PVCapacity   string `json:"pvCapacity"`
+	PVCs         int    `json:"pvcs"`
+	BoundPVCs    int    `json:"boundPVCs"`
+	PVCRequests  string `json:"pvcRequests"`
+}
+
+/*
+   Pod的有效requests与limits,与调度器的计算方式一致:
+   max(所有容器之和, 任一初始化容器) + overhead
+*/
+func podResources(pod *core_v1.Pod) (requests, limits core_v1.ResourceList) {
+	requests, limits = core_v1.ResourceList{}, core_v1.ResourceList{}
+	for _, c := range pod.Spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+	for _, c := range pod.Spec.InitContainers {
+		maxResources(requests, c.Resources.Requests)
+		maxResources(limits, c.Resources.Limits)
+	}
+	addResources(requests, pod.Spec.Overhead)
+	addResources(limits, pod.Spec.Overhead)
+	return requests, limits
+}
+
+func addResources(total, list core_v1.ResourceList) {
+	for name, quantity := range list {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+func maxResources(total, list core_v1.ResourceList) {
+	for name, quantity := range list {
+		if current, ok := total[name]; !ok || quantity.Cmp(current) > 0 {
+			total[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func percent(used, total resource.Quantity) int64 {
+	if total.IsZero() {
+		return 0
+	}
+	return used.MilliValue() * 100 / total.MilliValue()
+}
+
+func quantityString(list core_v1.ResourceList, name core_v1.ResourceName) string {
+	q := list[name]
+	return q.String()
+}
+
+/*
+   节点角色取自node-role.kubernetes.io/<角色>标签
+*/
+func nodeRoles(node *core_v1.Node) []string {
+	var roles []string
+	for key := range node.Labels {
+		if strings.HasPrefix(key, nodeRoleLabelPrefix) {
+			roles = append(roles, strings.TrimPrefix(key, nodeRoleLabelPrefix))
+		}
+	}
+	sort.Strings(roles)
+	if len(roles) == 0 {
+		roles = []string{"<none>"}
+	}
+	return roles
+}
+
+func buildNodeReport(node *core_v1.Node, pods []core_v1.Pod) nodeReport {
+	r := nodeReport{
+		Name:          node.Name,
+		Roles:         nodeRoles(node),
+		Version:       node.Status.NodeInfo.KubeletVersion,
+		Unschedulable: node.Spec.Unschedulable,
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == core_v1.NodeReady {
+			r.Ready = cond.Status == core_v1.ConditionTrue
+			if !r.Ready {
+				r.Conditions = append(r.Conditions, fmt.Sprintf("Ready=%s", cond.Status))
+			}
+		} else if cond.Status == core_v1.ConditionTrue {
+			r.Conditions = append(r.Conditions, string(cond.Type))
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		s := taint.Key
+		if taint.Value != "" {
+			s += "=" + taint.Value
+		}
+		r.Taints = append(r.Taints, s+":"+string(taint.Effect))
+	}
+	requests, limits := core_v1.ResourceList{}, core_v1.ResourceList{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName != node.Name || pod.Status.Phase == core_v1.PodSucceeded || pod.Status.Phase == core_v1.PodFailed {
+			continue
+		}
+		r.Pods++
+		podRequests, podLimits := podResources(pod)
+		addResources(requests, podRequests)
+		addResources(limits, podLimits)
+	}
+	allocatable := node.Status.Allocatable
+	r.AllocatableCPU = quantityString(allocatable, core_v1.ResourceCPU)
+	r.AllocatableMemory = quantityString(allocatable, core_v1.ResourceMemory)
+	r.AllocatablePods = quantityString(allocatable, core_v1.ResourcePods)
+	r.RequestsCPU = quantityString(requests, core_v1.ResourceCPU)
+	r.RequestsMemory = quantityString(requests, core_v1.ResourceMemory)
+	r.LimitsCPU = quantityString(limits, core_v1.ResourceCPU)
+	r.LimitsMemory = quantityString(limits, core_v1.ResourceMemory)
+	r.CPURequestPct = percent(requests[core_v1.ResourceCPU], allocatable[core_v1.ResourceCPU])
+	r.MemoryRequestPct = percent(requests[core_v1.ResourceMemory], allocatable[core_v1.ResourceMemory])
+	return r
+}
+
+func buildClusterReport(ctx context.Context, clientSet kubernetes.Interface) (*clusterReport, error) {
+	report := &clusterReport{}
+	nodes, err := clientSet.CoreV1().Nodes().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取节点列表", err)
+	}
+	pods, err := clientSet.CoreV1().Pods("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	for i := range nodes.Items {
+		report.Nodes = append(report.Nodes, buildNodeReport(&nodes.Items[i], pods.Items))
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].Name < report.Nodes[j].Name
+	})
+
+	if err := report.countNamespaces(ctx, clientSet, pods.Items); err != nil {
+		return nil, err
+	}
+	if err := report.sumStorage(ctx, clientSet); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (report *clusterReport) countNamespaces(ctx context.Context, clientSet kubernetes.Interface, pods []core_v1.Pod) error {
+	namespaces, err := clientSet.CoreV1().Namespaces().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取命名空间列表", err)
+	}
+	byName := map[string]*namespaceReport{}
+	for _, ns := range namespaces.Items {
+		byName[ns.Name] = &namespaceReport{Name: ns.Name, Phase: string(ns.Status.Phase)}
+	}
+	//对象所在的命名空间不在列表中时(如没有list命名空间的权限)也计入
+	count := func(namespace string, field func(*namespaceReport) *int) {
+		r, ok := byName[namespace]
+		if !ok {
+			r = &namespaceReport{Name: namespace}
+			byName[namespace] = r
+		}
+		*field(r)++
+	}
+	for _, pod := range pods {
+		count(pod.Namespace, func(r *namespaceReport) *int { return &r.Pods })
+	}
+	deployments, err := clientSet.AppsV1().Deployments("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Deployment列表", err)
+	}
+	for _, obj := range deployments.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.Deployments })
+	}
+	statefulSets, err := clientSet.AppsV1().StatefulSets("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取StatefulSet列表", err)
+	}
+	for _, obj := range statefulSets.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.StatefulSets })
+	}
+	daemonSets, err := clientSet.AppsV1().DaemonSets("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取DaemonSet列表", err)
+	}
+	for _, obj := range daemonSets.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.DaemonSets })
+	}
+	jobs, err := clientSet.BatchV1().Jobs("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Job列表", err)
+	}
+	for _, obj := range jobs.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.Jobs })
+	}
+	cronJobs, err := clientSet.BatchV1().CronJobs("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取CronJob列表", err)
+	}
+	for _, obj := range cronJobs.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.CronJobs })
+	}
+	services, err := clientSet.CoreV1().Services("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Service列表", err)
+	}
+	for _, obj := range services.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.Services })
+	}
+	claims, err := clientSet.CoreV1().PersistentVolumeClaims("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取PVC列表", err)
+	}
+	for _, obj := range claims.Items {
+		count(obj.Namespace, func(r *namespaceReport) *int { return &r.PVCs })
+	}
+	for _, r := range byName {
+		report.Namespaces = append(report.Namespaces, *r)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Name < report.Namespaces[j].Name
+	})
+	return nil
+}
+
+func (report *clusterReport) sumStorage(ctx context.Context, clientSet kubernetes.Interface) error {
+	type totals struct {
+		storageReport
+		capacity, requests resource.Quantity
+	}
+	byClass := map[string]*totals{}
+	get := func(class string) *totals {
+		if class == "" {
+			class = "<none>"
+		}
+		if byClass[class] == nil {
+			byClass[class] = &totals{storageReport: storageReport{StorageClass: class}}
+		}
+		return byClass[class]
+	}
+	pvs, err := clientSet.CoreV1().PersistentVolumes().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取PV列表", err)
+	}
+	for _, pv := range pvs.Items {
+		t := get(pv.Spec.StorageClassName)
+		t.PVs++
+		t.capacity.Add(pv.Spec.Capacity[core_v1.ResourceStorage])
+	}
+	claims, err := clientSet.CoreV1().PersistentVolumeClaims("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取PVC列表", err)
+	}
+	for _, pvc := range claims.Items {
+		class := ""
+		if pvc.Spec.StorageClassName != nil {
+			class = *pvc.Spec.StorageClassName
+		}
+		t := get(class)
+		t.PVCs++
+		if pvc.Status.Phase == core_v1.ClaimBound {
+			t.BoundPVCs++
+		}
+		t.requests.Add(pvc.Spec.Resources.Requests[core_v1.ResourceStorage])
+	}
+	for _, t := range byClass {
+		t.PVCapacity = t.capacity.String()
+		t.PVCRequests = t.requests.String()
+		report.Storage = append(report.Storage, t.storageReport)
+	}
+	sort.Slice(report.Storage, func(i, j int) bool {
+		return report.Storage[i].StorageClass < report.Storage[j].StorageClass
+	})
+	return nil
+}
+
+func joinOrNone(items []string) string {
+	if len(items) == 0 {
+		return "<none>"
+	}
+	return strings.Join(items, ",")
+}
+
+func printClusterReport(out io.Writer, report *clusterReport) {
+	fmt.Fprintln(out, "节点:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLES\tVERSION\tSTATUS\tTAINTS\tPODS\tCPU(REQ/LIM/ALLOC)\tMEMORY(REQ/LIM/ALLOC)")
+	for _, n := range report.Nodes {
+		status := "Ready"
+		if !n.Ready {
+			status = "NotReady"
+		}
+		if n.Unschedulable {
+			status += ",SchedulingDisabled"
+		}
+		if len(n.Conditions) > 0 && n.Ready {
+			status += "," + strings.Join(n.Conditions, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d/%s\t%s/%s/%s (%d%%)\t%s/%s/%s (%d%%)\n",
+			n.Name, strings.Join(n.Roles, ","), n.Version, status, joinOrNone(n.Taints), n.Pods, n.AllocatablePods,
+			n.RequestsCPU, n.LimitsCPU, n.AllocatableCPU, n.CPURequestPct,
+			n.RequestsMemory, n.LimitsMemory, n.AllocatableMemory, n.MemoryRequestPct)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "\n命名空间:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPHASE\tDEPLOY\tSTS\tDS\tJOB\tCRONJOB\tPOD\tSVC\tPVC")
+	for _, ns := range report.Namespaces {
+		phase := ns.Phase
+		if phase == "" {
+			phase = "<unknown>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", ns.Name, phase, ns.Deployments, ns.StatefulSets, ns.DaemonSets, ns.Jobs, ns.CronJobs, ns.Pods, ns.Services, ns.PVCs)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "\n存储:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGECLASS\tPV\tPV CAPACITY\tPVC\tBOUND\tPVC REQUESTS")
+	for _, s := range report.Storage {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%s\n", s.StorageClass, s.PVs, s.PVCapacity, s.PVCs, s.BoundPVCs, s.PVCRequests)
+	}
+	w.Flush()
+}
+
+func runCluster(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "report" {
+		return usageError("用法: cluster report [-o table|json]")
+	}
+	fs := newFlagSet("cluster report", out)
+	format := fs.String("o", activeProfile.output("table", "json"), "输出格式: table|json")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return usageError("不支持的输出格式: %s", *format)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	report, err := buildClusterReport(ctx, clientSet)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+	printClusterReport(out, report)
+	return nil
+}
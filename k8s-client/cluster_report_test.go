@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func resources(cpu, memory string) core_v1.ResourceList {
+	list := core_v1.ResourceList{}
+	if cpu != "" {
+		list[core_v1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[core_v1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func newReportNode(name, role string, ready bool, conditions ...core_v1.NodeConditionType) *core_v1.Node {
+	status := core_v1.ConditionTrue
+	if !ready {
+		status = core_v1.ConditionFalse
+	}
+	node := &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Status: core_v1.NodeStatus{
+			NodeInfo:    core_v1.NodeSystemInfo{KubeletVersion: "v1.23.1"},
+			Conditions:  []core_v1.NodeCondition{{Type: core_v1.NodeReady, Status: status}},
+			Allocatable: core_v1.ResourceList{core_v1.ResourceCPU: resource.MustParse("2"), core_v1.ResourceMemory: resource.MustParse("2Gi"), core_v1.ResourcePods: resource.MustParse("110")},
+		},
+	}
+	if role != "" {
+		node.Labels[nodeRoleLabelPrefix+role] = ""
+	}
+	for _, cond := range conditions {
+		node.Status.Conditions = append(node.Status.Conditions, core_v1.NodeCondition{Type: cond, Status: core_v1.ConditionTrue})
+	}
+	return node
+}
+
+func newReportPod(namespace, name, node string, phase core_v1.PodPhase, containers ...core_v1.ResourceRequirements) *core_v1.Pod {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       core_v1.PodSpec{NodeName: node},
+		Status:     core_v1.PodStatus{Phase: phase},
+	}
+	for _, r := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, core_v1.Container{Name: "c", Resources: r})
+	}
+	return pod
+}
+
+func newReportObjects() []runtime.Object {
+	master := newReportNode("master", "control-plane", true)
+	master.Labels[nodeRoleLabelPrefix+"master"] = ""
+	master.Spec.Taints = []core_v1.Taint{{Key: "node-role.kubernetes.io/master", Effect: core_v1.TaintEffectNoSchedule}}
+	node1 := newReportNode("node1", "", true, core_v1.NodeMemoryPressure)
+	node1.Spec.Unschedulable = true
+	node2 := newReportNode("node2", "", false)
+
+	//初始化容器的requests大于所有容器之和时按初始化容器计算
+	withInit := newReportPod(TestNamespace, "test-nginx-a", "node1", core_v1.PodRunning,
+		core_v1.ResourceRequirements{Requests: resources("100m", "128Mi"), Limits: resources("500m", "256Mi")},
+		core_v1.ResourceRequirements{Requests: resources("50m", "")})
+	withInit.Spec.InitContainers = []core_v1.Container{{Name: "init", Resources: core_v1.ResourceRequirements{Requests: resources("", "512Mi")}}}
+
+	standard, fast := "standard", "fast"
+	newPV := func(name, class, capacity string) *core_v1.PersistentVolume {
+		return &core_v1.PersistentVolume{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name},
+			Spec:       core_v1.PersistentVolumeSpec{StorageClassName: class, Capacity: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(capacity)}},
+		}
+	}
+	newPVC := func(name string, class *string, request string, phase core_v1.PersistentVolumeClaimPhase) *core_v1.PersistentVolumeClaim {
+		return &core_v1.PersistentVolumeClaim{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace},
+			Spec: core_v1.PersistentVolumeClaimSpec{
+				StorageClassName: class,
+				Resources:        core_v1.ResourceRequirements{Requests: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(request)}},
+			},
+			Status: core_v1.PersistentVolumeClaimStatus{Phase: phase},
+		}
+	}
+	return []runtime.Object{
+		master, node1, node2,
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "kube-system"}, Status: core_v1.NamespaceStatus{Phase: core_v1.NamespaceActive}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: TestNamespace}, Status: core_v1.NamespaceStatus{Phase: core_v1.NamespaceActive}},
+		newReportPod("kube-system", "kube-apiserver-master", "master", core_v1.PodRunning, core_v1.ResourceRequirements{Requests: resources("250m", "")}),
+		newReportPod("kube-system", "coredns-a", "node1", core_v1.PodRunning, core_v1.ResourceRequirements{Requests: resources("100m", "70Mi"), Limits: resources("", "170Mi")}),
+		withInit,
+		newReportPod(TestNamespace, "test-job-a", "node1", core_v1.PodSucceeded, core_v1.ResourceRequirements{Requests: resources("1", "1Gi")}),
+		newReportPod(TestNamespace, "test-nginx-pending", "", core_v1.PodPending, core_v1.ResourceRequirements{Requests: resources("1", "1Gi")}),
+		&apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace}},
+		&apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}},
+		&apps_v1.DaemonSet{ObjectMeta: meta_v1.ObjectMeta{Name: "calico-node", Namespace: "kube-system"}},
+		&core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace}},
+		newPV("pv-a", standard, "1Gi"),
+		newPV("pv-b", standard, "500Mi"),
+		newPV("pv-c", "", "2Gi"),
+		newPVC("test-pvc", &standard, "1Gi", core_v1.ClaimBound),
+		newPVC("test-pvc-fast", &fast, "10Gi", core_v1.ClaimPending),
+	}
+}
+
+func TestClusterReport(t *testing.T) {
+	report, err := buildClusterReport(context.TODO(), fake.NewSimpleClientset(newReportObjects()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printClusterReport(&out, report)
+	assertGolden(t, "cluster_report.golden", out.Bytes())
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "cluster_report.json.golden", append(data, '\n'))
+}
+
+func TestPodResources(t *testing.T) {
+	pod := newReportPod(TestNamespace, "p", "node1", core_v1.PodRunning,
+		core_v1.ResourceRequirements{Requests: resources("100m", "64Mi")},
+		core_v1.ResourceRequirements{Requests: resources("200m", "64Mi")})
+	pod.Spec.InitContainers = []core_v1.Container{{Resources: core_v1.ResourceRequirements{Requests: resources("500m", "100Mi")}}}
+	pod.Spec.Overhead = resources("10m", "")
+	requests, _ := podResources(pod)
+	if cpu := requests[core_v1.ResourceCPU]; cpu.String() != "510m" {
+		t.Errorf("cpu = %s, want 510m", cpu.String())
+	}
+	if memory := requests[core_v1.ResourceMemory]; memory.String() != "128Mi" {
+		t.Errorf("memory = %s, want 128Mi", memory.String())
+	}
+}
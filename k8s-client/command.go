@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+   子命令定义
+   用法: k8s-client <命令> [参数]
+*/
+type command struct {
+	name   string
+	args   string
+	usage  string
+	run    func(ctx context.Context, args []string, out io.Writer) error
+	fanOut bool //支持-contexts/-all-contexts多集群执行
+	server bool //长期运行的服务,不受-timeout限制,只在收到中断信号时结束
+}
+
+var commands []command
+
+/*
+   命令使用的ctx,服务在收到中断信号时结束,单个请求仍受RequestTimeout限制,其他命令受-timeout限制
+*/
+func (cmd command) context(opts clientOptions) (context.Context, context.CancelFunc) {
+	if cmd.server {
+		return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	}
+	return opts.context()
+}
+
+func init() {
+	commands = []command{
+		{name: "apply", args: "[-wait] [-timeout 2m] [-pull-secret docker-harbor] <资源类型>", usage: "创建或更新清单目录(默认./yaml)下对应的资源", run: runApply, fanOut: true},
+		{name: "list", args: "<资源类型>", usage: "获取资源列表", run: runList, fanOut: true},
+		{name: "delete", args: "[-yes] <资源类型>", usage: "删除资源", run: runDelete, fanOut: true},
+		{name: "status", args: "<资源类型> [名称]", usage: "查看工作负载的就绪状态,支持deployment/statefulset/daemonset/job/cronjob", run: runStatus, fanOut: true},
+		{name: "describe", args: "<资源类型>/<名称>", usage: "查看deployment/statefulset/daemonset及其ReplicaSet、Pod、Service端点、引用的ConfigMap/Secret/PVC与PV/StorageClass", run: runDescribe, fanOut: true},
+		{name: "graph", args: "[-namespace ns] [-o dot|mermaid|json]", usage: "导出命名空间内对象的关系图: 所有关系、selector、挂载、引用以及PVC/PV/StorageClass绑定", run: runGraph, fanOut: true},
+		{name: "scale", args: "[-wait] [-timeout 5m] -replicas N <资源类型> [名称]", usage: "通过scale子资源修改deployment/statefulset的副本数", run: runScale},
+		{name: "rollout", args: "restart|pause|resume|status|history|undo [-wait] [-timeout 5m] [-to-revision N] <资源类型> [名称]", usage: "重启、暂停、恢复发布,查看发布状态与历史版本,回滚到历史版本", run: runRollout},
+		{name: "set", args: "image [-wait] [-timeout 5m] <资源类型> [名称] 容器名=镜像...", usage: "修改容器镜像,容器名为*时修改所有容器", run: runSet},
+		{name: "logs", args: "[-f] [-since 10m | -since-time t] [-tail N] [-previous] [-c 容器] [-include re] [-exclude re] <资源类型>/<名称>", usage: "并发输出工作负载所有Pod中所有容器的日志", run: runLogs},
+		{name: "events", args: "[-f] <资源类型>/<名称>", usage: "按时间顺序合并输出对象及其所属对象的事件,如Deployment的ReplicaSet与Pod、PVC绑定的PV", run: runEvents},
+		{name: "exec", args: "[-c 容器] [-i] [-t] <资源类型>/<名称> <命令> [参数...]", usage: "在工作负载或Service选中的Pod中执行命令", run: runExec},
+		{name: "cp", args: "[-c 容器] <源> <目标>", usage: "通过tar在本地与Pod之间复制文件或目录,Pod中的路径格式为 资源类型/名称:路径", run: runCopy},
+		{name: "port-forward", args: "[-address localhost] <资源类型>/<名称> [本地端口:]远端端口...", usage: "将本地端口转发到Pod,目标为Service时远端端口为Service端口", run: runPortForward},
+		{name: "cluster", args: "report [-o table|json]", usage: "输出节点角色、版本、状况、污点、可分配资源与Pod requests/limits,各命名空间工作负载数量,按StorageClass汇总的PV/PVC", run: runCluster, fanOut: true},
+		{name: "node", args: "cordon|uncordon|drain [-delete-emptydir-data] [-force] [-grace-period N] [-timeout 5m] [-replacement-timeout 1m] <节点>", usage: "禁止或恢复节点调度,通过eviction API驱逐节点上的Pod(遵守PodDisruptionBudget,跳过DaemonSet与mirror Pod)并输出替代Pod所在的节点", run: runNode},
+		{name: "namespace", args: "diagnose [-namespace ns] [-remove-finalizers] [-finalize-namespace] [-yes]", usage: "诊断卡在Terminating的命名空间: 状况、剩余对象及其finalizer、不可用的聚合API,确认后可移除finalizer", run: runNamespace},
+		{name: "pull-secret", args: "[-secret docker-harbor] [-from ns] [-namespaces a,b] [-namespace-selector k=v] [-service-account default] [-inject]", usage: "将镜像拉取密文复制到目标命名空间并挂载到ServiceAccount或注入工作负载的Pod模板", run: runPullSecret},
+		{name: "create-sa", args: "[-namespace ns] <名称>", usage: "创建ServiceAccount", run: runCreateServiceAccount},
+		{name: "create-role", args: "[-namespace ns] [-cluster] [-read-only | -verb get,list] -resource deployments,pods/log [-resource-name n] <名称>", usage: "创建或更新Role/ClusterRole", run: runCreateRole},
+		{name: "create-binding", args: "[-namespace ns] [-cluster] -role r | -clusterrole cr [-serviceaccount ns:sa] [-user u] [-group g] <名称>", usage: "创建或更新RoleBinding/ClusterRoleBinding", run: runCreateBinding},
+		{name: "who-can", args: "[-namespace ns] [-resource-name n] <动作> <资源>", usage: "根据集群中的RBAC对象离线计算拥有权限的主体", run: runWhoCan, fanOut: true},
+		{name: "audit", args: "query [-object 类型[/名称]] [-namespace ns] [-verb v] [-token t] [-since 1h | -since-time t] [-until t] [-o table|json] | undo [-force] [-yes] <记录ID>", usage: "查询本地审计日志中的创建、更新、patch与删除记录,撤销一条记录恢复对象之前的状态", run: runAudit},
+		{name: "serve", args: "[-address 127.0.0.1:8080] [-tls-cert f -tls-key f] [-insecure] [-tokens ./tokens.yaml] [-ui]", usage: "以JSON REST API提供各资源类型的增删改查、清单apply(支持dryRun与差异)、伸缩与重启以及Server-Sent Events watch,需要Bearer token,OpenAPI描述见/openapi.json,-ui在/ui/提供实时更新的页面", run: runServe, server: true},
+		{name: "lint", args: "[-node-port-range 30000-32767] [路径...]", usage: "校验清单集合中资源之间的引用关系,默认为清单目录(默认./yaml)", run: runLint},
+	}
+}
+
+/*
+   资源类型与对应的操作函数,manifest为清单文件名,在profile的manifestDirs中查找,secret在代码中构造没有清单
+*/
+type resourceOps struct {
+	createOrUpdate func(context.Context, kubernetes.Interface, io.Writer) error
+	list           func(context.Context, kubernetes.Interface, io.Writer) error
+	delete         func(context.Context, kubernetes.Interface, io.Writer) error
+	manifest       string
+}
+
+var resourceKinds = map[string]resourceOps{
+	"namespace":     {createOrUpdateNamespace, listNamespace, deleteNamespace, "namespace.yaml"},
+	"configmap":     {createOrUpdateConfigMap, listConfigMap, deleteConfigMap, "configMap.yaml"},
+	"secret":        {createOrUpdateSecret, listSecret, deleteSecret, ""},
+	"deployment":    {createOrUpdateDeployment, listDeployment, deleteDeployment, "deployment.yaml"},
+	"statefulset":   {createOrUpdateStatefulSet, listStatefulSet, deleteStatefulSet, "statefulSet.yaml"},
+	"daemonset":     {createOrUpdateDaemonSet, listDaemonSet, deleteDaemonSet, "daemonSet.yaml"},
+	"job":           {createOrUpdateJob, listJob, deleteJob, "job.yaml"},
+	"cronjob":       {createOrUpdateCronJob, listCronJob, deleteCronJob, "cronJob.yaml"},
+	"service":       {createOrUpdateService, listService, deleteService, "service.yaml"},
+	"ingress":       {createOrUpdateIngress, listIngress, deleteIngress, "ingress.yaml"},
+	"networkpolicy": {createOrUpdateNetworkPolicy, listNetworkPolicy, deleteNetworkPolicy, "networkPolicy.yaml"},
+	"hpa":           {createOrUpdateHPA, listHPA, deleteHPA, "horizontalPodAutoscaler.yaml"},
+	"storageclass":  {createOrUpdateStorage, listStorage, deleteStorage, "storageClass.yaml"},
+	"pv":            {createOrUpdatePV, listPV, deletePV, "persistentVolume.yaml"},
+	"pvc":           {createOrUpdatePVC, listPVC, deletePVC, "persistentVolumeClaim.yaml"},
+}
+
+var kindAliases = map[string]string{
+	"ns":     "namespace",
+	"cm":     "configmap",
+	"deploy": "deployment",
+	"sts":    "statefulset",
+	"ds":     "daemonset",
+	"cj":     "cronjob",
+	"svc":    "service",
+	"ing":    "ingress",
+	"netpol": "networkpolicy",
+	"sc":     "storageclass",
+}
+
+func run(args []string, out io.Writer) error {
+	opts, p, args, err := parseGlobalFlags(args, out)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		printUsage(out)
+		return usageError("缺少命令")
+	}
+	clientOpts = opts
+	activeProfile = p
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		ctx, cancel := cmd.context(opts)
+		defer cancel()
+		if len(opts.Contexts) > 0 || opts.AllContexts {
+			return runFanOut(ctx, cmd, opts, args[1:], out)
+		}
+		if err := cmd.run(ctx, args[1:], out); err != nil && !errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return nil
+	}
+	if args[0] == "help" {
+		printUsage(out)
+		return nil
+	}
+	printUsage(out)
+	return usageError("未知命令: %s", args[0])
+}
+
+/*
+   在多个集群上并发执行命令,按集群输出结果并汇总
+*/
+func runFanOut(ctx context.Context, cmd command, opts clientOptions, args []string, out io.Writer) error {
+	if !cmd.fanOut {
+		return usageError("%s 不支持多集群执行", cmd.name)
+	}
+	contexts, err := resolveContexts(opts)
+	if err != nil {
+		return err
+	}
+	if len(contexts) == 0 {
+		return usageError("kubeconfig中没有context")
+	}
+	results := fanOut(ctx, contexts, opts.Parallel, func(ctx context.Context, out io.Writer) error {
+		if err := cmd.run(ctx, args, out); err != nil && !errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return nil
+	})
+	return printFanOut(out, results)
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "用法: k8s-client [全局参数] <命令> [参数]")
+	fmt.Fprintln(out, "命令:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	w.Flush()
+	var fanOut []string
+	for _, cmd := range commands {
+		if cmd.fanOut {
+			fanOut = append(fanOut, cmd.name)
+		}
+	}
+	fmt.Fprintf(out, "支持多集群执行(-contexts a,b 或 -all-contexts)的命令: %s\n", strings.Join(fanOut, ", "))
+	kinds := make([]string, 0, len(resourceKinds))
+	for kind := range resourceKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(out, "资源类型: %s\n", strings.Join(kinds, ", "))
+}
+
+func newFlagSet(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	return fs
+}
+
+/*
+   解析命令参数,解析错误统一转换为usageError,-h时返回flag.ErrHelp
+*/
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError("%v", err)
+	}
+	return nil
+}
+
+/*
+   从参数中解析唯一的资源类型
+*/
+func parseKind(args []string) (string, resourceOps, error) {
+	if len(args) != 1 {
+		return "", resourceOps{}, usageError("需要且只能指定一个资源类型")
+	}
+	kind := strings.ToLower(args[0])
+	if alias, ok := kindAliases[kind]; ok {
+		kind = alias
+	}
+	ops, ok := resourceKinds[kind]
+	if !ok {
+		return "", resourceOps{}, usageError("不支持的资源类型: %s", args[0])
+	}
+	return kind, ops, nil
+}
+
+func runApply(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("apply", out)
+	wait := fs.Bool("wait", false, "等待资源就绪,目前支持pvc(等待Bound)")
+	timeout := fs.Duration("timeout", 2*time.Minute, "等待超时时间")
+	pullSecret := fs.String("pull-secret", "", "创建或更新前为Pod模板注入该dockerconfigjson密文,仅对拉取其中仓库镜像的工作负载生效")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	kind, ops, err := parseKind(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *wait && kind != "pvc" {
+		return usageError("-wait 目前仅支持pvc")
+	}
+	if *pullSecret != "" {
+		if !hasPodTemplate(kind) {
+			return usageError("-pull-secret 仅支持带Pod模板的工作负载: %s", strings.Join(podTemplateKinds, ", "))
+		}
+		ctx = withPullSecret(ctx, *pullSecret)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ops.createOrUpdate(ctx, clientSet, out); err != nil {
+		return err
+	}
+	if !*wait {
+		return nil
+	}
+	name, err := manifestName(ops.manifest)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	return waitForPVCBound(ctx, clientSet, activeProfile.Namespace, name, out)
+}
+
+/*
+   读取清单文件中对象的名称
+*/
+func manifestName(file string) (string, error) {
+	if file == "" {
+		return "", usageError("该资源类型没有清单文件,需要指定名称")
+	}
+	obj := struct {
+		Metadata meta_v1.ObjectMeta `json:"metadata"`
+	}{}
+	if err := readManifest(activeProfile.manifestPath(file), &obj); err != nil {
+		return "", err
+	}
+	return obj.Metadata.Name, nil
+}
+
+func runList(ctx context.Context, args []string, out io.Writer) error {
+	_, ops, err := parseKind(args)
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return ops.list(ctx, clientSet, out)
+}
+
+func runDelete(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("delete", out)
+	yes := fs.Bool("yes", false, "profile开启confirmDelete时跳过确认")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	kind, ops, err := parseKind(fs.Args())
+	if err != nil {
+		return err
+	}
+	if activeProfile.ConfirmDelete && !*yes {
+		//多集群执行时各集群并发执行,无法逐个确认
+		if kubeContextFrom(ctx) != "" {
+			return usageError("%s开启了confirmDelete,多集群执行时需要-yes", activeProfile.describe())
+		}
+		if !confirm(os.Stdin, out, fmt.Sprintf("将删除命名空间%s中的%s", activeProfile.Namespace, kind), kind) {
+			return &clientError{Kind: ErrUsage, Action: "删除资源", Err: fmt.Errorf("未确认,已取消")}
+		}
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return ops.delete(ctx, clientSet, out)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRunUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", nil, ExitUsage},
+		{"help", []string{"help"}, ExitOK},
+		{"unknown command", []string{"create"}, ExitUsage},
+		{"missing kind", []string{"list"}, ExitUsage},
+		{"unknown kind", []string{"delete", "pod"}, ExitUsage},
+		{"bad flag", []string{"apply", "-foo", "pvc"}, ExitUsage},
+		{"wait unsupported kind", []string{"apply", "-wait", "deployment"}, ExitUsage},
+		{"flag help", []string{"apply", "-h"}, ExitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(tt.args, &out)
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode = %d, want %d (err: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseKindAliases(t *testing.T) {
+	for alias, kind := range kindAliases {
+		got, _, err := parseKind([]string{alias})
+		if err != nil || got != kind {
+			t.Errorf("parseKind(%q) = %q, %v; want %q", alias, got, err, kind)
+		}
+	}
+}
+
+func TestCommandContext(t *testing.T) {
+	opts := defaultClientOptions()
+	opts.Timeout.Duration = time.Minute
+	for _, cmd := range commands {
+		ctx, cancel := cmd.context(opts)
+		_, hasDeadline := ctx.Deadline()
+		cancel()
+		//serve不受-timeout限制,否则到期后服务会退出
+		if want := cmd.name != "serve"; hasDeadline != want {
+			t.Errorf("%s: deadline = %v, want %v", cmd.name, hasDeadline, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+   cp的一端,Pod中的路径格式为 资源类型/名称:路径,如 deployment/test-nginx:/etc/nginx/html
+*/
+type copyPath struct {
+	Kind string //为空表示本地路径
+	Name string
+	Path string
+}
+
+func parseCopyPath(s string) copyPath {
+	i := strings.Index(s, ":")
+	if i <= 0 || !strings.Contains(s[:i], "/") {
+		return copyPath{Path: s}
+	}
+	kind, name, err := parseObjectRef(s[:i])
+	if err != nil {
+		return copyPath{Path: s}
+	}
+	return copyPath{Kind: kind, Name: name, Path: s[i+1:]}
+}
+
+/*
+   将本地文件或目录复制为Pod中的dst,与kubectl cp一致: 打包为tar通过exec传给容器中的tar解压
+*/
+func copyToPod(executor podExecutor, namespace, pod, container, src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return &clientError{Kind: ErrNotFound, Action: "读取本地文件", Err: err}
+	}
+	dst = path.Clean(dst)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, src, path.Base(dst)))
+	}()
+	defer reader.Close()
+	return execCommand(executor, namespace, pod, container, []string{"tar", "xmf", "-", "-C", path.Dir(dst)}, reader, io.Discard)
+}
+
+/*
+   将Pod中的文件或目录src复制为本地的dst,容器中需要有tar
+   只解压普通文件与目录,跳过符号链接等,并拒绝解压到dst之外的条目
+*/
+func copyFromPod(executor podExecutor, namespace, pod, container, src, dst string, out io.Writer) error {
+	src = path.Clean(src)
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := readTar(reader, path.Base(src), dst, out)
+		//解压失败时继续读完tar输出,避免exec阻塞
+		io.Copy(io.Discard, reader)
+		done <- err
+	}()
+	err := execCommand(executor, namespace, pod, container, []string{"tar", "cf", "-", "-C", path.Dir(src), path.Base(src)}, nil, writer)
+	writer.Close()
+	if tarErr := <-done; err == nil {
+		err = tarErr
+	}
+	return err
+}
+
+/*
+   将src打包为tar,条目名称以prefix开头
+*/
+func writeTar(w io.Writer, src, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+   解压tar,将条目名称中的prefix替换为dst
+*/
+func readTar(r io.Reader, prefix, dst string, out io.Writer) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取tar失败: %w", err)
+		}
+		name := path.Clean(header.Name)
+		if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			return fmt.Errorf("tar中的条目 %s 不在 %s 下", header.Name, prefix)
+		}
+		target := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		if rel, err := filepath.Rel(dst, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("tar中的条目 %s 指向目标目录之外", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			fmt.Fprintf(out, "跳过 %s: 不支持的文件类型\n", header.Name)
+		}
+	}
+}
+
+func runCopy(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("cp", out)
+	container := fs.String("c", "", "容器名称,默认为default-container注解中的容器或第一个容器")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("用法: cp [-c 容器] <源> <目标>, Pod中的路径格式为 资源类型/名称:路径")
+	}
+	src, dst := parseCopyPath(fs.Arg(0)), parseCopyPath(fs.Arg(1))
+	if (src.Kind == "") == (dst.Kind == "") {
+		return usageError("源与目标中必须有且只有一个是Pod中的路径")
+	}
+	remote := src
+	if dst.Kind != "" {
+		remote = dst
+	}
+	if remote.Path == "" {
+		return usageError("Pod中的路径不能为空")
+	}
+	executor, err := newSPDYExecutor(ctx)
+	if err != nil {
+		return err
+	}
+	pod, err := choosePod(ctx, executor.clientSet, remote.Kind, activeProfile.Namespace, remote.Name)
+	if err != nil {
+		return err
+	}
+	containerName, err := podContainer(pod, *container)
+	if err != nil {
+		return err
+	}
+	if dst.Kind != "" {
+		if err := copyToPod(executor, activeProfile.Namespace, pod.Name, containerName, src.Path, dst.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已复制 %s 到 %s/%s:%s\n", src.Path, pod.Name, containerName, dst.Path)
+		return nil
+	}
+	if err := copyFromPod(executor, activeProfile.Namespace, pod.Name, containerName, src.Path, dst.Path, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "已复制 %s/%s:%s 到 %s\n", pod.Name, containerName, src.Path, dst.Path)
+	return nil
+}
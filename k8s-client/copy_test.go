@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	utilexec "k8s.io/client-go/util/exec"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/*
+   用内存中的文件模拟容器,只实现cp使用的两条tar命令
+*/
+type fakeTarExecutor struct {
+	files    map[string]string //容器中的文件路径与内容
+	commands []string
+}
+
+func (e *fakeTarExecutor) exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
+	e.commands = append(e.commands, strings.Join(command, " "))
+	switch {
+	case len(command) == 5 && command[1] == "xmf":
+		tr := tar.NewReader(stdin)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag == tar.TypeReg {
+				data, _ := ioutil.ReadAll(tr)
+				e.files[path.Join(command[4], header.Name)] = string(data)
+			}
+		}
+	case len(command) == 6 && command[1] == "cf":
+		root := path.Join(command[4], command[5])
+		var names []string
+		for name := range e.files {
+			if name == root || strings.HasPrefix(name, root+"/") {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Fprintf(stderr, "tar: %s: Cannot stat: No such file or directory\n", command[5])
+			return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code 2"), Code: 2}
+		}
+		sort.Strings(names)
+		tw := tar.NewWriter(stdout)
+		for _, name := range names {
+			rel := strings.TrimPrefix(name, command[4]+"/")
+			tw.WriteHeader(&tar.Header{Name: rel, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.files[name]))})
+			tw.Write([]byte(e.files[name]))
+		}
+		tw.WriteHeader(&tar.Header{Name: command[5] + "/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+		return tw.Close()
+	}
+	return fmt.Errorf("unexpected command %q", command)
+}
+
+func TestParseCopyPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want copyPath
+	}{
+		{in: "deployment/test-nginx:/etc/nginx/html", want: copyPath{Kind: "deployment", Name: "test-nginx", Path: "/etc/nginx/html"}},
+		{in: "svc/test-nginx:/tmp/a.txt", want: copyPath{Kind: "service", Name: "test-nginx", Path: "/tmp/a.txt"}},
+		{in: "./html", want: copyPath{Path: "./html"}},
+		{in: "./backup:2023/html", want: copyPath{Path: "./backup:2023/html"}},
+		{in: "c:/html", want: copyPath{Path: "c:/html"}},
+	}
+	for _, tt := range tests {
+		if got := parseCopyPath(tt.in); got != tt.want {
+			t.Errorf("parseCopyPath(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCopyRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "index.html"), []byte("<h1>nginx</h1>"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "css", "site.css"), []byte("body{}"), 0644)
+
+	executor := &fakeTarExecutor{files: map[string]string{}}
+	if err := copyToPod(executor, TestNamespace, "test-nginx-a", "nginx", src, "/etc/nginx/html/"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/etc/nginx/html/index.html":   "<h1>nginx</h1>",
+		"/etc/nginx/html/css/site.css": "body{}",
+	}
+	if fmt.Sprint(executor.files) != fmt.Sprint(want) {
+		t.Errorf("pod files = %v, want %v", executor.files, want)
+	}
+
+	dst := filepath.Join(t.TempDir(), "html")
+	var out bytes.Buffer
+	if err := copyFromPod(executor, TestNamespace, "test-nginx-a", "nginx", "/etc/nginx/html", dst, &out); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"index.html": "<h1>nginx</h1>", "css/site.css": "body{}"} {
+		data, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); !os.IsNotExist(err) {
+		t.Error("symlink extracted")
+	}
+	if !strings.Contains(out.String(), "跳过 html/link") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	wantCommands := []string{"tar xmf - -C /etc/nginx", "tar cf - -C /etc/nginx html"}
+	if strings.Join(executor.commands, ",") != strings.Join(wantCommands, ",") {
+		t.Errorf("commands = %q, want %q", executor.commands, wantCommands)
+	}
+
+	err := copyFromPod(executor, TestNamespace, "test-nginx-a", "nginx", "/etc/nginx/missing", dst, &out)
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("missing path error = %v", err)
+	}
+}
+
+func TestReadTarRejectsEscape(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	if err := readTar(&buf, "html", t.TempDir(), io.Discard); err == nil {
+		t.Error("entry outside prefix accepted")
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listers_apps_v1 "k8s.io/client-go/listers/apps/v1"
+	listers_core_v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+   页面的html、js与css,不依赖外部资源,离线可用
+*/
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+//快照中保留的最近事件数
+const dashboardEventLimit = 100
+
+//对象变化后等待合并更多变化再推送快照的时间,测试时修改
+var dashboardDebounce = 500 * time.Millisecond
+
+/*
+   基于informer缓存的页面数据,对象变化时通知所有监听的连接
+   informer监听所有命名空间,返回给页面时按token允许的命名空间过滤
+*/
+type dashboard struct {
+	factory     informers.SharedInformerFactory
+	namespaces  listers_core_v1.NamespaceLister
+	deployments listers_apps_v1.DeploymentLister
+	services    listers_core_v1.ServiceLister
+	endpoints   listers_core_v1.EndpointsLister
+	claims      listers_core_v1.PersistentVolumeClaimLister
+	volumes     listers_core_v1.PersistentVolumeLister
+	events      listers_core_v1.EventLister
+
+	mu      sync.Mutex
+	changed chan struct{} //对象变化时关闭并替换
+
+	cacheMu  sync.Mutex
+	cache    *dashboardSnapshot //所有命名空间的快照,各连接按token过滤后使用
+	cacheFor <-chan struct{}    //生成cache时的changed,未关闭说明之后没有变化
+	cacheAt  time.Time
+}
+
+func newDashboard(clientSet kubernetes.Interface) *dashboard {
+	factory := informers.NewSharedInformerFactory(clientSet, 0)
+	d := &dashboard{factory: factory, changed: make(chan struct{})}
+	core := factory.Core().V1()
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { d.notify() },
+		UpdateFunc: func(interface{}, interface{}) { d.notify() },
+		DeleteFunc: func(interface{}) { d.notify() },
+	}
+	for _, informer := range []cache.SharedIndexInformer{
+		core.Namespaces().Informer(),
+		factory.Apps().V1().Deployments().Informer(),
+		core.Services().Informer(),
+		core.Endpoints().Informer(),
+		core.PersistentVolumeClaims().Informer(),
+		core.PersistentVolumes().Informer(),
+		core.Events().Informer(),
+	} {
+		informer.AddEventHandler(handler)
+	}
+	d.namespaces = core.Namespaces().Lister()
+	d.deployments = factory.Apps().V1().Deployments().Lister()
+	d.services = core.Services().Lister()
+	d.endpoints = core.Endpoints().Lister()
+	d.claims = core.PersistentVolumeClaims().Lister()
+	d.volumes = core.PersistentVolumes().Lister()
+	d.events = core.Events().Lister()
+	return d
+}
+
+/*
+   启动informer并等待缓存同步,ctx结束时停止
+*/
+func (d *dashboard) start(ctx context.Context) error {
+	d.factory.Start(ctx.Done())
+	for informerType, synced := range d.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return &clientError{Kind: ErrTimeout, Action: "同步informer缓存", Err: fmt.Errorf("%v 未同步", informerType)}
+		}
+	}
+	return nil
+}
+
+func (d *dashboard) notify() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+/*
+   返回下一次对象变化时关闭的channel
+*/
+func (d *dashboard) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.changed
+}
+
+type dashboardNamespace struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+}
+
+/*
+   State与Summary的判断与status命令一致
+*/
+type dashboardDeployment struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Replicas  int32    `json:"replicas"`
+	Updated   int32    `json:"updated"`
+	Ready     int32    `json:"ready"`
+	Available int32    `json:"available"`
+	Paused    bool     `json:"paused"`
+	Images    []string `json:"images"`
+	State     string   `json:"state"`
+	Summary   string   `json:"summary"`
+}
+
+type dashboardService struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	ClusterIP string   `json:"clusterIP"`
+	Ports     string   `json:"ports"`
+	Endpoints []string `json:"endpoints"`
+	NotReady  []string `json:"notReady"`
+}
+
+/*
+   PVC及其绑定的PV,未绑定时Volume为空
+*/
+type dashboardClaim struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Phase         string `json:"phase"`
+	StorageClass  string `json:"storageClass"`
+	Capacity      string `json:"capacity"`
+	Volume        string `json:"volume"`
+	VolumePhase   string `json:"volumePhase"`
+	ReclaimPolicy string `json:"reclaimPolicy"`
+}
+
+type dashboardEvent struct {
+	Namespace string    `json:"namespace"`
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Object    string    `json:"object"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+}
+
+type dashboardSnapshot struct {
+	Namespaces  []dashboardNamespace  `json:"namespaces"`
+	Deployments []dashboardDeployment `json:"deployments"`
+	Services    []dashboardService    `json:"services"`
+	Claims      []dashboardClaim      `json:"claims"`
+	Events      []dashboardEvent      `json:"events"`
+}
+
+/*
+   token可以访问的命名空间中的对象,namespace不为空时只包含该命名空间
+   集群级别对象的事件记录在default命名空间中,只有可以访问default时才会返回
+*/
+func (d *dashboard) snapshot(token apiToken, namespace string) (dashboardSnapshot, error) {
+	full, err := d.current()
+	if err != nil {
+		return full, err
+	}
+	visible := func(ns string) bool {
+		return token.allows(ns) && (namespace == "" || ns == namespace)
+	}
+	s := dashboardSnapshot{
+		Namespaces:  []dashboardNamespace{},
+		Deployments: []dashboardDeployment{},
+		Services:    []dashboardService{},
+		Claims:      []dashboardClaim{},
+		Events:      []dashboardEvent{},
+	}
+	for _, ns := range full.Namespaces {
+		if token.allows(ns.Name) {
+			s.Namespaces = append(s.Namespaces, ns)
+		}
+	}
+	for _, deployment := range full.Deployments {
+		if visible(deployment.Namespace) {
+			s.Deployments = append(s.Deployments, deployment)
+		}
+	}
+	for _, service := range full.Services {
+		if visible(service.Namespace) {
+			s.Services = append(s.Services, service)
+		}
+	}
+	for _, claim := range full.Claims {
+		if visible(claim.Namespace) {
+			s.Claims = append(s.Claims, claim)
+		}
+	}
+	for _, event := range full.Events {
+		if len(s.Events) == dashboardEventLimit {
+			break
+		}
+		if visible(event.Namespace) {
+			s.Events = append(s.Events, event)
+		}
+	}
+	return s, nil
+}
+
+/*
+   返回所有命名空间的快照,之后没有对象变化或距上次生成不足dashboardDebounce时复用上次的结果
+   连接在对象变化后等待dashboardDebounce再获取快照,因此复用的快照一定包含了这次变化
+*/
+func (d *dashboard) current() (dashboardSnapshot, error) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	changed := d.wait()
+	if d.cache != nil && (d.cacheFor == changed || time.Since(d.cacheAt) < dashboardDebounce) {
+		return *d.cache, nil
+	}
+	s, err := d.build()
+	if err != nil {
+		return s, err
+	}
+	d.cache, d.cacheFor, d.cacheAt = &s, changed, time.Now()
+	return s, nil
+}
+
+/*
+   从informer缓存生成所有命名空间的快照
+   事件每个命名空间只保留最近的dashboardEventLimit条,过滤后的结果不会超过这个数量,不需要对所有事件排序
+*/
+func (d *dashboard) build() (dashboardSnapshot, error) {
+	var s dashboardSnapshot
+	namespaces, err := d.namespaces.List(labels.Everything())
+	if err != nil {
+		return s, err
+	}
+	for _, ns := range namespaces {
+		s.Namespaces = append(s.Namespaces, dashboardNamespace{Name: ns.Name, Phase: string(ns.Status.Phase)})
+	}
+
+	deployments, err := d.deployments.List(labels.Everything())
+	if err != nil {
+		return s, err
+	}
+	for _, deployment := range deployments {
+		s.Deployments = append(s.Deployments, newDashboardDeployment(deployment))
+	}
+
+	services, err := d.services.List(labels.Everything())
+	if err != nil {
+		return s, err
+	}
+	for _, service := range services {
+		info := dashboardService{
+			Namespace: service.Namespace, Name: service.Name, Type: string(service.Spec.Type), ClusterIP: service.Spec.ClusterIP,
+			Ports: servicePortsString(service.Spec.Ports), Endpoints: []string{}, NotReady: []string{},
+		}
+		if endpoints, err := d.endpoints.Endpoints(service.Namespace).Get(service.Name); err == nil {
+			for _, subset := range endpoints.Subsets {
+				info.Endpoints = append(info.Endpoints, endpointAddresses(subset.Addresses, subset.Ports)...)
+				info.NotReady = append(info.NotReady, endpointAddresses(subset.NotReadyAddresses, subset.Ports)...)
+			}
+		}
+		s.Services = append(s.Services, info)
+	}
+
+	claims, err := d.claims.List(labels.Everything())
+	if err != nil {
+		return s, err
+	}
+	for _, claim := range claims {
+		info := dashboardClaim{Namespace: claim.Namespace, Name: claim.Name, Phase: string(claim.Status.Phase), Volume: claim.Spec.VolumeName}
+		if claim.Spec.StorageClassName != nil {
+			info.StorageClass = *claim.Spec.StorageClassName
+		}
+		if capacity, ok := claim.Status.Capacity[core_v1.ResourceStorage]; ok {
+			info.Capacity = capacity.String()
+		} else if request, ok := claim.Spec.Resources.Requests[core_v1.ResourceStorage]; ok {
+			info.Capacity = request.String()
+		}
+		if claim.Spec.VolumeName != "" {
+			if volume, err := d.volumes.Get(claim.Spec.VolumeName); err == nil {
+				info.VolumePhase = string(volume.Status.Phase)
+				info.ReclaimPolicy = string(volume.Spec.PersistentVolumeReclaimPolicy)
+			}
+		}
+		s.Claims = append(s.Claims, info)
+	}
+
+	events, err := d.events.List(labels.Everything())
+	if err != nil {
+		return s, err
+	}
+	for _, recent := range recentEvents(events, dashboardEventLimit) {
+		for _, event := range recent {
+			s.Events = append(s.Events, dashboardEvent{
+				Namespace: event.Namespace, Time: eventTime(*event), Type: event.Type, Object: involvedKey(event).String(),
+				Reason: event.Reason, Message: event.Message, Count: event.Count,
+			})
+		}
+	}
+
+	sort.Slice(s.Namespaces, func(i, j int) bool { return s.Namespaces[i].Name < s.Namespaces[j].Name })
+	sort.Slice(s.Deployments, func(i, j int) bool {
+		return s.Deployments[i].Namespace+"/"+s.Deployments[i].Name < s.Deployments[j].Namespace+"/"+s.Deployments[j].Name
+	})
+	sort.Slice(s.Services, func(i, j int) bool {
+		return s.Services[i].Namespace+"/"+s.Services[i].Name < s.Services[j].Namespace+"/"+s.Services[j].Name
+	})
+	sort.Slice(s.Claims, func(i, j int) bool {
+		return s.Claims[i].Namespace+"/"+s.Claims[i].Name < s.Claims[j].Namespace+"/"+s.Claims[j].Name
+	})
+	sort.SliceStable(s.Events, func(i, j int) bool { return s.Events[i].Time.After(s.Events[j].Time) })
+	return s, nil
+}
+
+/*
+   按命名空间保留最近的limit条事件
+   超过2*limit条时排序并截断,每个命名空间的排序规模不超过2*limit
+*/
+func recentEvents(events []*core_v1.Event, limit int) map[string][]*core_v1.Event {
+	newest := func(list []*core_v1.Event) []*core_v1.Event {
+		sort.SliceStable(list, func(i, j int) bool {
+			return eventTime(*list[i]).After(eventTime(*list[j]))
+		})
+		return list[:limit]
+	}
+	recent := map[string][]*core_v1.Event{}
+	for _, event := range events {
+		list := append(recent[event.Namespace], event)
+		if len(list) > 2*limit {
+			list = newest(list)
+		}
+		recent[event.Namespace] = list
+	}
+	for ns, list := range recent {
+		if len(list) > limit {
+			recent[ns] = newest(list)
+		}
+	}
+	return recent
+}
+
+func newDashboardDeployment(deployment *apps_v1.Deployment) dashboardDeployment {
+	status := deploymentStatus(deployment)
+	info := dashboardDeployment{
+		Namespace: deployment.Namespace, Name: deployment.Name, Replicas: 1,
+		Updated: deployment.Status.UpdatedReplicas, Ready: deployment.Status.ReadyReplicas, Available: deployment.Status.AvailableReplicas,
+		Paused: deployment.Spec.Paused, Images: []string{}, State: status.state(), Summary: status.Summary,
+	}
+	if deployment.Spec.Replicas != nil {
+		info.Replicas = *deployment.Spec.Replicas
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		info.Images = append(info.Images, container.Image)
+	}
+	return info
+}
+
+/*
+   GET /v1/dashboard?namespace=ns 返回快照,watch=true时先推送当前快照,之后对象变化时推送新的快照
+*/
+func (s *apiServer) serveDashboard(w http.ResponseWriter, r *http.Request, token apiToken) {
+	if s.dashboard == nil {
+		writeError(w, notFoundError("未启用页面,serve需要-ui参数"))
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace != "" && !token.allows(namespace) {
+		writeError(w, forbiddenError(token, namespace))
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		snapshot, err := s.dashboard.snapshot(token, namespace)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &clientError{Kind: ErrUnknown, Action: "监听", Err: fmt.Errorf("不支持流式响应")})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(serveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		changed := s.dashboard.wait()
+		snapshot, err := s.dashboard.snapshot(token, namespace)
+		if err != nil {
+			writeSSE(w, "ERROR", err.Error())
+			flusher.Flush()
+			return
+		}
+		if err := writeSSE(w, "snapshot", snapshot); err != nil {
+			return
+		}
+		flusher.Flush()
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case <-changed:
+				break wait
+			}
+		}
+		//合并短时间内的多次变化
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(dashboardDebounce):
+		}
+	}
+}
+
+/*
+   页面的静态文件
+*/
+func dashboardHandler() (http.Handler, error) {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		return nil, &clientError{Kind: ErrUnknown, Action: "加载页面文件", Err: err}
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(files))), nil
+}
@@ -0,0 +1,253 @@
+'use strict';
+
+// token保存在localStorage中,页面刷新后自动连接
+const tokenKey = 'k8s-client-token';
+let token = localStorage.getItem(tokenKey) || '';
+let controller = null;
+let retryTimer = null;
+
+const $ = (id) => document.getElementById(id);
+
+function setStatus(text, className) {
+  const status = $('status');
+  status.textContent = text;
+  status.className = 'status ' + (className || '');
+}
+
+function request(method, path, body) {
+  return fetch(path, {
+    method: method,
+    headers: { 'Authorization': 'Bearer ' + token },
+    body: body,
+  }).then((resp) => resp.text().then((text) => {
+    const data = text ? JSON.parse(text) : {};
+    if (!resp.ok) {
+      throw new Error(data.message || resp.statusText);
+    }
+    return data;
+  }));
+}
+
+// 通过fetch读取Server-Sent Events,EventSource不支持设置Authorization
+function connect() {
+  if (controller) {
+    controller.abort();
+  }
+  clearTimeout(retryTimer);
+  if (!token) {
+    setStatus('需要token');
+    return;
+  }
+  controller = new AbortController();
+  const signal = controller.signal;
+  const namespace = $('namespace').value;
+  setStatus('连接中');
+  fetch('/v1/dashboard?watch=true&namespace=' + encodeURIComponent(namespace), {
+    headers: { 'Authorization': 'Bearer ' + token },
+    signal: signal,
+  }).then((resp) => {
+    if (!resp.ok) {
+      return resp.json().then((e) => { throw new Error(e.message); });
+    }
+    setStatus('实时', 'live');
+    return readEvents(resp.body.getReader());
+  }).then(() => {
+    setStatus('连接已断开', 'error');
+    retry();
+  }).catch((err) => {
+    if (signal.aborted) {
+      return;
+    }
+    setStatus(err.message, 'error');
+    retry();
+  });
+}
+
+function retry() {
+  retryTimer = setTimeout(connect, 3000);
+}
+
+function readEvents(reader) {
+  const decoder = new TextDecoder();
+  let buffer = '';
+  const pump = () => reader.read().then(({ done, value }) => {
+    if (done) {
+      return;
+    }
+    buffer += decoder.decode(value, { stream: true });
+    let end;
+    while ((end = buffer.indexOf('\n\n')) >= 0) {
+      handleEvent(buffer.slice(0, end));
+      buffer = buffer.slice(end + 2);
+    }
+    return pump();
+  });
+  return pump();
+}
+
+function handleEvent(frame) {
+  let event = 'message';
+  const data = [];
+  for (const line of frame.split('\n')) {
+    if (line.startsWith('event: ')) {
+      event = line.slice(7);
+    } else if (line.startsWith('data: ')) {
+      data.push(line.slice(6));
+    }
+  }
+  if (event === 'snapshot') {
+    render(JSON.parse(data.join('\n')));
+  } else if (event === 'ERROR') {
+    setStatus(JSON.parse(data.join('\n')), 'error');
+  }
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text === undefined || text === null || text === '' ? '-' : text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function fill(id, items, build) {
+  const tbody = $(id).tBodies[0];
+  tbody.replaceChildren();
+  for (const item of items) {
+    build(tbody.insertRow(), item);
+  }
+}
+
+function stateClass(state) {
+  return { '就绪': 'ready', '未就绪': 'pending', '失败': 'failed' }[state] || '';
+}
+
+function render(s) {
+  const select = $('namespace');
+  const selected = select.value;
+  const names = s.namespaces.map((ns) => ns.name);
+  if (select.options.length - 1 !== names.length || names.some((name, i) => select.options[i + 1].value !== name)) {
+    select.replaceChildren(new Option('全部', ''));
+    for (const name of names) {
+      select.add(new Option(name, name));
+    }
+    select.value = selected;
+  }
+
+  fill('namespaces', s.namespaces, (row, ns) => {
+    cell(row, ns.name);
+    cell(row, ns.phase, ns.phase === 'Active' ? 'ready' : 'pending');
+  });
+  fill('deployments', s.deployments, (row, d) => {
+    cell(row, d.namespace);
+    cell(row, d.name);
+    cell(row, d.ready + '/' + d.replicas);
+    cell(row, d.updated);
+    cell(row, d.available);
+    cell(row, d.state + ': ' + d.summary, stateClass(d.state));
+    cell(row, d.images.join(', '), 'wrap');
+    row.insertCell().append(button('伸缩', () => scale(d)), button('重启', () => restart(d)));
+  });
+  fill('services', s.services, (row, svc) => {
+    cell(row, svc.namespace);
+    cell(row, svc.name);
+    cell(row, svc.type);
+    cell(row, svc.clusterIP);
+    cell(row, svc.ports);
+    cell(row, svc.endpoints.join(', '), 'wrap');
+    cell(row, svc.notReady.join(', '), svc.notReady.length ? 'wrap pending' : 'wrap');
+  });
+  fill('claims', s.claims, (row, c) => {
+    cell(row, c.namespace);
+    cell(row, c.name);
+    cell(row, c.phase, c.phase === 'Bound' ? 'ready' : 'pending');
+    cell(row, c.storageClass);
+    cell(row, c.capacity);
+    cell(row, c.volume);
+    cell(row, c.volumePhase);
+    cell(row, c.reclaimPolicy);
+  });
+  fill('events', s.events, (row, e) => {
+    cell(row, new Date(e.time).toLocaleString());
+    cell(row, e.type, e.type === 'Warning' ? 'warning' : '');
+    cell(row, e.object);
+    cell(row, e.reason);
+    cell(row, e.message + (e.count > 1 ? ' (x' + e.count + ')' : ''), 'wrap');
+  });
+}
+
+function button(text, onClick) {
+  const b = document.createElement('button');
+  b.type = 'button';
+  b.textContent = text;
+  b.addEventListener('click', onClick);
+  return b;
+}
+
+function workloadPath(d, action) {
+  return '/v1/namespaces/' + encodeURIComponent(d.namespace) + '/deployment/' + encodeURIComponent(d.name) + '/' + action;
+}
+
+function scale(d) {
+  const value = prompt('Deployment ' + d.namespace + '/' + d.name + ' 的副本数', d.replicas);
+  if (value === null) {
+    return;
+  }
+  const replicas = Number(value);
+  if (!Number.isInteger(replicas) || replicas < 0) {
+    alert('副本数需要为非负整数');
+    return;
+  }
+  request('POST', workloadPath(d, 'scale'), JSON.stringify({ replicas: replicas }))
+    .then((r) => setStatus(r.message, 'live'))
+    .catch((err) => alert(err.message));
+}
+
+function restart(d) {
+  if (!confirm('重启 Deployment ' + d.namespace + '/' + d.name + ' 的所有Pod?')) {
+    return;
+  }
+  request('POST', workloadPath(d, 'restart'))
+    .then((r) => setStatus(r.message, 'live'))
+    .catch((err) => alert(err.message));
+}
+
+function apply(dryRun) {
+  const result = $('apply-result');
+  const namespace = $('namespace').value;
+  let path = '/v1/apply?namespace=' + encodeURIComponent(namespace);
+  if (dryRun) {
+    path += '&dryRun=true';
+  }
+  result.textContent = dryRun ? '计算差异...' : 'Apply...';
+  request('POST', path, $('manifest').value).then((r) => {
+    const lines = [];
+    for (const item of r.results) {
+      const target = item.kind + ' ' + (item.namespace ? item.namespace + '/' : '') + item.name;
+      lines.push(target + ': ' + item.action + (r.dryRun ? ' (dry run)' : ''));
+      for (const line of item.diff || []) {
+        lines.push('  ' + line);
+      }
+    }
+    result.textContent = lines.join('\n');
+  }).catch((err) => {
+    result.textContent = err.message;
+  });
+}
+
+$('login').addEventListener('submit', (e) => {
+  e.preventDefault();
+  token = $('token').value.trim();
+  localStorage.setItem(tokenKey, token);
+  connect();
+});
+$('namespace').addEventListener('change', connect);
+$('dry-run').addEventListener('click', () => apply(true));
+$('apply').addEventListener('click', () => {
+  if (confirm('Apply清单中的所有对象?')) {
+    apply(false);
+  }
+});
+$('token').value = token;
+connect();
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDashboardClientSet() *fake.Clientset {
+	deployment := newRolloutDeployment()
+	other := newTestDeployment(1, 1, 1, 1)
+	other.Namespace = "other"
+	storageClass := "nfs-storage"
+	eventAt := func(minute int) meta_v1.Time {
+		return meta_v1.NewTime(time.Date(2022, 1, 2, 3, minute, 0, 0, time.UTC))
+	}
+	return fake.NewSimpleClientset(
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: TestNamespace}, Status: core_v1.NamespaceStatus{Phase: core_v1.NamespaceActive}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "other"}, Status: core_v1.NamespaceStatus{Phase: core_v1.NamespaceTerminating}},
+		deployment, other,
+		&core_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-service", Namespace: TestNamespace},
+			Spec: core_v1.ServiceSpec{
+				Type: core_v1.ServiceTypeNodePort, ClusterIP: "10.96.0.10",
+				Ports: []core_v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: core_v1.ProtocolTCP, TargetPort: intstr.FromInt(8080)}},
+			},
+		},
+		&core_v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-service", Namespace: TestNamespace},
+			Subsets: []core_v1.EndpointSubset{{
+				Addresses:         []core_v1.EndpointAddress{{IP: "10.244.1.5", TargetRef: &core_v1.ObjectReference{Kind: "Pod", Name: "test-nginx-abc"}}},
+				NotReadyAddresses: []core_v1.EndpointAddress{{IP: "10.244.2.7", TargetRef: &core_v1.ObjectReference{Kind: "Pod", Name: "test-nginx-def"}}},
+				Ports:             []core_v1.EndpointPort{{Port: 8080}},
+			}},
+		},
+		&core_v1.PersistentVolumeClaim{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-pvc", Namespace: TestNamespace},
+			Spec: core_v1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass, VolumeName: "pvc-1234",
+				Resources: core_v1.ResourceRequirements{Requests: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("1Gi")}},
+			},
+			Status: core_v1.PersistentVolumeClaimStatus{Phase: core_v1.ClaimBound, Capacity: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("2Gi")}},
+		},
+		&core_v1.PersistentVolumeClaim{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "pending-pvc", Namespace: TestNamespace},
+			Spec: core_v1.PersistentVolumeClaimSpec{
+				Resources: core_v1.ResourceRequirements{Requests: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("5Gi")}},
+			},
+			Status: core_v1.PersistentVolumeClaimStatus{Phase: core_v1.ClaimPending},
+		},
+		&core_v1.PersistentVolume{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "pvc-1234"},
+			Spec:       core_v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: core_v1.PersistentVolumeReclaimDelete},
+			Status:     core_v1.PersistentVolumeStatus{Phase: core_v1.VolumeBound},
+		},
+		&core_v1.Event{
+			ObjectMeta:     meta_v1.ObjectMeta{Name: "e1", Namespace: TestNamespace},
+			InvolvedObject: core_v1.ObjectReference{Kind: "Deployment", Namespace: TestNamespace, Name: "test-nginx"},
+			Type:           core_v1.EventTypeNormal, Reason: "ScalingReplicaSet", Message: "Scaled up replica set test-nginx-abc to 2",
+			LastTimestamp: eventAt(1), Count: 1,
+		},
+		&core_v1.Event{
+			ObjectMeta:     meta_v1.ObjectMeta{Name: "e2", Namespace: TestNamespace},
+			InvolvedObject: core_v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: TestNamespace, Name: "pending-pvc"},
+			Type:           core_v1.EventTypeWarning, Reason: "ProvisioningFailed", Message: "storageclass.storage.k8s.io \"slow\" not found",
+			LastTimestamp: eventAt(5), Count: 3,
+		},
+		&core_v1.Event{
+			ObjectMeta:     meta_v1.ObjectMeta{Name: "e3", Namespace: "other"},
+			InvolvedObject: core_v1.ObjectReference{Kind: "Deployment", Namespace: "other", Name: "test-nginx"},
+			Type:           core_v1.EventTypeNormal, Reason: "ScalingReplicaSet", LastTimestamp: eventAt(3), Count: 1,
+		},
+	)
+}
+
+func startTestDashboard(t *testing.T, clientSet *fake.Clientset) *dashboard {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d := newDashboard(clientSet)
+	if err := d.start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDashboardSnapshot(t *testing.T) {
+	d := startTestDashboard(t, newDashboardClientSet())
+	snapshot, err := d.snapshot(testTokens[1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "dashboard_snapshot.golden", append(data, '\n'))
+
+	snapshot, err = d.snapshot(testTokens[0], "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Namespaces) != 2 || len(snapshot.Deployments) != 1 || snapshot.Deployments[0].Namespace != "other" || len(snapshot.Services) != 0 || len(snapshot.Events) != 1 {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	var events []*core_v1.Event
+	for i := 0; i < 250; i++ {
+		events = append(events, &core_v1.Event{
+			ObjectMeta:    meta_v1.ObjectMeta{Name: fmt.Sprintf("e%d", i), Namespace: TestNamespace},
+			LastTimestamp: meta_v1.NewTime(time.Date(2022, 1, 2, 0, 0, i, 0, time.UTC)),
+		})
+	}
+	events = append(events, &core_v1.Event{ObjectMeta: meta_v1.ObjectMeta{Name: "old", Namespace: "other"}})
+	recent := recentEvents(events, 10)
+	if len(recent[TestNamespace]) != 10 || recent[TestNamespace][0].Name != "e249" || recent[TestNamespace][9].Name != "e240" {
+		t.Errorf("recent[%s] = %d events, first %s", TestNamespace, len(recent[TestNamespace]), recent[TestNamespace][0].Name)
+	}
+	//其他命名空间的事件不会被较新的事件挤掉
+	if len(recent["other"]) != 1 {
+		t.Errorf("recent[other] = %v", recent["other"])
+	}
+}
+
+func TestDashboardWatch(t *testing.T) {
+	original, originalDebounce := serveHeartbeat, dashboardDebounce
+	serveHeartbeat, dashboardDebounce = time.Minute, 10*time.Millisecond
+	defer func() { serveHeartbeat, dashboardDebounce = original, originalDebounce }()
+
+	clientSet := newDashboardClientSet()
+	server := newAPIServer(newServeClient(), clientSet, testTokens)
+	if err := server.enableDashboard(startTestDashboard(t, clientSet)); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", httpServer.URL+"/v1/dashboard?watch=true&namespace="+TestNamespace, nil)
+	req.Header.Set("Authorization", "Bearer dev-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("watch = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	next := func() dashboardSnapshot {
+		t.Helper()
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				var s dashboardSnapshot
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &s); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			}
+		}
+		t.Fatalf("stream ended: %v", scanner.Err())
+		return dashboardSnapshot{}
+	}
+
+	if s := next(); len(s.Deployments) != 1 || s.Deployments[0].Available != 2 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	deployment, err := clientSet.AppsV1().Deployments(TestNamespace).Get(ctx, "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment.Status.AvailableReplicas = 1
+	if _, err := clientSet.AppsV1().Deployments(TestNamespace).UpdateStatus(ctx, deployment, meta_v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	//其他命名空间中的变化也会触发推送,直到出现更新后的状态
+	for {
+		s := next()
+		if s.Deployments[0].Available == 1 {
+			if s.Deployments[0].State != "未就绪" {
+				t.Errorf("deployment = %+v", s.Deployments[0])
+			}
+			break
+		}
+	}
+}
+
+func TestWorkloadActions(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newRolloutDeployment())
+	replicas := int32(2)
+	clientSet.PrependReactor("*", "deployments", scaleReactor(&replicas))
+	handler := newAPIServer(newServeClient(), clientSet, testTokens).handler()
+	path := "/v1/namespaces/" + TestNamespace + "/deployment/test-nginx/"
+	for _, tc := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"POST", path + "scale", "dev-token", `{"replicas":5}`, http.StatusOK},
+		{"POST", path + "scale", "dev-token", `{"replica":5}`, http.StatusBadRequest},
+		{"POST", path + "scale", "dev-token", `{"replicas":-1}`, http.StatusBadRequest},
+		{"GET", path + "scale", "dev-token", "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/namespaces/other/deployment/test-nginx/scale", "dev-token", `{"replicas":5}`, http.StatusForbidden},
+		{"POST", "/v1/namespaces/" + TestNamespace + "/daemonset/test-daemonset/scale", "dev-token", `{"replicas":5}`, http.StatusNotFound},
+		{"POST", "/v1/namespaces/" + TestNamespace + "/deployment/missing/restart", "dev-token", "", http.StatusNotFound},
+		{"POST", path + "restart", "dev-token", "", http.StatusOK},
+		{"GET", "/v1/dashboard", "dev-token", "", http.StatusNotFound},
+		{"GET", "/ui/", "", "", http.StatusNotFound},
+	} {
+		if w := serveRequest(handler, tc.method, tc.path, tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d: %s", tc.method, tc.path, w.Code, tc.want, w.Body)
+		}
+	}
+	if replicas != 5 {
+		t.Errorf("replicas = %d, want 5", replicas)
+	}
+	deployment, err := clientSet.AppsV1().Deployments(TestNamespace).Get(context.TODO(), "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[restartedAtAnnotation] == "" {
+		t.Errorf("annotations = %v", deployment.Spec.Template.Annotations)
+	}
+}
+
+func TestDashboardFiles(t *testing.T) {
+	server := newAPIServer(newServeClient(), fake.NewSimpleClientset(), testTokens)
+	if err := server.enableDashboard(newDashboard(fake.NewSimpleClientset())); err != nil {
+		t.Fatal(err)
+	}
+	handler := server.handler()
+	for path, want := range map[string]string{
+		"/":                 "/ui/",
+		"/ui/":              "<title>k8s-client</title>",
+		"/ui/app.js":        "/v1/dashboard?watch=true",
+		"/ui/style.css":     "font:",
+		"/ui/missing.html":  "404",
+		"/v1/dashboard?x=1": "未认证",
+	} {
+		w := serveRequest(handler, "GET", path, "", "")
+		if body := w.Body.String() + w.Header().Get("Location"); !strings.Contains(body, want) {
+			t.Errorf("GET %s = %d %s, want %q", path, w.Code, body, want)
+		}
+	}
+
+	//页面需要离线可用,不能引用外部资源
+	err := fs.WalkDir(dashboardFiles, "dashboard", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := dashboardFiles.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		for _, ref := range []string{"http://", "https://", "//cdn", "@import"} {
+			if strings.Contains(string(data), ref) {
+				t.Errorf("%s 引用了外部资源: %s", path, ref)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/*
+   工作负载及其关联的对象
+*/
+type workloadRelations struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Status      workloadStatus
+	Template    core_v1.PodTemplateSpec
+	ReplicaSets []replicaSetInfo
+	Pods        []podInfo
+	Services    []serviceInfo
+	ConfigMaps  []mountedRef
+	Secrets     []mountedRef
+	Claims      []claimInfo
+}
+
+type replicaSetInfo struct {
+	Name     string
+	Revision string
+	Desired  int32
+	Ready    int32
+}
+
+type podInfo struct {
+	Name     string
+	Owner    string
+	Ready    bool
+	Status   string
+	Restarts int32
+	Node     string
+}
+
+type serviceInfo struct {
+	Name      string
+	Type      core_v1.ServiceType
+	Ports     []core_v1.ServicePort
+	Ready     []string //就绪端点 ip(pod)
+	NotReady  []string
+	Endpoints bool //Endpoints对象是否存在
+}
+
+/*
+   Pod模板引用的ConfigMap或Secret,Usages为引用位置
+*/
+type mountedRef struct {
+	Name   string
+	Found  bool
+	Usages []string
+}
+
+type claimInfo struct {
+	mountedRef
+	Phase         core_v1.PersistentVolumeClaimPhase
+	Volume        string
+	Capacity      string
+	VolumeFound   bool
+	ClassName     string
+	ClassFound    bool
+	Provisioner   string
+	BindingMode   string
+	ReclaimPolicy string
+}
+
+/*
+   收集Pod模板引用的ConfigMap、Secret与PVC,按名称合并引用位置
+*/
+type templateRefs struct {
+	configMaps map[string][]string
+	secrets    map[string][]string
+	claims     map[string][]string
+}
+
+func collectTemplateRefs(spec *core_v1.PodSpec) templateRefs {
+	refs := templateRefs{configMaps: map[string][]string{}, secrets: map[string][]string{}, claims: map[string][]string{}}
+	for _, volume := range spec.Volumes {
+		usage := "数据卷" + volume.Name
+		if volume.ConfigMap != nil {
+			refs.configMaps[volume.ConfigMap.Name] = append(refs.configMaps[volume.ConfigMap.Name], usage)
+		}
+		if volume.Secret != nil {
+			refs.secrets[volume.Secret.SecretName] = append(refs.secrets[volume.Secret.SecretName], usage)
+		}
+		if volume.PersistentVolumeClaim != nil {
+			refs.claims[volume.PersistentVolumeClaim.ClaimName] = append(refs.claims[volume.PersistentVolumeClaim.ClaimName], usage)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					refs.configMaps[source.ConfigMap.Name] = append(refs.configMaps[source.ConfigMap.Name], usage)
+				}
+				if source.Secret != nil {
+					refs.secrets[source.Secret.Name] = append(refs.secrets[source.Secret.Name], usage)
+				}
+			}
+		}
+	}
+	for _, container := range append(append([]core_v1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, envFrom := range container.EnvFrom {
+			usage := "容器" + container.Name + " envFrom"
+			if envFrom.ConfigMapRef != nil {
+				refs.configMaps[envFrom.ConfigMapRef.Name] = append(refs.configMaps[envFrom.ConfigMapRef.Name], usage)
+			}
+			if envFrom.SecretRef != nil {
+				refs.secrets[envFrom.SecretRef.Name] = append(refs.secrets[envFrom.SecretRef.Name], usage)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			usage := "容器" + container.Name + "环境变量" + env.Name
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs.configMaps[ref.Name] = append(refs.configMaps[ref.Name], usage)
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				refs.secrets[ref.Name] = append(refs.secrets[ref.Name], usage)
+			}
+		}
+	}
+	for _, ref := range spec.ImagePullSecrets {
+		refs.secrets[ref.Name] = append(refs.secrets[ref.Name], "imagePullSecrets")
+	}
+	return refs
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+   查询对象是否存在,不存在时返回false,其他错误原样返回
+*/
+func exists(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if k8s_errors.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+/*
+   获取工作负载及其关联的ReplicaSet、Pod、Service与端点、引用的ConfigMap/Secret/PVC以及PVC绑定的PV与StorageClass
+*/
+func describeWorkload(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (*workloadRelations, error) {
+	r := &workloadRelations{Kind: kind, Namespace: namespace, Name: name}
+	var uid types.UID
+	var selector *meta_v1.LabelSelector
+	switch kind {
+	case "deployment":
+		obj, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Deployment", err)
+		}
+		r.Kind, uid, selector, r.Template, r.Status = "Deployment", obj.UID, obj.Spec.Selector, obj.Spec.Template, deploymentStatus(obj)
+	case "statefulset":
+		obj, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取StatefulSet", err)
+		}
+		r.Kind, uid, selector, r.Template = "StatefulSet", obj.UID, obj.Spec.Selector, obj.Spec.Template
+		if r.Status, err = getWorkloadStatus(ctx, clientSet, kind, namespace, name); err != nil {
+			return nil, err
+		}
+	case "daemonset":
+		obj, err := clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取DaemonSet", err)
+		}
+		r.Kind, uid, selector, r.Template, r.Status = "DaemonSet", obj.UID, obj.Spec.Selector, obj.Spec.Template, daemonSetStatus(obj)
+	default:
+		return nil, usageError("%s 不支持describe,支持deployment/statefulset/daemonset", kind)
+	}
+	podSelector, err := meta_v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析" + r.Kind + " selector", Err: err}
+	}
+
+	owners := map[types.UID]string{uid: r.Kind + "/" + name}
+	if kind == "deployment" {
+		list, err := clientSet.AppsV1().ReplicaSets(namespace).List(ctx, meta_v1.ListOptions{LabelSelector: podSelector.String()})
+		if err != nil {
+			return nil, wrapError("获取ReplicaSet列表", err)
+		}
+		for _, rs := range list.Items {
+			if ref := meta_v1.GetControllerOf(&rs); ref == nil || ref.UID != uid {
+				continue
+			}
+			desired := int32(1)
+			if rs.Spec.Replicas != nil {
+				desired = *rs.Spec.Replicas
+			}
+			r.ReplicaSets = append(r.ReplicaSets, replicaSetInfo{Name: rs.Name, Revision: rs.Annotations[revisionAnnotation], Desired: desired, Ready: rs.Status.ReadyReplicas})
+			owners[rs.UID] = "ReplicaSet/" + rs.Name
+		}
+		sort.Slice(r.ReplicaSets, func(i, j int) bool {
+			a, _ := strconv.Atoi(r.ReplicaSets[i].Revision)
+			b, _ := strconv.Atoi(r.ReplicaSets[j].Revision)
+			return a > b
+		})
+		//Deployment直接管理的是ReplicaSet
+		delete(owners, uid)
+	}
+
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, meta_v1.ListOptions{LabelSelector: podSelector.String()})
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ref := meta_v1.GetControllerOf(pod)
+		if ref == nil || owners[ref.UID] == "" {
+			continue
+		}
+		info := podInfo{Name: pod.Name, Owner: owners[ref.UID], Ready: isPodReady(pod), Status: podPhaseReason(pod), Node: pod.Spec.NodeName}
+		for _, cs := range pod.Status.ContainerStatuses {
+			info.Restarts += cs.RestartCount
+		}
+		r.Pods = append(r.Pods, info)
+	}
+	sort.Slice(r.Pods, func(i, j int) bool {
+		return r.Pods[i].Name < r.Pods[j].Name
+	})
+
+	if err := r.describeServices(ctx, clientSet); err != nil {
+		return nil, err
+	}
+	if err := r.describeRefs(ctx, clientSet); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/*
+   selector匹配Pod模板标签的Service及其端点
+*/
+func (r *workloadRelations) describeServices(ctx context.Context, clientSet kubernetes.Interface) error {
+	services, err := clientSet.CoreV1().Services(r.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Service列表", err)
+	}
+	for _, svc := range services.Items {
+		if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(r.Template.Labels)) {
+			continue
+		}
+		info := serviceInfo{Name: svc.Name, Type: svc.Spec.Type, Ports: svc.Spec.Ports}
+		endpoints, err := clientSet.CoreV1().Endpoints(r.Namespace).Get(ctx, svc.Name, meta_v1.GetOptions{})
+		if info.Endpoints, err = exists(err); err != nil {
+			return wrapError("获取Endpoints", err)
+		}
+		if info.Endpoints {
+			for _, subset := range endpoints.Subsets {
+				info.Ready = append(info.Ready, endpointAddresses(subset.Addresses, subset.Ports)...)
+				info.NotReady = append(info.NotReady, endpointAddresses(subset.NotReadyAddresses, subset.Ports)...)
+			}
+		}
+		r.Services = append(r.Services, info)
+	}
+	sort.Slice(r.Services, func(i, j int) bool {
+		return r.Services[i].Name < r.Services[j].Name
+	})
+	return nil
+}
+
+func endpointAddresses(addresses []core_v1.EndpointAddress, ports []core_v1.EndpointPort) []string {
+	var result []string
+	for _, address := range addresses {
+		target := ""
+		if address.TargetRef != nil {
+			target = "(" + address.TargetRef.Name + ")"
+		}
+		if len(ports) == 0 {
+			result = append(result, address.IP+target)
+		}
+		for _, port := range ports {
+			result = append(result, fmt.Sprintf("%s:%d%s", address.IP, port.Port, target))
+		}
+	}
+	return result
+}
+
+/*
+   Pod模板引用的ConfigMap、Secret与PVC,以及PVC绑定的PV和StorageClass
+*/
+func (r *workloadRelations) describeRefs(ctx context.Context, clientSet kubernetes.Interface) error {
+	refs := collectTemplateRefs(&r.Template.Spec)
+	for _, name := range sortedKeys(refs.configMaps) {
+		_, err := clientSet.CoreV1().ConfigMaps(r.Namespace).Get(ctx, name, meta_v1.GetOptions{})
+		found, err := exists(err)
+		if err != nil {
+			return wrapError("获取ConfigMap", err)
+		}
+		r.ConfigMaps = append(r.ConfigMaps, mountedRef{Name: name, Found: found, Usages: refs.configMaps[name]})
+	}
+	for _, name := range sortedKeys(refs.secrets) {
+		_, err := clientSet.CoreV1().Secrets(r.Namespace).Get(ctx, name, meta_v1.GetOptions{})
+		found, err := exists(err)
+		if err != nil {
+			return wrapError("获取Secret", err)
+		}
+		r.Secrets = append(r.Secrets, mountedRef{Name: name, Found: found, Usages: refs.secrets[name]})
+	}
+	for _, name := range sortedKeys(refs.claims) {
+		claim := claimInfo{mountedRef: mountedRef{Name: name, Usages: refs.claims[name]}}
+		pvc, err := clientSet.CoreV1().PersistentVolumeClaims(r.Namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if claim.Found, err = exists(err); err != nil {
+			return wrapError("获取PVC", err)
+		}
+		if claim.Found {
+			if err := claim.describeVolume(ctx, clientSet, pvc); err != nil {
+				return err
+			}
+		}
+		r.Claims = append(r.Claims, claim)
+	}
+	return nil
+}
+
+func (c *claimInfo) describeVolume(ctx context.Context, clientSet kubernetes.Interface, pvc *core_v1.PersistentVolumeClaim) error {
+	c.Phase = pvc.Status.Phase
+	c.Volume = pvc.Spec.VolumeName
+	if pvc.Spec.StorageClassName != nil {
+		c.ClassName = *pvc.Spec.StorageClassName
+	}
+	if c.Volume != "" {
+		pv, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, c.Volume, meta_v1.GetOptions{})
+		if c.VolumeFound, err = exists(err); err != nil {
+			return wrapError("获取PV", err)
+		}
+		if c.VolumeFound {
+			capacity := pv.Spec.Capacity[core_v1.ResourceStorage]
+			c.Capacity = capacity.String()
+			c.ReclaimPolicy = string(pv.Spec.PersistentVolumeReclaimPolicy)
+			if pv.Spec.StorageClassName != "" {
+				c.ClassName = pv.Spec.StorageClassName
+			}
+		}
+	}
+	if c.ClassName == "" {
+		return nil
+	}
+	class, err := clientSet.StorageV1().StorageClasses().Get(ctx, c.ClassName, meta_v1.GetOptions{})
+	if c.ClassFound, err = exists(err); err != nil {
+		return wrapError("获取StorageClass", err)
+	}
+	if c.ClassFound {
+		c.Provisioner = class.Provisioner
+		if class.VolumeBindingMode != nil {
+			c.BindingMode = string(*class.VolumeBindingMode)
+		}
+	}
+	return nil
+}
+
+func servicePortsString(ports []core_v1.ServicePort) string {
+	var items []string
+	for _, port := range ports {
+		item := strconv.Itoa(int(port.Port))
+		if port.NodePort != 0 {
+			item += ":" + strconv.Itoa(int(port.NodePort))
+		}
+		item += "/" + string(port.Protocol)
+		if port.TargetPort.String() != "0" {
+			item += "->" + port.TargetPort.String()
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ",")
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "<none>"
+	}
+	return strings.Join(items, ", ")
+}
+
+func foundString(found bool) string {
+	if found {
+		return ""
+	}
+	return " (不存在)"
+}
+
+func printWorkloadRelations(out io.Writer, r *workloadRelations) {
+	fmt.Fprintf(out, "%s: %s/%s\n", r.Kind, r.Namespace, r.Name)
+	fmt.Fprintf(out, "状态: %s, %s\n", r.Status.state(), r.Status.Summary)
+	for _, detail := range r.Status.Details {
+		fmt.Fprintf(out, "  %s\n", detail)
+	}
+	fmt.Fprintf(out, "镜像: %s\n", containerImages(r.Template))
+
+	if r.Kind == "Deployment" {
+		fmt.Fprintln(out, "\nReplicaSet:")
+		if len(r.ReplicaSets) == 0 {
+			fmt.Fprintln(out, "  <none>")
+		} else {
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "  NAME\tREVISION\tREADY")
+			for _, rs := range r.ReplicaSets {
+				fmt.Fprintf(w, "  %s\t%s\t%d/%d\n", rs.Name, rs.Revision, rs.Ready, rs.Desired)
+			}
+			w.Flush()
+		}
+	}
+
+	fmt.Fprintln(out, "\nPod:")
+	if len(r.Pods) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	} else {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tREADY\tSTATUS\tRESTARTS\tNODE\tOWNER")
+		for _, pod := range r.Pods {
+			node := pod.Node
+			if node == "" {
+				node = "<none>"
+			}
+			fmt.Fprintf(w, "  %s\t%v\t%s\t%d\t%s\t%s\n", pod.Name, pod.Ready, pod.Status, pod.Restarts, node, pod.Owner)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(out, "\nService:")
+	if len(r.Services) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	}
+	for _, svc := range r.Services {
+		fmt.Fprintf(out, "  %s (%s %s)\n", svc.Name, svc.Type, servicePortsString(svc.Ports))
+		if !svc.Endpoints {
+			fmt.Fprintln(out, "    Endpoints不存在")
+			continue
+		}
+		fmt.Fprintf(out, "    就绪端点: %s\n", listOrNone(svc.Ready))
+		fmt.Fprintf(out, "    未就绪端点: %s\n", listOrNone(svc.NotReady))
+	}
+
+	for _, group := range []struct {
+		title string
+		refs  []mountedRef
+	}{{"ConfigMap", r.ConfigMaps}, {"Secret", r.Secrets}} {
+		fmt.Fprintf(out, "\n%s:\n", group.title)
+		if len(group.refs) == 0 {
+			fmt.Fprintln(out, "  <none>")
+		}
+		for _, ref := range group.refs {
+			fmt.Fprintf(out, "  %s%s: %s\n", ref.Name, foundString(ref.Found), strings.Join(ref.Usages, ", "))
+		}
+	}
+
+	fmt.Fprintln(out, "\nPVC:")
+	if len(r.Claims) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	}
+	for _, claim := range r.Claims {
+		fmt.Fprintf(out, "  %s%s: %s\n", claim.Name, foundString(claim.Found), strings.Join(claim.Usages, ", "))
+		if !claim.Found {
+			continue
+		}
+		fmt.Fprintf(out, "    状态: %s\n", claim.Phase)
+		if claim.Volume != "" {
+			fmt.Fprintf(out, "    PV: %s%s", claim.Volume, foundString(claim.VolumeFound))
+			if claim.VolumeFound {
+				fmt.Fprintf(out, " %s %s", claim.Capacity, claim.ReclaimPolicy)
+			}
+			fmt.Fprintln(out)
+		}
+		if claim.ClassName != "" {
+			fmt.Fprintf(out, "    StorageClass: %s%s", claim.ClassName, foundString(claim.ClassFound))
+			if claim.ClassFound {
+				fmt.Fprintf(out, " provisioner=%s", claim.Provisioner)
+				if claim.BindingMode != "" {
+					fmt.Fprintf(out, " volumeBindingMode=%s", claim.BindingMode)
+				}
+			}
+			fmt.Fprintln(out)
+		}
+	}
+}
+
+func runDescribe(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("describe", out)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: describe <资源类型>/<名称>")
+	}
+	kind, name, err := parseObjectRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := describeWorkload(ctx, clientSet, kind, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	printWorkloadRelations(out, r)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+/*
+   Deployment test-nginx 有两个版本的ReplicaSet,挂载ConfigMap与PVC,
+   通过envFrom引用一个不存在的Secret,Service test-nginx 匹配它而 other 不匹配
+*/
+func newDescribeObjects() []runtime.Object {
+	labels := map[string]string{"app": "test-nginx"}
+	className := "test-sc"
+	bindingMode := storage_v1.VolumeBindingWaitForFirstConsumer
+	template := core_v1.PodTemplateSpec{
+		ObjectMeta: meta_v1.ObjectMeta{Labels: labels},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{{
+				Name:    "test-nginx-container",
+				Image:   "nginx:1.23",
+				EnvFrom: []core_v1.EnvFromSource{{SecretRef: &core_v1.SecretEnvSource{LocalObjectReference: core_v1.LocalObjectReference{Name: "test-env"}}}},
+			}},
+			ImagePullSecrets: []core_v1.LocalObjectReference{{Name: TestDockerConfigJsonKey}},
+			Volumes: []core_v1.Volume{
+				{Name: "nginx-conf", VolumeSource: core_v1.VolumeSource{ConfigMap: &core_v1.ConfigMapVolumeSource{LocalObjectReference: core_v1.LocalObjectReference{Name: "test-configmap-nginx"}}}},
+				{Name: "vol", VolumeSource: core_v1.VolumeSource{PersistentVolumeClaim: &core_v1.PersistentVolumeClaimVolumeSource{ClaimName: "test-pvc"}}},
+			},
+		},
+	}
+	deployment := newTestDeployment(2, 2, 1, 2)
+	deployment.UID = "deploy-uid"
+	deployment.Spec.Selector = &meta_v1.LabelSelector{MatchLabels: labels}
+	deployment.Spec.Template = template
+	newRS := func(name, revision string, replicas, ready int32) *apps_v1.ReplicaSet {
+		return &apps_v1.ReplicaSet{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:            name,
+				Namespace:       TestNamespace,
+				UID:             types.UID("uid-" + name),
+				Labels:          labels,
+				Annotations:     map[string]string{revisionAnnotation: revision},
+				OwnerReferences: ownedBy("Deployment", "test-nginx", "deploy-uid"),
+			},
+			Spec:   apps_v1.ReplicaSetSpec{Replicas: &replicas},
+			Status: apps_v1.ReplicaSetStatus{ReadyReplicas: ready},
+		}
+	}
+	newPod := func(name, rs string, ready bool) *core_v1.Pod {
+		pod := newForwardPod(name, core_v1.PodRunning, ready)
+		pod.OwnerReferences = ownedBy("ReplicaSet", rs, types.UID("uid-"+rs))
+		pod.Spec.NodeName = "node1"
+		pod.Status.ContainerStatuses = []core_v1.ContainerStatus{{Name: "test-nginx-container", RestartCount: 2}}
+		return pod
+	}
+	pending := newPod("test-nginx-2-b", "test-nginx-2", false)
+	pending.Spec.NodeName = ""
+	pending.Status.ContainerStatuses[0].State.Waiting = &core_v1.ContainerStateWaiting{Reason: "ContainerCreating"}
+	return []runtime.Object{
+		deployment,
+		newRS("test-nginx-1", "1", 0, 0),
+		newRS("test-nginx-2", "2", 2, 1),
+		newPod("test-nginx-2-a", "test-nginx-2", true),
+		pending,
+		&core_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+			Spec: core_v1.ServiceSpec{
+				Type:     core_v1.ServiceTypeNodePort,
+				Selector: labels,
+				Ports:    []core_v1.ServicePort{{Name: "bdbdse", Port: 81, NodePort: 32000, Protocol: core_v1.ProtocolTCP, TargetPort: intstr.FromInt(81)}},
+			},
+		},
+		&core_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "other", Namespace: TestNamespace},
+			Spec:       core_v1.ServiceSpec{Selector: map[string]string{"app": "other"}},
+		},
+		&core_v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+			Subsets: []core_v1.EndpointSubset{{
+				Addresses:         []core_v1.EndpointAddress{{IP: "10.244.1.5", TargetRef: &core_v1.ObjectReference{Kind: "Pod", Name: "test-nginx-2-a"}}},
+				NotReadyAddresses: []core_v1.EndpointAddress{{IP: "10.244.1.6", TargetRef: &core_v1.ObjectReference{Kind: "Pod", Name: "test-nginx-2-b"}}},
+				Ports:             []core_v1.EndpointPort{{Name: "bdbdse", Port: 81, Protocol: core_v1.ProtocolTCP}},
+			}},
+		},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "test-configmap-nginx", Namespace: TestNamespace}},
+		&core_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Name: TestDockerConfigJsonKey, Namespace: TestNamespace}},
+		&core_v1.PersistentVolumeClaim{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-pvc", Namespace: TestNamespace},
+			Spec:       core_v1.PersistentVolumeClaimSpec{VolumeName: "test-pv", StorageClassName: &className},
+			Status:     core_v1.PersistentVolumeClaimStatus{Phase: core_v1.ClaimBound},
+		},
+		&core_v1.PersistentVolume{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-pv"},
+			Spec: core_v1.PersistentVolumeSpec{
+				Capacity:                      core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("1Gi")},
+				StorageClassName:              className,
+				PersistentVolumeReclaimPolicy: core_v1.PersistentVolumeReclaimRetain,
+			},
+		},
+		&storage_v1.StorageClass{ObjectMeta: meta_v1.ObjectMeta{Name: className}, Provisioner: "kubernetes.io/no-provisioner", VolumeBindingMode: &bindingMode},
+	}
+}
+
+func TestDescribeWorkload(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newDescribeObjects()...)
+	r, err := describeWorkload(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printWorkloadRelations(&out, r)
+	assertGolden(t, "describe_deployment.golden", out.Bytes())
+
+	if _, err := describeWorkload(context.TODO(), clientSet, "deployment", TestNamespace, "missing"); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing deployment error = %v", err)
+	}
+	if _, err := describeWorkload(context.TODO(), clientSet, "service", TestNamespace, "test-nginx"); errorKindOf(err) != ErrUsage {
+		t.Errorf("service error = %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"net/url"
+	"strings"
+)
+
+/*
+   错误分类,每一类对应一个独立的退出码,便于脚本根据退出码做判断
+   0 成功, 1 未分类错误, 2 命令行参数错误
+*/
+type errorKind int
+
+const (
+	ErrUnknown           errorKind = iota //未分类错误
+	ErrNotFound                           //资源不存在
+	ErrAlreadyExists                      //资源已存在
+	ErrConflict                           //资源版本冲突
+	ErrForbidden                          //无权限或未认证
+	ErrInvalidManifest                    //清单文件无法读取、解析或被ApiServer判定为非法
+	ErrServerUnreachable                  //无法连接ApiServer
+	ErrTimeout                            //请求超时
+	ErrUsage                              //命令行参数错误
+)
+
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+var exitCodes = map[errorKind]int{
+	ErrUnknown:           ExitError,
+	ErrNotFound:          3,
+	ErrAlreadyExists:     4,
+	ErrConflict:          5,
+	ErrForbidden:         6,
+	ErrInvalidManifest:   7,
+	ErrServerUnreachable: 8,
+	ErrTimeout:           9,
+	ErrUsage:             ExitUsage,
+}
+
+var kindNames = map[errorKind]string{
+	ErrUnknown:           "Unknown",
+	ErrNotFound:          "NotFound",
+	ErrAlreadyExists:     "AlreadyExists",
+	ErrConflict:          "Conflict",
+	ErrForbidden:         "Forbidden",
+	ErrInvalidManifest:   "InvalidManifest",
+	ErrServerUnreachable: "ServerUnreachable",
+	ErrTimeout:           "Timeout",
+	ErrUsage:             "Usage",
+}
+
+func (k errorKind) String() string {
+	return kindNames[k]
+}
+
+/*
+   带分类的错误,Action描述正在进行的操作,如"创建Deployment"
+   Causes为ApiServer返回的Status.Details.Causes,通常包含非法字段信息
+*/
+type clientError struct {
+	Kind   errorKind
+	Action string
+	Causes []meta_v1.StatusCause
+	Err    error
+}
+
+func (e *clientError) Error() string {
+	return fmt.Sprintf("%s失败[%s]: %v", e.Action, e.Kind, e.Err)
+}
+
+func (e *clientError) Unwrap() error {
+	return e.Err
+}
+
+/*
+   输出错误详情,包含字段级别的原因
+*/
+func (e *clientError) Detail() string {
+	var sb strings.Builder
+	sb.WriteString(e.Error())
+	for _, cause := range e.Causes {
+		sb.WriteString("\n  - ")
+		if cause.Field != "" {
+			sb.WriteString(cause.Field)
+			sb.WriteString(": ")
+		}
+		sb.WriteString(cause.Message)
+		if cause.Type != "" {
+			sb.WriteString(" (")
+			sb.WriteString(string(cause.Type))
+			sb.WriteString(")")
+		}
+	}
+	return sb.String()
+}
+
+/*
+   包装ApiServer或网络返回的错误,并按类型分类
+*/
+func wrapError(action string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *clientError
+	if errors.As(err, &ce) {
+		return err
+	}
+	e := &clientError{Kind: classify(err), Action: action, Err: err}
+	var status k8s_errors.APIStatus
+	if errors.As(err, &status) {
+		if details := status.Status().Details; details != nil {
+			e.Causes = details.Causes
+		}
+	}
+	return e
+}
+
+/*
+   清单文件读取或解析失败
+*/
+func manifestError(path string, err error) error {
+	return &clientError{Kind: ErrInvalidManifest, Action: "解析清单" + path, Err: err}
+}
+
+/*
+   kubeconfig无法读取、解析或据此构造客户端失败,归为参数错误,需要检查-kubeconfig及profile配置
+*/
+func configError(action string, err error) error {
+	return &clientError{Kind: ErrUsage, Action: action, Err: err}
+}
+
+/*
+   命令行参数错误
+*/
+func usageError(format string, a ...interface{}) error {
+	return &clientError{Kind: ErrUsage, Action: "解析命令行参数", Err: fmt.Errorf(format, a...)}
+}
+
+func classify(err error) errorKind {
+	switch {
+	case k8s_errors.IsNotFound(err):
+		return ErrNotFound
+	case k8s_errors.IsAlreadyExists(err):
+		return ErrAlreadyExists
+	case k8s_errors.IsConflict(err):
+		return ErrConflict
+	case k8s_errors.IsForbidden(err), k8s_errors.IsUnauthorized(err):
+		return ErrForbidden
+	case k8s_errors.IsInvalid(err), k8s_errors.IsBadRequest(err):
+		return ErrInvalidManifest
+	case k8s_errors.IsTimeout(err), k8s_errors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case k8s_errors.IsServiceUnavailable(err):
+		return ErrServerUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return ErrServerUnreachable
+	}
+	return ErrUnknown
+}
+
+/*
+   获取错误分类,非clientError视为未分类错误
+*/
+func errorKindOf(err error) errorKind {
+	var ce *clientError
+	if errors.As(err, &ce) {
+		return ce.Kind
+	}
+	return ErrUnknown
+}
+
+/*
+   错误对应的进程退出码
+*/
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	return exitCodes[errorKindOf(err)]
+}
+
+/*
+   格式化错误输出,clientError会附带字段原因
+*/
+func errorDetail(err error) string {
+	var ce *clientError
+	if errors.As(err, &ce) {
+		return ce.Detail()
+	}
+	return err.Error()
+}
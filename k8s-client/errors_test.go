@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, ExitOK},
+		{"plain error", fmt.Errorf("boom"), ExitError},
+		{"not found", k8s_errors.NewNotFound(gr, "test-nginx"), 3},
+		{"already exists", k8s_errors.NewAlreadyExists(gr, "test-nginx"), 4},
+		{"conflict", k8s_errors.NewConflict(gr, "test-nginx", fmt.Errorf("modified")), 5},
+		{"forbidden", k8s_errors.NewForbidden(gr, "test-nginx", fmt.Errorf("denied")), 6},
+		{"unauthorized", k8s_errors.NewUnauthorized("token expired"), 6},
+		{"bad request", k8s_errors.NewBadRequest("bad"), 7},
+		{"server timeout", k8s_errors.NewTimeoutError("slow", 1), 9},
+		{"deadline", context.DeadlineExceeded, 9},
+		{"service unavailable", k8s_errors.NewServiceUnavailable("down"), 8},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://192.168.2.111:6443", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}, 8},
+		{"dns", &net.DNSError{Err: "no such host", Name: "master"}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.err != nil {
+				err = wrapError("测试", tt.err)
+			}
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapErrorKeepsKind(t *testing.T) {
+	err := wrapError("创建Deployment", k8s_errors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, TestNamespace))
+	wrapped := wrapError("外层操作", fmt.Errorf("context: %w", err))
+	if got := errorKindOf(wrapped); got != ErrNotFound {
+		t.Errorf("errorKindOf() = %v, want %v", got, ErrNotFound)
+	}
+	if !strings.HasPrefix(wrapped.Error(), "context: 创建Deployment失败[NotFound]") {
+		t.Errorf("Error() = %q", wrapped.Error())
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"valid", "./yaml/deployment.yaml", false},
+		{"missing", "./yaml/missing.yaml", true},
+		{"not yaml", "./testdata/invalid.yaml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj map[string]interface{}
+			err := readManifest(tt.path, &obj)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if got := errorKindOf(err); got != ErrInvalidManifest {
+				t.Errorf("errorKindOf() = %v, want %v", got, ErrInvalidManifest)
+			}
+		})
+	}
+}
+
+func TestInitRESTConfigErrors(t *testing.T) {
+	original := activeProfile
+	defer func() { activeProfile = original }()
+	for _, path := range []string{"./testdata/missing-kubeconfig", "./testdata/invalid.yaml"} {
+		activeProfile.Kubeconfig = path
+		_, err := initRESTConfig(context.TODO())
+		if got := errorKindOf(err); got != ErrUsage {
+			t.Errorf("%s: errorKindOf() = %v, want %v (err: %v)", path, got, ErrUsage, err)
+		}
+	}
+}
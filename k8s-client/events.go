@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+   资源类型对应的Kind,与事件的involvedObject.kind一致
+*/
+var objectKindNames = map[string]string{
+	"pod":           "Pod",
+	"namespace":     "Namespace",
+	"configmap":     "ConfigMap",
+	"secret":        "Secret",
+	"deployment":    "Deployment",
+	"statefulset":   "StatefulSet",
+	"daemonset":     "DaemonSet",
+	"job":           "Job",
+	"cronjob":       "CronJob",
+	"service":       "Service",
+	"ingress":       "Ingress",
+	"networkpolicy": "NetworkPolicy",
+	"hpa":           "HorizontalPodAutoscaler",
+	"storageclass":  "StorageClass",
+	"pv":            "PersistentVolume",
+	"pvc":           "PersistentVolumeClaim",
+}
+
+/*
+   关系树中会在跟踪过程中新建的对象
+*/
+var dependentKinds = map[string]bool{
+	"ReplicaSet":       true,
+	"Job":              true,
+	"Pod":              true,
+	"PersistentVolume": true,
+}
+
+/*
+   集群级别对象的事件记录在default命名空间中
+*/
+const clusterEventNamespace = meta_v1.NamespaceDefault
+
+/*
+   事件关联的对象,集群级别对象的Namespace为空
+*/
+type objectKey struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (k objectKey) String() string {
+	return k.Kind + "/" + k.Name
+}
+
+/*
+   获取对象及其所属关系树中的所有对象:
+   Deployment -> ReplicaSet -> Pod, CronJob -> Job -> Pod, StatefulSet/DaemonSet/Job -> Pod, PVC -> PV
+*/
+func ownershipTree(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) ([]objectKey, error) {
+	kindName, ok := objectKindNames[kind]
+	if !ok {
+		return nil, usageError("不支持的资源类型: %s", kind)
+	}
+	root := objectKey{Kind: kindName, Namespace: namespace, Name: name}
+	switch kind {
+	case "namespace", "storageclass", "pv":
+		root.Namespace = ""
+	}
+	keys := []objectKey{root}
+	var owners []types.UID
+	switch kind {
+	case "deployment":
+		obj, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Deployment", err)
+		}
+		list, err := clientSet.AppsV1().ReplicaSets(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取ReplicaSet列表", err)
+		}
+		for _, rs := range list.Items {
+			if ref := meta_v1.GetControllerOf(&rs); ref != nil && ref.UID == obj.UID {
+				keys = append(keys, objectKey{Kind: "ReplicaSet", Namespace: namespace, Name: rs.Name})
+				owners = append(owners, rs.UID)
+			}
+		}
+	case "cronjob":
+		obj, err := clientSet.BatchV1().CronJobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取CronJob", err)
+		}
+		list, err := clientSet.BatchV1().Jobs(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取Job列表", err)
+		}
+		for _, job := range list.Items {
+			if ref := meta_v1.GetControllerOf(&job); ref != nil && ref.UID == obj.UID {
+				keys = append(keys, objectKey{Kind: "Job", Namespace: namespace, Name: job.Name})
+				owners = append(owners, job.UID)
+			}
+		}
+	case "statefulset":
+		obj, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取StatefulSet", err)
+		}
+		owners = append(owners, obj.UID)
+	case "daemonset":
+		obj, err := clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取DaemonSet", err)
+		}
+		owners = append(owners, obj.UID)
+	case "job":
+		obj, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Job", err)
+		}
+		owners = append(owners, obj.UID)
+	case "pvc":
+		obj, err := clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取PVC", err)
+		}
+		if obj.Spec.VolumeName != "" {
+			keys = append(keys, objectKey{Kind: "PersistentVolume", Name: obj.Spec.VolumeName})
+		}
+	}
+	if len(owners) > 0 {
+		pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取Pod列表", err)
+		}
+		for _, pod := range pods.Items {
+			ref := meta_v1.GetControllerOf(&pod)
+			if ref == nil {
+				continue
+			}
+			for _, uid := range owners {
+				if ref.UID == uid {
+					keys = append(keys, objectKey{Kind: "Pod", Namespace: namespace, Name: pod.Name})
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+/*
+   合并关系树中所有对象的事件,follow时持续监听
+   关系树在遇到未知对象的事件时重新获取,发布过程中新建的ReplicaSet与Pod也会被包含
+*/
+type eventTimeline struct {
+	clientSet kubernetes.Interface
+	kind      string
+	namespace string
+	name      string
+	out       io.Writer
+	mu        sync.Mutex
+	objects   map[objectKey]bool
+}
+
+func (t *eventTimeline) refresh(ctx context.Context) error {
+	keys, err := ownershipTree(ctx, t.clientSet, t.kind, t.namespace, t.name)
+	if err != nil {
+		return err
+	}
+	objects := map[objectKey]bool{}
+	for _, key := range keys {
+		objects[key] = true
+	}
+	t.mu.Lock()
+	t.objects = objects
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *eventTimeline) namespaces() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	namespaces := map[string]bool{}
+	for key := range t.objects {
+		if key.Namespace == "" {
+			namespaces[clusterEventNamespace] = true
+		} else {
+			namespaces[key.Namespace] = true
+		}
+	}
+	//PVC在跟踪过程中才绑定PV时也需要监听集群级别对象的事件
+	if t.kind == "pvc" {
+		namespaces[clusterEventNamespace] = true
+	}
+	var list []string
+	for ns := range namespaces {
+		list = append(list, ns)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func involvedKey(event *core_v1.Event) objectKey {
+	return objectKey{Kind: event.InvolvedObject.Kind, Namespace: event.InvolvedObject.Namespace, Name: event.InvolvedObject.Name}
+}
+
+func (t *eventTimeline) contains(event *core_v1.Event) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.objects[involvedKey(event)]
+}
+
+func printEvent(out io.Writer, event *core_v1.Event) {
+	count := ""
+	if event.Count > 1 {
+		count = fmt.Sprintf(" (x%d)", event.Count)
+	}
+	fmt.Fprintf(out, "%s  %-7s  %s  %s  %s%s\n", eventTime(*event).Format(time.RFC3339), event.Type, involvedKey(event), event.Reason, strings.TrimSpace(event.Message), count)
+}
+
+/*
+   输出关系树中所有对象的事件,按时间排序,返回每个命名空间事件列表的ResourceVersion
+*/
+func (t *eventTimeline) printHistory(ctx context.Context) (map[string]string, error) {
+	versions := map[string]string{}
+	var events []core_v1.Event
+	for _, ns := range t.namespaces() {
+		list, err := t.clientSet.CoreV1().Events(ns).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取事件列表", err)
+		}
+		versions[ns] = list.ResourceVersion
+		for i := range list.Items {
+			if t.contains(&list.Items[i]) {
+				events = append(events, list.Items[i])
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	for i := range events {
+		printEvent(t.out, &events[i])
+	}
+	return versions, nil
+}
+
+/*
+   监听一个命名空间的事件,watch被服务端关闭时从最后的ResourceVersion重新监听
+*/
+func (t *eventTimeline) watch(ctx context.Context, namespace, resourceVersion string) error {
+	client := t.clientSet.CoreV1().Events(namespace)
+	for ctx.Err() == nil {
+		w, err := client.Watch(ctx, meta_v1.ListOptions{ResourceVersion: resourceVersion})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return wrapError("监听事件", err)
+		}
+		resourceVersion = t.handleEvents(ctx, w, resourceVersion)
+		w.Stop()
+	}
+	return nil
+}
+
+func (t *eventTimeline) handleEvents(ctx context.Context, w watch.Interface, resourceVersion string) string {
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			event, ok := e.Object.(*core_v1.Event)
+			if !ok || (e.Type != watch.Added && e.Type != watch.Modified) {
+				continue
+			}
+			resourceVersion = event.ResourceVersion
+			if !t.contains(event) {
+				//可能是关系树中新建的对象,重新获取后再判断
+				if !dependentKinds[event.InvolvedObject.Kind] {
+					continue
+				}
+				if err := t.refresh(ctx); err != nil || !t.contains(event) {
+					continue
+				}
+			}
+			t.mu.Lock()
+			printEvent(t.out, event)
+			t.mu.Unlock()
+		}
+	}
+}
+
+func showEvents(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, follow bool, out io.Writer) error {
+	t := &eventTimeline{clientSet: clientSet, kind: kind, namespace: namespace, name: name, out: out}
+	if err := t.refresh(ctx); err != nil {
+		return err
+	}
+	versions, err := t.printHistory(ctx)
+	if err != nil {
+		return err
+	}
+	if !follow {
+		return nil
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(versions))
+	for ns, rv := range versions {
+		wg.Add(1)
+		go func(ns, rv string) {
+			defer wg.Done()
+			errs <- t.watch(ctx, ns, rv)
+		}(ns, rv)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runEvents(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("events", out)
+	follow := fs.Bool("f", false, "持续监听新的事件")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: events [-f] <资源类型>/<名称>")
+	}
+	kind, name, err := parseObjectRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	return showEvents(ctx, clientSet, kind, activeProfile.Namespace, name, *follow, out)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+var eventBaseTime = time.Date(2023, 1, 2, 3, 4, 0, 0, time.UTC)
+
+func newTestEvent(name, namespace, kind, objectNamespace, objectName, reason, message string, minute int) *core_v1.Event {
+	return &core_v1.Event{
+		ObjectMeta:     meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+		InvolvedObject: core_v1.ObjectReference{Kind: kind, Namespace: objectNamespace, Name: objectName},
+		Type:           core_v1.EventTypeNormal,
+		Reason:         reason,
+		Message:        message,
+		Count:          1,
+		LastTimestamp:  meta_v1.NewTime(eventBaseTime.Add(time.Duration(minute) * time.Minute)),
+	}
+}
+
+func ownedBy(kind, name string, uid types.UID) []meta_v1.OwnerReference {
+	isController := true
+	return []meta_v1.OwnerReference{{Kind: kind, Name: name, UID: uid, Controller: &isController}}
+}
+
+/*
+   Deployment test-nginx -> ReplicaSet test-nginx-1 -> Pod test-nginx-1-a,
+   另有不属于它的Pod other以及PVC test-pvc -> PV test-pv
+*/
+func newEventObjects() []runtime.Object {
+	failed := newTestEvent("e4", TestNamespace, "Pod", TestNamespace, "test-nginx-1-a", "BackOff", "Back-off restarting failed container", 3)
+	failed.Type = core_v1.EventTypeWarning
+	failed.Count = 5
+	return []runtime.Object{
+		&apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace, UID: "deploy-uid"}},
+		&apps_v1.ReplicaSet{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx-1", Namespace: TestNamespace, UID: "rs-uid", OwnerReferences: ownedBy("Deployment", "test-nginx", "deploy-uid")}},
+		&core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx-1-a", Namespace: TestNamespace, OwnerReferences: ownedBy("ReplicaSet", "test-nginx-1", "rs-uid")}},
+		&core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Name: "other", Namespace: TestNamespace, OwnerReferences: ownedBy("ReplicaSet", "other", "other-uid")}},
+		&core_v1.PersistentVolumeClaim{ObjectMeta: meta_v1.ObjectMeta{Name: "test-pvc", Namespace: TestNamespace}, Spec: core_v1.PersistentVolumeClaimSpec{VolumeName: "test-pv"}},
+		newTestEvent("e2", TestNamespace, "ReplicaSet", TestNamespace, "test-nginx-1", "SuccessfulCreate", "Created pod: test-nginx-1-a", 1),
+		newTestEvent("e1", TestNamespace, "Deployment", TestNamespace, "test-nginx", "ScalingReplicaSet", "Scaled up replica set test-nginx-1 to 1", 0),
+		newTestEvent("e3", TestNamespace, "Pod", TestNamespace, "test-nginx-1-a", "Scheduled", "Successfully assigned test-namespace/test-nginx-1-a to node1", 2),
+		failed,
+		newTestEvent("e5", TestNamespace, "Pod", TestNamespace, "other", "Scheduled", "Successfully assigned test-namespace/other to node1", 2),
+		newTestEvent("e6", TestNamespace, "PersistentVolumeClaim", TestNamespace, "test-pvc", "ExternalProvisioning", "waiting for a volume to be created", 0),
+		newTestEvent("e7", clusterEventNamespace, "PersistentVolume", "", "test-pv", "VolumeFailedRecycle", "recycler pod failed", 1),
+	}
+}
+
+func TestShowEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		object string
+		golden string
+	}{
+		{name: "deployment", kind: "deployment", object: "test-nginx", golden: "events_deployment.golden"},
+		{name: "pvc", kind: "pvc", object: "test-pvc", golden: "events_pvc.golden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(newEventObjects()...)
+			var out bytes.Buffer
+			if err := showEvents(context.Background(), clientSet, tt.kind, TestNamespace, tt.object, false, &out); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, tt.golden, out.Bytes())
+		})
+	}
+
+	clientSet := fake.NewSimpleClientset()
+	if err := showEvents(context.Background(), clientSet, "deployment", TestNamespace, "missing", false, &bytes.Buffer{}); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing deployment error = %v", err)
+	}
+}
+
+func TestShowEventsFollow(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newEventObjects()...)
+	watcher := watch.NewFake()
+	clientSet.PrependWatchReactor("events", k8s_testing.DefaultWatchReactor(watcher, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- showEvents(ctx, clientSet, "deployment", TestNamespace, "test-nginx", true, out)
+	}()
+
+	//发布过程中新建的ReplicaSet在事件到达前已存在,重新获取关系树后应被输出;无关对象的事件不输出
+	newRS := &apps_v1.ReplicaSet{ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx-2", Namespace: TestNamespace, UID: "rs2-uid", OwnerReferences: ownedBy("Deployment", "test-nginx", "deploy-uid")}}
+	if err := clientSet.Tracker().Add(newRS); err != nil {
+		t.Fatal(err)
+	}
+	watcher.Add(newTestEvent("e8", TestNamespace, "Pod", TestNamespace, "other", "Killing", "Stopping container", 4))
+	watcher.Add(newTestEvent("e9", TestNamespace, "ReplicaSet", TestNamespace, "test-nginx-2", "SuccessfulCreate", "Created pod: test-nginx-2-a", 5))
+
+	want := "ReplicaSet/test-nginx-2  SuccessfulCreate"
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(strings.Join(out.lines(), "\n"), want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(out.lines(), "\n")
+	if !strings.Contains(got, want) || strings.Contains(got, "Killing") {
+		t.Errorf("output:\n%s", got)
+	}
+}
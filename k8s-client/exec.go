@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"os"
+	"strings"
+)
+
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+/*
+   在Pod的容器中执行命令,exec与cp共用,测试中替换为本地实现
+*/
+type podExecutor interface {
+	exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error
+}
+
+/*
+   通过SPDY升级连接调用Pod的exec子资源
+*/
+type spdyExecutor struct {
+	config    *rest.Config
+	clientSet kubernetes.Interface
+}
+
+func (e *spdyExecutor) exec(namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
+	req := e.clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&core_v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil && !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	opts := remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Tty: tty}
+	if !tty {
+		opts.Stderr = stderr
+	}
+	return executor.Stream(opts)
+}
+
+func newSPDYExecutor(ctx context.Context) (*spdyExecutor, error) {
+	config, err := initRESTConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, configError("初始化客户端", err)
+	}
+	return &spdyExecutor{config: config, clientSet: clientSet}, nil
+}
+
+/*
+   从目标对应的Pod中选择一个运行中的Pod,优先选择已就绪的
+*/
+func choosePod(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (*core_v1.Pod, error) {
+	pods, err := targetPods(ctx, clientSet, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	var running *core_v1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != core_v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if isPodReady(pod) {
+			return pod, nil
+		}
+		if running == nil {
+			running = pod
+		}
+	}
+	if running == nil {
+		return nil, &clientError{Kind: ErrNotFound, Action: "选择Pod", Err: fmt.Errorf("%s/%s 没有运行中的Pod", kind, name)}
+	}
+	return running, nil
+}
+
+/*
+   未指定容器时使用default-container注解中的容器,没有注解时使用第一个容器
+*/
+func podContainer(pod *core_v1.Pod, container string) (string, error) {
+	if container == "" {
+		container = pod.Annotations[defaultContainerAnnotation]
+	}
+	if container == "" {
+		return pod.Spec.Containers[0].Name, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return container, nil
+		}
+	}
+	return "", &clientError{Kind: ErrNotFound, Action: "选择容器", Err: fmt.Errorf("Pod %s 中没有容器 %s", pod.Name, container)}
+}
+
+/*
+   执行命令并将非0退出码转换为错误,stderr中的内容一并输出便于排查,如容器中没有tar
+*/
+func execCommand(executor podExecutor, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	if err := executor.exec(namespace, pod, container, command, stdin, stdout, &stderr, false); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return execError(command, err)
+	}
+	return nil
+}
+
+func execError(command []string, err error) error {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return &clientError{Kind: ErrUnknown, Action: "执行" + command[0], Err: err}
+	}
+	return wrapError("执行"+command[0], err)
+}
+
+func runExec(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("exec", out)
+	container := fs.String("c", "", "容器名称,默认为default-container注解中的容器或第一个容器")
+	stdin := fs.Bool("i", false, "将标准输入传给容器")
+	tty := fs.Bool("t", false, "分配终端,需要同时指定-i")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return usageError("用法: exec [参数] <资源类型>/<名称> <命令> [参数...]")
+	}
+	if *tty && !*stdin {
+		return usageError("-t需要同时指定-i")
+	}
+	kind, name, err := parseObjectRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	command := fs.Args()[1:]
+	if command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		return usageError("缺少要执行的命令")
+	}
+	executor, err := newSPDYExecutor(ctx)
+	if err != nil {
+		return err
+	}
+	pod, err := choosePod(ctx, executor.clientSet, kind, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	containerName, err := podContainer(pod, *container)
+	if err != nil {
+		return err
+	}
+	var in io.Reader
+	if *stdin {
+		in = os.Stdin
+	}
+	if *tty && term.IsTerminal(int(os.Stdin.Fd())) {
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("设置终端失败: %w", err)
+		}
+		defer term.Restore(int(os.Stdin.Fd()), state)
+	}
+	return execError(command, executor.exec(activeProfile.Namespace, pod.Name, containerName, command, in, out, os.Stderr, *tty))
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+   关系类型
+*/
+const (
+	edgeOwner     = "owner"     //ownerReferences,由所有者指向被管理的对象
+	edgeSelector  = "selector"  //Service、NetworkPolicy的selector匹配工作负载
+	edgeMount     = "mount"     //以数据卷挂载ConfigMap、Secret、PVC
+	edgeReference = "reference" //通过环境变量、imagePullSecrets引用,Ingress后端,HPA伸缩目标
+	edgeBinding   = "binding"   //PVC -> PV -> StorageClass
+)
+
+type graphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Missing   bool   `json:"missing,omitempty"` //被引用但不存在
+}
+
+type graphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+}
+
+/*
+   命名空间内对象之间的关系图
+*/
+type resourceGraph struct {
+	Namespace string      `json:"namespace"`
+	Nodes     []graphNode `json:"nodes"`
+	Edges     []graphEdge `json:"edges"`
+	nodes     map[string]int
+	edges     map[graphEdge]bool
+}
+
+func newResourceGraph(namespace string) *resourceGraph {
+	return &resourceGraph{Namespace: namespace, nodes: map[string]int{}, edges: map[graphEdge]bool{}}
+}
+
+func nodeID(kind, name string) string {
+	return kind + "/" + name
+}
+
+/*
+   添加存在的对象,之前作为缺失对象添加过时更正
+*/
+func (g *resourceGraph) addNode(kind, namespace, name string) string {
+	id := nodeID(kind, name)
+	if i, ok := g.nodes[id]; ok {
+		g.Nodes[i].Missing = false
+		return id
+	}
+	g.nodes[id] = len(g.Nodes)
+	g.Nodes = append(g.Nodes, graphNode{ID: id, Kind: kind, Name: name, Namespace: namespace})
+	return id
+}
+
+/*
+   添加被引用的对象,尚未添加时标记为缺失
+*/
+func (g *resourceGraph) refNode(kind, namespace, name string) string {
+	id := nodeID(kind, name)
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, graphNode{ID: id, Kind: kind, Name: name, Namespace: namespace, Missing: true})
+	}
+	return id
+}
+
+func (g *resourceGraph) addEdge(from, to, edgeType, label string) {
+	edge := graphEdge{From: from, To: to, Type: edgeType, Label: label}
+	if !g.edges[edge] {
+		g.edges[edge] = true
+		g.Edges = append(g.Edges, edge)
+	}
+}
+
+/*
+   节点按类型的先后顺序与名称排序,便于输出稳定
+*/
+var graphKindOrder = []string{
+	"Ingress", "Service", "HorizontalPodAutoscaler", "NetworkPolicy", "CronJob", "Deployment", "StatefulSet", "DaemonSet",
+	"ReplicaSet", "Job", "Pod", "ConfigMap", "Secret", "PersistentVolumeClaim", "PersistentVolume", "StorageClass",
+}
+
+func (g *resourceGraph) sort() {
+	rank := map[string]int{}
+	for i, kind := range graphKindOrder {
+		rank[kind] = i
+	}
+	sort.SliceStable(g.Nodes, func(i, j int) bool {
+		a, b := g.Nodes[i], g.Nodes[j]
+		if rank[a.Kind] != rank[b.Kind] {
+			return rank[a.Kind] < rank[b.Kind]
+		}
+		return a.Name < b.Name
+	})
+	for i, node := range g.Nodes {
+		g.nodes[node.ID] = i
+	}
+	sort.SliceStable(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if g.nodes[a.From] != g.nodes[b.From] {
+			return g.nodes[a.From] < g.nodes[b.From]
+		}
+		if g.nodes[a.To] != g.nodes[b.To] {
+			return g.nodes[a.To] < g.nodes[b.To]
+		}
+		return a.Type < b.Type
+	})
+}
+
+/*
+   带Pod模板的对象,用于selector匹配与引用关系
+*/
+type templateOwner struct {
+	id       string
+	template *core_v1.PodTemplateSpec
+}
+
+/*
+   获取命名空间内的对象并建立关系图
+*/
+func buildResourceGraph(ctx context.Context, clientSet kubernetes.Interface, namespace string) (*resourceGraph, error) {
+	g := newResourceGraph(namespace)
+	var owners []templateOwner
+	addOwnerRefs := func(id string, obj meta_v1.Object) {
+		for _, ref := range obj.GetOwnerReferences() {
+			g.addEdge(g.refNode(ref.Kind, namespace, ref.Name), id, edgeOwner, "")
+		}
+	}
+
+	deployments, err := clientSet.AppsV1().Deployments(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Deployment列表", err)
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		owners = append(owners, templateOwner{g.addNode("Deployment", namespace, d.Name), &d.Spec.Template})
+	}
+	statefulSets, err := clientSet.AppsV1().StatefulSets(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取StatefulSet列表", err)
+	}
+	for i := range statefulSets.Items {
+		sts := &statefulSets.Items[i]
+		id := g.addNode("StatefulSet", namespace, sts.Name)
+		owners = append(owners, templateOwner{id, &sts.Spec.Template})
+		//volumeClaimTemplates创建的PVC名称为 模板名-StatefulSet名-序号
+		for _, claim := range sts.Spec.VolumeClaimTemplates {
+			replicas := int32(1)
+			if sts.Spec.Replicas != nil {
+				replicas = *sts.Spec.Replicas
+			}
+			for ordinal := int32(0); ordinal < replicas; ordinal++ {
+				name := claim.Name + "-" + sts.Name + "-" + strconv.Itoa(int(ordinal))
+				g.addEdge(id, g.refNode("PersistentVolumeClaim", namespace, name), edgeMount, "volumeClaimTemplates "+claim.Name)
+			}
+		}
+	}
+	daemonSets, err := clientSet.AppsV1().DaemonSets(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取DaemonSet列表", err)
+	}
+	for i := range daemonSets.Items {
+		ds := &daemonSets.Items[i]
+		owners = append(owners, templateOwner{g.addNode("DaemonSet", namespace, ds.Name), &ds.Spec.Template})
+	}
+	cronJobs, err := clientSet.BatchV1().CronJobs(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取CronJob列表", err)
+	}
+	for i := range cronJobs.Items {
+		cj := &cronJobs.Items[i]
+		owners = append(owners, templateOwner{g.addNode("CronJob", namespace, cj.Name), &cj.Spec.JobTemplate.Spec.Template})
+	}
+	//ReplicaSet、Job、Pod由控制器创建时只添加所有关系,模板的引用关系由控制器表示
+	replicaSets, err := clientSet.AppsV1().ReplicaSets(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取ReplicaSet列表", err)
+	}
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		id := g.addNode("ReplicaSet", namespace, rs.Name)
+		addOwnerRefs(id, rs)
+		if meta_v1.GetControllerOf(rs) == nil {
+			owners = append(owners, templateOwner{id, &rs.Spec.Template})
+		}
+	}
+	jobs, err := clientSet.BatchV1().Jobs(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Job列表", err)
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		id := g.addNode("Job", namespace, job.Name)
+		addOwnerRefs(id, job)
+		if meta_v1.GetControllerOf(job) == nil {
+			owners = append(owners, templateOwner{id, &job.Spec.Template})
+		}
+	}
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		id := g.addNode("Pod", namespace, pod.Name)
+		addOwnerRefs(id, pod)
+		if meta_v1.GetControllerOf(pod) == nil {
+			owners = append(owners, templateOwner{id, &core_v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}})
+		}
+	}
+
+	if err := g.addConfigObjects(ctx, clientSet); err != nil {
+		return nil, err
+	}
+	for _, owner := range owners {
+		g.addTemplateRefs(owner)
+	}
+	if err := g.addNetworkObjects(ctx, clientSet, owners); err != nil {
+		return nil, err
+	}
+	if err := g.addStorageObjects(ctx, clientSet); err != nil {
+		return nil, err
+	}
+	g.sort()
+	return g, nil
+}
+
+func (g *resourceGraph) addConfigObjects(ctx context.Context, clientSet kubernetes.Interface) error {
+	configMaps, err := clientSet.CoreV1().ConfigMaps(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取ConfigMap列表", err)
+	}
+	for _, cm := range configMaps.Items {
+		g.addNode("ConfigMap", g.Namespace, cm.Name)
+	}
+	secrets, err := clientSet.CoreV1().Secrets(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Secret列表", err)
+	}
+	for _, secret := range secrets.Items {
+		//ServiceAccount的token由控制器维护,不属于应用拓扑
+		if secret.Type == core_v1.SecretTypeServiceAccountToken {
+			continue
+		}
+		g.addNode("Secret", g.Namespace, secret.Name)
+	}
+	claims, err := clientSet.CoreV1().PersistentVolumeClaims(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取PVC列表", err)
+	}
+	for _, pvc := range claims.Items {
+		g.addNode("PersistentVolumeClaim", g.Namespace, pvc.Name)
+	}
+	return nil
+}
+
+/*
+   Pod模板通过数据卷挂载或通过环境变量、imagePullSecrets引用的对象
+*/
+func (g *resourceGraph) addTemplateRefs(owner templateOwner) {
+	refs := collectTemplateRefs(&owner.template.Spec)
+	for _, group := range []struct {
+		kind string
+		refs map[string][]string
+	}{{"ConfigMap", refs.configMaps}, {"Secret", refs.secrets}, {"PersistentVolumeClaim", refs.claims}} {
+		for _, name := range sortedKeys(group.refs) {
+			mounted, referenced := []string{}, []string{}
+			for _, usage := range group.refs[name] {
+				if strings.HasPrefix(usage, "数据卷") {
+					mounted = append(mounted, strings.TrimPrefix(usage, "数据卷"))
+				} else {
+					referenced = append(referenced, usage)
+				}
+			}
+			to := g.refNode(group.kind, g.Namespace, name)
+			if len(mounted) > 0 {
+				g.addEdge(owner.id, to, edgeMount, strings.Join(mounted, ","))
+			}
+			if len(referenced) > 0 {
+				g.addEdge(owner.id, to, edgeReference, strings.Join(referenced, ","))
+			}
+		}
+	}
+}
+
+/*
+   Service、NetworkPolicy的selector,Ingress的后端Service,HPA的伸缩目标
+*/
+func (g *resourceGraph) addNetworkObjects(ctx context.Context, clientSet kubernetes.Interface, owners []templateOwner) error {
+	selectOwners := func(from string, selector labels.Selector) {
+		for _, owner := range owners {
+			if selector.Matches(labels.Set(owner.template.Labels)) {
+				g.addEdge(from, owner.id, edgeSelector, "")
+			}
+		}
+	}
+	services, err := clientSet.CoreV1().Services(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Service列表", err)
+	}
+	for _, svc := range services.Items {
+		id := g.addNode("Service", g.Namespace, svc.Name)
+		if len(svc.Spec.Selector) > 0 {
+			selectOwners(id, labels.SelectorFromSet(svc.Spec.Selector))
+		}
+	}
+	ingresses, err := clientSet.NetworkingV1().Ingresses(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Ingress列表", err)
+	}
+	for _, ing := range ingresses.Items {
+		id := g.addNode("Ingress", g.Namespace, ing.Name)
+		if backend := ing.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+			g.addEdge(id, g.refNode("Service", g.Namespace, backend.Service.Name), edgeReference, "defaultBackend")
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service != nil {
+					g.addEdge(id, g.refNode("Service", g.Namespace, path.Backend.Service.Name), edgeReference, rule.Host+path.Path)
+				}
+			}
+		}
+	}
+	policies, err := clientSet.NetworkingV1().NetworkPolicies(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取NetworkPolicy列表", err)
+	}
+	for _, np := range policies.Items {
+		id := g.addNode("NetworkPolicy", g.Namespace, np.Name)
+		if selector, err := meta_v1.LabelSelectorAsSelector(&np.Spec.PodSelector); err == nil {
+			selectOwners(id, selector)
+		}
+	}
+	hpas, err := clientSet.AutoscalingV2().HorizontalPodAutoscalers(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取HPA列表", err)
+	}
+	for _, hpa := range hpas.Items {
+		id := g.addNode("HorizontalPodAutoscaler", g.Namespace, hpa.Name)
+		ref := hpa.Spec.ScaleTargetRef
+		g.addEdge(id, g.refNode(ref.Kind, g.Namespace, ref.Name), edgeReference, "scaleTargetRef")
+	}
+	return nil
+}
+
+/*
+   PVC绑定的PV以及它们的StorageClass,只包含与命名空间内PVC相关的集群级别对象
+*/
+func (g *resourceGraph) addStorageObjects(ctx context.Context, clientSet kubernetes.Interface) error {
+	claims, err := clientSet.CoreV1().PersistentVolumeClaims(g.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取PVC列表", err)
+	}
+	for _, pvc := range claims.Items {
+		id := nodeID("PersistentVolumeClaim", pvc.Name)
+		className := ""
+		if pvc.Spec.StorageClassName != nil {
+			className = *pvc.Spec.StorageClassName
+		}
+		if pvc.Spec.VolumeName == "" {
+			if className != "" {
+				g.addEdge(id, g.storageClassNode(ctx, clientSet, className), edgeBinding, "")
+			}
+			continue
+		}
+		pv, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, meta_v1.GetOptions{})
+		found, err := exists(err)
+		if err != nil {
+			return wrapError("获取PV", err)
+		}
+		pvID := g.refNode("PersistentVolume", "", pvc.Spec.VolumeName)
+		if found {
+			g.addNode("PersistentVolume", "", pv.Name)
+			className = pv.Spec.StorageClassName
+		}
+		g.addEdge(id, pvID, edgeBinding, "")
+		if className != "" {
+			g.addEdge(pvID, g.storageClassNode(ctx, clientSet, className), edgeBinding, "")
+		}
+	}
+	return nil
+}
+
+func (g *resourceGraph) storageClassNode(ctx context.Context, clientSet kubernetes.Interface, name string) string {
+	id := g.refNode("StorageClass", "", name)
+	if _, err := clientSet.StorageV1().StorageClasses().Get(ctx, name, meta_v1.GetOptions{}); err == nil {
+		g.addNode("StorageClass", "", name)
+	}
+	return id
+}
+
+func (g *resourceGraph) writeDOT(out io.Writer) {
+	fmt.Fprintf(out, "digraph %q {\n", g.Namespace)
+	fmt.Fprintln(out, "  rankdir=LR;")
+	fmt.Fprintln(out, "  node [shape=box];")
+	for _, node := range g.Nodes {
+		style := ""
+		if node.Missing {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(out, "  %q [label=%q%s];\n", node.ID, node.Kind+"\n"+node.Name, style)
+	}
+	for _, edge := range g.Edges {
+		label := edge.Type
+		if edge.Label != "" {
+			label += ": " + edge.Label
+		}
+		fmt.Fprintf(out, "  %q -> %q [label=%q];\n", edge.From, edge.To, label)
+	}
+	fmt.Fprintln(out, "}")
+}
+
+/*
+   Mermaid节点ID不能包含/等字符,按序号命名
+*/
+func (g *resourceGraph) writeMermaid(out io.Writer) {
+	fmt.Fprintln(out, "graph LR")
+	ids := map[string]string{}
+	for i, node := range g.Nodes {
+		ids[node.ID] = "n" + strconv.Itoa(i)
+		class := ""
+		if node.Missing {
+			class = ":::missing"
+		}
+		fmt.Fprintf(out, "  %s[\"%s<br/>%s\"]%s\n", ids[node.ID], node.Kind, mermaidEscape(node.Name), class)
+	}
+	for _, edge := range g.Edges {
+		label := edge.Type
+		if edge.Label != "" {
+			label += ": " + edge.Label
+		}
+		fmt.Fprintf(out, "  %s -->|%s| %s\n", ids[edge.From], mermaidEscape(label), ids[edge.To])
+	}
+	fmt.Fprintln(out, "  classDef missing stroke-dasharray: 5 5")
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "|", "#124;").Replace(s)
+}
+
+func (g *resourceGraph) writeJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+func runGraph(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("graph", out)
+	namespace := fs.String("namespace", activeProfile.Namespace, "命名空间")
+	format := fs.String("o", activeProfile.output("dot", "mermaid", "json"), "输出格式: dot|mermaid|json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError("用法: graph [-namespace ns] [-o dot|mermaid|json]")
+	}
+	switch *format {
+	case "dot", "mermaid", "json":
+	default:
+		return usageError("不支持的输出格式: %s", *format)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	g, err := buildResourceGraph(ctx, clientSet, *namespace)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "mermaid":
+		g.writeMermaid(out)
+	case "json":
+		return g.writeJSON(out)
+	default:
+		g.writeDOT(out)
+	}
+	return nil
+}
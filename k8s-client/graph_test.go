@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func newGraphClientSet() *fake.Clientset {
+	objects := newDescribeObjects()
+	objects = append(objects,
+		&networking_v1.Ingress{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-ingress", Namespace: TestNamespace},
+			Spec: networking_v1.IngressSpec{Rules: []networking_v1.IngressRule{{
+				Host: "test-nginx.local",
+				IngressRuleValue: networking_v1.IngressRuleValue{HTTP: &networking_v1.HTTPIngressRuleValue{Paths: []networking_v1.HTTPIngressPath{{
+					Path:    "/",
+					Backend: networking_v1.IngressBackend{Service: &networking_v1.IngressServiceBackend{Name: "test-nginx"}},
+				}}}},
+			}}},
+		},
+		&autoscaling_v2.HorizontalPodAutoscaler{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-hpa", Namespace: TestNamespace},
+			Spec: autoscaling_v2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscaling_v2.CrossVersionObjectReference{Kind: "Deployment", Name: "test-nginx"},
+			},
+		},
+		&core_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Name: "default-token-abcde", Namespace: TestNamespace}, Type: core_v1.SecretTypeServiceAccountToken},
+	)
+	return fake.NewSimpleClientset(objects...)
+}
+
+func TestResourceGraph(t *testing.T) {
+	g, err := buildResourceGraph(context.TODO(), newGraphClientSet(), TestNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dot, mermaid, data bytes.Buffer
+	g.writeDOT(&dot)
+	assertGolden(t, "graph.dot.golden", dot.Bytes())
+	g.writeMermaid(&mermaid)
+	assertGolden(t, "graph.mermaid.golden", mermaid.Bytes())
+	if err := g.writeJSON(&data); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "graph.json.golden", data.Bytes())
+
+	var decoded resourceGraph
+	if err := json.Unmarshal(data.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != len(g.Nodes) || len(decoded.Edges) != len(g.Edges) {
+		t.Errorf("decoded %d nodes %d edges, want %d %d", len(decoded.Nodes), len(decoded.Edges), len(g.Nodes), len(g.Edges))
+	}
+}
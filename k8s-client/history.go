@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	sigs_yaml "sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+/*
+   Deployment的一个历史版本,对应一个ReplicaSet
+*/
+type revision struct {
+	Number     int64
+	ReplicaSet *apps_v1.ReplicaSet
+}
+
+/*
+   获取Deployment管理的ReplicaSet,按版本号从小到大排序
+*/
+func deploymentRevisions(ctx context.Context, clientSet kubernetes.Interface, deployment *apps_v1.Deployment) ([]revision, error) {
+	selector, err := meta_v1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析Deployment selector", Err: err}
+	}
+	list, err := clientSet.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, meta_v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, wrapError("获取ReplicaSet列表", err)
+	}
+	var revisions []revision
+	for i := range list.Items {
+		rs := &list.Items[i]
+		if ref := meta_v1.GetControllerOf(rs); ref == nil || ref.UID != deployment.UID {
+			continue
+		}
+		number, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, revision{Number: number, ReplicaSet: rs})
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Number < revisions[j].Number
+	})
+	return revisions, nil
+}
+
+/*
+   去掉ReplicaSet控制器添加的pod-template-hash标签,得到与Deployment中一致的Pod模板
+*/
+func revisionTemplate(rs *apps_v1.ReplicaSet) core_v1.PodTemplateSpec {
+	template := *rs.Spec.Template.DeepCopy()
+	delete(template.Labels, apps_v1.DefaultDeploymentUniqueLabelKey)
+	return template
+}
+
+func templateLines(template core_v1.PodTemplateSpec) []string {
+	data, err := sigs_yaml.Marshal(template)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+/*
+   基于最长公共子序列的逐行对比,只输出变化的行,删除的行以-开头,新增的行以+开头
+*/
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
+
+func containerImages(template core_v1.PodTemplateSpec) string {
+	var images []string
+	for _, c := range template.Spec.Containers {
+		images = append(images, c.Name+"="+c.Image)
+	}
+	return strings.Join(images, ",")
+}
+
+/*
+   输出版本列表以及相邻版本之间Pod模板的差异,当前版本以*标记
+*/
+func printRolloutHistory(out io.Writer, deployment *apps_v1.Deployment, revisions []revision) {
+	current := deployment.Annotations[revisionAnnotation]
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tREPLICASET\tREPLICAS\tIMAGES\tCHANGE-CAUSE")
+	for _, r := range revisions {
+		number := strconv.FormatInt(r.Number, 10)
+		if number == current {
+			number += "*"
+		}
+		cause := r.ReplicaSet.Annotations[changeCauseAnnotation]
+		if cause == "" {
+			cause = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", number, r.ReplicaSet.Name, r.ReplicaSet.Status.Replicas, containerImages(r.ReplicaSet.Spec.Template), cause)
+	}
+	w.Flush()
+	for i := 1; i < len(revisions); i++ {
+		prev, next := revisions[i-1], revisions[i]
+		fmt.Fprintf(out, "\n版本%d -> 版本%d:\n", prev.Number, next.Number)
+		diff := diffLines(templateLines(revisionTemplate(prev.ReplicaSet)), templateLines(revisionTemplate(next.ReplicaSet)))
+		if len(diff) == 0 {
+			fmt.Fprintln(out, "  Pod模板无变化")
+		}
+		for _, line := range diff {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+	}
+}
+
+/*
+   查找指定版本,toRevision为0时返回当前版本的上一个版本
+*/
+func findRevision(deployment *apps_v1.Deployment, revisions []revision, toRevision int64) (revision, error) {
+	if toRevision == 0 {
+		current, _ := strconv.ParseInt(deployment.Annotations[revisionAnnotation], 10, 64)
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if revisions[i].Number < current || (current == 0 && i < len(revisions)-1) {
+				return revisions[i], nil
+			}
+		}
+		return revision{}, &clientError{Kind: ErrNotFound, Action: "回滚Deployment", Err: fmt.Errorf("%s/%s 没有可以回滚的历史版本", deployment.Namespace, deployment.Name)}
+	}
+	for _, r := range revisions {
+		if r.Number == toRevision {
+			return r, nil
+		}
+	}
+	return revision{}, &clientError{Kind: ErrNotFound, Action: "回滚Deployment", Err: fmt.Errorf("%s/%s 没有版本%d,保留的版本数受revisionHistoryLimit限制", deployment.Namespace, deployment.Name, toRevision)}
+}
+
+/*
+   将Deployment的Pod模板恢复为指定版本,Deployment控制器会为该模板对应的ReplicaSet分配新的版本号
+   返回false表示当前模板已经与该版本一致
+*/
+func undoDeployment(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, toRevision int64, out io.Writer) (bool, error) {
+	client := clientSet.AppsV1().Deployments(namespace)
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := client.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if deployment.Spec.Paused {
+			return &clientError{Kind: ErrUnknown, Action: "回滚Deployment", Err: fmt.Errorf("%s/%s 已暂停,请先执行rollout resume", namespace, name)}
+		}
+		revisions, err := deploymentRevisions(ctx, clientSet, deployment)
+		if err != nil {
+			return err
+		}
+		target, err := findRevision(deployment, revisions, toRevision)
+		if err != nil {
+			return err
+		}
+		template := revisionTemplate(target.ReplicaSet)
+		if equality.Semantic.DeepEqual(template, deployment.Spec.Template) {
+			changed = false
+			fmt.Fprintf(out, "deployment %s/%s 已经是版本%d的模板\n", namespace, name, target.Number)
+			return nil
+		}
+		deployment.Spec.Template = template
+		if _, err := client.Update(ctx, deployment, meta_v1.UpdateOptions{}); err != nil {
+			return err
+		}
+		changed = true
+		fmt.Fprintf(out, "deployment %s/%s 已回滚到版本%d(%s)\n", namespace, name, target.Number, containerImages(template))
+		return nil
+	})
+	return changed, wrapError("回滚Deployment", err)
+}
+
+func rolloutHistory(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, out io.Writer) error {
+	deployment, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return wrapError("获取Deployment", err)
+	}
+	revisions, err := deploymentRevisions(ctx, clientSet, deployment)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		fmt.Fprintf(out, "deployment %s/%s 没有历史版本\n", namespace, name)
+		return nil
+	}
+	printRolloutHistory(out, deployment, revisions)
+	return nil
+}
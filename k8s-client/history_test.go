@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newHistoryTemplate(image string, env ...core_v1.EnvVar) core_v1.PodTemplateSpec {
+	return core_v1.PodTemplateSpec{
+		ObjectMeta: meta_v1.ObjectMeta{Labels: map[string]string{"app": "test-nginx"}},
+		Spec: core_v1.PodSpec{
+			Containers: []core_v1.Container{{Name: "test-nginx-container", Image: image, Env: env}},
+		},
+	}
+}
+
+/*
+   Deployment当前为版本3,保留了版本1、2、3的ReplicaSet,另有一个不属于它的ReplicaSet
+*/
+func newHistoryObjects() []runtime.Object {
+	isController := true
+	deployment := &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "test-nginx",
+			Namespace:   TestNamespace,
+			UID:         "deploy-uid",
+			Annotations: map[string]string{revisionAnnotation: "3"},
+		},
+		Spec: apps_v1.DeploymentSpec{
+			Selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "test-nginx"}},
+			Template: newHistoryTemplate("nginx:1.23", core_v1.EnvVar{Name: "MODE", Value: "debug"}),
+		},
+	}
+	newRS := func(number int, owner string, template core_v1.PodTemplateSpec, replicas int32) *apps_v1.ReplicaSet {
+		template.Labels = map[string]string{"app": "test-nginx", apps_v1.DefaultDeploymentUniqueLabelKey: "hash" + strconv.Itoa(number)}
+		rs := &apps_v1.ReplicaSet{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:            "test-nginx-hash" + strconv.Itoa(number),
+				Namespace:       TestNamespace,
+				Labels:          template.Labels,
+				Annotations:     map[string]string{revisionAnnotation: strconv.Itoa(number)},
+				OwnerReferences: []meta_v1.OwnerReference{{Kind: "Deployment", Name: "test-nginx", UID: "deploy-uid", Controller: &isController}},
+			},
+			Spec:   apps_v1.ReplicaSetSpec{Template: template},
+			Status: apps_v1.ReplicaSetStatus{Replicas: replicas},
+		}
+		if owner != "" {
+			rs.OwnerReferences[0].UID = "other-uid"
+			rs.Name = owner
+		}
+		return rs
+	}
+	rs2 := newRS(2, "", newHistoryTemplate("nginx:1.22"), 0)
+	rs2.Annotations[changeCauseAnnotation] = "set image nginx:1.22"
+	return []runtime.Object{
+		deployment,
+		newRS(1, "", newHistoryTemplate("nginx:1.21"), 0),
+		rs2,
+		newRS(3, "", newHistoryTemplate("nginx:1.23", core_v1.EnvVar{Name: "MODE", Value: "debug"}), 2),
+		newRS(9, "orphan", newHistoryTemplate("httpd"), 1),
+	}
+}
+
+func TestRolloutHistory(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newHistoryObjects()...)
+	var out bytes.Buffer
+	if err := rolloutHistory(context.TODO(), clientSet, TestNamespace, "test-nginx", &out); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "rollout_history.golden", out.Bytes())
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "x", "d", "e"})
+	want := []string{"- b", "+ x", "+ e"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("diffLines() = %q, want %q", got, want)
+	}
+}
+
+func TestUndoDeployment(t *testing.T) {
+	tests := []struct {
+		name        string
+		toRevision  int64
+		wantImage   string
+		wantChanged bool
+		wantKind    errorKind
+	}{
+		{name: "previous revision", wantImage: "nginx:1.22", wantChanged: true},
+		{name: "to revision", toRevision: 1, wantImage: "nginx:1.21", wantChanged: true},
+		{name: "current revision", toRevision: 3, wantImage: "nginx:1.23"},
+		{name: "pruned revision", toRevision: 9, wantImage: "nginx:1.23", wantKind: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(newHistoryObjects()...)
+			changed, err := undoDeployment(context.TODO(), clientSet, TestNamespace, "test-nginx", tt.toRevision, &bytes.Buffer{})
+			if tt.wantKind != ErrUnknown {
+				if errorKindOf(err) != tt.wantKind {
+					t.Fatalf("error = %v, want kind %v", err, tt.wantKind)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			d, _ := clientSet.AppsV1().Deployments(TestNamespace).Get(context.TODO(), "test-nginx", meta_v1.GetOptions{})
+			if got := d.Spec.Template.Spec.Containers[0].Image; got != tt.wantImage {
+				t.Errorf("image = %s, want %s", got, tt.wantImage)
+			}
+			if _, ok := d.Spec.Template.Labels[apps_v1.DefaultDeploymentUniqueLabelKey]; ok {
+				t.Error("pod-template-hash label copied into deployment template")
+			}
+		})
+	}
+}
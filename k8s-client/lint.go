@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+/*
+   校验发现的问题
+*/
+type lintIssue struct {
+	Severity string
+	Object   string
+	Path     string
+	Message  string
+}
+
+/*
+   nodePort允许的范围,对应ApiServer的--service-node-port-range参数
+*/
+type portRange struct {
+	Min, Max int32
+}
+
+var defaultNodePortRange = portRange{Min: 30000, Max: 32767}
+
+func parsePortRange(s string) (portRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return portRange{}, fmt.Errorf("端口范围格式应为min-max: %s", s)
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return portRange{}, fmt.Errorf("端口范围格式应为min-max: %s", s)
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil || max < min {
+		return portRange{}, fmt.Errorf("端口范围格式应为min-max: %s", s)
+	}
+	return portRange{Min: int32(min), Max: int32(max)}, nil
+}
+
+func (r portRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+/*
+   工作负载及其Pod模板
+*/
+type workload struct {
+	manifest
+	namespace string
+	selector  *meta_v1.LabelSelector //Pod与Job可以为空
+	template  *core_v1.PodTemplateSpec
+}
+
+/*
+   获取对象的Pod模板与标签选择器,不是工作负载时返回false
+*/
+func podTemplateOf(obj runtime.Object) (*core_v1.PodTemplateSpec, *meta_v1.LabelSelector, bool) {
+	switch o := obj.(type) {
+	case *apps_v1.Deployment:
+		return &o.Spec.Template, o.Spec.Selector, true
+	case *apps_v1.StatefulSet:
+		return &o.Spec.Template, o.Spec.Selector, true
+	case *apps_v1.DaemonSet:
+		return &o.Spec.Template, o.Spec.Selector, true
+	case *apps_v1.ReplicaSet:
+		return &o.Spec.Template, o.Spec.Selector, true
+	case *batch_v1.Job:
+		return &o.Spec.Template, o.Spec.Selector, true
+	case *batch_v1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template, o.Spec.JobTemplate.Spec.Selector, true
+	case *core_v1.Pod:
+		return &core_v1.PodTemplateSpec{ObjectMeta: o.ObjectMeta, Spec: o.Spec}, nil, true
+	}
+	return nil, nil, false
+}
+
+/*
+   清单集合的索引,按 namespace/name 查找
+*/
+type manifestIndex struct {
+	workloads       []workload
+	services        []*core_v1.Service
+	ingresses       []*networking_v1.Ingress
+	networkPolicies []*networking_v1.NetworkPolicy
+	hpas            []*autoscaling_v2.HorizontalPodAutoscaler
+	configMaps      map[string]bool
+	pvcs            map[string]*core_v1.PersistentVolumeClaim
+	pvs             map[string]*core_v1.PersistentVolume
+	classes         map[string]*storage_v1.StorageClass
+	paths           map[runtime.Object]string
+}
+
+func newManifestIndex(manifests []manifest) *manifestIndex {
+	idx := &manifestIndex{
+		configMaps: map[string]bool{},
+		pvcs:       map[string]*core_v1.PersistentVolumeClaim{},
+		pvs:        map[string]*core_v1.PersistentVolume{},
+		classes:    map[string]*storage_v1.StorageClass{},
+		paths:      map[runtime.Object]string{},
+	}
+	for _, m := range manifests {
+		idx.paths[m.obj] = m.path
+		if template, selector, ok := podTemplateOf(m.obj); ok {
+			idx.workloads = append(idx.workloads, workload{manifest: m, namespace: namespaceOf(m.obj), selector: selector, template: template})
+			continue
+		}
+		switch o := m.obj.(type) {
+		case *core_v1.Service:
+			idx.services = append(idx.services, o)
+		case *networking_v1.Ingress:
+			idx.ingresses = append(idx.ingresses, o)
+		case *networking_v1.NetworkPolicy:
+			idx.networkPolicies = append(idx.networkPolicies, o)
+		case *autoscaling_v2.HorizontalPodAutoscaler:
+			idx.hpas = append(idx.hpas, o)
+		case *core_v1.ConfigMap:
+			idx.configMaps[o.Namespace+"/"+o.Name] = true
+		case *core_v1.PersistentVolumeClaim:
+			idx.pvcs[o.Namespace+"/"+o.Name] = o
+		case *core_v1.PersistentVolume:
+			idx.pvs[o.Name] = o
+		case *storage_v1.StorageClass:
+			idx.classes[o.Name] = o
+		}
+	}
+	return idx
+}
+
+func namespaceOf(obj runtime.Object) string {
+	if accessor, ok := obj.(meta_v1.Object); ok {
+		return accessor.GetNamespace()
+	}
+	return ""
+}
+
+func nameOf(obj runtime.Object) string {
+	if accessor, ok := obj.(meta_v1.Object); ok {
+		return accessor.GetName()
+	}
+	return ""
+}
+
+/*
+   Pod模板标签匹配selector的工作负载
+*/
+func (idx *manifestIndex) selectWorkloads(namespace string, selector labels.Selector) []workload {
+	var matched []workload
+	for _, w := range idx.workloads {
+		if w.namespace == namespace && selector.Matches(labels.Set(w.template.Labels)) {
+			matched = append(matched, w)
+		}
+	}
+	return matched
+}
+
+func (idx *manifestIndex) service(namespace, name string) *core_v1.Service {
+	for _, svc := range idx.services {
+		if svc.Namespace == namespace && svc.Name == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+func (idx *manifestIndex) workload(namespace, kind, name string) (workload, bool) {
+	for _, w := range idx.workloads {
+		if w.namespace == namespace && w.obj.GetObjectKind().GroupVersionKind().Kind == kind && nameOf(w.obj) == name {
+			return w, true
+		}
+	}
+	return workload{}, false
+}
+
+/*
+   校验清单集合中资源之间的引用关系
+*/
+func lintManifests(manifests []manifest, nodePorts portRange) []lintIssue {
+	idx := newManifestIndex(manifests)
+	l := &linter{idx: idx}
+	for _, w := range idx.workloads {
+		l.checkSelector(w)
+		l.checkReferences(w)
+	}
+	for _, svc := range idx.services {
+		l.checkService(svc, nodePorts)
+	}
+	for _, ing := range idx.ingresses {
+		l.checkIngress(ing)
+	}
+	for _, np := range idx.networkPolicies {
+		l.checkNetworkPolicy(np)
+	}
+	for _, hpa := range idx.hpas {
+		l.checkHPA(hpa)
+	}
+	pvcNames := make([]string, 0, len(idx.pvcs))
+	for key := range idx.pvcs {
+		pvcNames = append(pvcNames, key)
+	}
+	sort.Strings(pvcNames)
+	for _, key := range pvcNames {
+		l.checkClaim(idx.pvcs[key])
+	}
+	return l.issues
+}
+
+type linter struct {
+	idx    *manifestIndex
+	issues []lintIssue
+}
+
+func (l *linter) report(severity string, obj runtime.Object, format string, a ...interface{}) {
+	l.issues = append(l.issues, lintIssue{
+		Severity: severity,
+		Object:   objectRef(obj),
+		Path:     l.idx.paths[obj],
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+/*
+   selector必须匹配template中的标签
+*/
+func (l *linter) checkSelector(w workload) {
+	if w.selector == nil {
+		switch w.obj.(type) {
+		case *core_v1.Pod, *batch_v1.Job, *batch_v1.CronJob:
+		default:
+			l.report(lintError, w.obj, "缺少spec.selector")
+		}
+		return
+	}
+	selector, err := meta_v1.LabelSelectorAsSelector(w.selector)
+	if err != nil {
+		l.report(lintError, w.obj, "spec.selector非法: %v", err)
+		return
+	}
+	if selector.Empty() || !selector.Matches(labels.Set(w.template.Labels)) {
+		l.report(lintError, w.obj, "spec.selector(%s)与template标签(%s)不匹配", selector, labels.Set(w.template.Labels))
+	}
+}
+
+/*
+   Pod模板引用的ConfigMap与PVC必须存在于清单中
+*/
+func (l *linter) checkReferences(w workload) {
+	spec := &w.template.Spec
+	for _, volume := range spec.Volumes {
+		if cm := volume.ConfigMap; cm != nil && !isOptional(cm.Optional) && !l.idx.configMaps[w.namespace+"/"+cm.Name] {
+			l.report(lintError, w.obj, "数据卷%s引用的ConfigMap %s 不存在", volume.Name, cm.Name)
+		}
+		if claim := volume.PersistentVolumeClaim; claim != nil && l.idx.pvcs[w.namespace+"/"+claim.ClaimName] == nil {
+			l.report(lintError, w.obj, "数据卷%s引用的PVC %s 不存在", volume.Name, claim.ClaimName)
+		}
+	}
+	for _, container := range append(append([]core_v1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, envFrom := range container.EnvFrom {
+			if ref := envFrom.ConfigMapRef; ref != nil && !isOptional(ref.Optional) && !l.idx.configMaps[w.namespace+"/"+ref.Name] {
+				l.report(lintError, w.obj, "容器%s的envFrom引用的ConfigMap %s 不存在", container.Name, ref.Name)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil {
+				continue
+			}
+			ref := env.ValueFrom.ConfigMapKeyRef
+			if !isOptional(ref.Optional) && !l.idx.configMaps[w.namespace+"/"+ref.Name] {
+				l.report(lintError, w.obj, "容器%s的环境变量%s引用的ConfigMap %s 不存在", container.Name, env.Name, ref.Name)
+			}
+		}
+	}
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+/*
+   Service的selector必须匹配工作负载,targetPort必须与容器端口一致,nodePort必须在范围内
+*/
+func (l *linter) checkService(svc *core_v1.Service, nodePorts portRange) {
+	for _, port := range svc.Spec.Ports {
+		if port.NodePort != 0 && (port.NodePort < nodePorts.Min || port.NodePort > nodePorts.Max) {
+			l.report(lintError, svc, "端口%s的nodePort %d 不在范围%s内", portName(port), port.NodePort, nodePorts)
+		}
+	}
+	if svc.Spec.Type == core_v1.ServiceTypeExternalName || len(svc.Spec.Selector) == 0 {
+		return
+	}
+	matched := l.idx.selectWorkloads(svc.Namespace, labels.SelectorFromSet(svc.Spec.Selector))
+	if len(matched) == 0 {
+		l.report(lintError, svc, "selector(%s)没有匹配的工作负载", labels.Set(svc.Spec.Selector))
+		return
+	}
+	for _, port := range svc.Spec.Ports {
+		target := port.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt(int(port.Port))
+		}
+		for _, w := range matched {
+			declared := containerPorts(w.template)
+			if len(declared) == 0 {
+				l.report(lintWarning, svc, "端口%s的targetPort %s 无法校验: %s 的容器未声明containerPort", portName(port), target.String(), objectRef(w.obj))
+				continue
+			}
+			if !hasContainerPort(declared, target, port.Protocol) {
+				l.report(lintError, svc, "端口%s的targetPort %s 与 %s 的容器端口(%s)不匹配", portName(port), target.String(), objectRef(w.obj), portsString(declared))
+			}
+		}
+	}
+}
+
+func portName(port core_v1.ServicePort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return strconv.Itoa(int(port.Port))
+}
+
+func containerPorts(template *core_v1.PodTemplateSpec) []core_v1.ContainerPort {
+	var ports []core_v1.ContainerPort
+	for _, container := range template.Spec.Containers {
+		ports = append(ports, container.Ports...)
+	}
+	return ports
+}
+
+func hasContainerPort(ports []core_v1.ContainerPort, target intstr.IntOrString, protocol core_v1.Protocol) bool {
+	if protocol == "" {
+		protocol = core_v1.ProtocolTCP
+	}
+	for _, p := range ports {
+		containerProtocol := p.Protocol
+		if containerProtocol == "" {
+			containerProtocol = core_v1.ProtocolTCP
+		}
+		if containerProtocol != protocol {
+			continue
+		}
+		if target.Type == intstr.String && p.Name == target.StrVal {
+			return true
+		}
+		if target.Type == intstr.Int && p.ContainerPort == target.IntVal {
+			return true
+		}
+	}
+	return false
+}
+
+func portsString(ports []core_v1.ContainerPort) string {
+	names := make([]string, 0, len(ports))
+	for _, p := range ports {
+		if p.Name != "" {
+			names = append(names, fmt.Sprintf("%s:%d", p.Name, p.ContainerPort))
+		} else {
+			names = append(names, strconv.Itoa(int(p.ContainerPort)))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+/*
+   Ingress后端的Service及端口必须存在于清单中
+*/
+func (l *linter) checkIngress(ing *networking_v1.Ingress) {
+	var backends []networking_v1.IngressBackend
+	if ing.Spec.DefaultBackend != nil {
+		backends = append(backends, *ing.Spec.DefaultBackend)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			backends = append(backends, path.Backend)
+		}
+	}
+	for _, backend := range backends {
+		if backend.Service == nil {
+			continue
+		}
+		svc := l.idx.service(ing.Namespace, backend.Service.Name)
+		if svc == nil {
+			l.report(lintError, ing, "后端Service %s 不存在", backend.Service.Name)
+			continue
+		}
+		if port := backend.Service.Port; !hasServicePort(svc, port) {
+			l.report(lintError, ing, "后端Service %s 没有端口%s", svc.Name, ingressPortString(port))
+		}
+	}
+}
+
+func hasServicePort(svc *core_v1.Service, port networking_v1.ServiceBackendPort) bool {
+	for _, p := range svc.Spec.Ports {
+		if (port.Name != "" && p.Name == port.Name) || (port.Name == "" && p.Port == port.Number) {
+			return true
+		}
+	}
+	return false
+}
+
+func ingressPortString(port networking_v1.ServiceBackendPort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return strconv.Itoa(int(port.Number))
+}
+
+/*
+   NetworkPolicy的podSelector应当匹配工作负载,ingress规则中的端口必须是被选中Pod声明的容器端口
+*/
+func (l *linter) checkNetworkPolicy(np *networking_v1.NetworkPolicy) {
+	selector, err := meta_v1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+	if err != nil {
+		l.report(lintError, np, "podSelector非法: %v", err)
+		return
+	}
+	matched := l.idx.selectWorkloads(np.Namespace, selector)
+	if len(matched) == 0 {
+		if !selector.Empty() {
+			l.report(lintWarning, np, "podSelector(%s)没有匹配的工作负载", selector)
+		}
+		return
+	}
+	for _, rule := range np.Spec.Ingress {
+		for _, port := range rule.Ports {
+			if port.Port == nil {
+				continue
+			}
+			protocol := core_v1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			for _, w := range matched {
+				declared := containerPorts(w.template)
+				if len(declared) == 0 && port.Port.Type == intstr.Int {
+					continue
+				}
+				if port.EndPort == nil && !hasContainerPort(declared, *port.Port, protocol) {
+					l.report(lintError, np, "ingress端口%s 不是 %s 的容器端口(%s)", port.Port.String(), objectRef(w.obj), portsString(declared))
+				}
+			}
+		}
+	}
+}
+
+/*
+   HPA的伸缩目标必须存在且支持伸缩,按资源使用率伸缩时容器必须设置对应的requests
+*/
+func (l *linter) checkHPA(hpa *autoscaling_v2.HorizontalPodAutoscaler) {
+	if hpa.Spec.MinReplicas != nil && *hpa.Spec.MinReplicas > hpa.Spec.MaxReplicas {
+		l.report(lintError, hpa, "minReplicas %d 大于maxReplicas %d", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+	ref := hpa.Spec.ScaleTargetRef
+	switch ref.Kind {
+	case "Deployment", "StatefulSet", "ReplicaSet":
+	default:
+		l.report(lintError, hpa, "伸缩目标%s %s 不支持伸缩", ref.Kind, ref.Name)
+		return
+	}
+	target, ok := l.idx.workload(hpa.Namespace, ref.Kind, ref.Name)
+	if !ok {
+		l.report(lintError, hpa, "伸缩目标%s %s 不存在", ref.Kind, ref.Name)
+		return
+	}
+	for _, metric := range hpa.Spec.Metrics {
+		var name core_v1.ResourceName
+		var container string
+		switch {
+		case metric.Type == autoscaling_v2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Target.Type == autoscaling_v2.UtilizationMetricType:
+			name = metric.Resource.Name
+		case metric.Type == autoscaling_v2.ContainerResourceMetricSourceType && metric.ContainerResource != nil &&
+			metric.ContainerResource.Target.Type == autoscaling_v2.UtilizationMetricType:
+			name, container = metric.ContainerResource.Name, metric.ContainerResource.Container
+		default:
+			continue
+		}
+		for _, c := range target.template.Spec.Containers {
+			if container != "" && c.Name != container {
+				continue
+			}
+			if _, ok := c.Resources.Requests[name]; !ok {
+				l.report(lintError, hpa, "%s 的容器%s未设置%s的requests,无法按使用率伸缩", objectRef(target.obj), c.Name, name)
+			}
+		}
+	}
+}
+
+/*
+   PVC的StorageClass需要存在,且与清单中的PV一致
+*/
+func (l *linter) checkClaim(pvc *core_v1.PersistentVolumeClaim) {
+	if pvc.Spec.StorageClassName == nil {
+		return
+	}
+	className := *pvc.Spec.StorageClassName
+	if className != "" && len(l.idx.classes) > 0 && l.idx.classes[className] == nil {
+		l.report(lintWarning, pvc, "StorageClass %s 不在清单中", className)
+	}
+	if pvc.Spec.VolumeName != "" {
+		if pv := l.idx.pvs[pvc.Spec.VolumeName]; pv != nil && pv.Spec.StorageClassName != className {
+			l.report(lintError, pvc, "StorageClass %q 与PV %s 的StorageClass %q 不一致", className, pv.Name, pv.Spec.StorageClassName)
+		}
+		return
+	}
+	if len(l.idx.pvs) == 0 {
+		return
+	}
+	var pvClasses []string
+	for _, pv := range l.idx.pvs {
+		if pv.Spec.StorageClassName == className {
+			return
+		}
+		pvClasses = append(pvClasses, fmt.Sprintf("%s:%q", pv.Name, pv.Spec.StorageClassName))
+	}
+	sort.Strings(pvClasses)
+	l.report(lintError, pvc, "StorageClass %q 与清单中PV的StorageClass(%s)不一致", className, strings.Join(pvClasses, ", "))
+}
+
+func printLintIssues(out io.Writer, issues []lintIssue) (errors, warnings int) {
+	for _, issue := range issues {
+		if issue.Severity == lintError {
+			errors++
+		} else {
+			warnings++
+		}
+		fmt.Fprintf(out, "[%s] %s: %s (%s)\n", issue.Severity, issue.Object, issue.Message, issue.Path)
+	}
+	fmt.Fprintf(out, "%d个错误, %d个警告\n", errors, warnings)
+	return errors, warnings
+}
+
+func runLint(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("lint", out)
+	nodePortRange := fs.String("node-port-range", defaultNodePortRange.String(), "nodePort允许的范围,与ApiServer的--service-node-port-range一致")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	nodePorts, err := parsePortRange(*nodePortRange)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = activeProfile.manifestDirs()
+	}
+	manifests, err := loadManifests(paths)
+	if err != nil {
+		return err
+	}
+	if errors, _ := printLintIssues(out, lintManifests(manifests, nodePorts)); errors > 0 {
+		return &clientError{Kind: ErrInvalidManifest, Action: "校验清单", Err: fmt.Errorf("发现%d个错误", errors)}
+	}
+	return nil
+}
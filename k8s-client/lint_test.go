@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLintRepoManifests(t *testing.T) {
+	manifests, err := loadManifests([]string{"./yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 14 {
+		t.Errorf("loaded %d manifests, want 14", len(manifests))
+	}
+	for _, issue := range lintManifests(manifests, defaultNodePortRange) {
+		if issue.Severity == lintError {
+			t.Errorf("unexpected error: %+v", issue)
+		}
+	}
+}
+
+func TestLintManifests(t *testing.T) {
+	manifests, err := loadManifests([]string{"./testdata/lint/broken.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := lintManifests(manifests, defaultNodePortRange)
+	tests := []struct {
+		object  string
+		message string
+	}{
+		{"Deployment test-namespace/web", "spec.selector(app=web-v2)与template标签(app=web)不匹配"},
+		{"Deployment test-namespace/web", "数据卷conf引用的ConfigMap missing-configmap 不存在"},
+		{"Deployment test-namespace/web", "数据卷data引用的PVC missing-pvc 不存在"},
+		{"Deployment test-namespace/web", "容器web的envFrom引用的ConfigMap web-env 不存在"},
+		{"Service test-namespace/web", "端口http的nodePort 8080 不在范围30000-32767内"},
+		{"Service test-namespace/web", "端口http的targetPort 8080 与 Deployment test-namespace/web 的容器端口(http:80)不匹配"},
+		{"Service test-namespace/orphan", "selector(app=nothing)没有匹配的工作负载"},
+		{"PersistentVolumeClaim test-namespace/data", "StorageClass fast 不在清单中"},
+		{"PersistentVolumeClaim test-namespace/data", `StorageClass "fast" 与清单中PV的StorageClass(data-pv:"slow")不一致`},
+	}
+	if len(issues) != len(tests) {
+		var out bytes.Buffer
+		printLintIssues(&out, issues)
+		t.Errorf("got %d issues, want %d:\n%s", len(issues), len(tests), out.String())
+	}
+	for _, tt := range tests {
+		found := false
+		for _, issue := range issues {
+			if issue.Object == tt.object && issue.Message == tt.message {
+				found = true
+				if issue.Path != "./testdata/lint/broken.yaml" && issue.Path != "testdata/lint/broken.yaml" {
+					t.Errorf("issue path = %q", issue.Path)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("missing issue %s: %s", tt.object, tt.message)
+		}
+	}
+}
+
+func TestLintNetworkManifests(t *testing.T) {
+	manifests, err := loadManifests([]string{"./testdata/lint/network.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range lintManifests(manifests, defaultNodePortRange) {
+		got = append(got, fmt.Sprintf("[%s] %s: %s", issue.Severity, issue.Object, issue.Message))
+	}
+	want := []string{
+		"[error] Ingress test-namespace/api: 后端Service api 没有端口https",
+		"[error] Ingress test-namespace/api: 后端Service api-v1 不存在",
+		"[error] NetworkPolicy test-namespace/api: ingress端口9090 不是 Deployment test-namespace/api 的容器端口(http:8080)",
+		"[warning] NetworkPolicy test-namespace/orphan: podSelector(app=nothing)没有匹配的工作负载",
+		"[error] HorizontalPodAutoscaler test-namespace/api: minReplicas 5 大于maxReplicas 3",
+		"[error] HorizontalPodAutoscaler test-namespace/api: Deployment test-namespace/api 的容器api未设置cpu的requests,无法按使用率伸缩",
+		"[error] HorizontalPodAutoscaler test-namespace/missing: 伸缩目标StatefulSet api 不存在",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunLintExitCode(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"lint", "./testdata/lint/broken.yaml"}, &out)
+	if got := exitCode(err); got != exitCodes[ErrInvalidManifest] {
+		t.Errorf("exit code = %d, want %d", got, exitCodes[ErrInvalidManifest])
+	}
+	if !strings.Contains(out.String(), "8个错误, 1个警告") {
+		t.Errorf("summary missing:\n%s", out.String())
+	}
+	if err := run([]string{"lint", "-node-port-range", "30000"}, &out); exitCode(err) != ExitUsage {
+		t.Errorf("bad range: exit code = %d, want %d", exitCode(err), ExitUsage)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    portRange
+		wantErr bool
+	}{
+		{"30000-32767", portRange{30000, 32767}, false},
+		{"1-65535", portRange{1, 65535}, false},
+		{"32767-30000", portRange{}, true},
+		{"30000", portRange{}, true},
+		{"a-b", portRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePortRange(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePortRange(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+   日志选项
+*/
+type logOptions struct {
+	Follow     bool           //持续输出,并跟踪发布过程中新启动的Pod
+	Since      time.Duration  //只输出最近一段时间的日志
+	SinceTime  *meta_v1.Time  //只输出该时间之后的日志
+	Tail       int64          //每个容器只输出最后几行,-1为全部
+	Previous   bool           //输出上一个已退出容器的日志
+	Timestamps bool           //每行带时间戳
+	Container  string         //只输出该容器的日志
+	Include    *regexp.Regexp //只输出匹配的行
+	Exclude    *regexp.Regexp //不输出匹配的行
+}
+
+func (o logOptions) podLogOptions(container string) *core_v1.PodLogOptions {
+	opts := &core_v1.PodLogOptions{
+		Container:  container,
+		Follow:     o.Follow,
+		Previous:   o.Previous,
+		Timestamps: o.Timestamps,
+		SinceTime:  o.SinceTime,
+	}
+	if o.Since > 0 {
+		seconds := int64(o.Since.Seconds())
+		opts.SinceSeconds = &seconds
+	}
+	if o.Tail >= 0 {
+		tail := o.Tail
+		opts.TailLines = &tail
+	}
+	return opts
+}
+
+/*
+   多个容器的日志并发写入同一个输出,按行加锁避免交错
+*/
+type prefixWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *prefixWriter) println(prefix, line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, "[%s] %s\n", prefix, line)
+}
+
+type logStreamer struct {
+	clientSet kubernetes.Interface
+	namespace string
+	opts      logOptions
+	out       *prefixWriter
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	started   map[string]bool //已开始输出的 pod/容器/重启次数
+}
+
+/*
+   并发输出listOpts选中的所有Pod中所有容器的日志,带 pod/容器 前缀
+   Follow时监听Pod变化,发布过程中新启动的Pod或重启的容器也会被输出,直到ctx结束
+*/
+func streamLogs(ctx context.Context, clientSet kubernetes.Interface, namespace string, listOpts meta_v1.ListOptions, opts logOptions, out io.Writer) error {
+	s := &logStreamer{
+		clientSet: clientSet,
+		namespace: namespace,
+		opts:      opts,
+		out:       &prefixWriter{out: out},
+		started:   map[string]bool{},
+	}
+	client := clientSet.CoreV1().Pods(namespace)
+	list, err := client.List(ctx, listOpts)
+	if err != nil {
+		return wrapError("获取Pod列表", err)
+	}
+	if len(list.Items) == 0 && !opts.Follow {
+		return &clientError{Kind: ErrNotFound, Action: "获取日志", Err: fmt.Errorf("没有匹配的Pod")}
+	}
+	for i := range list.Items {
+		s.startPod(ctx, &list.Items[i])
+	}
+	if opts.Follow {
+		if err := s.watchPods(ctx, listOpts, list.ResourceVersion); err != nil {
+			return err
+		}
+	}
+	s.wg.Wait()
+	return nil
+}
+
+/*
+   监听Pod变化,watch被服务端关闭时从最后的ResourceVersion重新监听
+*/
+func (s *logStreamer) watchPods(ctx context.Context, listOpts meta_v1.ListOptions, resourceVersion string) error {
+	client := s.clientSet.CoreV1().Pods(s.namespace)
+	for ctx.Err() == nil {
+		listOpts.ResourceVersion = resourceVersion
+		w, err := client.Watch(ctx, listOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return wrapError("监听Pod", err)
+		}
+		resourceVersion = s.handleEvents(ctx, w, resourceVersion)
+		w.Stop()
+	}
+	return nil
+}
+
+func (s *logStreamer) handleEvents(ctx context.Context, w watch.Interface, resourceVersion string) string {
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			pod, ok := event.Object.(*core_v1.Pod)
+			if !ok {
+				continue
+			}
+			resourceVersion = pod.ResourceVersion
+			if event.Type == watch.Added || event.Type == watch.Modified {
+				s.startPod(ctx, pod)
+			}
+		}
+	}
+}
+
+/*
+   为Pod中已启动且尚未输出的容器启动日志输出,包括初始化容器
+*/
+func (s *logStreamer) startPod(ctx context.Context, pod *core_v1.Pod) {
+	containers := append(append([]core_v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		if s.opts.Container != "" && container.Name != s.opts.Container {
+			continue
+		}
+		status, ok := containerStatus(pod, container.Name)
+		if !ok || !containerLogAvailable(status, s.opts.Previous) {
+			continue
+		}
+		key := pod.Name + "/" + container.Name + "/" + strconv.Itoa(int(status.RestartCount))
+		s.mu.Lock()
+		if s.started[key] {
+			s.mu.Unlock()
+			continue
+		}
+		s.started[key] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(podName, containerName string) {
+			defer s.wg.Done()
+			s.stream(ctx, podName, containerName)
+		}(pod.Name, container.Name)
+	}
+}
+
+func containerStatus(pod *core_v1.Pod, name string) (core_v1.ContainerStatus, bool) {
+	for _, statuses := range [][]core_v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == name {
+				return status, true
+			}
+		}
+	}
+	return core_v1.ContainerStatus{}, false
+}
+
+/*
+   容器启动后才有日志,Previous需要容器有上一次退出的记录
+*/
+func containerLogAvailable(status core_v1.ContainerStatus, previous bool) bool {
+	if previous {
+		return status.LastTerminationState.Terminated != nil
+	}
+	return status.State.Running != nil || status.State.Terminated != nil
+}
+
+func (s *logStreamer) stream(ctx context.Context, podName, containerName string) {
+	prefix := podName + "/" + containerName
+	body, err := s.clientSet.CoreV1().Pods(s.namespace).GetLogs(podName, s.opts.podLogOptions(containerName)).Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.out.println(prefix, "获取日志失败: "+err.Error())
+		}
+		return
+	}
+	defer body.Close()
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if s.opts.Include != nil && !s.opts.Include.MatchString(line) {
+			continue
+		}
+		if s.opts.Exclude != nil && s.opts.Exclude.MatchString(line) {
+			continue
+		}
+		s.out.println(prefix, line)
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		s.out.println(prefix, "读取日志失败: "+err.Error())
+	}
+}
+
+func runLogs(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("logs", out)
+	opts := logOptions{}
+	fs.BoolVar(&opts.Follow, "f", false, "持续输出,并跟踪新启动的Pod")
+	fs.DurationVar(&opts.Since, "since", 0, "只输出最近一段时间的日志,如 10m")
+	sinceTime := fs.String("since-time", "", "只输出该时间之后的日志,RFC3339格式")
+	fs.Int64Var(&opts.Tail, "tail", -1, "每个容器只输出最后几行,-1为全部")
+	fs.BoolVar(&opts.Previous, "previous", false, "输出上一个已退出容器的日志")
+	fs.BoolVar(&opts.Timestamps, "timestamps", false, "每行带时间戳")
+	fs.StringVar(&opts.Container, "c", "", "只输出该容器的日志")
+	include := fs.String("include", "", "只输出匹配该正则的行")
+	exclude := fs.String("exclude", "", "不输出匹配该正则的行")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: logs [参数] <资源类型>/<名称>")
+	}
+	kind, name, err := parseObjectRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *sinceTime != "" {
+		t, err := time.Parse(time.RFC3339, *sinceTime)
+		if err != nil {
+			return usageError("-since-time格式应为RFC3339: %v", err)
+		}
+		opts.SinceTime = &meta_v1.Time{Time: t}
+	}
+	if opts.Since > 0 && opts.SinceTime != nil {
+		return usageError("-since与-since-time只能指定一个")
+	}
+	if opts.Include, err = compileOptional(*include); err != nil {
+		return usageError("-include: %v", err)
+	}
+	if opts.Exclude, err = compileOptional(*exclude); err != nil {
+		return usageError("-exclude: %v", err)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	listOpts, err := podListOptions(ctx, clientSet, kind, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	return streamLogs(ctx, clientSet, activeProfile.Namespace, listOpts, opts, out)
+}
+
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+   containers中值为true的容器已启动
+*/
+func newLogPod(name, app string, containers map[string]bool) *core_v1.Pod {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace, Labels: map[string]string{"app": app}},
+	}
+	names := make([]string, 0, len(containers))
+	for container := range containers {
+		names = append(names, container)
+	}
+	sort.Strings(names)
+	for _, container := range names {
+		pod.Spec.Containers = append(pod.Spec.Containers, core_v1.Container{Name: container})
+		status := core_v1.ContainerStatus{Name: container}
+		if containers[container] {
+			status.State.Running = &core_v1.ContainerStateRunning{}
+		} else {
+			status.State.Waiting = &core_v1.ContainerStateWaiting{Reason: "ContainerCreating"}
+		}
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, status)
+	}
+	return pod
+}
+
+func newLogDeployment() *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+		Spec: apps_v1.DeploymentSpec{
+			Selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": "test-nginx"}},
+		},
+	}
+}
+
+/*
+   输出日志时并发写入,测试中读取需要加锁
+*/
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStreamLogs(t *testing.T) {
+	objects := []runtime.Object{
+		newLogDeployment(),
+		newLogPod("test-nginx-a", "test-nginx", map[string]bool{"nginx": true, "sidecar": true}),
+		newLogPod("test-nginx-b", "test-nginx", map[string]bool{"nginx": true, "sidecar": false}),
+		newLogPod("other", "other", map[string]bool{"nginx": true}),
+	}
+	tests := []struct {
+		name string
+		opts logOptions
+		want []string
+	}{
+		{
+			name: "all containers",
+			opts: logOptions{Tail: 10},
+			want: []string{"[test-nginx-a/nginx] fake logs", "[test-nginx-a/sidecar] fake logs", "[test-nginx-b/nginx] fake logs"},
+		},
+		{
+			name: "single container",
+			opts: logOptions{Tail: -1, Container: "nginx"},
+			want: []string{"[test-nginx-a/nginx] fake logs", "[test-nginx-b/nginx] fake logs"},
+		},
+		{
+			name: "include",
+			opts: logOptions{Tail: -1, Container: "sidecar", Include: regexp.MustCompile("^fake")},
+			want: []string{"[test-nginx-a/sidecar] fake logs"},
+		},
+		{
+			name: "exclude",
+			opts: logOptions{Tail: -1, Exclude: regexp.MustCompile("logs$")},
+			want: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(objects...)
+			listOpts, err := podListOptions(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &syncBuffer{}
+			if err := streamLogs(context.Background(), clientSet, TestNamespace, listOpts, tt.opts, out); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.lines(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("output:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, action := range clientSet.Actions() {
+				if action.GetSubresource() != "log" {
+					continue
+				}
+				opts := action.(k8s_testing.GenericAction).GetValue().(*core_v1.PodLogOptions)
+				if (tt.opts.Tail >= 0) != (opts.TailLines != nil) {
+					t.Errorf("tailLines = %v for tail %d", opts.TailLines, tt.opts.Tail)
+				}
+			}
+		})
+	}
+
+	clientSet := fake.NewSimpleClientset(newLogDeployment())
+	listOpts, _ := podListOptions(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx")
+	if err := streamLogs(context.Background(), clientSet, TestNamespace, listOpts, logOptions{Tail: -1}, &bytes.Buffer{}); errorKindOf(err) != ErrNotFound {
+		t.Errorf("no pods error = %v", err)
+	}
+}
+
+func TestStreamLogsFollow(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		newLogDeployment(),
+		newLogPod("test-nginx-a", "test-nginx", map[string]bool{"nginx": true}),
+	)
+	watcher := watch.NewFake()
+	clientSet.PrependWatchReactor("pods", k8s_testing.DefaultWatchReactor(watcher, nil))
+	listOpts, _ := podListOptions(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- streamLogs(ctx, clientSet, TestNamespace, listOpts, logOptions{Follow: true, Tail: -1}, out)
+	}()
+
+	//发布过程中新建的Pod先处于创建中,容器启动后开始输出;重启后的容器重新输出
+	starting := newLogPod("test-nginx-b", "test-nginx", map[string]bool{"nginx": false})
+	watcher.Add(starting)
+	watcher.Modify(newLogPod("test-nginx-b", "test-nginx", map[string]bool{"nginx": true}))
+	watcher.Modify(newLogPod("test-nginx-b", "test-nginx", map[string]bool{"nginx": true}))
+	restarted := newLogPod("test-nginx-b", "test-nginx", map[string]bool{"nginx": true})
+	restarted.Status.ContainerStatuses[0].RestartCount = 1
+	watcher.Modify(restarted)
+
+	want := []string{"[test-nginx-a/nginx] fake logs", "[test-nginx-b/nginx] fake logs", "[test-nginx-b/nginx] fake logs"}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Join(out.lines(), "\n") != strings.Join(want, "\n") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := out.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseObjectRef(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantKind string
+		wantName string
+		wantErr  bool
+	}{
+		{in: "deployment/test-nginx", wantKind: "deployment", wantName: "test-nginx"},
+		{in: "deploy/test-nginx", wantKind: "deployment", wantName: "test-nginx"},
+		{in: "po/test-nginx-abc", wantKind: "pod", wantName: "test-nginx-abc"},
+		{in: "test-nginx", wantErr: true},
+		{in: "widget/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		kind, name, err := parseObjectRef(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && (kind != tt.wantKind || name != tt.wantName)) {
+			t.Errorf("parseObjectRef(%q) = %q, %q, %v", tt.in, kind, name, err)
+		}
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	batch_v1 "k8s.io/api/batch/v1"
 	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
 	storage_v1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
+	"os"
 )
 
 /*
@@ -21,104 +28,74 @@ import (
 */
 
 const (
-	TestNamespace           = "test-namespace" //测试使用的命名空间
-	TestDockerConfigJsonKey = "docker-harbor"  //docker仓库密文key
+	TestNamespace           = "test-namespace" //默认命名空间,可以在profile中修改
+	TestDockerConfigJsonKey = "docker-harbor"  //默认的docker仓库密文key,可以在profile中修改
 )
 
 func main() {
-	clientSet := initClient()
-
-	//createOrUpdateNamespace(clientSet)
-	//listNamespace(clientSet)
-	//deleteNamespace(clientSet)
-
-	//createOrUpdateConfigMap(clientSet)
-	//listConfigMap(clientSet)
-	//deleteConfigMap(clientSet)
-
-	//createOrUpdateSecret(clientSet)
-	//listSecret(clientSet)
-	//deleteSecret(clientSet)
-
-	createOrUpdateDeployment(clientSet)
-	//listDeployment(clientSet)
-	//deleteDeployment(clientSet)
-
-	//createOrUpdateService(clientSet)
-	//listService(clientSet)
-	//deleteService(clientSet)
-
-	//createOrUpdateStorage(clientSet)
-	//listStorage(clientSet)
-	//deleteStorage(clientSet)
-
-	//createOrUpdatePV(clientSet)
-	//listPV(clientSet)
-	//deletePV(clientSet)
-
-	//createOrUpdatePVC(clientSet)
-	//listPVC(clientSet)
-	//deletePVC(clientSet)
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, errorDetail(err))
+		os.Exit(exitCode(err))
+	}
 }
 
 /*
    创建Namespace,已存在则更新
    源码位置:K8s.io/client-go/kubernetes/typed/core/v1/namespace.go
 */
-func createOrUpdateNamespace(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/namespace.yaml")
-	if err != nil {
-		panic(err)
-	}
-	jsonBytes := yaml2Json(yamlFile)
+func createOrUpdateNamespace(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	namespace := core_v1.Namespace{}
-	err = json.Unmarshal(jsonBytes, &namespace)
-	if err != nil {
-		panic(err)
+	if err := readManifest(activeProfile.manifestPath("namespace.yaml"), &namespace); err != nil {
+		return err
 	}
+	//清单中的名称为默认命名空间,与其他资源使用的命名空间保持一致
+	namespace.ObjectMeta.Name = activeProfile.Namespace
 	client := clientSet.CoreV1().Namespaces()
-	if _, err = client.Get(context.TODO(), namespace.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	if _, err := client.Get(ctx, namespace.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &namespace, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &namespace, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Namespace", err)
 			}
-			fmt.Println("Namespace创建成功")
-			return
+			fmt.Fprintln(out, "Namespace创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取Namespace", err)
 	}
-	if _, err := client.Update(context.TODO(), &namespace, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &namespace, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Namespace", err)
 	}
-	fmt.Println("Namespace更新成功")
+	fmt.Fprintln(out, "Namespace更新成功")
+	return nil
 }
 
 /*
    获取命名空间列表
 */
-func listNamespace(clientSet *kubernetes.Clientset) {
+func listNamespace(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	client := clientSet.CoreV1().Namespaces()
-	namespaceList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+	namespaceList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取Namespace列表", err)
 	}
 	marshal, _ := json.Marshal(namespaceList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
    删除Namespace
 */
-func deleteNamespace(clientSet *kubernetes.Clientset) {
+func deleteNamespace(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	client := clientSet.CoreV1().Namespaces()
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), TestNamespace, meta_v1.DeleteOptions{
+	err := client.Delete(ctx, activeProfile.Namespace, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除Namespace", err)
 	}
-	fmt.Println("Namespace删除成功")
+	fmt.Fprintln(out, "Namespace删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -127,64 +104,67 @@ func deleteNamespace(clientSet *kubernetes.Clientset) {
     创建密文,已存在则更新
 	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/secret.go
 */
-func createOrUpdateSecret(clientSet *kubernetes.Clientset) {
+func createOrUpdateSecret(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	secret := core_v1.Secret{
 		TypeMeta: meta_v1.TypeMeta{
 			Kind:       "Secret",
 			APIVersion: "v1",
 		},
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name:      TestDockerConfigJsonKey,
-			Namespace: TestNamespace,
+			Name:      activeProfile.PullSecret,
+			Namespace: activeProfile.Namespace,
 		},
 		StringData: map[string]string{
 			core_v1.DockerConfigJsonKey: "{\"auths\":{\"https://registry.dockerhubar.com/\":{\"username\":\"admin\",\"password\":\"123456\"}}}",
 		},
 		Type: core_v1.SecretTypeDockerConfigJson,
 	}
-	client := clientSet.CoreV1().Secrets(TestNamespace)
-	if _, err := client.Get(context.TODO(), secret.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	client := clientSet.CoreV1().Secrets(activeProfile.Namespace)
+	if _, err := client.Get(ctx, secret.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &secret, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &secret, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Secret", err)
 			}
-			fmt.Println("Secret创建成功")
-			return
+			fmt.Fprintln(out, "Secret创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取Secret", err)
 	}
-	if _, err := client.Update(context.TODO(), &secret, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &secret, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Secret", err)
 	}
-	fmt.Println("Secret更新成功")
+	fmt.Fprintln(out, "Secret更新成功")
+	return nil
 }
 
 /*
 	获取Secret列表,若不指定Namespace则获取所有的
 */
-func listSecret(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().Secrets(TestNamespace)
-	secretList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+func listSecret(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.CoreV1().Secrets(activeProfile.Namespace)
+	secretList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取Secret列表", err)
 	}
 	marshal, _ := json.Marshal(secretList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
    删除Secret
 */
-func deleteSecret(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().Secrets(TestNamespace)
+func deleteSecret(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.CoreV1().Secrets(activeProfile.Namespace)
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), TestDockerConfigJsonKey, meta_v1.DeleteOptions{
+	err := client.Delete(ctx, activeProfile.PullSecret, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除Secret", err)
 	}
-	fmt.Println("Secret删除成功")
+	fmt.Fprintln(out, "Secret删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -193,108 +173,372 @@ func deleteSecret(clientSet *kubernetes.Clientset) {
    创建Deployment,已存在则更新
    源码位置:K8s.io/client-go/kubernetes/typed/apps/v1/deployment.go
 */
-func createOrUpdateDeployment(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/deployment.yaml")
-	if err != nil {
-		panic(err)
-	}
-	jsonBytes := yaml2Json(yamlFile)
+func createOrUpdateDeployment(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	deployment := apps_v1.Deployment{}
-	err = json.Unmarshal(jsonBytes, &deployment)
-	if err != nil {
-		panic(err)
+	if err := readManifest(activeProfile.manifestPath("deployment.yaml"), &deployment); err != nil {
+		return err
+	}
+	if err := injectManifestPullSecret(ctx, clientSet, &deployment.Spec.Template.Spec, out); err != nil {
+		return err
 	}
-	deploymentClient := clientSet.AppsV1().Deployments(TestNamespace)
-	if _, err = deploymentClient.Get(context.TODO(), deployment.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	deploymentClient := clientSet.AppsV1().Deployments(activeProfile.Namespace)
+	if _, err := deploymentClient.Get(ctx, deployment.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := deploymentClient.Create(context.TODO(), &deployment, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := deploymentClient.Create(ctx, &deployment, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Deployment", err)
 			}
-			fmt.Println("Deployment创建成功")
-			return
+			fmt.Fprintln(out, "Deployment创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取Deployment", err)
 	}
-	if _, err := deploymentClient.Update(context.TODO(), &deployment, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := deploymentClient.Update(ctx, &deployment, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Deployment", err)
 	}
-	fmt.Println("Deployment更新成功")
+	fmt.Fprintln(out, "Deployment更新成功")
+	return nil
 }
 
 /*
    获取Deployment列表,若不指定namespace则获取所有的
 */
-func listDeployment(clientSet *kubernetes.Clientset) {
-	client := clientSet.AppsV1().Deployments(TestNamespace)
-	deploymentList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+func listDeployment(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.AppsV1().Deployments(activeProfile.Namespace)
+	deploymentList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取Deployment列表", err)
 	}
 	marshal, _ := json.Marshal(deploymentList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
    删除Deployment
 */
-func deleteDeployment(clientSet *kubernetes.Clientset) {
-	client := clientSet.AppsV1().Deployments(TestNamespace)
+func deleteDeployment(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("deployment.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.AppsV1().Deployments(activeProfile.Namespace)
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "svc-cloud-resourceserver", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除Deployment", err)
 	}
-	fmt.Println("Deployment删除成功")
+	fmt.Fprintln(out, "Deployment删除成功")
+	return nil
 }
 
 //*************************分割线****************************
 
 /*
-    创建Service,已存在则更新
-	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/service.go
+   创建StatefulSet,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/apps/v1/statefulset.go
 */
-func createOrUpdateService(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/service.yaml")
+func createOrUpdateStatefulSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	statefulSet := apps_v1.StatefulSet{}
+	if err := readManifest(activeProfile.manifestPath("statefulSet.yaml"), &statefulSet); err != nil {
+		return err
+	}
+	if err := injectManifestPullSecret(ctx, clientSet, &statefulSet.Spec.Template.Spec, out); err != nil {
+		return err
+	}
+	statefulSetClient := clientSet.AppsV1().StatefulSets(activeProfile.Namespace)
+	if _, err := statefulSetClient.Get(ctx, statefulSet.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := statefulSetClient.Create(ctx, &statefulSet, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建StatefulSet", err)
+			}
+			fmt.Fprintln(out, "StatefulSet创建成功")
+			return nil
+		}
+		return wrapError("获取StatefulSet", err)
+	}
+	if _, err := statefulSetClient.Update(ctx, &statefulSet, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新StatefulSet", err)
+	}
+	fmt.Fprintln(out, "StatefulSet更新成功")
+	return nil
+}
+
+/*
+   获取StatefulSet列表,若不指定namespace则获取所有的
+*/
+func listStatefulSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.AppsV1().StatefulSets(activeProfile.Namespace)
+	statefulSetList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取StatefulSet列表", err)
 	}
-	jsonBytes := yaml2Json(yamlFile)
-	service := core_v1.Service{}
-	err = json.Unmarshal(jsonBytes, &service)
+	marshal, _ := json.Marshal(statefulSetList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除StatefulSet
+*/
+func deleteStatefulSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("statefulSet.yaml")
 	if err != nil {
-		panic(err)
+		return err
 	}
-	client := clientSet.CoreV1().Services(TestNamespace)
-	existService, err := client.Get(context.TODO(), service.ObjectMeta.Name, meta_v1.GetOptions{})
+	client := clientSet.AppsV1().StatefulSets(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
 	if err != nil {
+		return wrapError("删除StatefulSet", err)
+	}
+	fmt.Fprintln(out, "StatefulSet删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+   创建DaemonSet,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/apps/v1/daemonset.go
+*/
+func createOrUpdateDaemonSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	daemonSet := apps_v1.DaemonSet{}
+	if err := readManifest(activeProfile.manifestPath("daemonSet.yaml"), &daemonSet); err != nil {
+		return err
+	}
+	if err := injectManifestPullSecret(ctx, clientSet, &daemonSet.Spec.Template.Spec, out); err != nil {
+		return err
+	}
+	daemonSetClient := clientSet.AppsV1().DaemonSets(activeProfile.Namespace)
+	if _, err := daemonSetClient.Get(ctx, daemonSet.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &service, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := daemonSetClient.Create(ctx, &daemonSet, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建DaemonSet", err)
 			}
-			fmt.Println("Service创建成功")
-			return
+			fmt.Fprintln(out, "DaemonSet创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取DaemonSet", err)
 	}
-	if _, err := client.Update(context.TODO(), existService, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := daemonSetClient.Update(ctx, &daemonSet, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新DaemonSet", err)
 	}
-	fmt.Println("service更新成功")
+	fmt.Fprintln(out, "DaemonSet更新成功")
+	return nil
+}
+
+/*
+   获取DaemonSet列表,若不指定namespace则获取所有的
+*/
+func listDaemonSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.AppsV1().DaemonSets(activeProfile.Namespace)
+	daemonSetList, err := client.List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取DaemonSet列表", err)
+	}
+	marshal, _ := json.Marshal(daemonSetList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除DaemonSet
+*/
+func deleteDaemonSet(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("daemonSet.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.AppsV1().DaemonSets(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除DaemonSet", err)
+	}
+	fmt.Fprintln(out, "DaemonSet删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+   创建Job,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/batch/v1/job.go
+*/
+func createOrUpdateJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	job := batch_v1.Job{}
+	if err := readManifest(activeProfile.manifestPath("job.yaml"), &job); err != nil {
+		return err
+	}
+	if err := injectManifestPullSecret(ctx, clientSet, &job.Spec.Template.Spec, out); err != nil {
+		return err
+	}
+	jobClient := clientSet.BatchV1().Jobs(activeProfile.Namespace)
+	if _, err := jobClient.Get(ctx, job.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := jobClient.Create(ctx, &job, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Job", err)
+			}
+			fmt.Fprintln(out, "Job创建成功")
+			return nil
+		}
+		return wrapError("获取Job", err)
+	}
+	if _, err := jobClient.Update(ctx, &job, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Job", err)
+	}
+	fmt.Fprintln(out, "Job更新成功")
+	return nil
+}
+
+/*
+   获取Job列表,若不指定namespace则获取所有的
+*/
+func listJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.BatchV1().Jobs(activeProfile.Namespace)
+	jobList, err := client.List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取Job列表", err)
+	}
+	marshal, _ := json.Marshal(jobList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除Job
+*/
+func deleteJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("job.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.BatchV1().Jobs(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除Job", err)
+	}
+	fmt.Fprintln(out, "Job删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+   创建CronJob,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/batch/v1/cronjob.go
+*/
+func createOrUpdateCronJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	cronJob := batch_v1.CronJob{}
+	if err := readManifest(activeProfile.manifestPath("cronJob.yaml"), &cronJob); err != nil {
+		return err
+	}
+	if err := injectManifestPullSecret(ctx, clientSet, &cronJob.Spec.JobTemplate.Spec.Template.Spec, out); err != nil {
+		return err
+	}
+	cronJobClient := clientSet.BatchV1().CronJobs(activeProfile.Namespace)
+	if _, err := cronJobClient.Get(ctx, cronJob.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := cronJobClient.Create(ctx, &cronJob, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建CronJob", err)
+			}
+			fmt.Fprintln(out, "CronJob创建成功")
+			return nil
+		}
+		return wrapError("获取CronJob", err)
+	}
+	if _, err := cronJobClient.Update(ctx, &cronJob, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新CronJob", err)
+	}
+	fmt.Fprintln(out, "CronJob更新成功")
+	return nil
+}
+
+/*
+   获取CronJob列表,若不指定namespace则获取所有的
+*/
+func listCronJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.BatchV1().CronJobs(activeProfile.Namespace)
+	cronJobList, err := client.List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取CronJob列表", err)
+	}
+	marshal, _ := json.Marshal(cronJobList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除CronJob
+*/
+func deleteCronJob(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("cronJob.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.BatchV1().CronJobs(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除CronJob", err)
+	}
+	fmt.Fprintln(out, "CronJob删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+    创建Service,已存在则更新
+	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/service.go
+*/
+func createOrUpdateService(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	service := core_v1.Service{}
+	if err := readManifest(activeProfile.manifestPath("service.yaml"), &service); err != nil {
+		return err
+	}
+	client := clientSet.CoreV1().Services(activeProfile.Namespace)
+	existService, err := client.Get(ctx, service.ObjectMeta.Name, meta_v1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := client.Create(ctx, &service, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Service", err)
+			}
+			fmt.Fprintln(out, "Service创建成功")
+			return nil
+		}
+		return wrapError("获取Service", err)
+	}
+	if _, err := client.Update(ctx, existService, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Service", err)
+	}
+	fmt.Fprintln(out, "service更新成功")
+	return nil
 }
 
 /*
    获取Service列表,若不指定namespace则获取所有的
 */
-func listService(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().Services(TestNamespace)
-	serviceList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+func listService(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.CoreV1().Services(activeProfile.Namespace)
+	serviceList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取Service列表", err)
 	}
 	marshal, _ := json.Marshal(serviceList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
@@ -304,78 +548,273 @@ func listService(clientSet *kubernetes.Clientset) {
 	Background：删除之后，所管理的资源对象由GC删除
 	Foreground：删除之前所管理的资源对象必须先删除
 */
-func deleteService(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().Services(TestNamespace)
+func deleteService(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("service.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.CoreV1().Services(activeProfile.Namespace)
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "svc-cloud-resourceserver", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除Service", err)
 	}
-	fmt.Println("Service删除成功")
+	fmt.Fprintln(out, "Service删除成功")
+	return nil
 }
 
 //*************************分割线****************************
 
 /*
-    创建Storage,已存在则更新
-	源码位置:K8s.io/client-go/kubernetes/typed/storage/v1/storageclass.go
+   创建Ingress,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/networking/v1/ingress.go
+*/
+func createOrUpdateIngress(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	ingress := networking_v1.Ingress{}
+	if err := readManifest(activeProfile.manifestPath("ingress.yaml"), &ingress); err != nil {
+		return err
+	}
+	ingressClient := clientSet.NetworkingV1().Ingresses(activeProfile.Namespace)
+	if _, err := ingressClient.Get(ctx, ingress.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := ingressClient.Create(ctx, &ingress, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建Ingress", err)
+			}
+			fmt.Fprintln(out, "Ingress创建成功")
+			return nil
+		}
+		return wrapError("获取Ingress", err)
+	}
+	if _, err := ingressClient.Update(ctx, &ingress, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新Ingress", err)
+	}
+	fmt.Fprintln(out, "Ingress更新成功")
+	return nil
+}
+
+/*
+   获取Ingress列表,若不指定namespace则获取所有的
 */
-func createOrUpdateStorage(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/storageClass.yaml")
+func listIngress(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.NetworkingV1().Ingresses(activeProfile.Namespace)
+	ingressList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取Ingress列表", err)
 	}
-	jsonBytes := yaml2Json(yamlFile)
-	storageClass := storage_v1.StorageClass{}
-	err = json.Unmarshal(jsonBytes, &storageClass)
+	marshal, _ := json.Marshal(ingressList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除Ingress
+*/
+func deleteIngress(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("ingress.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.NetworkingV1().Ingresses(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除Ingress", err)
+	}
+	fmt.Fprintln(out, "Ingress删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+   创建NetworkPolicy,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/networking/v1/networkpolicy.go
+*/
+func createOrUpdateNetworkPolicy(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	networkPolicy := networking_v1.NetworkPolicy{}
+	if err := readManifest(activeProfile.manifestPath("networkPolicy.yaml"), &networkPolicy); err != nil {
+		return err
+	}
+	networkPolicyClient := clientSet.NetworkingV1().NetworkPolicies(activeProfile.Namespace)
+	if _, err := networkPolicyClient.Get(ctx, networkPolicy.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := networkPolicyClient.Create(ctx, &networkPolicy, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建NetworkPolicy", err)
+			}
+			fmt.Fprintln(out, "NetworkPolicy创建成功")
+			return nil
+		}
+		return wrapError("获取NetworkPolicy", err)
+	}
+	if _, err := networkPolicyClient.Update(ctx, &networkPolicy, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新NetworkPolicy", err)
+	}
+	fmt.Fprintln(out, "NetworkPolicy更新成功")
+	return nil
+}
+
+/*
+   获取NetworkPolicy列表,若不指定namespace则获取所有的
+*/
+func listNetworkPolicy(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.NetworkingV1().NetworkPolicies(activeProfile.Namespace)
+	networkPolicyList, err := client.List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取NetworkPolicy列表", err)
+	}
+	marshal, _ := json.Marshal(networkPolicyList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除NetworkPolicy
+*/
+func deleteNetworkPolicy(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("networkPolicy.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.NetworkingV1().NetworkPolicies(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除NetworkPolicy", err)
+	}
+	fmt.Fprintln(out, "NetworkPolicy删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+   创建HPA,已存在则更新
+   源码位置:K8s.io/client-go/kubernetes/typed/autoscaling/v2/horizontalpodautoscaler.go
+*/
+func createOrUpdateHPA(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	hpa := autoscaling_v2.HorizontalPodAutoscaler{}
+	if err := readManifest(activeProfile.manifestPath("horizontalPodAutoscaler.yaml"), &hpa); err != nil {
+		return err
+	}
+	hpaClient := clientSet.AutoscalingV2().HorizontalPodAutoscalers(activeProfile.Namespace)
+	if _, err := hpaClient.Get(ctx, hpa.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			if _, err := hpaClient.Create(ctx, &hpa, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建HPA", err)
+			}
+			fmt.Fprintln(out, "HPA创建成功")
+			return nil
+		}
+		return wrapError("获取HPA", err)
+	}
+	if _, err := hpaClient.Update(ctx, &hpa, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新HPA", err)
+	}
+	fmt.Fprintln(out, "HPA更新成功")
+	return nil
+}
+
+/*
+   获取HPA列表,若不指定namespace则获取所有的
+*/
+func listHPA(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.AutoscalingV2().HorizontalPodAutoscalers(activeProfile.Namespace)
+	hpaList, err := client.List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return wrapError("获取HPA列表", err)
+	}
+	marshal, _ := json.Marshal(hpaList)
+	fmt.Fprintln(out, string(marshal))
+	return nil
+}
+
+/*
+   删除HPA
+*/
+func deleteHPA(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("horizontalPodAutoscaler.yaml")
 	if err != nil {
-		panic(err)
+		return err
+	}
+	client := clientSet.AutoscalingV2().HorizontalPodAutoscalers(activeProfile.Namespace)
+	deletePolicy := meta_v1.DeletePropagationForeground
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if err != nil {
+		return wrapError("删除HPA", err)
+	}
+	fmt.Fprintln(out, "HPA删除成功")
+	return nil
+}
+
+//*************************分割线****************************
+
+/*
+    创建Storage,已存在则更新
+	源码位置:K8s.io/client-go/kubernetes/typed/storage/v1/storageclass.go
+*/
+func createOrUpdateStorage(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	storageClass := storage_v1.StorageClass{}
+	if err := readManifest(activeProfile.manifestPath("storageClass.yaml"), &storageClass); err != nil {
+		return err
 	}
 	client := clientSet.StorageV1().StorageClasses()
-	if _, err := client.Get(context.TODO(), storageClass.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	if _, err := client.Get(ctx, storageClass.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &storageClass, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &storageClass, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建StorageClass", err)
 			}
-			fmt.Println("StorageClass创建成功")
-			return
+			fmt.Fprintln(out, "StorageClass创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取StorageClass", err)
 	}
-	if _, err := client.Update(context.TODO(), &storageClass, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &storageClass, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新StorageClass", err)
 	}
-	fmt.Println("StorageClass更新成功")
+	fmt.Fprintln(out, "StorageClass更新成功")
+	return nil
 }
 
 /*
   获取storageClass列表
 */
-func listStorage(clientSet *kubernetes.Clientset) {
+func listStorage(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	client := clientSet.StorageV1().StorageClasses()
-	storageClassList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+	storageClassList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取StorageClass列表", err)
 	}
 	marshal, _ := json.Marshal(storageClassList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
 	删除storageClass
 */
-func deleteStorage(clientSet *kubernetes.Clientset) {
+func deleteStorage(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("storageClass.yaml")
+	if err != nil {
+		return err
+	}
 	client := clientSet.StorageV1().StorageClasses()
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "test-storage-class", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除StorageClass", err)
 	}
-	fmt.Println("StorageClass删除成功")
+	fmt.Fprintln(out, "StorageClass删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -384,60 +823,61 @@ func deleteStorage(clientSet *kubernetes.Clientset) {
     创建ConfigMap,已存在则更新
 	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/configmap.go
 */
-func createOrUpdateConfigMap(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/configMap.yaml")
-	if err != nil {
-		panic(err)
-	}
-	jsonBytes := yaml2Json(yamlFile)
+func createOrUpdateConfigMap(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	configMap := core_v1.ConfigMap{}
-	err = json.Unmarshal(jsonBytes, &configMap)
-	if err != nil {
-		panic(err)
+	if err := readManifest(activeProfile.manifestPath("configMap.yaml"), &configMap); err != nil {
+		return err
 	}
-	client := clientSet.CoreV1().ConfigMaps(TestNamespace)
-	if _, err := client.Get(context.TODO(), configMap.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	client := clientSet.CoreV1().ConfigMaps(activeProfile.Namespace)
+	if _, err := client.Get(ctx, configMap.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &configMap, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &configMap, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建ConfigMap", err)
 			}
-			fmt.Println("ConfigMap创建成功")
-			return
+			fmt.Fprintln(out, "ConfigMap创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取ConfigMap", err)
 	}
-	if _, err := client.Update(context.TODO(), &configMap, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &configMap, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新ConfigMap", err)
 	}
-	fmt.Println("ConfigMap更新成功")
+	fmt.Fprintln(out, "ConfigMap更新成功")
+	return nil
 }
 
 /*
    获取ConfigMap列表,若不指定namespace则获取所有的
 */
-func listConfigMap(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().ConfigMaps(TestNamespace)
-	configMapList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+func listConfigMap(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.CoreV1().ConfigMaps(activeProfile.Namespace)
+	configMapList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取ConfigMap列表", err)
 	}
 	marshal, _ := json.Marshal(configMapList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
    删除ConfigMap
 */
-func deleteConfigMap(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().ConfigMaps(TestNamespace)
+func deleteConfigMap(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("configMap.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.CoreV1().ConfigMaps(activeProfile.Namespace)
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "test-configmap-nginx", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除ConfigMap", err)
 	}
-	fmt.Println("ConfigMap删除成功")
+	fmt.Fprintln(out, "ConfigMap删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -446,60 +886,61 @@ func deleteConfigMap(clientSet *kubernetes.Clientset) {
     创建PersistentVolume,已存在则更新
 	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/persistentvolume.go
 */
-func createOrUpdatePV(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/persistentVolume.yaml")
-	if err != nil {
-		panic(err)
-	}
-	jsonBytes := yaml2Json(yamlFile)
+func createOrUpdatePV(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	pv := core_v1.PersistentVolume{}
-	err = json.Unmarshal(jsonBytes, &pv)
-	if err != nil {
-		panic(err)
+	if err := readManifest(activeProfile.manifestPath("persistentVolume.yaml"), &pv); err != nil {
+		return err
 	}
 	client := clientSet.CoreV1().PersistentVolumes()
-	if _, err := client.Get(context.TODO(), pv.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	if _, err := client.Get(ctx, pv.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &pv, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &pv, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建PV", err)
 			}
-			fmt.Println("PV创建成功")
-			return
+			fmt.Fprintln(out, "PV创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取PV", err)
 	}
-	if _, err := client.Update(context.TODO(), &pv, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &pv, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新PV", err)
 	}
-	fmt.Println("PV更新成功")
+	fmt.Fprintln(out, "PV更新成功")
+	return nil
 }
 
 /*
   获取PersistentVolume列表
 */
-func listPV(clientSet *kubernetes.Clientset) {
+func listPV(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	client := clientSet.CoreV1().PersistentVolumes()
-	pvList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+	pvList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取PV列表", err)
 	}
 	marshal, _ := json.Marshal(pvList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
 	删除PersistentVolume
 */
-func deletePV(clientSet *kubernetes.Clientset) {
+func deletePV(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("persistentVolume.yaml")
+	if err != nil {
+		return err
+	}
 	client := clientSet.CoreV1().PersistentVolumes()
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "test-pv", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除PV", err)
 	}
-	fmt.Println("PV删除成功")
+	fmt.Fprintln(out, "PV删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -508,60 +949,61 @@ func deletePV(clientSet *kubernetes.Clientset) {
     创建PersistentVolumeClaim,已存在则更新
 	源码位置:K8s.io/client-go/kubernetes/typed/core/v1/persistentvolumeclaim.go
 */
-func createOrUpdatePVC(clientSet *kubernetes.Clientset) {
-	yamlFile, err := ioutil.ReadFile("./yaml/persistentVolumeClaim.yaml")
-	if err != nil {
-		panic(err)
-	}
-	jsonBytes := yaml2Json(yamlFile)
+func createOrUpdatePVC(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
 	pvc := core_v1.PersistentVolumeClaim{}
-	err = json.Unmarshal(jsonBytes, &pvc)
-	if err != nil {
-		panic(err)
+	if err := readManifest(activeProfile.manifestPath("persistentVolumeClaim.yaml"), &pvc); err != nil {
+		return err
 	}
-	client := clientSet.CoreV1().PersistentVolumeClaims(TestNamespace)
-	if _, err := client.Get(context.TODO(), pvc.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
+	client := clientSet.CoreV1().PersistentVolumeClaims(activeProfile.Namespace)
+	if _, err := client.Get(ctx, pvc.ObjectMeta.Name, meta_v1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
-			if _, err := client.Create(context.TODO(), &pvc, meta_v1.CreateOptions{}); err != nil {
-				panic(err)
+			if _, err := client.Create(ctx, &pvc, meta_v1.CreateOptions{}); err != nil {
+				return wrapError("创建PVC", err)
 			}
-			fmt.Println("PVC创建成功")
-			return
+			fmt.Fprintln(out, "PVC创建成功")
+			return nil
 		}
-		panic(err)
+		return wrapError("获取PVC", err)
 	}
-	if _, err := client.Update(context.TODO(), &pvc, meta_v1.UpdateOptions{}); err != nil {
-		panic(err)
+	if _, err := client.Update(ctx, &pvc, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("更新PVC", err)
 	}
-	fmt.Println("PVC更新成功")
+	fmt.Fprintln(out, "PVC更新成功")
+	return nil
 }
 
 /*
   获取PersistentVolumeClaim列表
 */
-func listPVC(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().PersistentVolumeClaims(TestNamespace)
-	pvList, err := client.List(context.TODO(), meta_v1.ListOptions{})
+func listPVC(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	client := clientSet.CoreV1().PersistentVolumeClaims(activeProfile.Namespace)
+	pvList, err := client.List(ctx, meta_v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return wrapError("获取PVC列表", err)
 	}
 	marshal, _ := json.Marshal(pvList)
-	fmt.Println(string(marshal))
+	fmt.Fprintln(out, string(marshal))
+	return nil
 }
 
 /*
 	删除PersistentVolumeClaim
 */
-func deletePVC(clientSet *kubernetes.Clientset) {
-	client := clientSet.CoreV1().PersistentVolumeClaims(TestNamespace)
+func deletePVC(ctx context.Context, clientSet kubernetes.Interface, out io.Writer) error {
+	name, err := manifestName("persistentVolumeClaim.yaml")
+	if err != nil {
+		return err
+	}
+	client := clientSet.CoreV1().PersistentVolumeClaims(activeProfile.Namespace)
 	deletePolicy := meta_v1.DeletePropagationForeground
-	err := client.Delete(context.TODO(), "test-pvc", meta_v1.DeleteOptions{
+	err = client.Delete(ctx, name, meta_v1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
-		panic(err)
+		return wrapError("删除PVC", err)
 	}
-	fmt.Println("PVC删除成功")
+	fmt.Fprintln(out, "PVC删除成功")
+	return nil
 }
 
 //*************************分割线****************************
@@ -571,24 +1013,82 @@ func deletePVC(clientSet *kubernetes.Clientset) {
    kubeconfig 默认在主节点 /etc/kubernetes/admin.conf
    一般在 $HOME/.kube/config 也会复制一份用于身份认证
 */
-func initClient() *kubernetes.Clientset {
-	var err error
-	kubeConfig, err := ioutil.ReadFile("./config")
-	restConf, err := clientcmd.RESTConfigFromKubeConfig(kubeConfig)
+func initClient(ctx context.Context) (*kubernetes.Clientset, error) {
+	restConf, err := initRESTConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
 	clientSet, err := kubernetes.NewForConfig(restConf)
 	if err != nil {
-		panic(err)
+		return nil, configError("初始化客户端", err)
+	}
+	return clientSet, nil
+}
+
+/*
+   动态客户端,用于访问编译时未知的资源类型,如CRD
+*/
+func initDynamicClient(ctx context.Context) (dynamic.Interface, error) {
+	restConf, err := initRESTConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConf)
+	if err != nil {
+		return nil, configError("初始化动态客户端", err)
 	}
-	return clientSet
+	return client, nil
+}
+
+/*
+   exec、cp、port-forward等需要升级连接的请求直接使用rest.Config
+   ctx中指定了kubeconfig context时连接对应的集群,见withKubeContext,否则使用profile中的context
+*/
+func initRESTConfig(ctx context.Context) (*rest.Config, error) {
+	kubeConfig, err := ioutil.ReadFile(activeProfile.kubeConfigPath())
+	if err != nil {
+		return nil, configError("读取kubeconfig", err)
+	}
+	name := kubeContextFrom(ctx)
+	if name == "" {
+		name = activeProfile.Context
+	}
+	restConf, err := restConfigForContext(kubeConfig, name)
+	if err != nil {
+		return nil, configError("解析kubeconfig", err)
+	}
+	clientOpts.apply(restConf)
+	activeProfile.apply(restConf)
+	contextName, user := kubeContextUser(kubeConfig, name)
+	clientOpts.applyAudit(restConf, contextName, user)
+	return restConf, nil
 }
 
 /*
    yaml转json
 */
-func yaml2Json(yamlBytes []byte) (jsonBytes []byte) {
-	toJSON, err := yaml.ToJSON(yamlBytes)
+func yaml2Json(yamlBytes []byte) (jsonBytes []byte, err error) {
+	return yaml.ToJSON(yamlBytes)
+}
+
+/*
+   读取yaml清单并反序列化到obj,失败时返回ErrInvalidManifest
+   清单中指定的命名空间替换为profile中的命名空间
+*/
+func readManifest(path string, obj interface{}) error {
+	yamlFile, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return manifestError(path, err)
+	}
+	jsonBytes, err := yaml2Json(yamlFile)
+	if err != nil {
+		return manifestError(path, err)
+	}
+	if err := json.Unmarshal(jsonBytes, obj); err != nil {
+		return manifestError(path, err)
+	}
+	if accessor, err := meta.Accessor(obj); err == nil && accessor.GetNamespace() != "" {
+		accessor.SetNamespace(activeProfile.Namespace)
 	}
-	return toJSON
+	return nil
 }
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	networking_v1 "k8s.io/api/networking/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	sigs_yaml "sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "更新testdata下的golden文件")
+
+/*
+   每种资源的测试描述
+   name为manifest中的资源名称,deleteName为delete函数删除的资源名称
+*/
+type resourceCase struct {
+	kind           string
+	gvr            schema.GroupVersionResource
+	namespace      string
+	name           string
+	deleteName     string
+	newObject      func(name string) runtime.Object
+	createOrUpdate func(context.Context, kubernetes.Interface, io.Writer) error
+	list           func(context.Context, kubernetes.Interface, io.Writer) error
+	delete         func(context.Context, kubernetes.Interface, io.Writer) error
+}
+
+var resourceCases = []resourceCase{
+	{
+		kind:       "Namespace",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("namespaces"),
+		name:       TestNamespace,
+		deleteName: TestNamespace,
+		newObject: func(name string) runtime.Object {
+			return &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+		},
+		createOrUpdate: createOrUpdateNamespace,
+		list:           listNamespace,
+		delete:         deleteNamespace,
+	},
+	{
+		kind:       "ConfigMap",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("configmaps"),
+		namespace:  TestNamespace,
+		name:       "test-configmap-nginx",
+		deleteName: "test-configmap-nginx",
+		newObject: func(name string) runtime.Object {
+			return &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateConfigMap,
+		list:           listConfigMap,
+		delete:         deleteConfigMap,
+	},
+	{
+		kind:       "Secret",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("secrets"),
+		namespace:  TestNamespace,
+		name:       TestDockerConfigJsonKey,
+		deleteName: TestDockerConfigJsonKey,
+		newObject: func(name string) runtime.Object {
+			return &core_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateSecret,
+		list:           listSecret,
+		delete:         deleteSecret,
+	},
+	{
+		kind:       "Deployment",
+		gvr:        apps_v1.SchemeGroupVersion.WithResource("deployments"),
+		namespace:  TestNamespace,
+		name:       "test-nginx",
+		deleteName: "test-nginx",
+		newObject: func(name string) runtime.Object {
+			return &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateDeployment,
+		list:           listDeployment,
+		delete:         deleteDeployment,
+	},
+	{
+		kind:       "StatefulSet",
+		gvr:        apps_v1.SchemeGroupVersion.WithResource("statefulsets"),
+		namespace:  TestNamespace,
+		name:       "test-statefulset",
+		deleteName: "test-statefulset",
+		newObject: func(name string) runtime.Object {
+			return &apps_v1.StatefulSet{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateStatefulSet,
+		list:           listStatefulSet,
+		delete:         deleteStatefulSet,
+	},
+	{
+		kind:       "DaemonSet",
+		gvr:        apps_v1.SchemeGroupVersion.WithResource("daemonsets"),
+		namespace:  TestNamespace,
+		name:       "test-daemonset",
+		deleteName: "test-daemonset",
+		newObject: func(name string) runtime.Object {
+			return &apps_v1.DaemonSet{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateDaemonSet,
+		list:           listDaemonSet,
+		delete:         deleteDaemonSet,
+	},
+	{
+		kind:       "Job",
+		gvr:        batch_v1.SchemeGroupVersion.WithResource("jobs"),
+		namespace:  TestNamespace,
+		name:       "test-job",
+		deleteName: "test-job",
+		newObject: func(name string) runtime.Object {
+			return &batch_v1.Job{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateJob,
+		list:           listJob,
+		delete:         deleteJob,
+	},
+	{
+		kind:       "CronJob",
+		gvr:        batch_v1.SchemeGroupVersion.WithResource("cronjobs"),
+		namespace:  TestNamespace,
+		name:       "test-cronjob",
+		deleteName: "test-cronjob",
+		newObject: func(name string) runtime.Object {
+			return &batch_v1.CronJob{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateCronJob,
+		list:           listCronJob,
+		delete:         deleteCronJob,
+	},
+	{
+		kind:       "Service",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("services"),
+		namespace:  TestNamespace,
+		name:       "test-nginx",
+		deleteName: "test-nginx",
+		newObject: func(name string) runtime.Object {
+			return &core_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateService,
+		list:           listService,
+		delete:         deleteService,
+	},
+	{
+		kind:       "Ingress",
+		gvr:        networking_v1.SchemeGroupVersion.WithResource("ingresses"),
+		namespace:  TestNamespace,
+		name:       "test-ingress",
+		deleteName: "test-ingress",
+		newObject: func(name string) runtime.Object {
+			return &networking_v1.Ingress{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateIngress,
+		list:           listIngress,
+		delete:         deleteIngress,
+	},
+	{
+		kind:       "NetworkPolicy",
+		gvr:        networking_v1.SchemeGroupVersion.WithResource("networkpolicies"),
+		namespace:  TestNamespace,
+		name:       "test-networkpolicy",
+		deleteName: "test-networkpolicy",
+		newObject: func(name string) runtime.Object {
+			return &networking_v1.NetworkPolicy{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateNetworkPolicy,
+		list:           listNetworkPolicy,
+		delete:         deleteNetworkPolicy,
+	},
+	{
+		kind:       "HPA",
+		gvr:        autoscaling_v2.SchemeGroupVersion.WithResource("horizontalpodautoscalers"),
+		namespace:  TestNamespace,
+		name:       "test-hpa",
+		deleteName: "test-hpa",
+		newObject: func(name string) runtime.Object {
+			return &autoscaling_v2.HorizontalPodAutoscaler{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdateHPA,
+		list:           listHPA,
+		delete:         deleteHPA,
+	},
+	{
+		kind:       "StorageClass",
+		gvr:        storage_v1.SchemeGroupVersion.WithResource("storageclasses"),
+		name:       "test-storage-class",
+		deleteName: "test-storage-class",
+		newObject: func(name string) runtime.Object {
+			return &storage_v1.StorageClass{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+		},
+		createOrUpdate: createOrUpdateStorage,
+		list:           listStorage,
+		delete:         deleteStorage,
+	},
+	{
+		kind:       "PV",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("persistentvolumes"),
+		name:       "test-pv",
+		deleteName: "test-pv",
+		newObject: func(name string) runtime.Object {
+			return &core_v1.PersistentVolume{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+		},
+		createOrUpdate: createOrUpdatePV,
+		list:           listPV,
+		delete:         deletePV,
+	},
+	{
+		kind:       "PVC",
+		gvr:        core_v1.SchemeGroupVersion.WithResource("persistentvolumeclaims"),
+		namespace:  TestNamespace,
+		name:       "test-pvc",
+		deleteName: "test-pvc",
+		newObject: func(name string) runtime.Object {
+			return &core_v1.PersistentVolumeClaim{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace}}
+		},
+		createOrUpdate: createOrUpdatePVC,
+		list:           listPVC,
+		delete:         deletePVC,
+	},
+}
+
+/*
+   注入一个对指定动作返回错误的reactor
+*/
+func failOn(verb string, rc resourceCase, err error) func(*fake.Clientset) {
+	return func(cs *fake.Clientset) {
+		cs.PrependReactor(verb, rc.gvr.Resource, func(action k8s_testing.Action) (bool, runtime.Object, error) {
+			return true, nil, err
+		})
+	}
+}
+
+func immutableError(rc resourceCase) error {
+	return k8s_errors.NewInvalid(schema.GroupKind{Group: rc.gvr.Group, Kind: rc.kind}, rc.name, field.ErrorList{
+		field.Invalid(field.NewPath("spec", "selector"), "app=other", "field is immutable"),
+	})
+}
+
+func TestCreateOrUpdate(t *testing.T) {
+	for _, rc := range resourceCases {
+		gr := rc.gvr.GroupResource()
+		tests := []struct {
+			name       string
+			existing   bool
+			setup      func(*fake.Clientset)
+			wantOutput string
+			wantKind   errorKind
+			wantCause  string
+		}{
+			{name: "create", wantOutput: "创建成功"},
+			{name: "update", existing: true, wantOutput: "更新成功"},
+			{
+				name:     "get forbidden",
+				setup:    failOn("get", rc, k8s_errors.NewForbidden(gr, rc.name, fmt.Errorf("denied"))),
+				wantKind: ErrForbidden,
+			},
+			{
+				name:     "create already exists",
+				setup:    failOn("create", rc, k8s_errors.NewAlreadyExists(gr, rc.name)),
+				wantKind: ErrAlreadyExists,
+			},
+			{
+				name:     "update conflict",
+				existing: true,
+				setup:    failOn("update", rc, k8s_errors.NewConflict(gr, rc.name, fmt.Errorf("the object has been modified"))),
+				wantKind: ErrConflict,
+			},
+			{
+				name:      "update immutable field",
+				existing:  true,
+				setup:     failOn("update", rc, immutableError(rc)),
+				wantKind:  ErrInvalidManifest,
+				wantCause: "spec.selector",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(rc.kind+"/"+tt.name, func(t *testing.T) {
+				var objects []runtime.Object
+				if tt.existing {
+					objects = append(objects, rc.newObject(rc.name))
+				}
+				cs := fake.NewSimpleClientset(objects...)
+				if tt.setup != nil {
+					tt.setup(cs)
+				}
+				var out bytes.Buffer
+				err := rc.createOrUpdate(context.TODO(), cs, &out)
+				if tt.wantOutput != "" {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if !strings.Contains(out.String(), tt.wantOutput) {
+						t.Errorf("output = %q, want %q", out.String(), tt.wantOutput)
+					}
+					if _, err := cs.Tracker().Get(rc.gvr, rc.namespace, rc.name); err != nil {
+						t.Errorf("object not stored: %v", err)
+					}
+					return
+				}
+				if got := errorKindOf(err); got != tt.wantKind {
+					t.Fatalf("error kind = %v, want %v (err: %v)", got, tt.wantKind, err)
+				}
+				if tt.wantCause != "" && !strings.Contains(errorDetail(err), tt.wantCause) {
+					t.Errorf("detail %q does not contain cause %q", errorDetail(err), tt.wantCause)
+				}
+			})
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, rc := range resourceCases {
+		t.Run(rc.kind, func(t *testing.T) {
+			cs := fake.NewSimpleClientset()
+			if err := rc.createOrUpdate(context.TODO(), cs, ioutil.Discard); err != nil {
+				t.Fatalf("seed: %v", err)
+			}
+			var out bytes.Buffer
+			if err := rc.list(context.TODO(), cs, &out); err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			assertGolden(t, "list_"+strings.ToLower(rc.kind)+".golden", out.Bytes())
+		})
+		t.Run(rc.kind+"/unreachable", func(t *testing.T) {
+			cs := fake.NewSimpleClientset()
+			failOn("list", rc, &url.Error{Op: "Get", URL: "https://192.168.2.111:6443", Err: fmt.Errorf("connection refused")})(cs)
+			err := rc.list(context.TODO(), cs, ioutil.Discard)
+			if got := exitCode(err); got != exitCodes[ErrServerUnreachable] {
+				t.Errorf("exit code = %d, want %d (err: %v)", got, exitCodes[ErrServerUnreachable], err)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for _, rc := range resourceCases {
+		gr := rc.gvr.GroupResource()
+		tests := []struct {
+			name     string
+			existing bool
+			setup    func(*fake.Clientset)
+			wantKind errorKind
+		}{
+			{name: "delete", existing: true},
+			{name: "not found", wantKind: ErrNotFound},
+			{
+				name:     "conflict",
+				existing: true,
+				setup:    failOn("delete", rc, k8s_errors.NewConflict(gr, rc.deleteName, fmt.Errorf("precondition failed"))),
+				wantKind: ErrConflict,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(rc.kind+"/"+tt.name, func(t *testing.T) {
+				var objects []runtime.Object
+				if tt.existing {
+					objects = append(objects, rc.newObject(rc.deleteName))
+				}
+				cs := fake.NewSimpleClientset(objects...)
+				if tt.setup != nil {
+					tt.setup(cs)
+				}
+				var out bytes.Buffer
+				err := rc.delete(context.TODO(), cs, &out)
+				if tt.wantKind != ErrUnknown {
+					if got := errorKindOf(err); got != tt.wantKind {
+						t.Fatalf("error kind = %v, want %v (err: %v)", got, tt.wantKind, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.Contains(out.String(), "删除成功") {
+					t.Errorf("output = %q", out.String())
+				}
+				if _, err := cs.Tracker().Get(rc.gvr, rc.namespace, rc.deleteName); !k8s_errors.IsNotFound(err) {
+					t.Errorf("object still exists: %v", err)
+				}
+				//所有资源都使用Foreground删除
+				for _, action := range cs.Actions() {
+					if del, ok := action.(k8s_testing.DeleteActionImpl); ok {
+						if policy := del.DeleteOptions.PropagationPolicy; policy == nil || *policy != meta_v1.DeletePropagationForeground {
+							t.Errorf("propagationPolicy = %v, want Foreground", policy)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+/*
+   通过serve的REST API驱动动态客户端的create/get/list/update/delete,错误按分类转换为HTTP状态码
+*/
+func TestDynamicCRUD(t *testing.T) {
+	for _, rc := range resourceCases {
+		k := apiKinds[strings.ToLower(rc.kind)]
+		collection := "/v1/" + k.Name
+		if k.Namespaced {
+			collection = "/v1/namespaces/" + TestNamespace + "/" + k.Name
+		}
+		item := collection + "/" + rc.name
+		gr := rc.gvr.GroupResource()
+		tests := []struct {
+			name     string
+			method   string
+			path     string
+			existing bool
+			fail     string //注入错误的动作
+			err      error
+			wantCode int
+			wantKind errorKind
+		}{
+			{name: "create", method: "POST", path: collection, wantCode: http.StatusCreated},
+			{name: "create already exists", method: "POST", path: collection, existing: true, wantCode: http.StatusConflict, wantKind: ErrAlreadyExists},
+			{name: "create forbidden", method: "POST", path: collection, fail: "create", err: k8s_errors.NewForbidden(gr, rc.name, fmt.Errorf("denied")), wantCode: http.StatusForbidden, wantKind: ErrForbidden},
+			{name: "get", method: "GET", path: item, existing: true, wantCode: http.StatusOK},
+			{name: "get not found", method: "GET", path: item, wantCode: http.StatusNotFound, wantKind: ErrNotFound},
+			{name: "list", method: "GET", path: collection, existing: true, wantCode: http.StatusOK},
+			{name: "update", method: "PUT", path: item, existing: true, wantCode: http.StatusOK},
+			{name: "update not found", method: "PUT", path: item, wantCode: http.StatusNotFound, wantKind: ErrNotFound},
+			{name: "update conflict", method: "PUT", path: item, existing: true, fail: "update", err: k8s_errors.NewConflict(gr, rc.name, fmt.Errorf("modified")), wantCode: http.StatusConflict, wantKind: ErrConflict},
+			{name: "delete", method: "DELETE", path: item, existing: true, wantCode: http.StatusNoContent},
+			{name: "delete not found", method: "DELETE", path: item, wantCode: http.StatusNotFound, wantKind: ErrNotFound},
+			{name: "delete forbidden", method: "DELETE", path: item, existing: true, fail: "delete", err: k8s_errors.NewForbidden(gr, rc.name, fmt.Errorf("denied")), wantCode: http.StatusForbidden, wantKind: ErrForbidden},
+		}
+		for _, tt := range tests {
+			t.Run(rc.kind+"/"+tt.name, func(t *testing.T) {
+				obj := newUnstructured(k.apiVersion(), k.Kind, rc.namespace, rc.name)
+				var objects []runtime.Object
+				if tt.existing {
+					objects = append(objects, obj.DeepCopy())
+				}
+				client := newServeClient(objects...)
+				if tt.fail != "" {
+					client.PrependReactor(tt.fail, rc.gvr.Resource, func(action k8s_testing.Action) (bool, runtime.Object, error) {
+						return true, nil, tt.err
+					})
+				}
+				var body string
+				if tt.method == "POST" || tt.method == "PUT" {
+					data, err := obj.MarshalJSON()
+					if err != nil {
+						t.Fatal(err)
+					}
+					body = string(data)
+				}
+				handler := newAPIServer(client, fake.NewSimpleClientset(), testTokens).handler()
+				w := serveRequest(handler, tt.method, tt.path, "admin-token", body)
+				if w.Code != tt.wantCode {
+					t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.wantCode, w.Body.String())
+				}
+				if tt.wantKind != ErrUnknown {
+					if !strings.Contains(w.Body.String(), `"kind":"`+tt.wantKind.String()+`"`) {
+						t.Errorf("body = %s, want kind %s", w.Body.String(), tt.wantKind)
+					}
+					return
+				}
+				_, err := client.Tracker().Get(rc.gvr, rc.namespace, rc.name)
+				switch tt.name {
+				case "delete":
+					if !k8s_errors.IsNotFound(err) {
+						t.Errorf("object still exists: %v", err)
+					}
+				case "list":
+					if !strings.Contains(w.Body.String(), `"name":"`+rc.name+`"`) {
+						t.Errorf("list = %s", w.Body.String())
+					}
+				default:
+					if err != nil {
+						t.Errorf("object missing after %s: %v", tt.name, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+/*
+   修改清单中的名称后,delete删除的是清单中的对象而不是原来的对象
+*/
+func TestDeleteRenamedManifest(t *testing.T) {
+	original := activeProfile
+	defer func() { activeProfile = original }()
+	for _, rc := range resourceCases {
+		file := resourceKinds[strings.ToLower(rc.kind)].manifest
+		if file == "" || rc.kind == "Namespace" {
+			continue
+		}
+		t.Run(rc.kind, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("yaml", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest map[string]interface{}
+			if err := sigs_yaml.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			manifest["metadata"].(map[string]interface{})["name"] = "renamed"
+			if data, err = sigs_yaml.Marshal(manifest); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			activeProfile.ManifestDirs = []string{dir}
+
+			cs := fake.NewSimpleClientset(rc.newObject(rc.deleteName), rc.newObject("renamed"))
+			if err := rc.delete(context.TODO(), cs, &bytes.Buffer{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cs.Tracker().Get(rc.gvr, rc.namespace, "renamed"); !k8s_errors.IsNotFound(err) {
+				t.Errorf("renamed object still exists: %v", err)
+			}
+			if _, err := cs.Tracker().Get(rc.gvr, rc.namespace, rc.deleteName); err != nil {
+				t.Errorf("original object was deleted: %v", err)
+			}
+		})
+	}
+}
+
+/*
+   与testdata下的golden文件比对,使用 go test -update 重新生成
+*/
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output mismatch with %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
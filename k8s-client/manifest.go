@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+   清单集合中的一个对象,path为来源文件
+*/
+type manifest struct {
+	path string
+	obj  runtime.Object
+}
+
+/*
+   对象的描述,如 Deployment test-namespace/test-nginx
+*/
+func (m manifest) String() string {
+	return objectRef(m.obj)
+}
+
+func objectRef(obj runtime.Object) string {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return kind
+	}
+	if accessor.GetNamespace() == "" {
+		return kind + " " + accessor.GetName()
+	}
+	return kind + " " + accessor.GetNamespace() + "/" + accessor.GetName()
+}
+
+/*
+   读取清单集合,paths可以是文件或目录(读取目录下的.yaml/.yml/.json文件)
+   支持以---分隔的多文档yaml,未指定namespace的命名空间级资源使用profile中的命名空间
+*/
+func loadManifests(paths []string) ([]manifest, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, manifestError(path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, manifestError(path, err)
+		}
+		var dirFiles []string
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					dirFiles = append(dirFiles, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+
+	var manifests []manifest
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, manifestError(file, err)
+		}
+		objects, err := decodeManifests(data)
+		if err != nil {
+			return nil, manifestError(file, err)
+		}
+		for _, obj := range objects {
+			manifests = append(manifests, manifest{path: file, obj: obj})
+		}
+	}
+	return manifests, nil
+}
+
+/*
+   将多文档yaml解码为client-go scheme中注册的类型化对象
+*/
+func decodeManifests(data []byte) ([]runtime.Object, error) {
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	decoder := scheme.Codecs.UniversalDeserializer()
+	var objects []runtime.Object
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		jsonBytes, err := yaml2Json(doc)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个文档: %w", i+1, err)
+		}
+		if trimmed := bytes.TrimSpace(jsonBytes); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+			continue
+		}
+		obj, gvk, err := decoder.Decode(jsonBytes, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个文档: %w", i+1, err)
+		}
+		obj.GetObjectKind().SetGroupVersionKind(*gvk)
+		if accessor, err := meta.Accessor(obj); err == nil && accessor.GetNamespace() == "" && isNamespaced(gvk.Kind) {
+			accessor.SetNamespace(activeProfile.Namespace)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+var clusterScopedKinds = map[string]bool{
+	"Namespace":          true,
+	"PersistentVolume":   true,
+	"StorageClass":       true,
+	"Node":               true,
+	"ClusterRole":        true,
+	"ClusterRoleBinding": true,
+	"CSIDriver":          true,
+	"IngressClass":       true,
+}
+
+func isNamespaced(kind string) bool {
+	return !clusterScopedKinds[kind]
+}
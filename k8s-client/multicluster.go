@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type kubeContextKey struct{}
+
+/*
+   在ctx中记录要连接的kubeconfig context,initRESTConfig根据它选择集群,为空时使用current-context
+*/
+func withKubeContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, kubeContextKey{}, name)
+}
+
+func kubeContextFrom(ctx context.Context) string {
+	name, _ := ctx.Value(kubeContextKey{}).(string)
+	return name
+}
+
+/*
+   根据kubeconfig中指定的context创建rest.Config,name为空时使用current-context
+*/
+func restConfigForContext(kubeConfig []byte, name string) (*rest.Config, error) {
+	if name == "" {
+		return clientcmd.RESTConfigFromKubeConfig(kubeConfig)
+	}
+	config, err := clientcmd.Load(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*config, name, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
+
+/*
+   返回实际使用的context名称及其user名称,用于审计日志
+*/
+func kubeContextUser(kubeConfig []byte, name string) (string, string) {
+	config, err := clientcmd.Load(kubeConfig)
+	if err != nil {
+		return name, ""
+	}
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if c, ok := config.Contexts[name]; ok {
+		return name, c.AuthInfo
+	}
+	return name, ""
+}
+
+/*
+   确定要执行的context: -all-contexts时为kubeconfig中的所有context,否则校验-contexts中的名称
+*/
+func resolveContexts(opts clientOptions) ([]string, error) {
+	if opts.AllContexts && len(opts.Contexts) > 0 {
+		return nil, usageError("-contexts与-all-contexts不能同时使用")
+	}
+	data, err := ioutil.ReadFile(activeProfile.kubeConfigPath())
+	if err != nil {
+		return nil, configError("读取kubeconfig", err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, configError("解析kubeconfig", err)
+	}
+	if opts.AllContexts {
+		names := make([]string, 0, len(config.Contexts))
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range opts.Contexts {
+		if _, ok := config.Contexts[name]; !ok {
+			return nil, usageError("kubeconfig中没有context: %s", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+/*
+   单个集群的执行结果
+*/
+type clusterResult struct {
+	Context string
+	Output  string
+	Err     error
+}
+
+/*
+   在每个context上并发执行fn,最多同时执行parallel个,结果按contexts的顺序返回
+*/
+func fanOut(ctx context.Context, contexts []string, parallel int, fn func(ctx context.Context, out io.Writer) error) []clusterResult {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	results := make([]clusterResult, len(contexts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, name := range contexts {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var out bytes.Buffer
+			err := fn(withKubeContext(ctx, name), &out)
+			results[i] = clusterResult{Context: name, Output: out.String(), Err: err}
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+/*
+   按集群输出结果,最后输出汇总以及成功的集群相对第一个成功集群的差异
+   输出为对象的json(如list)时按对象对比,忽略resourceVersion等每个集群都不同的字段,否则逐行对比
+*/
+func printFanOut(out io.Writer, results []clusterResult) error {
+	var succeeded, failed []string
+	var baseline *clusterResult
+	for i := range results {
+		r := &results[i]
+		state := "成功"
+		if r.Err != nil {
+			state = "失败"
+			failed = append(failed, r.Context)
+		} else {
+			succeeded = append(succeeded, r.Context)
+			if baseline == nil {
+				baseline = r
+			}
+		}
+		fmt.Fprintf(out, "==> %s: %s\n", r.Context, state)
+		fmt.Fprint(out, r.Output)
+		if r.Output != "" && !strings.HasSuffix(r.Output, "\n") {
+			fmt.Fprintln(out)
+		}
+		if r.Err != nil {
+			fmt.Fprintln(out, errorDetail(r.Err))
+		}
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "汇总: %d个集群, 成功%d: %s, 失败%d: %s\n", len(results), len(succeeded), joinOrNone(succeeded), len(failed), joinOrNone(failed))
+	if baseline != nil && len(succeeded) > 1 {
+		fmt.Fprintln(out, "差异:")
+		same := true
+		for _, r := range results {
+			if r.Err != nil || r.Context == baseline.Context || r.Output == baseline.Output {
+				continue
+			}
+			diff := diffOutputs(baseline, &r)
+			if len(diff) == 0 {
+				continue
+			}
+			same = false
+			fmt.Fprintf(out, "  %s 相对 %s:\n", r.Context, baseline.Context)
+			for _, line := range diff {
+				fmt.Fprintf(out, "    %s\n", line)
+			}
+		}
+		if same {
+			fmt.Fprintln(out, "  所有成功的集群输出一致")
+		}
+	}
+
+	if len(failed) > 0 {
+		var first error
+		for _, r := range results {
+			if r.Err != nil {
+				first = r.Err
+				break
+			}
+		}
+		return &clientError{Kind: errorKindOf(first), Action: "多集群执行", Err: fmt.Errorf("%d个集群失败: %s", len(failed), strings.Join(failed, ","))}
+	}
+	return nil
+}
+
+func diffOutputs(baseline, r *clusterResult) []string {
+	base, ok := outputObjects(baseline.Output)
+	other, otherOK := outputObjects(r.Output)
+	if !ok || !otherOK {
+		return diffLines(splitLines(baseline.Output), splitLines(r.Output))
+	}
+	keys := make([]string, 0, len(base)+len(other))
+	for key := range base {
+		keys = append(keys, key)
+	}
+	for key := range other {
+		if _, ok := base[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		switch a, b := base[key], other[key]; {
+		case b == nil:
+			lines = append(lines, fmt.Sprintf("%s: 仅在%s中存在", key, baseline.Context))
+		case a == nil:
+			lines = append(lines, fmt.Sprintf("%s: 仅在%s中存在", key, r.Context))
+		default:
+			diff := diffLines(objectLines(a), objectLines(b))
+			if len(diff) == 0 {
+				continue
+			}
+			lines = append(lines, key+":")
+			for _, line := range diff {
+				lines = append(lines, "  "+line)
+			}
+		}
+	}
+	return lines
+}
+
+/*
+   将输出解析为对象,列表展开为其中的对象,以kind/命名空间/名称为键
+   输出不是对象的json时返回false
+*/
+func outputObjects(output string) (map[string]*unstructured.Unstructured, bool) {
+	objects := map[string]*unstructured.Unstructured{}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		var value map[string]interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false
+		}
+		items := []interface{}{value}
+		if list, ok := value["items"].([]interface{}); ok {
+			items = list
+		}
+		for _, item := range items {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			obj := &unstructured.Unstructured{Object: fields}
+			if obj.GetName() == "" {
+				return nil, false
+			}
+			key := obj.GetName()
+			if obj.GetNamespace() != "" {
+				key = obj.GetNamespace() + "/" + key
+			}
+			if obj.GetKind() != "" {
+				key = obj.GetKind() + "/" + key
+			}
+			objects[key] = obj
+		}
+	}
+	return objects, len(objects) > 0
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
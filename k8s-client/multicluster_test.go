@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://192.168.2.111:6443
+- name: prod
+  cluster:
+    server: https://192.168.3.111:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+current-context: dev
+users:
+- name: admin
+  user:
+    token: test-token
+`
+
+func TestRESTConfigForContext(t *testing.T) {
+	for name, host := range map[string]string{"": "https://192.168.2.111:6443", "dev": "https://192.168.2.111:6443", "prod": "https://192.168.3.111:6443"} {
+		config, err := restConfigForContext([]byte(testKubeConfig), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Host != host || config.BearerToken != "test-token" {
+			t.Errorf("context %q: host = %s, token = %q", name, config.Host, config.BearerToken)
+		}
+	}
+	if _, err := restConfigForContext([]byte(testKubeConfig), "missing"); err == nil {
+		t.Error("不存在的context应返回错误")
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	outputs := map[string]string{
+		"dev":     "NAME  STATUS\ntest-pvc  Bound\n",
+		"staging": "NAME  STATUS\ntest-pvc  Bound\n",
+		"prod":    "NAME  STATUS\ntest-pvc  Pending\ntest-pvc-2  Bound\n",
+		"dr":      "",
+	}
+	results := fanOut(context.TODO(), []string{"dev", "staging", "prod", "dr", "test"}, 2, func(ctx context.Context, out io.Writer) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		name := kubeContextFrom(ctx)
+		if name == "dr" {
+			return &clientError{Kind: ErrServerUnreachable, Action: "获取PVC列表", Err: fmt.Errorf("dial tcp 192.168.4.111:6443: connect: connection refused")}
+		}
+		if name == "test" {
+			fmt.Fprint(out, "部分输出")
+			return &clientError{Kind: ErrForbidden, Action: "获取PVC列表", Err: fmt.Errorf("forbidden")}
+		}
+		fmt.Fprint(out, outputs[name])
+		return nil
+	})
+	if maxRunning != 2 {
+		t.Errorf("最大并发数 = %d, want 2", maxRunning)
+	}
+	var out bytes.Buffer
+	err := printFanOut(&out, results)
+	if errorKindOf(err) != ErrServerUnreachable || !strings.Contains(err.Error(), "2个集群失败: dr,test") {
+		t.Errorf("err = %v", err)
+	}
+	assertGolden(t, "fanout.golden", out.Bytes())
+
+	out.Reset()
+	if err := printFanOut(&out, results[:2]); err != nil || !strings.Contains(out.String(), "所有成功的集群输出一致") {
+		t.Errorf("err = %v, output:\n%s", err, out.String())
+	}
+}
+
+/*
+   list输出对象的json,按对象对比,resourceVersion与status不同不算差异
+*/
+func TestFanOutObjectDiff(t *testing.T) {
+	pvc := func(resourceVersion, phase, size string) string {
+		return fmt.Sprintf(`{"metadata":{"name":"test-pvc","namespace":"test-namespace","resourceVersion":%q},"spec":{"resources":{"requests":{"storage":%q}}},"status":{"phase":%q}}`,
+			resourceVersion, size, phase)
+	}
+	pv := `{"metadata":{"name":"test-pv","resourceVersion":"7"},"spec":{"storageClassName":"nfs"}}`
+	results := []clusterResult{
+		{Context: "dev", Output: `{"metadata":{},"items":[` + pvc("100", "Bound", "1Gi") + `]}` + "\n"},
+		{Context: "staging", Output: `{"metadata":{},"items":[` + pvc("205", "Pending", "1Gi") + `]}` + "\n"},
+		{Context: "prod", Output: `{"metadata":{},"items":[` + pvc("310", "Bound", "2Gi") + `,` + pv + `]}` + "\n"},
+	}
+	var out bytes.Buffer
+	if err := printFanOut(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "fanout_objects.golden", out.Bytes())
+}
+
+func TestRunFanOutUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"-contexts", "dev", "logs", "deployment/test-nginx"},
+		{"-all-contexts", "exec", "deployment/test-nginx", "ls"},
+		{"-parallel", "0", "-contexts", "dev", "list", "pvc"},
+	} {
+		if err := run(args, ioutil.Discard); errorKindOf(err) != ErrUsage {
+			t.Errorf("run(%v) error = %v, want Usage", args, err)
+		}
+	}
+}
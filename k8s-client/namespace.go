@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+/*
+   命名空间无法完成删除的诊断信息
+   UnavailableAPIs为发现失败的GroupVersion或不可用的APIService,命名空间控制器无法确认其中的对象已删除
+*/
+type namespaceDiagnosis struct {
+	Namespace       *core_v1.Namespace
+	Conditions      []core_v1.NamespaceCondition
+	UnavailableAPIs []unavailableAPI
+	Remaining       []remainingObject
+	ListErrors      []string
+}
+
+type unavailableAPI struct {
+	GroupVersion string
+	Reason       string
+}
+
+/*
+   命名空间中剩余的对象,Finalizers不为空时会阻止对象被删除
+*/
+type remainingObject struct {
+	Resource   schema.GroupVersionResource
+	Name       string
+	Deleting   bool
+	Finalizers []string
+}
+
+func (o remainingObject) String() string {
+	return o.Resource.GroupResource().String() + "/" + o.Name
+}
+
+/*
+   诊断命名空间: 状况、通过发现接口遍历所有可list的命名空间级资源类型查找剩余对象、不可用的聚合API
+*/
+func diagnoseNamespace(ctx context.Context, clientSet kubernetes.Interface, discoveryClient discovery.DiscoveryInterface, dynamicClient dynamic.Interface, name string) (*namespaceDiagnosis, error) {
+	namespace, err := clientSet.CoreV1().Namespaces().Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, wrapError("获取Namespace", err)
+	}
+	d := &namespaceDiagnosis{Namespace: namespace}
+	for _, condition := range namespace.Status.Conditions {
+		if condition.Status == core_v1.ConditionTrue {
+			d.Conditions = append(d.Conditions, condition)
+		}
+	}
+	if namespace.Status.Phase != core_v1.NamespaceTerminating {
+		return d, nil
+	}
+
+	resourceLists, err := discovery.ServerPreferredNamespacedResources(discoveryClient)
+	var failed *discovery.ErrGroupDiscoveryFailed
+	if errors.As(err, &failed) {
+		for gv, cause := range failed.Groups {
+			d.UnavailableAPIs = append(d.UnavailableAPIs, unavailableAPI{GroupVersion: gv.String(), Reason: cause.Error()})
+		}
+	} else if err != nil {
+		return nil, wrapError("获取资源类型", err)
+	}
+	if err := d.checkAPIServices(ctx, dynamicClient); err != nil {
+		return nil, err
+	}
+	sort.Slice(d.UnavailableAPIs, func(i, j int) bool { return d.UnavailableAPIs[i].GroupVersion < d.UnavailableAPIs[j].GroupVersion })
+
+	for _, list := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, resourceLists) {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			gvr := gv.WithResource(r.Name)
+			objects, err := dynamicClient.Resource(gvr).Namespace(name).List(ctx, meta_v1.ListOptions{})
+			if err != nil {
+				d.ListErrors = append(d.ListErrors, fmt.Sprintf("%s: %v", gvr.GroupResource(), err))
+				continue
+			}
+			for _, obj := range objects.Items {
+				d.Remaining = append(d.Remaining, remainingObject{
+					Resource:   gvr,
+					Name:       obj.GetName(),
+					Deleting:   obj.GetDeletionTimestamp() != nil,
+					Finalizers: obj.GetFinalizers(),
+				})
+			}
+		}
+	}
+	sort.Slice(d.Remaining, func(i, j int) bool { return d.Remaining[i].String() < d.Remaining[j].String() })
+	sort.Strings(d.ListErrors)
+	return d, nil
+}
+
+/*
+   检查APIService的Available状况,发现接口会跳过部分失败,这里补充不可用的APIService及原因
+*/
+func (d *namespaceDiagnosis) checkAPIServices(ctx context.Context, dynamicClient dynamic.Interface) error {
+	list, err := dynamicClient.Resource(apiServiceResource).List(ctx, meta_v1.ListOptions{})
+	if k8s_errors.IsNotFound(err) || k8s_errors.IsForbidden(err) {
+		return nil
+	}
+	if err != nil {
+		return wrapError("获取APIService", err)
+	}
+	reported := map[string]bool{}
+	for _, api := range d.UnavailableAPIs {
+		reported[api.GroupVersion] = true
+	}
+	for _, item := range list.Items {
+		available, reason := apiServiceAvailable(&item)
+		if available {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(item.Object, "spec", "group")
+		version, _, _ := unstructured.NestedString(item.Object, "spec", "version")
+		gv := schema.GroupVersion{Group: group, Version: version}.String()
+		reason = fmt.Sprintf("APIService %s 不可用: %s", item.GetName(), reason)
+		if reported[gv] {
+			for i := range d.UnavailableAPIs {
+				if d.UnavailableAPIs[i].GroupVersion == gv {
+					d.UnavailableAPIs[i].Reason = reason
+				}
+			}
+			continue
+		}
+		reported[gv] = true
+		d.UnavailableAPIs = append(d.UnavailableAPIs, unavailableAPI{GroupVersion: gv, Reason: reason})
+	}
+	return nil
+}
+
+func apiServiceAvailable(item *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Available" {
+			continue
+		}
+		if condition["status"] == "True" {
+			return true, ""
+		}
+		return false, fmt.Sprintf("%v %v", condition["reason"], condition["message"])
+	}
+	return false, "没有Available状况"
+}
+
+/*
+   带有finalizer的剩余对象
+*/
+func (d *namespaceDiagnosis) blocked() []remainingObject {
+	var objects []remainingObject
+	for _, obj := range d.Remaining {
+		if len(obj.Finalizers) > 0 {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+func printNamespaceDiagnosis(out io.Writer, d *namespaceDiagnosis) {
+	ns := d.Namespace
+	fmt.Fprintf(out, "命名空间 %s: %s\n", ns.Name, ns.Status.Phase)
+	if ns.DeletionTimestamp != nil {
+		fmt.Fprintf(out, "删除时间: %s\n", ns.DeletionTimestamp.UTC().Format(time.RFC3339))
+	}
+	var specFinalizers []string
+	for _, finalizer := range ns.Spec.Finalizers {
+		specFinalizers = append(specFinalizers, string(finalizer))
+	}
+	fmt.Fprintf(out, "spec.finalizers: %s\n", joinOrNone(specFinalizers))
+	fmt.Fprintf(out, "metadata.finalizers: %s\n", joinOrNone(ns.Finalizers))
+	if ns.Status.Phase != core_v1.NamespaceTerminating {
+		fmt.Fprintln(out, "命名空间未处于Terminating状态")
+		return
+	}
+
+	fmt.Fprintln(out, "\n状况:")
+	if len(d.Conditions) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	}
+	for _, c := range d.Conditions {
+		fmt.Fprintf(out, "  %s: %s (%s)\n", c.Type, c.Message, c.Reason)
+	}
+
+	fmt.Fprintln(out, "\n不可用的聚合API:")
+	if len(d.UnavailableAPIs) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	}
+	for _, api := range d.UnavailableAPIs {
+		fmt.Fprintf(out, "  %s: %s\n", api.GroupVersion, api.Reason)
+	}
+
+	fmt.Fprintln(out, "\n剩余对象:")
+	if len(d.Remaining) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	} else {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  RESOURCE\tNAME\tDELETING\tFINALIZERS")
+		for _, obj := range d.Remaining {
+			fmt.Fprintf(w, "  %s\t%s\t%t\t%s\n", obj.Resource.GroupResource(), obj.Name, obj.Deleting, joinOrNone(obj.Finalizers))
+		}
+		w.Flush()
+	}
+	for _, e := range d.ListErrors {
+		fmt.Fprintf(out, "  获取失败 %s\n", e)
+	}
+
+	fmt.Fprintln(out, "\n结论:")
+	switch blocked := d.blocked(); {
+	case len(blocked) > 0:
+		fmt.Fprintf(out, "  %d个对象的finalizer未被处理,通常是对应的控制器已被删除或不可用,确认后可使用 -remove-finalizers 移除\n", len(blocked))
+	case len(d.UnavailableAPIs) > 0:
+		fmt.Fprintln(out, "  命名空间控制器无法确认不可用API中的对象已删除,请修复或删除对应的APIService")
+	case len(d.Remaining) > 0:
+		fmt.Fprintln(out, "  剩余对象没有finalizer,命名空间控制器会继续删除")
+	case len(ns.Spec.Finalizers) > 0:
+		fmt.Fprintln(out, "  命名空间已没有剩余对象,仍被spec.finalizers阻止时可使用 -finalize-namespace 移除")
+	default:
+		fmt.Fprintln(out, "  没有发现阻止删除的原因")
+	}
+}
+
+/*
+   移除剩余对象的finalizer,对象会随后被ApiServer删除
+*/
+func removeFinalizers(ctx context.Context, dynamicClient dynamic.Interface, namespace string, objects []remainingObject, out io.Writer) error {
+	patch := []byte(`{"metadata":{"finalizers":null}}`)
+	for _, obj := range objects {
+		_, err := dynamicClient.Resource(obj.Resource).Namespace(namespace).Patch(ctx, obj.Name, types.MergePatchType, patch, meta_v1.PatchOptions{})
+		if k8s_errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return wrapError("移除"+obj.String()+"的finalizer", err)
+		}
+		fmt.Fprintf(out, "已移除 %s 的finalizer: %s\n", obj, strings.Join(obj.Finalizers, ","))
+	}
+	return nil
+}
+
+/*
+   通过finalize子资源清空命名空间的spec.finalizers
+*/
+func finalizeNamespace(ctx context.Context, clientSet kubernetes.Interface, namespace *core_v1.Namespace, out io.Writer) error {
+	ns := namespace.DeepCopy()
+	ns.Spec.Finalizers = nil
+	if _, err := clientSet.CoreV1().Namespaces().Finalize(ctx, ns, meta_v1.UpdateOptions{}); err != nil {
+		return wrapError("移除Namespace的finalizer", err)
+	}
+	fmt.Fprintf(out, "已清空命名空间 %s 的spec.finalizers\n", ns.Name)
+	return nil
+}
+
+/*
+   要求输入expected确认操作
+*/
+func confirm(in io.Reader, out io.Writer, prompt, expected string) bool {
+	fmt.Fprintf(out, "%s,输入 %s 确认: ", prompt, expected)
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	return strings.TrimSpace(line) == expected
+}
+
+/*
+   namespace diagnose [-namespace ns] [-remove-finalizers] [-finalize-namespace] [-yes]
+*/
+func runNamespace(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "diagnose" {
+		return usageError("用法: namespace diagnose [-namespace ns] [-remove-finalizers] [-finalize-namespace] [-yes]")
+	}
+	fs := newFlagSet("namespace diagnose", out)
+	name := fs.String("namespace", activeProfile.Namespace, "命名空间")
+	remove := fs.Bool("remove-finalizers", false, "移除剩余对象的finalizer")
+	finalize := fs.Bool("finalize-namespace", false, "清空命名空间的spec.finalizers,仅在确认剩余对象可以丢弃时使用")
+	yes := fs.Bool("yes", false, "跳过确认")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError("多余的参数: %s", strings.Join(fs.Args(), " "))
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := initDynamicClient(ctx)
+	if err != nil {
+		return err
+	}
+	d, err := diagnoseNamespace(ctx, clientSet, clientSet.Discovery(), dynamicClient, *name)
+	if err != nil {
+		return err
+	}
+	printNamespaceDiagnosis(out, d)
+	if !*remove && !*finalize {
+		return nil
+	}
+	if d.Namespace.Status.Phase != core_v1.NamespaceTerminating {
+		return &clientError{Kind: ErrConflict, Action: "修复Namespace", Err: fmt.Errorf("%s 未处于Terminating状态", *name)}
+	}
+	blocked := d.blocked()
+	if *remove && len(blocked) == 0 {
+		fmt.Fprintln(out, "没有带finalizer的剩余对象")
+	}
+	if (*remove && len(blocked) > 0) || *finalize {
+		var actions []string
+		if *remove && len(blocked) > 0 {
+			actions = append(actions, fmt.Sprintf("移除%d个对象的finalizer", len(blocked)))
+		}
+		if *finalize {
+			actions = append(actions, "清空命名空间的spec.finalizers(剩余对象不会被清理)")
+		}
+		if !*yes && !confirm(os.Stdin, out, "\n将"+strings.Join(actions, "并"), *name) {
+			return &clientError{Kind: ErrUsage, Action: "修复Namespace", Err: fmt.Errorf("未确认,已取消")}
+		}
+	}
+	if *remove {
+		if err := removeFinalizers(ctx, dynamicClient, *name, blocked, out); err != nil {
+			return err
+		}
+	}
+	if *finalize {
+		return finalizeNamespace(ctx, clientSet, d.Namespace, out)
+	}
+	return nil
+}
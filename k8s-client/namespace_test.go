@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+   发现接口中部分GroupVersion返回错误,模拟不可用的聚合API
+*/
+type failingDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	failed map[string]error
+}
+
+func (d *failingDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*meta_v1.APIResourceList, error) {
+	if err, ok := d.failed[groupVersion]; ok {
+		return nil, err
+	}
+	return d.FakeDiscovery.ServerResourcesForGroupVersion(groupVersion)
+}
+
+func newUnstructured(apiVersion, kind, namespace, name string, finalizers ...string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	if len(finalizers) > 0 {
+		obj.SetFinalizers(finalizers)
+		deleted := meta_v1.NewTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+		obj.SetDeletionTimestamp(&deleted)
+	}
+	return obj
+}
+
+func newAPIService(name, group, version, status, reason string) *unstructured.Unstructured {
+	obj := newUnstructured("apiregistration.k8s.io/v1", "APIService", "", name)
+	obj.Object["spec"] = map[string]interface{}{"group": group, "version": version}
+	obj.Object["status"] = map[string]interface{}{"conditions": []interface{}{
+		map[string]interface{}{"type": "Available", "status": status, "reason": reason, "message": "failing or missing response"},
+	}}
+	return obj
+}
+
+/*
+   test-namespace处于Terminating: Deployment与CRD对象widget带有finalizer,
+   metrics.k8s.io由不可用的APIService提供,发现失败
+*/
+func newTerminatingNamespace() (*fake.Clientset, *failingDiscovery, *fakedynamic.FakeDynamicClient) {
+	deleted := meta_v1.NewTime(time.Date(2022, 1, 2, 3, 0, 0, 0, time.UTC))
+	clientSet := fake.NewSimpleClientset(&core_v1.Namespace{
+		ObjectMeta: meta_v1.ObjectMeta{Name: TestNamespace, DeletionTimestamp: &deleted},
+		Spec:       core_v1.NamespaceSpec{Finalizers: []core_v1.FinalizerName{core_v1.FinalizerKubernetes}},
+		Status: core_v1.NamespaceStatus{
+			Phase: core_v1.NamespaceTerminating,
+			Conditions: []core_v1.NamespaceCondition{
+				{Type: core_v1.NamespaceDeletionDiscoveryFailure, Status: core_v1.ConditionTrue, Reason: "DiscoveryFailed", Message: "Discovery failed for some groups, 1 failing: unable to retrieve the complete list of server APIs: metrics.k8s.io/v1beta1: the server is currently unable to handle the request"},
+				{Type: core_v1.NamespaceDeletionGVParsingFailure, Status: core_v1.ConditionFalse, Reason: "ParsedGroupVersions", Message: "All legacy kube types successfully parsed"},
+				{Type: core_v1.NamespaceContentRemaining, Status: core_v1.ConditionTrue, Reason: "SomeResourcesRemain", Message: "Some resources are remaining: deployments.apps has 1 resource instances, widgets.example.com has 1 resource instances"},
+				{Type: core_v1.NamespaceFinalizersRemaining, Status: core_v1.ConditionTrue, Reason: "SomeFinalizersRemain", Message: "Some content in the namespace has finalizers remaining: example.com/cleanup in 1 resource instances"},
+			},
+		},
+	})
+	namespaced := func(name string) meta_v1.APIResource {
+		return meta_v1.APIResource{Name: name, Namespaced: true, Verbs: meta_v1.Verbs{"list", "delete", "get"}}
+	}
+	fakeDiscovery := clientSet.Discovery().(*fakediscovery.FakeDiscovery)
+	fakeDiscovery.Resources = []*meta_v1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []meta_v1.APIResource{
+			namespaced("configmaps"), namespaced("pods"),
+			{Name: "pods/log", Namespaced: true, Verbs: meta_v1.Verbs{"get"}},
+			{Name: "namespaces", Verbs: meta_v1.Verbs{"list", "delete"}},
+			{Name: "bindings", Namespaced: true, Verbs: meta_v1.Verbs{"create"}},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []meta_v1.APIResource{namespaced("deployments")}},
+		{GroupVersion: "example.com/v1", APIResources: []meta_v1.APIResource{namespaced("widgets")}},
+		{GroupVersion: "metrics.k8s.io/v1beta1", APIResources: []meta_v1.APIResource{namespaced("pods")}},
+	}
+	discoveryClient := &failingDiscovery{fakeDiscovery, map[string]error{
+		"metrics.k8s.io/v1beta1": k8s_errors.NewServiceUnavailable("the server is currently unable to handle the request"),
+	}}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}:                    "ConfigMapList",
+		{Version: "v1", Resource: "pods"}:                          "PodList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}:    "DeploymentList",
+		{Group: "example.com", Version: "v1", Resource: "widgets"}: "WidgetList",
+		apiServiceResource: "APIServiceList",
+	}
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		newUnstructured("v1", "ConfigMap", TestNamespace, "kube-root-ca.crt"),
+		newUnstructured("apps/v1", "Deployment", TestNamespace, "test-nginx", "foregroundDeletion"),
+		newUnstructured("example.com/v1", "Widget", TestNamespace, "w1", "example.com/cleanup"),
+		newUnstructured("example.com/v1", "Widget", "other", "w2", "example.com/cleanup"),
+		newAPIService("v1.apps", "apps", "v1", "True", "Local"),
+		newAPIService("v1beta1.metrics.k8s.io", "metrics.k8s.io", "v1beta1", "False", "FailedDiscoveryCheck"),
+		newAPIService("v1.example.com", "example.com", "v1", "False", "ServiceNotFound"),
+	)
+	return clientSet, discoveryClient, dynamicClient
+}
+
+func TestDiagnoseNamespace(t *testing.T) {
+	clientSet, discoveryClient, dynamicClient := newTerminatingNamespace()
+	d, err := diagnoseNamespace(context.TODO(), clientSet, discoveryClient, dynamicClient, TestNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printNamespaceDiagnosis(&out, d)
+	assertGolden(t, "namespace_diagnose.golden", out.Bytes())
+
+	var removed bytes.Buffer
+	if err := removeFinalizers(context.TODO(), dynamicClient, TestNamespace, d.blocked(), &removed); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(removed.String(), "已移除"); got != 2 {
+		t.Errorf("removed %d objects:\n%s", got, removed.String())
+	}
+	widgets := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	for _, ns := range []string{TestNamespace, "other"} {
+		list, err := dynamicClient.Resource(widgets).Namespace(ns).List(context.TODO(), meta_v1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if ns == "other" {
+			want = 1
+		}
+		if got := len(list.Items[0].GetFinalizers()); got != want {
+			t.Errorf("%s/%s finalizers = %v", ns, list.Items[0].GetName(), list.Items[0].GetFinalizers())
+		}
+	}
+
+	if err := finalizeNamespace(context.TODO(), clientSet, d.Namespace, &removed); err != nil {
+		t.Fatal(err)
+	}
+	ns, err := clientSet.CoreV1().Namespaces().Get(context.TODO(), TestNamespace, meta_v1.GetOptions{})
+	if err != nil || len(ns.Spec.Finalizers) != 0 {
+		t.Errorf("spec.finalizers = %v, err = %v", ns.Spec.Finalizers, err)
+	}
+}
+
+func TestDiagnoseActiveNamespace(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&core_v1.Namespace{
+		ObjectMeta: meta_v1.ObjectMeta{Name: TestNamespace},
+		Status:     core_v1.NamespaceStatus{Phase: core_v1.NamespaceActive},
+	})
+	d, err := diagnoseNamespace(context.TODO(), clientSet, clientSet.Discovery(), fakedynamic.NewSimpleDynamicClient(runtime.NewScheme()), TestNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printNamespaceDiagnosis(&out, d)
+	if !strings.Contains(out.String(), "命名空间未处于Terminating状态") {
+		t.Errorf("output:\n%s", out.String())
+	}
+	if _, err := diagnoseNamespace(context.TODO(), clientSet, clientSet.Discovery(), nil, "missing"); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing namespace error = %v", err)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  bool
+	}{
+		{TestNamespace + "\n", true},
+		{" " + TestNamespace + " \n", true},
+		{"y\n", false},
+		{"", false},
+	} {
+		var out bytes.Buffer
+		if got := confirm(strings.NewReader(tc.input), &out, "将移除1个对象的finalizer", TestNamespace); got != tc.want {
+			t.Errorf("confirm(%q) = %t, want %t", tc.input, got, tc.want)
+		}
+		if want := fmt.Sprintf("将移除1个对象的finalizer,输入 %s 确认: ", TestNamespace); out.String() != want {
+			t.Errorf("prompt = %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+//驱逐被PodDisruptionBudget拒绝后的重试间隔,以及等待Pod删除、替代Pod调度的轮询间隔
+var drainPollInterval = 2 * time.Second
+
+/*
+   设置节点是否可调度,状态未变化时只输出提示
+*/
+func setUnschedulable(ctx context.Context, clientSet kubernetes.Interface, name string, unschedulable bool, out io.Writer) error {
+	action := "恢复节点调度"
+	if unschedulable {
+		action = "禁止节点调度"
+	}
+	client := clientSet.CoreV1().Nodes()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Spec.Unschedulable == unschedulable {
+			fmt.Fprintf(out, "节点 %s 已经是%s状态\n", name, schedulableString(unschedulable))
+			return nil
+		}
+		node.Spec.Unschedulable = unschedulable
+		if _, err := client.Update(ctx, node, meta_v1.UpdateOptions{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "节点 %s 已设置为%s\n", name, schedulableString(unschedulable))
+		return nil
+	})
+	return wrapError(action, err)
+}
+
+func schedulableString(unschedulable bool) string {
+	if unschedulable {
+		return "不可调度"
+	}
+	return "可调度"
+}
+
+/*
+   驱逐参数
+   DeleteEmptyDirData为false时,使用emptyDir的Pod会阻止驱逐,避免数据被意外删除
+   Force为false时,不受控制器管理的Pod会阻止驱逐,这类Pod被驱逐后不会重建
+   GracePeriod小于0时使用Pod自身的terminationGracePeriodSeconds
+   ReplacementTimeout为驱逐完成后等待替代Pod调度的时间,与驱逐的超时分开计算,为0时使用defaultReplacementTimeout
+*/
+type drainOptions struct {
+	DeleteEmptyDirData bool
+	Force              bool
+	GracePeriod        int64
+	ReplacementTimeout time.Duration
+}
+
+const defaultReplacementTimeout = time.Minute
+
+/*
+   跳过或阻止驱逐的Pod
+*/
+type skippedPod struct {
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+/*
+   被驱逐的Pod以及控制器为它创建的替代Pod,Replacement为空表示还未创建
+*/
+type evictedPod struct {
+	Namespace   string
+	Name        string
+	UID         types.UID
+	Owner       string
+	ownerUID    types.UID
+	Err         error
+	Replacement string
+	NewNode     string
+}
+
+type drainReport struct {
+	Node    string
+	Skipped []skippedPod
+	Evicted []*evictedPod
+}
+
+/*
+   按驱逐规则划分节点上的Pod
+   mirror Pod由kubelet根据静态清单创建,DaemonSet的Pod会被重新调度回本节点,二者都跳过
+   已结束的Pod不再占用资源,不受emptyDir与控制器的限制
+*/
+func classifyDrainPods(pods []core_v1.Pod, opts drainOptions) (evict []core_v1.Pod, skipped, blocked []skippedPod) {
+	for _, pod := range pods {
+		controller := meta_v1.GetControllerOf(&pod)
+		switch {
+		case pod.Annotations[core_v1.MirrorPodAnnotationKey] != "":
+			skipped = append(skipped, skippedPod{pod.Namespace, pod.Name, "mirror Pod"})
+			continue
+		case controller != nil && controller.Kind == "DaemonSet":
+			skipped = append(skipped, skippedPod{pod.Namespace, pod.Name, "DaemonSet " + controller.Name + " 管理"})
+			continue
+		}
+		finished := pod.Status.Phase == core_v1.PodSucceeded || pod.Status.Phase == core_v1.PodFailed
+		var reasons []string
+		if !finished && controller == nil && !opts.Force {
+			reasons = append(reasons, "不受控制器管理,驱逐后不会重建,需要-force")
+		}
+		if !finished && !opts.DeleteEmptyDirData {
+			if volumes := emptyDirVolumes(&pod); len(volumes) > 0 {
+				reasons = append(reasons, fmt.Sprintf("使用emptyDir %s,驱逐后数据会丢失,需要-delete-emptydir-data", strings.Join(volumes, ",")))
+			}
+		}
+		if len(reasons) > 0 {
+			blocked = append(blocked, skippedPod{pod.Namespace, pod.Name, strings.Join(reasons, "; ")})
+			continue
+		}
+		evict = append(evict, pod)
+	}
+	return evict, skipped, blocked
+}
+
+func emptyDirVolumes(pod *core_v1.Pod) []string {
+	var names []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			names = append(names, volume.Name)
+		}
+	}
+	return names
+}
+
+type drainer struct {
+	clientSet kubernetes.Interface
+	node      string
+	opts      drainOptions
+	mu        sync.Mutex
+	out       io.Writer
+}
+
+func (d *drainer) printf(format string, a ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.out, format, a...)
+}
+
+/*
+   禁止节点调度后通过eviction API驱逐节点上的Pod,被PodDisruptionBudget拒绝时按间隔重试直到ctx结束
+   驱逐完成后查找控制器创建的替代Pod以及它们被调度到的节点
+*/
+func drainNode(ctx context.Context, clientSet kubernetes.Interface, name string, opts drainOptions, out io.Writer) (*drainReport, error) {
+	if err := setUnschedulable(ctx, clientSet, name, true, out); err != nil {
+		return nil, err
+	}
+	list, err := clientSet.CoreV1().Pods(meta_v1.NamespaceAll).List(ctx, meta_v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return nil, wrapError("获取节点上的Pod", err)
+	}
+	var pods []core_v1.Pod
+	for _, pod := range list.Items {
+		if pod.Spec.NodeName == name {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	evict, skipped, blocked := classifyDrainPods(pods, opts)
+	if len(blocked) > 0 {
+		var sb strings.Builder
+		for _, pod := range blocked {
+			fmt.Fprintf(&sb, "\n  - %s/%s: %s", pod.Namespace, pod.Name, pod.Reason)
+		}
+		return nil, &clientError{Kind: ErrUnknown, Action: "驱逐节点" + name, Err: fmt.Errorf("以下Pod无法驱逐:%s", sb.String())}
+	}
+
+	d := &drainer{clientSet: clientSet, node: name, opts: opts, out: out}
+	report := &drainReport{Node: name, Skipped: skipped}
+	existing, err := d.existingPods(ctx, evict)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range skipped {
+		d.printf("跳过 %s/%s: %s\n", pod.Namespace, pod.Name, pod.Reason)
+	}
+	var wg sync.WaitGroup
+	for i := range evict {
+		pod := &evict[i]
+		e := &evictedPod{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+		if controller := meta_v1.GetControllerOf(pod); controller != nil {
+			e.Owner = controller.Kind + "/" + controller.Name
+			e.ownerUID = controller.UID
+		}
+		report.Evicted = append(report.Evicted, e)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Err = d.evict(ctx, pod)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, e := range report.Evicted {
+		if e.Err != nil {
+			failed++
+		}
+	}
+	if err := d.findReplacements(ctx, report, existing); err != nil {
+		return report, err
+	}
+	if failed > 0 {
+		return report, &clientError{Kind: errorKindOf(report.firstError()), Action: "驱逐节点" + name, Err: fmt.Errorf("%d个Pod驱逐失败", failed)}
+	}
+	if pending := report.pendingReplacements(); len(pending) > 0 {
+		return report, &clientError{Kind: ErrTimeout, Action: "驱逐节点" + name, Err: fmt.Errorf("%d个Pod的替代Pod未调度: %s", len(pending), strings.Join(pending, ", "))}
+	}
+	return report, nil
+}
+
+/*
+   驱逐成功、由控制器管理但替代Pod还未创建或未调度的Pod
+*/
+func (report *drainReport) pendingReplacements() []string {
+	var pending []string
+	for _, e := range report.Evicted {
+		if e.Err == nil && e.Owner != "" && e.NewNode == "" {
+			pending = append(pending, e.Namespace+"/"+e.Name)
+		}
+	}
+	return pending
+}
+
+func (report *drainReport) firstError() error {
+	for _, e := range report.Evicted {
+		if e.Err != nil {
+			return e.Err
+		}
+	}
+	return nil
+}
+
+/*
+   驱逐单个Pod并等待其被删除
+   eviction API返回429表示驱逐会违反PodDisruptionBudget,此时等待后重试
+*/
+func (d *drainer) evict(ctx context.Context, pod *core_v1.Pod) error {
+	eviction := &policy_v1.Eviction{ObjectMeta: meta_v1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	if d.opts.GracePeriod >= 0 {
+		eviction.DeleteOptions = &meta_v1.DeleteOptions{GracePeriodSeconds: &d.opts.GracePeriod}
+	}
+	client := d.clientSet.PolicyV1().Evictions(pod.Namespace)
+	for {
+		err := client.Evict(ctx, eviction)
+		if err == nil || k8s_errors.IsNotFound(err) {
+			break
+		}
+		if !k8s_errors.IsTooManyRequests(err) {
+			return wrapError("驱逐Pod"+pod.Namespace+"/"+pod.Name, err)
+		}
+		d.printf("Pod %s/%s 受PodDisruptionBudget限制,%s后重试: %v\n", pod.Namespace, pod.Name, drainPollInterval, err)
+		select {
+		case <-ctx.Done():
+			return wrapError("驱逐Pod"+pod.Namespace+"/"+pod.Name, fmt.Errorf("%v: %w", err, ctx.Err()))
+		case <-time.After(drainPollInterval):
+		}
+	}
+	d.printf("已驱逐 %s/%s\n", pod.Namespace, pod.Name)
+	return d.waitDeleted(ctx, pod)
+}
+
+/*
+   等待Pod被删除,同名的新Pod(如StatefulSet重建的Pod)UID不同,也视为已删除
+*/
+func (d *drainer) waitDeleted(ctx context.Context, pod *core_v1.Pod) error {
+	client := d.clientSet.CoreV1().Pods(pod.Namespace)
+	for {
+		latest, err := client.Get(ctx, pod.Name, meta_v1.GetOptions{})
+		if k8s_errors.IsNotFound(err) || (err == nil && latest.UID != pod.UID) {
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return wrapError("获取Pod"+pod.Namespace+"/"+pod.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return wrapError("等待Pod"+pod.Namespace+"/"+pod.Name+"删除", ctx.Err())
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+/*
+   驱逐前被驱逐Pod所在命名空间中已存在的Pod,它们不会被当作替代Pod
+*/
+func (d *drainer) existingPods(ctx context.Context, pods []core_v1.Pod) (map[types.UID]bool, error) {
+	existing := map[types.UID]bool{}
+	listed := map[string]bool{}
+	for _, pod := range pods {
+		if listed[pod.Namespace] {
+			continue
+		}
+		listed[pod.Namespace] = true
+		list, err := d.clientSet.CoreV1().Pods(pod.Namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取Pod列表", err)
+		}
+		for _, item := range list.Items {
+			existing[item.UID] = true
+		}
+	}
+	return existing, nil
+}
+
+/*
+   查找被驱逐Pod的替代Pod: 属于同一个控制器、驱逐前不存在的Pod,按创建时间依次分配
+   轮询直到所有替代Pod都已调度或超过ReplacementTimeout,超时时保留已找到的结果
+   驱逐可能已用完ctx的时间,这里使用单独的超时,只在ctx被取消(而不是超时)时提前结束
+*/
+func (d *drainer) findReplacements(ctx context.Context, report *drainReport, existing map[types.UID]bool) error {
+	timeout := d.opts.ReplacementTimeout
+	if timeout <= 0 {
+		timeout = defaultReplacementTimeout
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-parent.Done():
+			if parent.Err() == context.Canceled {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		done, err := d.matchReplacements(ctx, report, existing)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+func (d *drainer) matchReplacements(ctx context.Context, report *drainReport, existing map[types.UID]bool) (bool, error) {
+	lists := map[string][]core_v1.Pod{}
+	done := true
+	claimed := map[types.UID]bool{}
+	for _, e := range report.Evicted {
+		if e.Err != nil || e.ownerUID == "" {
+			continue
+		}
+		pods, ok := lists[e.Namespace]
+		if !ok {
+			list, err := d.clientSet.CoreV1().Pods(e.Namespace).List(ctx, meta_v1.ListOptions{})
+			if err != nil {
+				return false, wrapError("获取Pod列表", err)
+			}
+			pods = list.Items
+			sort.Slice(pods, func(i, j int) bool {
+				if !pods[i].CreationTimestamp.Equal(&pods[j].CreationTimestamp) {
+					return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+				}
+				return pods[i].Name < pods[j].Name
+			})
+			lists[e.Namespace] = pods
+		}
+		e.Replacement, e.NewNode = "", ""
+		for i := range pods {
+			pod := &pods[i]
+			controller := meta_v1.GetControllerOf(pod)
+			if existing[pod.UID] || claimed[pod.UID] || controller == nil || controller.UID != e.ownerUID {
+				continue
+			}
+			//StatefulSet的Pod以相同的名称重建
+			if strings.HasPrefix(e.Owner, "StatefulSet/") && pod.Name != e.Name {
+				continue
+			}
+			claimed[pod.UID] = true
+			e.Replacement, e.NewNode = pod.Name, pod.Spec.NodeName
+			break
+		}
+		if e.NewNode == "" {
+			done = false
+		}
+	}
+	return done, nil
+}
+
+func printDrainReport(out io.Writer, report *drainReport) {
+	fmt.Fprintf(out, "\n节点 %s 驱逐结果:\n", report.Node)
+	if len(report.Evicted) == 0 {
+		fmt.Fprintln(out, "没有需要驱逐的Pod")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tOWNER\tSTATUS\tREPLACEMENT\tNODE")
+	for _, e := range report.Evicted {
+		status := "Evicted"
+		if e.Err != nil {
+			status = "Failed"
+		}
+		owner, replacement, node := e.Owner, e.Replacement, e.NewNode
+		switch {
+		case owner == "":
+			owner, replacement, node = "<none>", "<none>", "<none>"
+		case e.Err != nil:
+			replacement, node = "-", "-"
+		case replacement == "":
+			replacement, node = "<pending>", "<pending>"
+		case node == "":
+			node = "<pending>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Namespace, e.Name, owner, status, replacement, node)
+	}
+	w.Flush()
+	for _, e := range report.Evicted {
+		if e.Err != nil {
+			fmt.Fprintf(out, "%s/%s: %v\n", e.Namespace, e.Name, e.Err)
+		}
+	}
+}
+
+/*
+   node cordon|uncordon|drain <节点>
+*/
+func runNode(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("用法: node cordon|uncordon|drain <节点>")
+	}
+	action := args[0]
+	fs := newFlagSet("node "+action, out)
+	var opts drainOptions
+	var timeout *time.Duration
+	switch action {
+	case "cordon", "uncordon":
+	case "drain":
+		fs.BoolVar(&opts.DeleteEmptyDirData, "delete-emptydir-data", false, "驱逐使用emptyDir的Pod,emptyDir中的数据会丢失")
+		fs.BoolVar(&opts.Force, "force", false, "驱逐不受控制器管理的Pod,这些Pod不会被重建")
+		fs.Int64Var(&opts.GracePeriod, "grace-period", -1, "Pod的优雅终止时间(秒),小于0时使用Pod自身的设置")
+		timeout = fs.Duration("timeout", 5*time.Minute, "驱逐超时时间,包括等待PodDisruptionBudget允许驱逐")
+		fs.DurationVar(&opts.ReplacementTimeout, "replacement-timeout", defaultReplacementTimeout, "驱逐完成后等待替代Pod调度的时间")
+	default:
+		return usageError("未知的node操作: %s", action)
+	}
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("需要且只能指定一个节点")
+	}
+	name := fs.Arg(0)
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	if action != "drain" {
+		return setUnschedulable(ctx, clientSet, name, action == "cordon", out)
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	report, err := drainNode(ctx, clientSet, name, opts, out)
+	if report != nil {
+		printDrainReport(out, report)
+	}
+	return err
+}
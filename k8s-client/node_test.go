@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDrainPod(name, node, ownerKind, ownerName string) *core_v1.Pod {
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace, UID: types.UID("uid-" + name + "-" + node)},
+		Spec:       core_v1.PodSpec{NodeName: node},
+		Status:     core_v1.PodStatus{Phase: core_v1.PodRunning},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = ownedBy(ownerKind, ownerName, types.UID("uid-"+ownerName))
+	}
+	return pod
+}
+
+/*
+   node1 上有受PDB保护的Deployment Pod、StatefulSet Pod、DaemonSet Pod、mirror Pod以及已结束的独立Pod
+*/
+func newDrainObjects() []runtime.Object {
+	mirror := newDrainPod("kube-proxy-node1", "node1", "", "")
+	mirror.Annotations = map[string]string{core_v1.MirrorPodAnnotationKey: "hash"}
+	finished := newDrainPod("test-debug", "node1", "", "")
+	finished.Status.Phase = core_v1.PodSucceeded
+	return []runtime.Object{
+		&core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node1"}},
+		&core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node2"}},
+		newDrainPod("test-nginx-1-a", "node1", "ReplicaSet", "test-nginx-1"),
+		newDrainPod("test-nginx-1-b", "node2", "ReplicaSet", "test-nginx-1"),
+		newDrainPod("web-0", "node1", "StatefulSet", "web"),
+		newDrainPod("calico-node-a", "node1", "DaemonSet", "calico-node"),
+		mirror,
+		finished,
+	}
+}
+
+/*
+   模拟ApiServer的eviction: 受PDB保护的Pod第一次驱逐返回429,
+   驱逐成功后删除Pod,并模拟控制器创建替代Pod,替代Pod被调度到node,node为空表示未调度
+*/
+func addEvictionReactor(clientSet *fake.Clientset, protected, node string) *[]string {
+	var evictions []string
+	rejected := false
+	clientSet.PrependReactor("create", "pods", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8s_testing.CreateAction).GetObject().(*policy_v1.Eviction)
+		evictions = append(evictions, eviction.Name)
+		if eviction.Name == protected && !rejected {
+			rejected = true
+			return true, nil, k8s_errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		tracker := clientSet.Tracker()
+		gvr := core_v1.SchemeGroupVersion.WithResource("pods")
+		obj, err := tracker.Get(gvr, eviction.Namespace, eviction.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*core_v1.Pod)
+		if err := tracker.Delete(gvr, eviction.Namespace, eviction.Name); err != nil {
+			return true, nil, err
+		}
+		controller := meta_v1.GetControllerOf(pod)
+		if controller == nil {
+			return true, nil, nil
+		}
+		name := pod.Name
+		if controller.Kind == "ReplicaSet" {
+			name = controller.Name + "-c"
+		}
+		replacement := newDrainPod(name, node, controller.Kind, controller.Name)
+		replacement.CreationTimestamp = meta_v1.NewTime(time.Now())
+		return true, nil, tracker.Add(replacement)
+	})
+	return &evictions
+}
+
+func TestDrainNode(t *testing.T) {
+	interval := drainPollInterval
+	drainPollInterval = time.Millisecond
+	defer func() { drainPollInterval = interval }()
+
+	clientSet := fake.NewSimpleClientset(newDrainObjects()...)
+	evictions := addEvictionReactor(clientSet, "test-nginx-1-a", "node2")
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	report, err := drainNode(ctx, clientSet, "node1", drainOptions{GracePeriod: -1}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := clientSet.CoreV1().Nodes().Get(context.TODO(), "node1", meta_v1.GetOptions{})
+	if err != nil || !node.Spec.Unschedulable {
+		t.Errorf("node1 未被设置为不可调度: %v", err)
+	}
+	for _, want := range []string{"受PodDisruptionBudget限制", "跳过 test-namespace/calico-node-a: DaemonSet calico-node 管理", "跳过 test-namespace/kube-proxy-node1: mirror Pod"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("输出缺少 %q:\n%s", want, out.String())
+		}
+	}
+	for _, name := range *evictions {
+		if name == "calico-node-a" || name == "kube-proxy-node1" {
+			t.Errorf("不应驱逐 %s", name)
+		}
+	}
+	var result bytes.Buffer
+	printDrainReport(&result, report)
+	assertGolden(t, "node_drain.golden", result.Bytes())
+}
+
+func TestDrainNodeBlocked(t *testing.T) {
+	cache := newDrainPod("test-cache", "node1", "ReplicaSet", "test-cache-1")
+	cache.Spec.Volumes = []core_v1.Volume{{Name: "tmp", VolumeSource: core_v1.VolumeSource{EmptyDir: &core_v1.EmptyDirVolumeSource{}}}}
+	objects := append(newDrainObjects(), cache, newDrainPod("test-bare", "node1", "", ""))
+
+	clientSet := fake.NewSimpleClientset(objects...)
+	evictions := addEvictionReactor(clientSet, "", "node2")
+	_, err := drainNode(context.TODO(), clientSet, "node1", drainOptions{GracePeriod: -1}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "test-namespace/test-bare: 不受控制器管理") || !strings.Contains(err.Error(), "test-namespace/test-cache: 使用emptyDir tmp") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(*evictions) != 0 {
+		t.Errorf("存在无法驱逐的Pod时不应驱逐任何Pod, evictions = %v", *evictions)
+	}
+
+	evict, _, blocked := classifyDrainPods([]core_v1.Pod{*cache, *newDrainPod("test-bare", "node1", "", "")}, drainOptions{DeleteEmptyDirData: true, Force: true})
+	if len(evict) != 2 || len(blocked) != 0 {
+		t.Errorf("evict = %d, blocked = %v", len(evict), blocked)
+	}
+}
+
+func TestCordon(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node1"}})
+	var out bytes.Buffer
+	for _, unschedulable := range []bool{true, true, false} {
+		if err := setUnschedulable(context.TODO(), clientSet, "node1", unschedulable, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "节点 node1 已设置为不可调度\n节点 node1 已经是不可调度状态\n节点 node1 已设置为可调度\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if err := setUnschedulable(context.TODO(), clientSet, "missing", true, &out); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing node error = %v", err)
+	}
+}
+
+func TestDrainNodeUnscheduledReplacement(t *testing.T) {
+	interval := drainPollInterval
+	drainPollInterval = time.Millisecond
+	defer func() { drainPollInterval = interval }()
+
+	clientSet := fake.NewSimpleClientset(newDrainObjects()...)
+	addEvictionReactor(clientSet, "", "")
+	//等待替代Pod受ReplacementTimeout限制,不会等到驱逐的超时
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	report, err := drainNode(ctx, clientSet, "node1", drainOptions{GracePeriod: -1, ReplacementTimeout: 20 * time.Millisecond}, &bytes.Buffer{})
+	if errorKindOf(err) != ErrTimeout || !strings.Contains(err.Error(), "2个Pod的替代Pod未调度: test-namespace/test-nginx-1-a, test-namespace/web-0") {
+		t.Fatalf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("等待替代Pod用了%s,应受ReplacementTimeout限制", elapsed)
+	}
+	var result bytes.Buffer
+	printDrainReport(&result, report)
+	if !strings.Contains(result.String(), "web-0           StatefulSet/web          Evicted  web-0           <pending>") {
+		t.Errorf("report:\n%s", result.String())
+	}
+}
+
+func TestDrainNodeTimeout(t *testing.T) {
+	interval := drainPollInterval
+	drainPollInterval = time.Millisecond
+	defer func() { drainPollInterval = interval }()
+
+	clientSet := fake.NewSimpleClientset(newDrainObjects()...)
+	addEvictionReactor(clientSet, "", "node2")
+	clientSet.PrependReactor("create", "pods", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "eviction" && action.(k8s_testing.CreateAction).GetObject().(*policy_v1.Eviction).Name == "web-0" {
+			return true, nil, k8s_errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return false, nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err := drainNode(ctx, clientSet, "node1", drainOptions{GracePeriod: -1}, &bytes.Buffer{})
+	if errorKindOf(err) != ErrTimeout {
+		t.Fatalf("err = %v, want Timeout", err)
+	}
+	var result bytes.Buffer
+	printDrainReport(&result, report)
+	if !strings.Contains(result.String(), "web-0           StatefulSet/web          Failed   -") {
+		t.Errorf("report:\n%s", result.String())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+/*
+   serve提供的REST API的OpenAPI 3.0描述,路径由apiKinds生成
+   对象的schema只描述通用字段,完整定义见ApiServer的/openapi/v2
+*/
+func openAPISpec() map[string]interface{} {
+	paths := map[string]interface{}{
+		"/healthz": map[string]interface{}{
+			"get": operation("healthz", "健康检查", nil, false, response(http.StatusOK, "ok", nil)),
+		},
+		"/v1/kinds": map[string]interface{}{
+			"get": operation("listKinds", "支持的资源类型", nil, true, response(http.StatusOK, "资源类型列表", schemaRef("KindList"))),
+		},
+		"/v1/dashboard": map[string]interface{}{
+			"get": operation("getDashboard", "页面数据: 命名空间、Deployment发布状态、Service端点、PVC/PV绑定与最近事件,serve需要-ui参数",
+				[]interface{}{queryParam("namespace", "只返回该命名空间中的对象"), queryParam("watch", "为true时以Server-Sent Events推送snapshot事件,对象变化时推送新的快照")},
+				true, response(http.StatusOK, "快照", map[string]interface{}{"type": "object"})),
+		},
+		"/v1/apply": map[string]interface{}{
+			"post": withBody(operation("apply", "以server-side apply创建或更新清单中的对象,返回每个对象的差异",
+				[]interface{}{queryParam("dryRun", "为true时只计算结果与差异,不保存"), queryParam("namespace", "未指定命名空间的对象使用的命名空间,默认为profile中的命名空间"),
+					queryParam("force", "为true时接管属于其他manager的字段,默认false,冲突时返回409")},
+				true, response(http.StatusOK, "每个对象的apply结果", schemaRef("ApplyResponse"))), "application/yaml", "以---分隔的多个yaml文档", schemaRef("Object")),
+		},
+	}
+	for _, name := range apiKindNames() {
+		k := apiKinds[name]
+		collection, item := "/v1/"+name, "/v1/"+name+"/{name}"
+		var scope []interface{}
+		if k.Namespaced {
+			paths["/v1/"+name] = map[string]interface{}{
+				"get": operation("list"+k.Kind+"ForAllNamespaces", "列出或监听所有命名空间中的"+k.Kind, listParams(), true, listResponse(k)),
+			}
+			collection, item = "/v1/namespaces/{namespace}/"+name, "/v1/namespaces/{namespace}/"+name+"/{name}"
+			scope = []interface{}{pathParam("namespace")}
+		}
+		dryRun := queryParam("dryRun", "为true时ApiServer只校验请求,不保存")
+		paths[collection] = map[string]interface{}{
+			"get":  operation("list"+k.Kind, "列出或监听"+k.Kind, append(scope, listParams()...), true, listResponse(k)),
+			"post": withBody(operation("create"+k.Kind, "创建"+k.Kind, append(scope, dryRun), true, response(http.StatusCreated, "创建的"+k.Kind, schemaRef("Object"))), "application/json", k.apiVersion()+" "+k.Kind, schemaRef("Object")),
+		}
+		itemParams := append(scope, pathParam("name"))
+		paths[item] = map[string]interface{}{
+			"get":    operation("read"+k.Kind, "获取"+k.Kind, itemParams, true, response(http.StatusOK, k.Kind, schemaRef("Object"))),
+			"put":    withBody(operation("replace"+k.Kind, "更新"+k.Kind, append(itemParams, dryRun), true, response(http.StatusOK, "更新后的"+k.Kind, schemaRef("Object"))), "application/json", k.apiVersion()+" "+k.Kind, schemaRef("Object")),
+			"delete": operation("delete"+k.Kind, "删除"+k.Kind, append(itemParams, dryRun, queryParam("propagationPolicy", "Background(默认)、Foreground或Orphan")), true, response(http.StatusNoContent, "已删除", nil)),
+		}
+		for _, action := range []string{"restart", "scale"} {
+			if !workloadActions[action][name] {
+				continue
+			}
+			op := operation(action+k.Kind, action+" "+k.Kind, itemParams, true, response(http.StatusOK, "操作结果", schemaRef("ActionResponse")))
+			if action == "scale" {
+				op = withBody(op, "application/json", "目标副本数", schemaRef("ScaleRequest"))
+			}
+			paths[item+"/"+action] = map[string]interface{}{"post": op}
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "k8s-client", "version": "v1"},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]interface{}{
+				"Object": map[string]interface{}{
+					"type":                 "object",
+					"required":             []string{"apiVersion", "kind", "metadata"},
+					"additionalProperties": true,
+					"properties": map[string]interface{}{
+						"apiVersion": map[string]interface{}{"type": "string"},
+						"kind":       map[string]interface{}{"type": "string"},
+						"metadata":   map[string]interface{}{"type": "object"},
+					},
+				},
+				"ObjectList": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"items": map[string]interface{}{"type": "array", "items": schemaRef("Object")},
+					},
+				},
+				"KindList": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name":       map[string]interface{}{"type": "string"},
+							"apiVersion": map[string]interface{}{"type": "string"},
+							"kind":       map[string]interface{}{"type": "string"},
+							"resource":   map[string]interface{}{"type": "string"},
+							"namespaced": map[string]interface{}{"type": "boolean"},
+						},
+					},
+				},
+				"ApplyResponse": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"dryRun": map[string]interface{}{"type": "boolean"},
+						"results": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"kind":      map[string]interface{}{"type": "string"},
+									"namespace": map[string]interface{}{"type": "string"},
+									"name":      map[string]interface{}{"type": "string"},
+									"action":    map[string]interface{}{"type": "string", "enum": []string{"created", "configured", "unchanged"}},
+									"diff":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+								},
+							},
+						},
+					},
+				},
+				"ScaleRequest": map[string]interface{}{
+					"type":       "object",
+					"required":   []string{"replicas"},
+					"properties": map[string]interface{}{"replicas": map[string]interface{}{"type": "integer", "minimum": 0}},
+				},
+				"ActionResponse": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"message": map[string]interface{}{"type": "string"}},
+				},
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code":    map[string]interface{}{"type": "integer"},
+						"kind":    map[string]interface{}{"type": "string"},
+						"message": map[string]interface{}{"type": "string"},
+						"causes":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+/*
+   secured为true时需要bearer token,并附带通用的错误响应
+*/
+func operation(id, summary string, params []interface{}, secured bool, responses ...map[string]interface{}) map[string]interface{} {
+	all := map[string]interface{}{}
+	for _, r := range responses {
+		for code, v := range r {
+			all[code] = v
+		}
+	}
+	op := map[string]interface{}{"operationId": id, "summary": summary, "responses": all}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if secured {
+		op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict} {
+			for k, v := range response(code, http.StatusText(code), schemaRef("Error")) {
+				all[k] = v
+			}
+		}
+	}
+	return op
+}
+
+func withBody(op map[string]interface{}, contentType, description string, schema map[string]interface{}) map[string]interface{} {
+	op["requestBody"] = map[string]interface{}{
+		"required":    true,
+		"description": description,
+		"content":     map[string]interface{}{contentType: map[string]interface{}{"schema": schema}},
+	}
+	return op
+}
+
+func response(code int, description string, schema map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{"description": description}
+	if schema != nil {
+		r["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+	}
+	return map[string]interface{}{strconv.Itoa(code): r}
+}
+
+/*
+   watch=true时以text/event-stream返回,每个事件的data为对象
+*/
+func listResponse(k apiKind) map[string]interface{} {
+	r := response(http.StatusOK, k.Kind+"列表,watch=true时为Server-Sent Events", schemaRef("ObjectList"))
+	r["200"].(map[string]interface{})["content"].(map[string]interface{})["text/event-stream"] = map[string]interface{}{
+		"schema": map[string]interface{}{"type": "string"},
+	}
+	return r
+}
+
+func listParams() []interface{} {
+	return []interface{}{
+		queryParam("labelSelector", "标签选择器"),
+		queryParam("watch", "为true时以Server-Sent Events推送ADDED/MODIFIED/DELETED事件"),
+		queryParam("resourceVersion", "watch的起始版本"),
+	}
+}
+
+func pathParam(name string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func queryParam(name, description string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
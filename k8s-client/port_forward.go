@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+/*
+   端口映射,本地端口为0时随机选择
+*/
+type portMapping struct {
+	Local  int32
+	Remote int32
+}
+
+/*
+   解析端口参数: 8080:80、80(本地与远端相同)、:80(本地随机)
+*/
+func parsePortMapping(s string) (portMapping, error) {
+	local, remote := s, s
+	if i := strings.Index(s, ":"); i >= 0 {
+		local, remote = s[:i], s[i+1:]
+		if local == "" {
+			local = "0"
+		}
+	}
+	l, err := strconv.ParseInt(local, 10, 32)
+	if err != nil || l < 0 || l > 65535 {
+		return portMapping{}, usageError("本地端口不合法: %s", s)
+	}
+	r, err := strconv.ParseInt(remote, 10, 32)
+	if err != nil || r <= 0 || r > 65535 {
+		return portMapping{}, usageError("远端端口不合法: %s", s)
+	}
+	return portMapping{Local: int32(l), Remote: int32(r)}, nil
+}
+
+/*
+   将Service端口转换为Pod中的容器端口,targetPort为名称时按名称查找容器端口
+*/
+func serviceTargetPort(svc *core_v1.Service, pod *core_v1.Pod, port int32) (int32, error) {
+	for _, sp := range svc.Spec.Ports {
+		if sp.Port != port {
+			continue
+		}
+		if sp.TargetPort.StrVal == "" {
+			if sp.TargetPort.IntVal == 0 {
+				return sp.Port, nil
+			}
+			return sp.TargetPort.IntVal, nil
+		}
+		for _, c := range pod.Spec.Containers {
+			for _, cp := range c.Ports {
+				if cp.Name == sp.TargetPort.StrVal {
+					return cp.ContainerPort, nil
+				}
+			}
+		}
+		return 0, &clientError{Kind: ErrNotFound, Action: "解析targetPort", Err: fmt.Errorf("Pod %s 中没有名为 %s 的容器端口", pod.Name, sp.TargetPort.StrVal)}
+	}
+	return 0, &clientError{Kind: ErrNotFound, Action: "解析Service端口", Err: fmt.Errorf("Service %s/%s 没有端口 %d", svc.Namespace, svc.Name, port)}
+}
+
+/*
+   目标为Service时远端端口为Service端口,需要转换为选中Pod的容器端口
+*/
+func resolvePortMappings(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, pod *core_v1.Pod, mappings []portMapping) ([]portMapping, error) {
+	if kind != "service" {
+		return mappings, nil
+	}
+	svc, err := clientSet.CoreV1().Services(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, wrapError("获取Service", err)
+	}
+	resolved := make([]portMapping, 0, len(mappings))
+	for _, m := range mappings {
+		target, err := serviceTargetPort(svc, pod, m.Remote)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, portMapping{Local: m.Local, Remote: target})
+	}
+	return resolved, nil
+}
+
+/*
+   通过SPDY调用Pod的portforward子资源,直到stopCh关闭
+*/
+func forwardPorts(executor *spdyExecutor, namespace, pod string, addresses []string, mappings []portMapping, stopCh <-chan struct{}, out io.Writer) error {
+	transport, upgrader, err := spdy.RoundTripperFor(executor.config)
+	if err != nil {
+		return fmt.Errorf("初始化SPDY失败: %w", err)
+	}
+	req := executor.clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	ports := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		ports = append(ports, fmt.Sprintf("%d:%d", m.Local, m.Remote))
+	}
+	forwarder, err := portforward.NewOnAddresses(dialer, addresses, ports, stopCh, make(chan struct{}), out, os.Stderr)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	return wrapError("端口转发", forwarder.ForwardPorts())
+}
+
+func runPortForward(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("port-forward", out)
+	address := fs.String("address", "localhost", "监听地址,多个以逗号分隔")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return usageError("用法: port-forward [-address localhost] <资源类型>/<名称> [本地端口:]远端端口...")
+	}
+	kind, name, err := parseObjectRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var mappings []portMapping
+	for _, arg := range fs.Args()[1:] {
+		m, err := parsePortMapping(arg)
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, m)
+	}
+	executor, err := newSPDYExecutor(ctx)
+	if err != nil {
+		return err
+	}
+	pod, err := choosePod(ctx, executor.clientSet, kind, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	mappings, err = resolvePortMappings(ctx, executor.clientSet, kind, activeProfile.Namespace, name, pod, mappings)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "转发到Pod %s/%s\n", activeProfile.Namespace, pod.Name)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	return forwardPorts(executor, activeProfile.Namespace, pod.Name, splitList(*address), mappings, ctx.Done(), out)
+}
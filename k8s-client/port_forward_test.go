@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func newForwardPod(name string, phase core_v1.PodPhase, ready bool) *core_v1.Pod {
+	status := core_v1.ConditionFalse
+	if ready {
+		status = core_v1.ConditionTrue
+	}
+	return &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: TestNamespace, Labels: map[string]string{"app": "test-nginx"}},
+		Spec: core_v1.PodSpec{Containers: []core_v1.Container{
+			{Name: "test-nginx-container", Ports: []core_v1.ContainerPort{{Name: "http", ContainerPort: 80}, {Name: "bdbdse", ContainerPort: 81}}},
+			{Name: "sidecar"},
+		}},
+		Status: core_v1.PodStatus{Phase: phase, Conditions: []core_v1.PodCondition{{Type: core_v1.PodReady, Status: status}}},
+	}
+}
+
+func TestChoosePod(t *testing.T) {
+	svc := &core_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+		Spec:       core_v1.ServiceSpec{Selector: map[string]string{"app": "test-nginx"}},
+	}
+	clientSet := fake.NewSimpleClientset(svc,
+		newForwardPod("test-nginx-a", core_v1.PodPending, false),
+		newForwardPod("test-nginx-b", core_v1.PodRunning, false),
+		newForwardPod("test-nginx-c", core_v1.PodRunning, true),
+	)
+	pod, err := choosePod(context.TODO(), clientSet, "service", TestNamespace, "test-nginx")
+	if err != nil || pod.Name != "test-nginx-c" {
+		t.Fatalf("choosePod(context.TODO()) = %v, %v, want test-nginx-c", pod, err)
+	}
+	if _, err := choosePod(context.TODO(), clientSet, "pod", TestNamespace, "test-nginx-a"); errorKindOf(err) != ErrNotFound {
+		t.Errorf("pending pod error = %v", err)
+	}
+
+	if c, _ := podContainer(pod, ""); c != "test-nginx-container" {
+		t.Errorf("default container = %s", c)
+	}
+	pod.Annotations = map[string]string{defaultContainerAnnotation: "sidecar"}
+	if c, _ := podContainer(pod, ""); c != "sidecar" {
+		t.Errorf("annotated container = %s", c)
+	}
+	if _, err := podContainer(pod, "missing"); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing container error = %v", err)
+	}
+}
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    portMapping
+		wantErr bool
+	}{
+		{in: "8080:80", want: portMapping{Local: 8080, Remote: 80}},
+		{in: "80", want: portMapping{Local: 80, Remote: 80}},
+		{in: ":80", want: portMapping{Local: 0, Remote: 80}},
+		{in: "8080:", wantErr: true},
+		{in: "x:80", wantErr: true},
+		{in: "70000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePortMapping(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePortMapping(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestResolvePortMappings(t *testing.T) {
+	svc := &core_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+		Spec: core_v1.ServiceSpec{Ports: []core_v1.ServicePort{
+			{Port: 80, TargetPort: intstr.FromString("bdbdse")},
+			{Port: 8080, TargetPort: intstr.FromInt(80)},
+			{Port: 9090},
+			{Port: 9091, TargetPort: intstr.FromString("missing")},
+		}},
+	}
+	clientSet := fake.NewSimpleClientset(svc)
+	pod := newForwardPod("test-nginx-a", core_v1.PodRunning, true)
+	got, err := resolvePortMappings(context.TODO(), clientSet, "service", TestNamespace, "test-nginx", pod, []portMapping{{0, 80}, {18080, 8080}, {9090, 9090}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []portMapping{{0, 81}, {18080, 80}, {9090, 9090}}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mapping %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, port := range []int32{9091, 443} {
+		if _, err := resolvePortMappings(context.TODO(), clientSet, "service", TestNamespace, "test-nginx", pod, []portMapping{{0, port}}); errorKindOf(err) != ErrNotFound {
+			t.Errorf("port %d error = %v", port, err)
+		}
+	}
+	if got, _ := resolvePortMappings(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx", pod, []portMapping{{0, 81}}); got[0].Remote != 81 {
+		t.Errorf("deployment mapping = %+v", got)
+	}
+}
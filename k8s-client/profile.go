@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"os"
+	"path/filepath"
+	sigs_yaml "sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+//profile中未设置的字段以及没有配置文件时使用的默认值
+const (
+	defaultKubeConfig   = "./config"
+	defaultManifestDir  = "./yaml"
+	defaultFieldManager = "k8s-client"
+)
+
+//覆盖profile字段的环境变量,优先级低于命令行参数
+const (
+	envConfig       = "K8S_CLIENT_CONFIG"
+	envProfile      = "K8S_CLIENT_PROFILE"
+	envKubeConfig   = "K8S_CLIENT_KUBECONFIG"
+	envContext      = "K8S_CLIENT_CONTEXT"
+	envNamespace    = "K8S_CLIENT_NAMESPACE"
+	envManifestDirs = "K8S_CLIENT_MANIFEST_DIRS"
+	envOutput       = "K8S_CLIENT_OUTPUT"
+	envFieldManager = "K8S_CLIENT_FIELD_MANAGER"
+	envReadOnly     = "K8S_CLIENT_READ_ONLY"
+)
+
+//读取环境变量,测试时替换
+var getenv = os.Getenv
+
+/*
+   工具配置中的一个profile,优先级: 命令行参数 > 环境变量 > profile > 默认值
+   Kubeconfig与Context选择集群,Context为空时使用kubeconfig的current-context
+   Namespace为各命令默认操作的命名空间,./yaml下清单中的命名空间也会被替换为它
+   ManifestDirs为按顺序查找清单文件的目录,Output为支持-o的命令的默认输出格式,命令不支持时使用命令自身的默认值
+   FieldManager为创建、更新与patch请求的fieldManager
+   ReadOnly为true时拒绝所有变更请求,exec、attach与port-forward同样是POST请求且可以修改容器内的状态,也会被拒绝,
+   ProtectedNamespaces中的命名空间拒绝变更,ConfirmDelete为true时delete命令需要确认
+   限流、超时、重试、多集群与审计日志等客户端参数(clientOptions)直接写在profile中
+*/
+type profile struct {
+	Name                string   `json:"-"`
+	Kubeconfig          string   `json:"kubeconfig,omitempty"`
+	Context             string   `json:"context,omitempty"`
+	Namespace           string   `json:"namespace,omitempty"`
+	ManifestDirs        []string `json:"manifestDirs,omitempty"`
+	Output              string   `json:"output,omitempty"`
+	FieldManager        string   `json:"fieldManager,omitempty"`
+	PullSecret          string   `json:"pullSecret,omitempty"`
+	ReadOnly            bool     `json:"readOnly,omitempty"`
+	ConfirmDelete       bool     `json:"confirmDelete,omitempty"`
+	ProtectedNamespaces []string `json:"protectedNamespaces,omitempty"`
+	clientOptions
+}
+
+func defaultProfile() profile {
+	return profile{
+		Kubeconfig:    defaultKubeConfig,
+		Namespace:     TestNamespace,
+		ManifestDirs:  []string{defaultManifestDir},
+		FieldManager:  defaultFieldManager,
+		PullSecret:    TestDockerConfigJsonKey,
+		clientOptions: defaultClientOptions(),
+	}
+}
+
+//当前命令使用的profile,由run解析全局参数后设置
+var activeProfile = defaultProfile()
+
+/*
+   工具配置文件,profiles中的每一项只需要写与默认值不同的字段
+*/
+type toolConfig struct {
+	CurrentProfile string                     `json:"currentProfile,omitempty"`
+	Profiles       map[string]json.RawMessage `json:"profiles,omitempty"`
+}
+
+/*
+   默认配置文件路径: $XDG_CONFIG_HOME/k8s-client/config.yaml,未设置XDG_CONFIG_HOME时为~/.config/k8s-client/config.yaml
+*/
+func defaultToolConfigPath() string {
+	if dir := getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "k8s-client", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "k8s-client", "config.yaml")
+}
+
+/*
+   从配置文件中读取指定的profile,name为空时使用currentProfile,两者都为空时返回默认值
+   required为false时文件不存在不报错
+*/
+func loadProfile(path, name string, required bool) (profile, error) {
+	p := defaultProfile()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		if name != "" {
+			return p, usageError("配置文件%s不存在,无法使用profile: %s", path, name)
+		}
+		return p, nil
+	}
+	if err != nil {
+		return p, manifestError(path, err)
+	}
+	var config toolConfig
+	if err := sigs_yaml.UnmarshalStrict(data, &config); err != nil {
+		return p, manifestError(path, err)
+	}
+	if name == "" {
+		name = config.CurrentProfile
+	}
+	if name == "" {
+		return p, nil
+	}
+	raw, ok := config.Profiles[name]
+	if !ok {
+		return p, usageError("配置文件%s中没有profile: %s", path, name)
+	}
+	p.AuditLog = defaultAuditLog(name)
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return p, manifestError(path, fmt.Errorf("profile %s: %w", name, err))
+	}
+	p.Name = name
+	return p, nil
+}
+
+/*
+   用环境变量覆盖profile中的字段
+*/
+func (p *profile) applyEnv() error {
+	for env, into := range map[string]*string{
+		envKubeConfig:   &p.Kubeconfig,
+		envContext:      &p.Context,
+		envNamespace:    &p.Namespace,
+		envOutput:       &p.Output,
+		envFieldManager: &p.FieldManager,
+	} {
+		if value := getenv(env); value != "" {
+			*into = value
+		}
+	}
+	if value := getenv(envManifestDirs); value != "" {
+		p.ManifestDirs = splitList(value)
+	}
+	if value := getenv(envReadOnly); value != "" {
+		readOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return usageError("环境变量%s应为true或false: %s", envReadOnly, value)
+		}
+		p.ReadOnly = readOnly
+	}
+	return nil
+}
+
+func (p profile) validate() error {
+	switch {
+	case p.Namespace == "":
+		return usageError("namespace不能为空")
+	case len(p.ManifestDirs) == 0:
+		return usageError("manifestDirs不能为空")
+	case p.Kubeconfig == "":
+		return usageError("kubeconfig不能为空")
+	}
+	return nil
+}
+
+/*
+   kubeconfig路径,支持~开头
+*/
+func (p profile) kubeConfigPath() string {
+	return expandHome(p.Kubeconfig)
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+/*
+   在ManifestDirs中按顺序查找清单文件,都不存在时返回第一个目录中的路径,读取时报告文件不存在
+*/
+func (p profile) manifestPath(name string) string {
+	for _, dir := range p.ManifestDirs {
+		path := filepath.Join(expandHome(dir), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(expandHome(p.ManifestDirs[0]), name)
+}
+
+func (p profile) manifestDirs() []string {
+	dirs := make([]string, len(p.ManifestDirs))
+	for i, dir := range p.ManifestDirs {
+		dirs[i] = expandHome(dir)
+	}
+	return dirs
+}
+
+/*
+   支持-o的命令的默认输出格式: profile中的Output在supported中时使用它,否则使用supported[0]
+*/
+func (p profile) output(supported ...string) string {
+	for _, format := range supported {
+		if format == p.Output {
+			return format
+		}
+	}
+	return supported[0]
+}
+
+func (p profile) protected(namespace string) bool {
+	for _, ns := range p.ProtectedNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (p profile) describe() string {
+	if p.Name == "" {
+		return "默认配置"
+	}
+	return "profile " + p.Name
+}
+
+/*
+   为rest.Config设置fieldManager并检查安全设置
+*/
+func (p profile) apply(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &profileTransport{next: rt, profile: p}
+	})
+}
+
+/*
+   ReadOnly或目标命名空间受保护时直接返回403,不发送请求,dryRun请求不受限制
+   创建、更新与patch请求未指定fieldManager时使用profile中的FieldManager
+*/
+//只读profile同样拒绝的连接类子资源,它们不修改对象但可以在容器内执行命令或访问端口
+var readOnlyDeniedSubresources = map[string]bool{"exec": true, "attach": true, "portforward": true}
+
+type profileTransport struct {
+	next    http.RoundTripper
+	profile profile
+}
+
+func (t *profileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	verb := auditVerb(req.Method)
+	target, ok := parseResourcePath(req.URL.Path)
+	query := req.URL.Query()
+	if verb == "" || len(query["dryRun"]) > 0 {
+		return t.next.RoundTrip(req)
+	}
+	if t.profile.ReadOnly {
+		if ok && readOnlyDeniedSubresources[target.Subresource] {
+			return forbiddenResponse(req, fmt.Sprintf("%s为只读,不允许exec、attach与port-forward: %s %s", t.profile.describe(), req.Method, req.URL.Path)), nil
+		}
+		return forbiddenResponse(req, fmt.Sprintf("%s为只读,拒绝%s %s", t.profile.describe(), req.Method, req.URL.Path)), nil
+	}
+	if ok {
+		namespace := target.Namespace
+		if target.Resource == "namespaces" {
+			namespace = target.Name
+		}
+		if namespace != "" && t.profile.protected(namespace) {
+			return forbiddenResponse(req, fmt.Sprintf("%s中命名空间%s受保护,拒绝%s %s", t.profile.describe(), namespace, req.Method, req.URL.Path)), nil
+		}
+	}
+	if verb != "delete" && ok && !auditIgnoredSubresources[target.Subresource] && t.profile.FieldManager != "" && query.Get("fieldManager") == "" {
+		query.Set("fieldManager", t.profile.FieldManager)
+		req = req.Clone(req.Context())
+		req.URL.RawQuery = query.Encode()
+	}
+	return t.next.RoundTrip(req)
+}
+
+/*
+   构造ApiServer格式的403响应,客户端会将其解析为Forbidden错误
+*/
+func forbiddenResponse(req *http.Request, message string) *http.Response {
+	status := meta_v1.Status{
+		TypeMeta: meta_v1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   meta_v1.StatusFailure,
+		Message:  message,
+		Reason:   meta_v1.StatusReasonForbidden,
+		Code:     http.StatusForbidden,
+	}
+	body, _ := json.Marshal(status)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode:    http.StatusForbidden,
+		Status:        "403 Forbidden",
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testToolConfig = `currentProfile: dev
+profiles:
+  dev:
+    context: dev
+    namespace: dev-namespace
+    output: json
+  prod:
+    kubeconfig: ~/.kube/prod
+    context: prod
+    namespace: prod-namespace
+    manifestDirs: [./prod, ./yaml]
+    fieldManager: deployer
+    readOnly: true
+    confirmDelete: true
+    protectedNamespaces: [kube-system]
+    qps: 20
+    requestTimeout: 10s
+`
+
+func writeToolConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/*
+   用map替换环境变量,测试结束后恢复
+*/
+func setTestEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	original := getenv
+	getenv = func(key string) string { return env[key] }
+	t.Cleanup(func() { getenv = original })
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeToolConfig(t, testToolConfig)
+
+	p, err := loadProfile(path, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultProfile()
+	want.Name, want.Context, want.Namespace, want.Output = "dev", "dev", "dev-namespace", "json"
+	want.AuditLog = defaultAuditLog("dev")
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("profile = %+v, want %+v", p, want)
+	}
+
+	p, err = loadProfile(path, "prod", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = profile{
+		Name: "prod", Kubeconfig: "~/.kube/prod", Context: "prod", Namespace: "prod-namespace",
+		ManifestDirs: []string{"./prod", "./yaml"}, FieldManager: "deployer", PullSecret: TestDockerConfigJsonKey,
+		ReadOnly: true, ConfirmDelete: true, ProtectedNamespaces: []string{"kube-system"},
+		clientOptions: defaultClientOptions(),
+	}
+	want.QPS, want.RequestTimeout.Duration, want.AuditLog = 20, 10*time.Second, defaultAuditLog("prod")
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("profile = %+v, want %+v", p, want)
+	}
+
+	//默认配置文件不存在时使用默认值
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if p, err := loadProfile(missing, "", false); err != nil || !reflect.DeepEqual(p, defaultProfile()) {
+		t.Errorf("profile = %+v, err = %v", p, err)
+	}
+	for _, tc := range []struct {
+		path     string
+		name     string
+		required bool
+		want     errorKind
+	}{
+		{path, "staging", true, ErrUsage},
+		{missing, "dev", false, ErrUsage},
+		{missing, "", true, ErrInvalidManifest},
+		{writeToolConfig(t, "profiles:\n  dev:\n    namespce: dev\n"), "dev", true, ErrInvalidManifest},
+		{writeToolConfig(t, "profile: dev\n"), "", true, ErrInvalidManifest},
+	} {
+		if _, err := loadProfile(tc.path, tc.name, tc.required); errorKindOf(err) != tc.want {
+			t.Errorf("loadProfile(%s, %q) error = %v, want %s", tc.path, tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseGlobalFlagsProfile(t *testing.T) {
+	path := writeToolConfig(t, testToolConfig)
+	setTestEnv(t, map[string]string{
+		envConfig:    path,
+		envNamespace: "env-namespace",
+		envOutput:    "mermaid",
+	})
+
+	//命令行参数 > 环境变量 > profile > 默认值
+	_, p, args, err := parseGlobalFlags([]string{"-output", "table", "-manifest-dirs", "./a,./b", "graph"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultProfile()
+	want.Name, want.Context, want.Namespace, want.Output, want.ManifestDirs = "dev", "dev", "env-namespace", "table", []string{"./a", "./b"}
+	want.AuditLog = defaultAuditLog("dev")
+	if !reflect.DeepEqual(p, want) || !reflect.DeepEqual(args, []string{"graph"}) {
+		t.Errorf("profile = %+v, args = %v", p, args)
+	}
+
+	_, p, _, err = parseGlobalFlags([]string{"--profile", "prod", "-namespace", "flag-namespace", "-read-only=false", "list", "pvc"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "prod" || p.Namespace != "flag-namespace" || p.Output != "mermaid" || p.ReadOnly || p.FieldManager != "deployer" {
+		t.Errorf("profile = %+v", p)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		env  map[string]string
+		want errorKind
+	}{
+		{[]string{"-profile", "staging", "list"}, map[string]string{envConfig: path}, ErrUsage},
+		{[]string{"list"}, map[string]string{envConfig: path, envProfile: "staging"}, ErrUsage},
+		{[]string{"-namespace", "", "list"}, map[string]string{envConfig: path}, ErrUsage},
+		{[]string{"list"}, map[string]string{envConfig: path, envReadOnly: "maybe"}, ErrUsage},
+		{[]string{"-config", filepath.Join(t.TempDir(), "missing.yaml"), "list"}, nil, ErrInvalidManifest},
+	} {
+		setTestEnv(t, tc.env)
+		if _, _, _, err := parseGlobalFlags(tc.args, ioutil.Discard); errorKindOf(err) != tc.want {
+			t.Errorf("parseGlobalFlags(%v) error = %v, want %s", tc.args, err, tc.want)
+		}
+	}
+}
+
+func TestProfileOutput(t *testing.T) {
+	p := defaultProfile()
+	p.Output = "json"
+	if got := p.output("dot", "mermaid", "json"); got != "json" {
+		t.Errorf("output = %s, want json", got)
+	}
+	p.Output = "yaml"
+	if got := p.output("table", "json"); got != "table" {
+		t.Errorf("output = %s, want table", got)
+	}
+}
+
+func TestManifestPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "deployment.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: override\n  namespace: test-namespace\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	original := activeProfile
+	defer func() { activeProfile = original }()
+	activeProfile.ManifestDirs = []string{dir, "./yaml"}
+	activeProfile.Namespace = "dev-namespace"
+
+	if got := activeProfile.manifestPath("deployment.yaml"); got != filepath.Join(dir, "deployment.yaml") {
+		t.Errorf("manifestPath = %s", got)
+	}
+	if got := activeProfile.manifestPath("service.yaml"); got != filepath.Join("yaml", "service.yaml") {
+		t.Errorf("manifestPath = %s", got)
+	}
+	if got := activeProfile.manifestPath("missing.yaml"); got != filepath.Join(dir, "missing.yaml") {
+		t.Errorf("manifestPath = %s", got)
+	}
+
+	//清单中的命名空间替换为profile中的命名空间
+	deployment := apps_v1.Deployment{}
+	if err := readManifest(activeProfile.manifestPath("deployment.yaml"), &deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Name != "override" || deployment.Namespace != "dev-namespace" {
+		t.Errorf("deployment = %s/%s", deployment.Namespace, deployment.Name)
+	}
+}
+
+/*
+   记录收到的请求
+*/
+type recordingServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"kind":"ConfigMap","apiVersion":"v1","metadata":{"name":"test-config"}}`))
+}
+
+func TestProfileTransport(t *testing.T) {
+	s := &recordingServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	newClient := func(p profile) kubernetes.Interface {
+		config := &rest.Config{Host: server.URL}
+		p.apply(config)
+		clientSet, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clientSet
+	}
+	ctx := context.TODO()
+	configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "test-config"}}
+
+	p := defaultProfile()
+	p.FieldManager = "deployer"
+	p.ProtectedNamespaces = []string{"kube-system"}
+	clientSet := newClient(p)
+	if _, err := clientSet.CoreV1().ConfigMaps(TestNamespace).Create(ctx, configMap, meta_v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().ConfigMaps(TestNamespace).Update(ctx, configMap, meta_v1.UpdateOptions{FieldManager: "kubectl"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().ConfigMaps("kube-system").Get(ctx, "coredns", meta_v1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		clientSet.CoreV1().ConfigMaps("kube-system").Delete(ctx, "coredns", meta_v1.DeleteOptions{}),
+		clientSet.CoreV1().Namespaces().Delete(ctx, "kube-system", meta_v1.DeleteOptions{}),
+	} {
+		if errorKindOf(wrapError("删除", err)) != ErrForbidden {
+			t.Errorf("err = %v, want forbidden", err)
+		}
+	}
+
+	p.ReadOnly = true
+	clientSet = newClient(p)
+	if _, err := clientSet.CoreV1().ConfigMaps(TestNamespace).Create(ctx, configMap, meta_v1.CreateOptions{}); errorKindOf(wrapError("创建ConfigMap", err)) != ErrForbidden {
+		t.Errorf("err = %v, want forbidden", err)
+	}
+	if _, err := clientSet.CoreV1().ConfigMaps(TestNamespace).Create(ctx, configMap, meta_v1.CreateOptions{DryRun: []string{meta_v1.DryRunAll}}); err != nil {
+		t.Errorf("dryRun请求不受只读限制: %v", err)
+	}
+	//只读profile不允许exec
+	err := clientSet.CoreV1().RESTClient().Post().Namespace(TestNamespace).Resource("pods").Name("test-nginx-abc").SubResource("exec").Do(ctx).Error()
+	if errorKindOf(wrapError("exec", err)) != ErrForbidden || !strings.Contains(err.Error(), "不允许exec、attach与port-forward") {
+		t.Errorf("exec err = %v, want forbidden", err)
+	}
+
+	want := []string{
+		"POST /api/v1/namespaces/test-namespace/configmaps?fieldManager=deployer",
+		"PUT /api/v1/namespaces/test-namespace/configmaps/test-config?fieldManager=kubectl",
+		"GET /api/v1/namespaces/kube-system/configmaps/coredns",
+		"POST /api/v1/namespaces/test-namespace/configmaps?dryRun=All",
+	}
+	if !reflect.DeepEqual(s.requests, want) {
+		t.Errorf("requests = %v, want %v", s.requests, want)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const copiedFromAnnotation = "k8s-client/copied-from"
+
+/*
+   镜像拉取密文的分发选项
+*/
+type pullSecretOptions struct {
+	SecretName        string   //密文名称
+	SourceNamespace   string   //源命名空间
+	Namespaces        []string //目标命名空间
+	NamespaceSelector string   //按标签选择目标命名空间
+	ServiceAccount    string   //挂载密文的ServiceAccount,为空则不挂载
+	Inject            bool     //是否注入到拉取该仓库镜像的工作负载的Pod模板中
+}
+
+/*
+   将dockerconfigjson密文复制到目标命名空间,并挂载到ServiceAccount或注入到工作负载
+*/
+func propagatePullSecret(ctx context.Context, clientSet kubernetes.Interface, opts pullSecretOptions, out io.Writer) error {
+	source, hosts, err := getPullSecret(ctx, clientSet, opts.SourceNamespace, opts.SecretName)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := targetNamespaces(ctx, clientSet, opts)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		if ns != opts.SourceNamespace {
+			if err := copySecret(ctx, clientSet, source, ns); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Secret %s 已复制到 %s\n", source.Name, ns)
+		}
+		if opts.ServiceAccount != "" {
+			if err := attachToServiceAccount(ctx, clientSet, ns, opts.ServiceAccount, source.Name); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Secret %s 已挂载到ServiceAccount %s/%s\n", source.Name, ns, opts.ServiceAccount)
+		}
+		if opts.Inject {
+			if err := injectIntoWorkloads(ctx, clientSet, ns, source.Name, hosts, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+   获取dockerconfigjson密文及其中的仓库地址
+*/
+func getPullSecret(ctx context.Context, clientSet kubernetes.Interface, namespace, name string) (*core_v1.Secret, map[string]bool, error) {
+	secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, nil, wrapError("获取Secret", err)
+	}
+	if secret.Type != core_v1.SecretTypeDockerConfigJson {
+		return nil, nil, &clientError{Kind: ErrInvalidManifest, Action: "分发Secret", Err: fmt.Errorf("%s/%s 的类型为%s,不是%s", secret.Namespace, secret.Name, secret.Type, core_v1.SecretTypeDockerConfigJson)}
+	}
+	hosts, err := registryHosts(secret)
+	if err != nil {
+		return nil, nil, &clientError{Kind: ErrInvalidManifest, Action: "解析Secret", Err: err}
+	}
+	return secret, hosts, nil
+}
+
+type pullSecretKey struct{}
+
+/*
+   在ctx中记录apply -pull-secret指定的密文,工作负载的createOrUpdate在创建或更新前注入到清单的Pod模板
+*/
+func withPullSecret(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, pullSecretKey{}, name)
+}
+
+/*
+   ctx中指定了密文时,为清单中拉取该仓库镜像的Pod模板注入imagePullSecrets,密文需要在目标命名空间中
+*/
+func injectManifestPullSecret(ctx context.Context, clientSet kubernetes.Interface, spec *core_v1.PodSpec, out io.Writer) error {
+	name, _ := ctx.Value(pullSecretKey{}).(string)
+	if name == "" {
+		return nil
+	}
+	_, hosts, err := getPullSecret(ctx, clientSet, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	if injectPullSecret(spec, name, hosts) {
+		fmt.Fprintf(out, "Secret %s 已注入Pod模板\n", name)
+	} else if !hasPullSecret(spec.ImagePullSecrets, name) {
+		fmt.Fprintf(out, "没有容器拉取Secret %s 中仓库的镜像,未注入\n", name)
+	}
+	return nil
+}
+
+func targetNamespaces(ctx context.Context, clientSet kubernetes.Interface, opts pullSecretOptions) ([]string, error) {
+	seen := map[string]bool{}
+	var namespaces []string
+	for _, ns := range opts.Namespaces {
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if opts.NamespaceSelector != "" {
+		list, err := clientSet.CoreV1().Namespaces().List(ctx, meta_v1.ListOptions{LabelSelector: opts.NamespaceSelector})
+		if err != nil {
+			return nil, wrapError("获取Namespace列表", err)
+		}
+		for _, ns := range list.Items {
+			if !seen[ns.Name] {
+				seen[ns.Name] = true
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+/*
+   复制密文,已存在则只patch数据与类型,保留目标上的标签与注解
+*/
+func copySecret(ctx context.Context, clientSet kubernetes.Interface, source *core_v1.Secret, namespace string) error {
+	secret := &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        source.Name,
+			Namespace:   namespace,
+			Labels:      source.Labels,
+			Annotations: map[string]string{copiedFromAnnotation: source.Namespace + "/" + source.Name},
+		},
+		Data: source.Data,
+		Type: source.Type,
+	}
+	client := clientSet.CoreV1().Secrets(namespace)
+	_, err := client.Create(ctx, secret, meta_v1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8s_errors.IsAlreadyExists(err) {
+		return wrapError("创建Secret", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{"data": source.Data, "type": source.Type})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Patch(ctx, source.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return wrapError("更新Secret", err)
+	}
+	return nil
+}
+
+func attachToServiceAccount(ctx context.Context, clientSet kubernetes.Interface, namespace, name, secretName string) error {
+	client := clientSet.CoreV1().ServiceAccounts(namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		sa, err := client.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if hasPullSecret(sa.ImagePullSecrets, secretName) {
+			return nil
+		}
+		sa.ImagePullSecrets = append(sa.ImagePullSecrets, core_v1.LocalObjectReference{Name: secretName})
+		_, err = client.Update(ctx, sa, meta_v1.UpdateOptions{})
+		return err
+	})
+	return wrapError("更新ServiceAccount", err)
+}
+
+//带Pod模板的工作负载类型,Job的Pod模板创建后不可修改
+var podTemplateKinds = []string{"deployment", "statefulset", "daemonset", "job", "cronjob"}
+
+func hasPodTemplate(kind string) bool {
+	for _, k := range podTemplateKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type workloadTemplate struct {
+	Name     string
+	Template core_v1.PodTemplateSpec
+}
+
+/*
+   列出命名空间中kind类型的工作负载及其Pod模板,CronJob为jobTemplate中的Pod模板
+*/
+func listPodTemplates(ctx context.Context, clientSet kubernetes.Interface, kind, namespace string) ([]workloadTemplate, error) {
+	var templates []workloadTemplate
+	switch kind {
+	case "deployment":
+		list, err := clientSet.AppsV1().Deployments(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取Deployment列表", err)
+		}
+		for _, item := range list.Items {
+			templates = append(templates, workloadTemplate{Name: item.Name, Template: item.Spec.Template})
+		}
+	case "statefulset":
+		list, err := clientSet.AppsV1().StatefulSets(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取StatefulSet列表", err)
+		}
+		for _, item := range list.Items {
+			templates = append(templates, workloadTemplate{Name: item.Name, Template: item.Spec.Template})
+		}
+	case "daemonset":
+		list, err := clientSet.AppsV1().DaemonSets(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取DaemonSet列表", err)
+		}
+		for _, item := range list.Items {
+			templates = append(templates, workloadTemplate{Name: item.Name, Template: item.Spec.Template})
+		}
+	case "job":
+		list, err := clientSet.BatchV1().Jobs(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取Job列表", err)
+		}
+		for _, item := range list.Items {
+			templates = append(templates, workloadTemplate{Name: item.Name, Template: item.Spec.Template})
+		}
+	case "cronjob":
+		list, err := clientSet.BatchV1().CronJobs(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return nil, wrapError("获取CronJob列表", err)
+		}
+		for _, item := range list.Items {
+			templates = append(templates, workloadTemplate{Name: item.Name, Template: item.Spec.JobTemplate.Spec.Template})
+		}
+	default:
+		return nil, usageError("%s 没有Pod模板", kind)
+	}
+	return templates, nil
+}
+
+/*
+   修改工作负载的Pod模板,CronJob修改jobTemplate中的Pod模板,只影响之后创建的Job
+   其他类型与rollout命令相同,使用updatePodTemplate
+*/
+func updateWorkloadTemplate(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, mutate func(*core_v1.PodTemplateSpec)) error {
+	if kind != "cronjob" {
+		return updatePodTemplate(ctx, clientSet, kind, namespace, name, func(template *core_v1.PodTemplateSpec) error {
+			mutate(template)
+			return nil
+		})
+	}
+	client := clientSet.BatchV1().CronJobs(namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		mutate(&obj.Spec.JobTemplate.Spec.Template)
+		_, err = client.Update(ctx, obj, meta_v1.UpdateOptions{})
+		return err
+	})
+	return wrapError("更新CronJob", err)
+}
+
+/*
+   为Pod模板中拉取hosts仓库镜像的工作负载注入imagePullSecrets
+   Job的Pod模板不可修改,只提示需要重新创建
+*/
+func injectIntoWorkloads(ctx context.Context, clientSet kubernetes.Interface, namespace, secretName string, hosts map[string]bool, out io.Writer) error {
+	for _, kind := range podTemplateKinds {
+		templates, err := listPodTemplates(ctx, clientSet, kind, namespace)
+		if err != nil {
+			return err
+		}
+		for _, w := range templates {
+			if !injectPullSecret(&w.Template.Spec, secretName, hosts) {
+				continue
+			}
+			if kind == "job" {
+				fmt.Fprintf(out, "Job %s/%s 拉取该仓库的镜像但Pod模板不可修改,需要重新创建后才能使用Secret %s\n", namespace, w.Name, secretName)
+				continue
+			}
+			err := updateWorkloadTemplate(ctx, clientSet, kind, namespace, w.Name, func(template *core_v1.PodTemplateSpec) {
+				injectPullSecret(&template.Spec, secretName, hosts)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Secret %s 已注入%s %s/%s\n", secretName, apiKinds[kind].Kind, namespace, w.Name)
+		}
+	}
+	return nil
+}
+
+/*
+   Pod中有容器拉取hosts仓库的镜像且尚未引用该密文时添加imagePullSecrets
+*/
+func injectPullSecret(spec *core_v1.PodSpec, secretName string, hosts map[string]bool) bool {
+	if hasPullSecret(spec.ImagePullSecrets, secretName) {
+		return false
+	}
+	containers := append(append([]core_v1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		if hosts[imageRegistryHost(container.Image)] {
+			spec.ImagePullSecrets = append(spec.ImagePullSecrets, core_v1.LocalObjectReference{Name: secretName})
+			return true
+		}
+	}
+	return false
+}
+
+func hasPullSecret(refs []core_v1.LocalObjectReference, name string) bool {
+	for _, ref := range refs {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+   解析dockerconfigjson中的仓库地址
+*/
+func registryHosts(secret *core_v1.Secret) (map[string]bool, error) {
+	raw, ok := secret.Data[core_v1.DockerConfigJsonKey]
+	if !ok {
+		raw = []byte(secret.StringData[core_v1.DockerConfigJsonKey])
+	}
+	config := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("%s 不是合法的dockerconfigjson: %w", core_v1.DockerConfigJsonKey, err)
+	}
+	hosts := map[string]bool{}
+	for server := range config.Auths {
+		hosts[normalizeRegistryHost(server)] = true
+	}
+	return hosts, nil
+}
+
+/*
+   仓库地址转换为host,如 https://registry.dockerhubar.com/ -> registry.dockerhubar.com
+*/
+func normalizeRegistryHost(server string) string {
+	host := server
+	if strings.Contains(server, "://") {
+		if u, err := url.Parse(server); err == nil {
+			host = u.Host
+		}
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
+
+/*
+   镜像所在仓库的host,未指定仓库的镜像为docker.io
+*/
+func imageRegistryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return "docker.io"
+	}
+	if strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost" {
+		return normalizeRegistryHost(parts[0])
+	}
+	return "docker.io"
+}
+
+func runPullSecret(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("pull-secret", out)
+	opts := pullSecretOptions{}
+	fs.StringVar(&opts.SecretName, "secret", activeProfile.PullSecret, "dockerconfigjson密文名称")
+	fs.StringVar(&opts.SourceNamespace, "from", activeProfile.Namespace, "密文所在的命名空间")
+	namespaces := fs.String("namespaces", "", "目标命名空间,逗号分隔")
+	fs.StringVar(&opts.NamespaceSelector, "namespace-selector", "", "按标签选择目标命名空间,如 env=test")
+	fs.StringVar(&opts.ServiceAccount, "service-account", "default", "挂载密文的ServiceAccount,为空则不挂载")
+	fs.BoolVar(&opts.Inject, "inject", false, "注入到拉取该仓库镜像的工作负载的Pod模板中")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *namespaces != "" {
+		opts.Namespaces = strings.Split(*namespaces, ",")
+	}
+	if len(opts.Namespaces) == 0 && opts.NamespaceSelector == "" {
+		opts.Namespaces = []string{opts.SourceNamespace}
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return propagatePullSecret(ctx, clientSet, opts, out)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPullSecret() *core_v1.Secret {
+	return &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: TestDockerConfigJsonKey, Namespace: TestNamespace},
+		Data: map[string][]byte{
+			core_v1.DockerConfigJsonKey: []byte(`{"auths":{"https://registry.dockerhubar.com/":{"username":"admin","password":"123456"}}}`),
+		},
+		Type: core_v1.SecretTypeDockerConfigJson,
+	}
+}
+
+func newTestImageDeployment(ns, name, image string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: ns},
+		Spec: apps_v1.DeploymentSpec{
+			Template: core_v1.PodTemplateSpec{
+				Spec: core_v1.PodSpec{Containers: []core_v1.Container{{Name: "app", Image: image}}},
+			},
+		},
+	}
+}
+
+func TestPropagatePullSecret(t *testing.T) {
+	privateTemplate := newTestImageDeployment("team-a", "", "registry.dockerhubar.com/library/app:1.0").Spec.Template
+	objects := []runtime.Object{
+		newTestPullSecret(),
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "team-a", Labels: map[string]string{"registry": "harbor"}}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "team-b"}},
+		&core_v1.ServiceAccount{ObjectMeta: meta_v1.ObjectMeta{Name: "default", Namespace: "team-a"}},
+		newTestImageDeployment("team-a", "private", "registry.dockerhubar.com/library/app:1.0"),
+		newTestImageDeployment("team-a", "public", "nginx"),
+		&apps_v1.StatefulSet{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "private-db", Namespace: "team-a"},
+			Spec:       apps_v1.StatefulSetSpec{Template: privateTemplate},
+		},
+		&batch_v1.CronJob{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "private-backup", Namespace: "team-a"},
+			Spec: batch_v1.CronJobSpec{JobTemplate: batch_v1.JobTemplateSpec{
+				Spec: batch_v1.JobSpec{Template: privateTemplate},
+			}},
+		},
+		&batch_v1.Job{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "private-migrate", Namespace: "team-a"},
+			Spec:       batch_v1.JobSpec{Template: privateTemplate},
+		},
+		//目标命名空间中已存在的Secret保留自己的标签与注解
+		&core_v1.Secret{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name: TestDockerConfigJsonKey, Namespace: "team-a",
+				Labels:      map[string]string{"team": "a"},
+				Annotations: map[string]string{"owner": "team-a"},
+			},
+			Data: map[string][]byte{core_v1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+			Type: core_v1.SecretTypeDockerConfigJson,
+		},
+	}
+	cs := fake.NewSimpleClientset(objects...)
+	opts := pullSecretOptions{
+		SecretName:        TestDockerConfigJsonKey,
+		SourceNamespace:   TestNamespace,
+		NamespaceSelector: "registry=harbor",
+		ServiceAccount:    "default",
+		Inject:            true,
+	}
+	var out bytes.Buffer
+	if err := propagatePullSecret(context.TODO(), cs, opts, &out); err != nil {
+		t.Fatal(err)
+	}
+	//重复执行应保持幂等
+	if err := propagatePullSecret(context.TODO(), cs, opts, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := cs.CoreV1().Secrets("team-a").Get(context.TODO(), TestDockerConfigJsonKey, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("secret not copied: %v", err)
+	}
+	if secret.Labels["team"] != "a" || secret.Annotations["owner"] != "team-a" || string(secret.Data[core_v1.DockerConfigJsonKey]) != string(newTestPullSecret().Data[core_v1.DockerConfigJsonKey]) {
+		t.Errorf("copied secret = %+v", secret)
+	}
+	if _, err := cs.CoreV1().Secrets("team-b").Get(context.TODO(), TestDockerConfigJsonKey, meta_v1.GetOptions{}); err == nil {
+		t.Error("secret copied to unselected namespace")
+	}
+	sa, _ := cs.CoreV1().ServiceAccounts("team-a").Get(context.TODO(), "default", meta_v1.GetOptions{})
+	if len(sa.ImagePullSecrets) != 1 || sa.ImagePullSecrets[0].Name != TestDockerConfigJsonKey {
+		t.Errorf("service account pull secrets = %v", sa.ImagePullSecrets)
+	}
+	private, _ := cs.AppsV1().Deployments("team-a").Get(context.TODO(), "private", meta_v1.GetOptions{})
+	if refs := private.Spec.Template.Spec.ImagePullSecrets; len(refs) != 1 || refs[0].Name != TestDockerConfigJsonKey {
+		t.Errorf("private deployment pull secrets = %v", refs)
+	}
+	db, _ := cs.AppsV1().StatefulSets("team-a").Get(context.TODO(), "private-db", meta_v1.GetOptions{})
+	backup, _ := cs.BatchV1().CronJobs("team-a").Get(context.TODO(), "private-backup", meta_v1.GetOptions{})
+	for _, refs := range [][]core_v1.LocalObjectReference{db.Spec.Template.Spec.ImagePullSecrets, backup.Spec.JobTemplate.Spec.Template.Spec.ImagePullSecrets} {
+		if len(refs) != 1 || refs[0].Name != TestDockerConfigJsonKey {
+			t.Errorf("pull secrets = %v", refs)
+		}
+	}
+	if !strings.Contains(out.String(), "Job team-a/private-migrate 拉取该仓库的镜像但Pod模板不可修改") {
+		t.Errorf("output = %s", out.String())
+	}
+	public, _ := cs.AppsV1().Deployments("team-a").Get(context.TODO(), "public", meta_v1.GetOptions{})
+	if refs := public.Spec.Template.Spec.ImagePullSecrets; len(refs) != 0 {
+		t.Errorf("public deployment pull secrets = %v", refs)
+	}
+}
+
+func TestPropagatePullSecretErrors(t *testing.T) {
+	opaque := newTestPullSecret()
+	opaque.Type = core_v1.SecretTypeOpaque
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    errorKind
+	}{
+		{"missing secret", nil, ErrNotFound},
+		{"wrong type", []runtime.Object{opaque}, ErrInvalidManifest},
+		{"missing service account", []runtime.Object{newTestPullSecret()}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := pullSecretOptions{
+				SecretName:      TestDockerConfigJsonKey,
+				SourceNamespace: TestNamespace,
+				Namespaces:      []string{"team-a"},
+				ServiceAccount:  "default",
+			}
+			err := propagatePullSecret(context.TODO(), fake.NewSimpleClientset(tt.objects...), opts, &bytes.Buffer{})
+			if got := errorKindOf(err); got != tt.want {
+				t.Errorf("error kind = %v, want %v (err: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestApplyPullSecret(t *testing.T) {
+	original := activeProfile
+	defer func() { activeProfile = original }()
+	data, err := ioutil.ReadFile("yaml/deployment.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	data = []byte(strings.Replace(string(data), "image: 'nginx'", "image: 'registry.dockerhubar.com/library/nginx'", 1))
+	if err := ioutil.WriteFile(filepath.Join(dir, "deployment.yaml"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	activeProfile.ManifestDirs = []string{dir, "./yaml"}
+	activeProfile.Namespace = TestNamespace
+
+	cs := fake.NewSimpleClientset(newTestPullSecret())
+	ctx := withPullSecret(context.TODO(), TestDockerConfigJsonKey)
+	var out bytes.Buffer
+	if err := createOrUpdateDeployment(ctx, cs, &out); err != nil {
+		t.Fatal(err)
+	}
+	deployment, err := cs.AppsV1().Deployments(TestNamespace).Get(ctx, "test-nginx", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPullSecret(deployment.Spec.Template.Spec.ImagePullSecrets, TestDockerConfigJsonKey) {
+		t.Errorf("imagePullSecrets = %v", deployment.Spec.Template.Spec.ImagePullSecrets)
+	}
+	//busybox来自docker.io,不注入
+	if err := createOrUpdateCronJob(ctx, cs, &out); err != nil {
+		t.Fatal(err)
+	}
+	cronJob, err := cs.BatchV1().CronJobs(TestNamespace).Get(ctx, "test-cronjob", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := cronJob.Spec.JobTemplate.Spec.Template.Spec.ImagePullSecrets; len(refs) != 0 {
+		t.Errorf("cronjob imagePullSecrets = %v", refs)
+	}
+	if !strings.Contains(out.String(), "已注入Pod模板") || !strings.Contains(out.String(), "未注入") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	if err := createOrUpdateDeployment(ctx, fake.NewSimpleClientset(), &bytes.Buffer{}); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing secret error = %v", err)
+	}
+	if err := runApply(context.TODO(), []string{"-pull-secret", TestDockerConfigJsonKey, "service"}, &bytes.Buffer{}); errorKindOf(err) != ErrUsage {
+		t.Errorf("service error = %v", err)
+	}
+}
+
+func TestImageRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                                "docker.io",
+		"library/nginx:1.21":                   "docker.io",
+		"docker.io/library/nginx":              "docker.io",
+		"index.docker.io/library/nginx":        "docker.io",
+		"registry.dockerhubar.com/app/web:1.0": "registry.dockerhubar.com",
+		"192.168.2.111:5000/web@sha256:abc":    "192.168.2.111:5000",
+		"localhost/web":                        "localhost",
+	}
+	for image, want := range tests {
+		if got := imageRegistryHost(image); got != want {
+			t.Errorf("imageRegistryHost(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typed_core_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	provisionedByAnnotation       = "pv.kubernetes.io/provisioned-by"
+)
+
+//等待超时后获取诊断信息的时间上限
+const pvcDiagnoseTimeout = 15 * time.Second
+
+/*
+   等待PVC进入Bound状态
+   超时后输出诊断信息:候选PV、StorageClass绑定模式、相关事件以及provisioner是否存在
+*/
+func waitForPVCBound(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, out io.Writer) error {
+	client := clientSet.CoreV1().PersistentVolumeClaims(namespace)
+	pvc, err := client.Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return wrapError("获取PVC", err)
+	}
+	if pvc.Status.Phase != core_v1.ClaimBound {
+		fmt.Fprintf(out, "等待PVC %s/%s 绑定...\n", namespace, name)
+	}
+	for pvc.Status.Phase != core_v1.ClaimBound {
+		latest, err := watchPVC(ctx, client, pvc)
+		if k8s_errors.IsResourceExpired(err) || k8s_errors.IsGone(err) {
+			//resourceVersion已被压缩(410 Gone),重新获取PVC后从新的版本继续监听
+			latest, err = client.Get(ctx, name, meta_v1.GetOptions{})
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				return wrapError("监听PVC", err)
+			}
+			//原ctx已超时,诊断使用新的ctx,ApiServer无响应时不能一直阻塞
+			diagCtx, cancel := context.WithTimeout(context.Background(), pvcDiagnoseTimeout)
+			diagnosis, diagErr := diagnosePVC(diagCtx, clientSet, pvc)
+			cancel()
+			if diagErr != nil {
+				fmt.Fprintf(out, "获取诊断信息失败: %v\n", diagErr)
+			} else {
+				diagnosis.render(out)
+			}
+			return wrapError("等待PVC绑定", fmt.Errorf("PVC %s/%s 当前状态为%s: %w", namespace, name, pvc.Status.Phase, err))
+		}
+		pvc = latest
+	}
+	fmt.Fprintf(out, "PVC %s/%s 已绑定到PV %s\n", namespace, name, pvc.Spec.VolumeName)
+	return nil
+}
+
+/*
+   从pvc的ResourceVersion开始监听,状态变为Bound或watch被服务端关闭时返回最新的PVC
+*/
+func watchPVC(ctx context.Context, client typed_core_v1.PersistentVolumeClaimInterface, pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolumeClaim, error) {
+	w, err := client.Watch(ctx, meta_v1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", pvc.Name).String(),
+		ResourceVersion: pvc.ResourceVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return pvc, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return nil, k8s_errors.NewNotFound(core_v1.Resource("persistentvolumeclaims"), pvc.Name)
+			case watch.Error:
+				return nil, k8s_errors.FromObject(event.Object)
+			}
+			latest, ok := event.Object.(*core_v1.PersistentVolumeClaim)
+			if !ok || latest.Name != pvc.Name {
+				continue
+			}
+			pvc = latest
+			if pvc.Status.Phase == core_v1.ClaimBound {
+				return pvc, nil
+			}
+		}
+	}
+}
+
+/*
+   PVC未绑定时的诊断信息
+*/
+type pvcDiagnosis struct {
+	PVC          *core_v1.PersistentVolumeClaim
+	ClassName    string
+	StorageClass *storage_v1.StorageClass //未找到时为nil
+	ConsumerPods []string                 //使用该PVC的Pod
+	Volumes      []pvCandidate
+	Events       []core_v1.Event
+	Provisioners []string //找到的provisioner实现,为空表示未找到
+}
+
+/*
+   候选PV,Reasons为空表示可以与PVC绑定
+*/
+type pvCandidate struct {
+	PV      core_v1.PersistentVolume
+	Reasons []string
+}
+
+func diagnosePVC(ctx context.Context, clientSet kubernetes.Interface, pvc *core_v1.PersistentVolumeClaim) (*pvcDiagnosis, error) {
+	d := &pvcDiagnosis{PVC: pvc}
+	if pvc.Spec.StorageClassName != nil {
+		d.ClassName = *pvc.Spec.StorageClassName
+	}
+
+	classes, err := clientSet.StorageV1().StorageClasses().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取StorageClass列表", err)
+	}
+	for i := range classes.Items {
+		class := &classes.Items[i]
+		if pvc.Spec.StorageClassName == nil && class.Annotations[defaultStorageClassAnnotation] == "true" {
+			d.ClassName = class.Name
+		}
+		if class.Name == d.ClassName && d.ClassName != "" {
+			d.StorageClass = class
+		}
+	}
+
+	pvList, err := clientSet.CoreV1().PersistentVolumes().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取PV列表", err)
+	}
+	for _, pv := range pvList.Items {
+		d.Volumes = append(d.Volumes, pvCandidate{PV: pv, Reasons: pvMismatchReasons(pvc, d.ClassName, &pv)})
+	}
+
+	pods, err := clientSet.CoreV1().Pods(pvc.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	for _, pod := range pods.Items {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				d.ConsumerPods = append(d.ConsumerPods, pod.Name)
+				break
+			}
+		}
+	}
+
+	events, err := clientSet.CoreV1().Events(pvc.Namespace).List(ctx, meta_v1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "PersistentVolumeClaim",
+			"involvedObject.name": pvc.Name,
+		}.String(),
+	})
+	if err != nil {
+		return nil, wrapError("获取事件列表", err)
+	}
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind == "PersistentVolumeClaim" && event.InvolvedObject.Name == pvc.Name {
+			d.Events = append(d.Events, event)
+		}
+	}
+	sort.SliceStable(d.Events, func(i, j int) bool {
+		return eventTime(d.Events[i]).Before(eventTime(d.Events[j]))
+	})
+
+	if d.StorageClass != nil {
+		if d.Provisioners, err = findProvisioner(ctx, clientSet, d.StorageClass.Provisioner); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+/*
+   按照PV controller的匹配规则检查PV为何不能与PVC绑定
+*/
+func pvMismatchReasons(pvc *core_v1.PersistentVolumeClaim, className string, pv *core_v1.PersistentVolume) []string {
+	var reasons []string
+	if ref := pv.Spec.ClaimRef; ref != nil && (ref.Namespace != pvc.Namespace || ref.Name != pvc.Name) {
+		reasons = append(reasons, fmt.Sprintf("已被%s/%s占用", ref.Namespace, ref.Name))
+	} else if pv.Status.Phase == core_v1.VolumeReleased || pv.Status.Phase == core_v1.VolumeFailed {
+		reasons = append(reasons, fmt.Sprintf("状态为%s,需要手动清理claimRef", pv.Status.Phase))
+	}
+	if pv.Spec.StorageClassName != className {
+		reasons = append(reasons, fmt.Sprintf("StorageClass不一致(PV:%q PVC:%q)", pv.Spec.StorageClassName, className))
+	}
+	if request, ok := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]; ok {
+		capacity := pv.Spec.Capacity[core_v1.ResourceStorage]
+		if capacity.Cmp(request) < 0 {
+			reasons = append(reasons, fmt.Sprintf("容量不足(PV:%s PVC:%s)", capacity.String(), request.String()))
+		}
+	}
+	for _, mode := range pvc.Spec.AccessModes {
+		if !hasAccessMode(pv.Spec.AccessModes, mode) {
+			reasons = append(reasons, fmt.Sprintf("不支持访问模式%s", mode))
+		}
+	}
+	if volumeModeOf(pv.Spec.VolumeMode) != volumeModeOf(pvc.Spec.VolumeMode) {
+		reasons = append(reasons, fmt.Sprintf("VolumeMode不一致(PV:%s PVC:%s)", volumeModeOf(pv.Spec.VolumeMode), volumeModeOf(pvc.Spec.VolumeMode)))
+	}
+	if pvc.Spec.Selector != nil {
+		selector, err := meta_v1.LabelSelectorAsSelector(pvc.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pv.Labels)) {
+			reasons = append(reasons, "标签不满足PVC的selector")
+		}
+	}
+	return reasons
+}
+
+func hasAccessMode(modes []core_v1.PersistentVolumeAccessMode, mode core_v1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func volumeModeOf(mode *core_v1.PersistentVolumeMode) core_v1.PersistentVolumeMode {
+	if mode == nil {
+		return core_v1.PersistentVolumeFilesystem
+	}
+	return *mode
+}
+
+func eventTime(event core_v1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+/*
+   查找provisioner的实现
+   外部provisioner不会在ApiServer中注册,这里按以下约定查找:
+   kubernetes.io/前缀为内置provisioner,同名的CSIDriver,
+   以及环境变量PROVISIONER_NAME或启动参数--provisioner为该名称的Pod(如nfs-client-provisioner)
+   使用默认名称启动的provisioner没有这些参数,再根据PV的provisioned-by注解判断它是否创建过PV
+*/
+func findProvisioner(ctx context.Context, clientSet kubernetes.Interface, name string) ([]string, error) {
+	if strings.HasPrefix(name, "kubernetes.io/") {
+		return []string{"内置provisioner"}, nil
+	}
+	var found []string
+	if _, err := clientSet.StorageV1().CSIDrivers().Get(ctx, name, meta_v1.GetOptions{}); err == nil {
+		found = append(found, "CSIDriver/"+name)
+	} else if !k8s_errors.IsNotFound(err) {
+		return nil, wrapError("获取CSIDriver", err)
+	}
+	pods, err := clientSet.CoreV1().Pods(meta_v1.NamespaceAll).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	for _, pod := range pods.Items {
+		if podProvides(&pod, name) {
+			found = append(found, fmt.Sprintf("Pod/%s/%s(%s)", pod.Namespace, pod.Name, pod.Status.Phase))
+		}
+	}
+	if len(found) > 0 {
+		return found, nil
+	}
+	pvList, err := clientSet.CoreV1().PersistentVolumes().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取PV列表", err)
+	}
+	var provisioned []string
+	for _, pv := range pvList.Items {
+		if pv.Annotations[provisionedByAnnotation] == name {
+			provisioned = append(provisioned, pv.Name)
+		}
+	}
+	if len(provisioned) > 0 {
+		found = append(found, fmt.Sprintf("已创建%d个PV(%s),未找到对应的Pod", len(provisioned), provisioned[0]))
+	}
+	return found, nil
+}
+
+/*
+   支持--provisioner=name与--provisioner name两种写法,单横线同样支持
+*/
+func podProvides(pod *core_v1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "PROVISIONER_NAME" && env.Value == name {
+				return true
+			}
+		}
+		args := append(append([]string{}, container.Command...), container.Args...)
+		for i, arg := range args {
+			flag := strings.TrimLeft(arg, "-")
+			if len(flag) == len(arg) || len(arg)-len(flag) > 2 {
+				continue
+			}
+			if flag == "provisioner="+name || (flag == "provisioner" && i+1 < len(args) && args[i+1] == name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *pvcDiagnosis) render(out io.Writer) {
+	pvc := d.PVC
+	request := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+	fmt.Fprintf(out, "PVC %s/%s 状态: %s, 申请容量: %s, 访问模式: %s, StorageClass: %q\n",
+		pvc.Namespace, pvc.Name, pvc.Status.Phase, request.String(), accessModesString(pvc.Spec.AccessModes), d.ClassName)
+
+	switch {
+	case d.ClassName == "":
+		fmt.Fprintln(out, "PVC未指定StorageClass且集群中没有默认StorageClass,只能绑定storageClassName为空的PV")
+	case d.StorageClass == nil:
+		fmt.Fprintf(out, "StorageClass %s 不存在\n", d.ClassName)
+	default:
+		class := d.StorageClass
+		bindingMode := storage_v1.VolumeBindingImmediate
+		if class.VolumeBindingMode != nil {
+			bindingMode = *class.VolumeBindingMode
+		}
+		reclaimPolicy := core_v1.PersistentVolumeReclaimDelete
+		if class.ReclaimPolicy != nil {
+			reclaimPolicy = *class.ReclaimPolicy
+		}
+		fmt.Fprintf(out, "StorageClass %s: provisioner=%s, 绑定模式=%s, 回收策略=%s\n", class.Name, class.Provisioner, bindingMode, reclaimPolicy)
+		if bindingMode == storage_v1.VolumeBindingWaitForFirstConsumer {
+			if len(d.ConsumerPods) == 0 {
+				fmt.Fprintln(out, "  绑定模式为WaitForFirstConsumer,需要有使用该PVC的Pod被调度后才会绑定,当前没有Pod使用该PVC")
+			} else {
+				fmt.Fprintf(out, "  绑定模式为WaitForFirstConsumer,使用该PVC的Pod: %s\n", strings.Join(d.ConsumerPods, ", "))
+			}
+		}
+		if len(d.Provisioners) == 0 {
+			fmt.Fprintf(out, "  未找到provisioner %s 的实现,无法动态创建PV\n", class.Provisioner)
+		} else {
+			fmt.Fprintf(out, "  provisioner %s: %s\n", class.Provisioner, strings.Join(d.Provisioners, ", "))
+		}
+	}
+
+	if len(d.Volumes) == 0 {
+		fmt.Fprintln(out, "集群中没有PV")
+	} else {
+		fmt.Fprintln(out, "候选PV:")
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tCAPACITY\tACCESS MODES\tSTORAGECLASS\tSTATUS\t结果")
+		for _, candidate := range d.Volumes {
+			pv := candidate.PV
+			capacity := pv.Spec.Capacity[core_v1.ResourceStorage]
+			result := "可绑定"
+			if len(candidate.Reasons) > 0 {
+				result = strings.Join(candidate.Reasons, "; ")
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n", pv.Name, capacity.String(), accessModesString(pv.Spec.AccessModes), pv.Spec.StorageClassName, pv.Status.Phase, result)
+		}
+		w.Flush()
+	}
+
+	if len(d.Events) == 0 {
+		fmt.Fprintln(out, "没有相关事件")
+		return
+	}
+	fmt.Fprintln(out, "事件:")
+	for _, event := range d.Events {
+		fmt.Fprintf(out, "  %s  %s  %s  %s\n", eventTime(event).Format(time.RFC3339), event.Type, event.Reason, event.Message)
+	}
+}
+
+func accessModesString(modes []core_v1.PersistentVolumeAccessMode) string {
+	short := map[core_v1.PersistentVolumeAccessMode]string{
+		core_v1.ReadWriteOnce:    "RWO",
+		core_v1.ReadOnlyMany:     "ROX",
+		core_v1.ReadWriteMany:    "RWX",
+		core_v1.ReadWriteOncePod: "RWOP",
+	}
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		names = append(names, short[mode])
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPVC(phase core_v1.PersistentVolumeClaimPhase) *core_v1.PersistentVolumeClaim {
+	className := "test-storage-class"
+	return &core_v1.PersistentVolumeClaim{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-pvc", Namespace: TestNamespace},
+		Spec: core_v1.PersistentVolumeClaimSpec{
+			AccessModes: []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteMany},
+			Resources: core_v1.ResourceRequirements{
+				Requests: core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+			StorageClassName: &className,
+		},
+		Status: core_v1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func newTestPV(name, className, capacity string, modes ...core_v1.PersistentVolumeAccessMode) *core_v1.PersistentVolume {
+	return &core_v1.PersistentVolume{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		Spec: core_v1.PersistentVolumeSpec{
+			AccessModes:      modes,
+			Capacity:         core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(capacity)},
+			StorageClassName: className,
+		},
+		Status: core_v1.PersistentVolumeStatus{Phase: core_v1.VolumeAvailable},
+	}
+}
+
+func newTestStorageClass(provisioner string) *storage_v1.StorageClass {
+	mode := storage_v1.VolumeBindingWaitForFirstConsumer
+	return &storage_v1.StorageClass{
+		ObjectMeta:        meta_v1.ObjectMeta{Name: "test-storage-class"},
+		Provisioner:       provisioner,
+		VolumeBindingMode: &mode,
+	}
+}
+
+func TestWaitForPVCBound(t *testing.T) {
+	t.Run("already bound", func(t *testing.T) {
+		pvc := newTestPVC(core_v1.ClaimBound)
+		pvc.Spec.VolumeName = "test-pv"
+		cs := fake.NewSimpleClientset(pvc)
+		var out bytes.Buffer
+		if err := waitForPVCBound(context.TODO(), cs, TestNamespace, "test-pvc", &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "已绑定到PV test-pv") {
+			t.Errorf("output = %q", out.String())
+		}
+	})
+
+	t.Run("bound while watching", func(t *testing.T) {
+		cs := fake.NewSimpleClientset(newTestPVC(core_v1.ClaimPending))
+		watcher := watch.NewFake()
+		cs.PrependWatchReactor("persistentvolumeclaims", k8s_testing.DefaultWatchReactor(watcher, nil))
+		go func() {
+			watcher.Modify(newTestPVC(core_v1.ClaimPending))
+			bound := newTestPVC(core_v1.ClaimBound)
+			bound.Spec.VolumeName = "test-pv"
+			watcher.Modify(bound)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var out bytes.Buffer
+		if err := waitForPVCBound(ctx, cs, TestNamespace, "test-pvc", &out); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("resource version expired", func(t *testing.T) {
+		cs := fake.NewSimpleClientset(newTestPVC(core_v1.ClaimPending))
+		expired, resumed := watch.NewFake(), watch.NewFake()
+		watchers := []*watch.FakeWatcher{expired, resumed}
+		cs.PrependWatchReactor("persistentvolumeclaims", func(action k8s_testing.Action) (bool, watch.Interface, error) {
+			w := watchers[0]
+			watchers = watchers[1:]
+			return true, w, nil
+		})
+		go func() {
+			expired.Error(&k8s_errors.NewResourceExpired("too old resource version").ErrStatus)
+			bound := newTestPVC(core_v1.ClaimBound)
+			bound.Spec.VolumeName = "test-pv"
+			resumed.Modify(bound)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var out bytes.Buffer
+		if err := waitForPVCBound(ctx, cs, TestNamespace, "test-pvc", &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(watchers) != 0 {
+			t.Errorf("expired watch was not restarted")
+		}
+	})
+
+	t.Run("deleted while watching", func(t *testing.T) {
+		cs := fake.NewSimpleClientset(newTestPVC(core_v1.ClaimPending))
+		watcher := watch.NewFake()
+		cs.PrependWatchReactor("persistentvolumeclaims", k8s_testing.DefaultWatchReactor(watcher, nil))
+		go watcher.Delete(newTestPVC(core_v1.ClaimPending))
+		err := waitForPVCBound(context.TODO(), cs, TestNamespace, "test-pvc", &bytes.Buffer{})
+		if got := errorKindOf(err); got != ErrNotFound {
+			t.Errorf("error kind = %v, want %v", got, ErrNotFound)
+		}
+	})
+
+	t.Run("timeout with diagnosis", func(t *testing.T) {
+		objects := []runtime.Object{
+			newTestPVC(core_v1.ClaimPending),
+			newTestStorageClass("nfs-test-storage"),
+			newTestPV("test-pv", "other-class", "1Gi", core_v1.ReadWriteMany),
+			&core_v1.Event{
+				ObjectMeta:     meta_v1.ObjectMeta{Name: "test-pvc.1", Namespace: TestNamespace},
+				InvolvedObject: core_v1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "test-pvc"},
+				Type:           core_v1.EventTypeNormal,
+				Reason:         "WaitForFirstConsumer",
+				Message:        "waiting for first consumer to be created before binding",
+			},
+		}
+		cs := fake.NewSimpleClientset(objects...)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var out bytes.Buffer
+		err := waitForPVCBound(ctx, cs, TestNamespace, "test-pvc", &out)
+		if got := errorKindOf(err); got != ErrTimeout {
+			t.Fatalf("error kind = %v, want %v (err: %v)", got, ErrTimeout, err)
+		}
+		for _, want := range []string{
+			"绑定模式=WaitForFirstConsumer",
+			"当前没有Pod使用该PVC",
+			"未找到provisioner nfs-test-storage",
+			`StorageClass不一致(PV:"other-class" PVC:"test-storage-class")`,
+			"waiting for first consumer",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("diagnosis missing %q:\n%s", want, out.String())
+			}
+		}
+	})
+}
+
+func TestPVMismatchReasons(t *testing.T) {
+	block := core_v1.PersistentVolumeBlock
+	tests := []struct {
+		name  string
+		pv    func() *core_v1.PersistentVolume
+		wants []string
+	}{
+		{
+			name: "match",
+			pv: func() *core_v1.PersistentVolume {
+				return newTestPV("pv", "test-storage-class", "2Gi", core_v1.ReadWriteMany)
+			},
+		},
+		{
+			name: "too small",
+			pv: func() *core_v1.PersistentVolume {
+				return newTestPV("pv", "test-storage-class", "500Mi", core_v1.ReadWriteMany)
+			},
+			wants: []string{"容量不足(PV:500Mi PVC:1Gi)"},
+		},
+		{
+			name: "access mode",
+			pv: func() *core_v1.PersistentVolume {
+				return newTestPV("pv", "test-storage-class", "1Gi", core_v1.ReadWriteOnce)
+			},
+			wants: []string{"不支持访问模式ReadWriteMany"},
+		},
+		{
+			name: "claimed by other",
+			pv: func() *core_v1.PersistentVolume {
+				pv := newTestPV("pv", "test-storage-class", "1Gi", core_v1.ReadWriteMany)
+				pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "default", Name: "other"}
+				return pv
+			},
+			wants: []string{"已被default/other占用"},
+		},
+		{
+			name: "volume mode",
+			pv: func() *core_v1.PersistentVolume {
+				pv := newTestPV("pv", "test-storage-class", "1Gi", core_v1.ReadWriteMany)
+				pv.Spec.VolumeMode = &block
+				return pv
+			},
+			wants: []string{"VolumeMode不一致(PV:Block PVC:Filesystem)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pvMismatchReasons(newTestPVC(core_v1.ClaimPending), "test-storage-class", tt.pv())
+			if strings.Join(got, "|") != strings.Join(tt.wants, "|") {
+				t.Errorf("reasons = %v, want %v", got, tt.wants)
+			}
+		})
+	}
+}
+
+func TestFindProvisioner(t *testing.T) {
+	provisionerPod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "nfs-client-provisioner", Namespace: "kube-system"},
+		Spec: core_v1.PodSpec{Containers: []core_v1.Container{{
+			Name: "nfs-client-provisioner",
+			Env:  []core_v1.EnvVar{{Name: "PROVISIONER_NAME", Value: "nfs-test-storage"}},
+		}}},
+		Status: core_v1.PodStatus{Phase: core_v1.PodRunning},
+	}
+	argsPod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "nfs-provisioner", Namespace: "kube-system"},
+		Spec: core_v1.PodSpec{Containers: []core_v1.Container{{
+			Name:    "controller",
+			Command: []string{"/controller", "--provisioner", "nfs-test-storage", "-nfs-root", "/export"},
+		}}},
+		Status: core_v1.PodStatus{Phase: core_v1.PodRunning},
+	}
+	//controller使用默认的-provisioner时启动参数中没有名称,只能通过它创建的PV判断
+	provisionedPV := newTestPV("pvc-1", "test-storage-class", "1Gi", core_v1.ReadWriteMany)
+	provisionedPV.Annotations = map[string]string{provisionedByAnnotation: "nfs-test-storage"}
+	tests := []struct {
+		name        string
+		provisioner string
+		objects     []runtime.Object
+		want        string
+	}{
+		{"in-tree", "kubernetes.io/no-provisioner", nil, "内置provisioner"},
+		{"missing", "nfs-test-storage", nil, ""},
+		{"pod env", "nfs-test-storage", []runtime.Object{provisionerPod}, "Pod/kube-system/nfs-client-provisioner(Running)"},
+		{"pod args", "nfs-test-storage", []runtime.Object{argsPod}, "Pod/kube-system/nfs-provisioner(Running)"},
+		{"provisioned pv", "nfs-test-storage", []runtime.Object{provisionedPV}, "已创建1个PV(pvc-1),未找到对应的Pod"},
+		{"csi driver", "nfs.csi.k8s.io", []runtime.Object{&storage_v1.CSIDriver{ObjectMeta: meta_v1.ObjectMeta{Name: "nfs.csi.k8s.io"}}}, "CSIDriver/nfs.csi.k8s.io"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := findProvisioner(context.TODO(), fake.NewSimpleClientset(tt.objects...), tt.provisioner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(found, ","); got != tt.want {
+				t.Errorf("findProvisioner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var readOnlyVerbs = []string{"get", "list", "watch"}
+
+/*
+   常用资源所在的api组,其他资源需要使用 资源.组 的格式,如 certificates.cert-manager.io
+*/
+var resourceGroups = map[string]string{
+	"pods":                     "",
+	"services":                 "",
+	"endpoints":                "",
+	"configmaps":               "",
+	"secrets":                  "",
+	"namespaces":               "",
+	"serviceaccounts":          "",
+	"persistentvolumes":        "",
+	"persistentvolumeclaims":   "",
+	"nodes":                    "",
+	"events":                   "",
+	"deployments":              "apps",
+	"statefulsets":             "apps",
+	"daemonsets":               "apps",
+	"replicasets":              "apps",
+	"jobs":                     "batch",
+	"cronjobs":                 "batch",
+	"ingresses":                "networking.k8s.io",
+	"networkpolicies":          "networking.k8s.io",
+	"horizontalpodautoscalers": "autoscaling",
+	"storageclasses":           "storage.k8s.io",
+	"roles":                    "rbac.authorization.k8s.io",
+	"rolebindings":             "rbac.authorization.k8s.io",
+	"clusterroles":             "rbac.authorization.k8s.io",
+	"clusterrolebindings":      "rbac.authorization.k8s.io",
+}
+
+/*
+   解析 资源[.组] 格式,子资源使用 资源/子资源,如 pods/log、deployments.apps/scale
+*/
+func parseResource(s string) (group, resource string, err error) {
+	resource, sub := s, ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		resource, sub = s[:i], s[i:]
+	}
+	if i := strings.Index(resource, "."); i >= 0 {
+		return resource[i+1:], resource[:i] + sub, nil
+	}
+	if resource == "*" {
+		return "*", resource + sub, nil
+	}
+	group, ok := resourceGroups[resource]
+	if !ok {
+		return "", "", fmt.Errorf("无法确定资源%s所在的api组,请使用 资源.组 的格式", resource)
+	}
+	return group, resource + sub, nil
+}
+
+/*
+   根据资源列表生成授权规则,相同api组的资源合并到一条规则中
+*/
+func buildPolicyRules(verbs, resources, resourceNames []string) ([]rbac_v1.PolicyRule, error) {
+	if len(verbs) == 0 || len(resources) == 0 {
+		return nil, fmt.Errorf("需要指定动作与资源")
+	}
+	var groups []string
+	byGroup := map[string][]string{}
+	for _, r := range resources {
+		group, resource, err := parseResource(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byGroup[group]; !ok {
+			groups = append(groups, group)
+		}
+		byGroup[group] = append(byGroup[group], resource)
+	}
+	rules := make([]rbac_v1.PolicyRule, 0, len(groups))
+	for _, group := range groups {
+		rules = append(rules, rbac_v1.PolicyRule{
+			APIGroups:     []string{group},
+			Resources:     byGroup[group],
+			Verbs:         verbs,
+			ResourceNames: resourceNames,
+		})
+	}
+	return rules, nil
+}
+
+/*
+   解析绑定的主体,ServiceAccount为 namespace:名称 或 名称(使用defaultNamespace)
+*/
+func parseSubjects(serviceAccounts, users, groups []string, defaultNamespace string) ([]rbac_v1.Subject, error) {
+	var subjects []rbac_v1.Subject
+	for _, sa := range serviceAccounts {
+		namespace, name := defaultNamespace, sa
+		if i := strings.Index(sa, ":"); i >= 0 {
+			namespace, name = sa[:i], sa[i+1:]
+		}
+		if namespace == "" || name == "" {
+			return nil, fmt.Errorf("ServiceAccount格式应为 namespace:名称: %s", sa)
+		}
+		subjects = append(subjects, rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: namespace, Name: name})
+	}
+	for _, user := range users {
+		subjects = append(subjects, rbac_v1.Subject{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: user})
+	}
+	for _, group := range groups {
+		subjects = append(subjects, rbac_v1.Subject{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: group})
+	}
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("需要至少指定一个ServiceAccount、用户或组")
+	}
+	return subjects, nil
+}
+
+func createServiceAccount(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, out io.Writer) error {
+	sa := &core_v1.ServiceAccount{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace}}
+	if _, err := clientSet.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, meta_v1.CreateOptions{}); err != nil {
+		if k8s_errors.IsAlreadyExists(err) {
+			fmt.Fprintf(out, "ServiceAccount %s/%s 已存在\n", namespace, name)
+			return nil
+		}
+		return wrapError("创建ServiceAccount", err)
+	}
+	fmt.Fprintf(out, "ServiceAccount %s/%s 创建成功\n", namespace, name)
+	return nil
+}
+
+/*
+   创建Role,namespace为空时创建ClusterRole,已存在则更新规则
+*/
+func applyRole(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, rules []rbac_v1.PolicyRule, out io.Writer) error {
+	meta := meta_v1.ObjectMeta{Name: name, Namespace: namespace}
+	if namespace == "" {
+		client := clientSet.RbacV1().ClusterRoles()
+		role := &rbac_v1.ClusterRole{ObjectMeta: meta, Rules: rules}
+		if _, err := client.Create(ctx, role, meta_v1.CreateOptions{}); err != nil {
+			if !k8s_errors.IsAlreadyExists(err) {
+				return wrapError("创建ClusterRole", err)
+			}
+			if _, err := client.Update(ctx, role, meta_v1.UpdateOptions{}); err != nil {
+				return wrapError("更新ClusterRole", err)
+			}
+		}
+		fmt.Fprintf(out, "ClusterRole %s 已应用\n", name)
+		return nil
+	}
+	client := clientSet.RbacV1().Roles(namespace)
+	role := &rbac_v1.Role{ObjectMeta: meta, Rules: rules}
+	if _, err := client.Create(ctx, role, meta_v1.CreateOptions{}); err != nil {
+		if !k8s_errors.IsAlreadyExists(err) {
+			return wrapError("创建Role", err)
+		}
+		if _, err := client.Update(ctx, role, meta_v1.UpdateOptions{}); err != nil {
+			return wrapError("更新Role", err)
+		}
+	}
+	fmt.Fprintf(out, "Role %s/%s 已应用\n", namespace, name)
+	return nil
+}
+
+/*
+   创建RoleBinding,namespace为空时创建ClusterRoleBinding,已存在则更新主体
+   roleRef不可修改,修改时ApiServer会返回Invalid
+*/
+func applyBinding(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, roleRef rbac_v1.RoleRef, subjects []rbac_v1.Subject, out io.Writer) error {
+	meta := meta_v1.ObjectMeta{Name: name, Namespace: namespace}
+	if namespace == "" {
+		client := clientSet.RbacV1().ClusterRoleBindings()
+		binding := &rbac_v1.ClusterRoleBinding{ObjectMeta: meta, RoleRef: roleRef, Subjects: subjects}
+		if _, err := client.Create(ctx, binding, meta_v1.CreateOptions{}); err != nil {
+			if !k8s_errors.IsAlreadyExists(err) {
+				return wrapError("创建ClusterRoleBinding", err)
+			}
+			if _, err := client.Update(ctx, binding, meta_v1.UpdateOptions{}); err != nil {
+				return wrapError("更新ClusterRoleBinding", err)
+			}
+		}
+		fmt.Fprintf(out, "ClusterRoleBinding %s 已应用\n", name)
+		return nil
+	}
+	client := clientSet.RbacV1().RoleBindings(namespace)
+	binding := &rbac_v1.RoleBinding{ObjectMeta: meta, RoleRef: roleRef, Subjects: subjects}
+	if _, err := client.Create(ctx, binding, meta_v1.CreateOptions{}); err != nil {
+		if !k8s_errors.IsAlreadyExists(err) {
+			return wrapError("创建RoleBinding", err)
+		}
+		if _, err := client.Update(ctx, binding, meta_v1.UpdateOptions{}); err != nil {
+			return wrapError("更新RoleBinding", err)
+		}
+	}
+	fmt.Fprintf(out, "RoleBinding %s/%s 已应用\n", namespace, name)
+	return nil
+}
+
+/*
+   集群中的RBAC对象,who-can基于这些对象离线计算
+*/
+type rbacObjects struct {
+	Roles               []rbac_v1.Role
+	ClusterRoles        []rbac_v1.ClusterRole
+	RoleBindings        []rbac_v1.RoleBinding
+	ClusterRoleBindings []rbac_v1.ClusterRoleBinding
+}
+
+func listRBAC(ctx context.Context, clientSet kubernetes.Interface, namespace string) (*rbacObjects, error) {
+	objects := &rbacObjects{}
+	clusterRoles, err := clientSet.RbacV1().ClusterRoles().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取ClusterRole列表", err)
+	}
+	objects.ClusterRoles = clusterRoles.Items
+	clusterBindings, err := clientSet.RbacV1().ClusterRoleBindings().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取ClusterRoleBinding列表", err)
+	}
+	objects.ClusterRoleBindings = clusterBindings.Items
+	if namespace == "" {
+		return objects, nil
+	}
+	roles, err := clientSet.RbacV1().Roles(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取Role列表", err)
+	}
+	objects.Roles = roles.Items
+	bindings, err := clientSet.RbacV1().RoleBindings(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, wrapError("获取RoleBinding列表", err)
+	}
+	objects.RoleBindings = bindings.Items
+	return objects, nil
+}
+
+/*
+   访问请求,Namespace为空表示集群级资源或所有命名空间
+*/
+type accessRequest struct {
+	Verb         string
+	Group        string
+	Resource     string
+	ResourceName string
+	Namespace    string
+}
+
+/*
+   主体通过某个绑定获得的权限
+*/
+type grant struct {
+	Subject rbac_v1.Subject
+	Binding string //如 RoleBinding test-namespace/read
+	Role    string //如 ClusterRole view
+}
+
+/*
+   计算可以执行请求的主体
+   ClusterRoleBinding在所有命名空间生效,RoleBinding只在其所在命名空间生效
+*/
+func whoCan(objects *rbacObjects, req accessRequest) []grant {
+	var grants []grant
+	for _, binding := range objects.ClusterRoleBindings {
+		if binding.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+		if rules, ok := objects.clusterRoleRules(binding.RoleRef.Name); ok && rulesAllow(rules, req) {
+			for _, subject := range binding.Subjects {
+				grants = append(grants, grant{Subject: subject, Binding: "ClusterRoleBinding " + binding.Name, Role: "ClusterRole " + binding.RoleRef.Name})
+			}
+		}
+	}
+	for _, binding := range objects.RoleBindings {
+		if req.Namespace == "" || binding.Namespace != req.Namespace {
+			continue
+		}
+		var rules []rbac_v1.PolicyRule
+		ok := false
+		switch binding.RoleRef.Kind {
+		case "ClusterRole":
+			rules, ok = objects.clusterRoleRules(binding.RoleRef.Name)
+		case "Role":
+			rules, ok = objects.roleRules(binding.Namespace, binding.RoleRef.Name)
+		}
+		if ok && rulesAllow(rules, req) {
+			for _, subject := range binding.Subjects {
+				if subject.Kind == rbac_v1.ServiceAccountKind && subject.Namespace == "" {
+					subject.Namespace = binding.Namespace
+				}
+				grants = append(grants, grant{Subject: subject, Binding: "RoleBinding " + binding.Namespace + "/" + binding.Name, Role: binding.RoleRef.Kind + " " + binding.RoleRef.Name})
+			}
+		}
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		a, b := grants[i].Subject, grants[j].Subject
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return grants
+}
+
+func (o *rbacObjects) clusterRoleRules(name string) ([]rbac_v1.PolicyRule, bool) {
+	for _, role := range o.ClusterRoles {
+		if role.Name == name {
+			return role.Rules, true
+		}
+	}
+	return nil, false
+}
+
+func (o *rbacObjects) roleRules(namespace, name string) ([]rbac_v1.PolicyRule, bool) {
+	for _, role := range o.Roles {
+		if role.Namespace == namespace && role.Name == name {
+			return role.Rules, true
+		}
+	}
+	return nil, false
+}
+
+func rulesAllow(rules []rbac_v1.PolicyRule, req accessRequest) bool {
+	for _, rule := range rules {
+		if ruleAllows(rule, req) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+   与ApiServer的RBAC授权器一致: 动作、api组、资源都需要匹配,resourceNames为空时匹配所有名称
+*/
+func ruleAllows(rule rbac_v1.PolicyRule, req accessRequest) bool {
+	if !containsOrStar(rule.Verbs, req.Verb) || !containsOrStar(rule.APIGroups, req.Group) {
+		return false
+	}
+	resourceMatched := false
+	for _, r := range rule.Resources {
+		if r == rbac_v1.ResourceAll || r == req.Resource {
+			resourceMatched = true
+			break
+		}
+		//*/scale 匹配所有资源的scale子资源
+		if strings.HasPrefix(r, "*/") && strings.Contains(req.Resource, "/") && strings.HasSuffix(req.Resource, r[1:]) {
+			resourceMatched = true
+			break
+		}
+	}
+	if !resourceMatched {
+		return false
+	}
+	if len(rule.ResourceNames) == 0 {
+		return true
+	}
+	return req.ResourceName != "" && containsOrStar(rule.ResourceNames, req.ResourceName)
+}
+
+func containsOrStar(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func printGrants(out io.Writer, req accessRequest, grants []grant) {
+	if len(grants) == 0 {
+		fmt.Fprintf(out, "没有主体可以%s\n", describeRequest(req))
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tBINDING\tROLE")
+	for _, g := range grants {
+		namespace := g.Subject.Namespace
+		if namespace == "" {
+			namespace = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.Subject.Kind, namespace, g.Subject.Name, g.Binding, g.Role)
+	}
+	w.Flush()
+}
+
+func describeRequest(req accessRequest) string {
+	resource := req.Resource
+	if req.Group != "" {
+		resource += "." + req.Group
+	}
+	if req.ResourceName != "" {
+		resource += "/" + req.ResourceName
+	}
+	if req.Namespace == "" {
+		return fmt.Sprintf("%s %s", req.Verb, resource)
+	}
+	return fmt.Sprintf("在%s中%s %s", req.Namespace, req.Verb, resource)
+}
+
+/*
+   逗号分隔的列表,忽略空项
+*/
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runCreateServiceAccount(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("create-sa", out)
+	namespace := fs.String("namespace", activeProfile.Namespace, "命名空间")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: create-sa [-namespace ns] <名称>")
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return createServiceAccount(ctx, clientSet, *namespace, fs.Arg(0), out)
+}
+
+func runCreateRole(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("create-role", out)
+	namespace := fs.String("namespace", activeProfile.Namespace, "Role所在的命名空间")
+	cluster := fs.Bool("cluster", false, "创建ClusterRole")
+	verbs := fs.String("verb", "", "允许的动作,逗号分隔,如 get,list,watch")
+	readOnly := fs.Bool("read-only", false, "只读权限,等同于 -verb get,list,watch")
+	resources := fs.String("resource", "", "资源,逗号分隔,如 deployments,pods/log,certificates.cert-manager.io")
+	resourceNames := fs.String("resource-name", "", "限定资源名称,逗号分隔")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: create-role [-cluster] [-read-only | -verb v1,v2] -resource r1,r2 <名称>")
+	}
+	verbList := splitList(*verbs)
+	if *readOnly {
+		verbList = append(verbList, readOnlyVerbs...)
+	}
+	rules, err := buildPolicyRules(verbList, splitList(*resources), splitList(*resourceNames))
+	if err != nil {
+		return usageError("%v", err)
+	}
+	if *cluster {
+		*namespace = ""
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return applyRole(ctx, clientSet, *namespace, fs.Arg(0), rules, out)
+}
+
+func runCreateBinding(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("create-binding", out)
+	namespace := fs.String("namespace", activeProfile.Namespace, "RoleBinding所在的命名空间")
+	cluster := fs.Bool("cluster", false, "创建ClusterRoleBinding")
+	role := fs.String("role", "", "绑定的Role")
+	clusterRole := fs.String("clusterrole", "", "绑定的ClusterRole")
+	serviceAccounts := fs.String("serviceaccount", "", "ServiceAccount,逗号分隔,格式为 namespace:名称")
+	users := fs.String("user", "", "用户,逗号分隔")
+	groups := fs.String("group", "", "组,逗号分隔")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("用法: create-binding [-cluster] -role r | -clusterrole cr [-serviceaccount ns:sa] [-user u] [-group g] <名称>")
+	}
+	if (*role == "") == (*clusterRole == "") {
+		return usageError("-role与-clusterrole需要且只能指定一个")
+	}
+	if *cluster && *role != "" {
+		return usageError("ClusterRoleBinding只能绑定ClusterRole")
+	}
+	roleRef := rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: *clusterRole}
+	if *role != "" {
+		roleRef.Kind, roleRef.Name = "Role", *role
+	}
+	if *cluster {
+		*namespace = ""
+	}
+	subjects, err := parseSubjects(splitList(*serviceAccounts), splitList(*users), splitList(*groups), *namespace)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	return applyBinding(ctx, clientSet, *namespace, fs.Arg(0), roleRef, subjects, out)
+}
+
+func runWhoCan(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("who-can", out)
+	namespace := fs.String("namespace", activeProfile.Namespace, "命名空间,为空时只计算ClusterRoleBinding")
+	resourceName := fs.String("resource-name", "", "资源名称")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("用法: who-can [-namespace ns] [-resource-name n] <动作> <资源>")
+	}
+	group, resource, err := parseResource(fs.Arg(1))
+	if err != nil {
+		return usageError("%v", err)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	objects, err := listRBAC(ctx, clientSet, *namespace)
+	if err != nil {
+		return err
+	}
+	req := accessRequest{Verb: fs.Arg(0), Group: group, Resource: resource, ResourceName: *resourceName, Namespace: *namespace}
+	printGrants(out, req, whoCan(objects, req))
+	return nil
+}
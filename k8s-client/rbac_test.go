@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		in           string
+		wantGroup    string
+		wantResource string
+		wantErr      bool
+	}{
+		{in: "pods", wantGroup: "", wantResource: "pods"},
+		{in: "pods/log", wantGroup: "", wantResource: "pods/log"},
+		{in: "deployments", wantGroup: "apps", wantResource: "deployments"},
+		{in: "deployments.apps/scale", wantGroup: "apps", wantResource: "deployments/scale"},
+		{in: "certificates.cert-manager.io", wantGroup: "cert-manager.io", wantResource: "certificates"},
+		{in: "*", wantGroup: "*", wantResource: "*"},
+		{in: "certificates", wantErr: true},
+	}
+	for _, tt := range tests {
+		group, resource, err := parseResource(tt.in)
+		if (err != nil) != tt.wantErr || group != tt.wantGroup || resource != tt.wantResource {
+			t.Errorf("parseResource(%q) = %q, %q, %v", tt.in, group, resource, err)
+		}
+	}
+}
+
+func TestBuildPolicyRules(t *testing.T) {
+	rules, err := buildPolicyRules(readOnlyVerbs, []string{"deployments", "pods", "statefulsets"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rbac_v1.PolicyRule{
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets"}, Verbs: readOnlyVerbs},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: readOnlyVerbs},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("buildPolicyRules() = %+v", rules)
+	}
+	if _, err := buildPolicyRules(nil, []string{"pods"}, nil); err == nil {
+		t.Error("expected error without verbs")
+	}
+}
+
+func TestApplyRBAC(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	var out bytes.Buffer
+	rules, _ := buildPolicyRules(readOnlyVerbs, []string{"deployments"}, nil)
+	if err := applyRole(context.TODO(), clientSet, TestNamespace, "deployment-reader", rules, &out); err != nil {
+		t.Fatal(err)
+	}
+	rules, _ = buildPolicyRules(readOnlyVerbs, []string{"deployments", "pods"}, nil)
+	if err := applyRole(context.TODO(), clientSet, TestNamespace, "deployment-reader", rules, &out); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	role, err := clientSet.RbacV1().Roles(TestNamespace).Get(context.TODO(), "deployment-reader", meta_v1.GetOptions{})
+	if err != nil || len(role.Rules) != 2 {
+		t.Fatalf("role = %+v, err %v", role, err)
+	}
+
+	subjects, err := parseSubjects([]string{"ci", "kube-system:deployer"}, []string{"alice"}, nil, TestNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleRef := rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "Role", Name: "deployment-reader"}
+	if err := applyBinding(context.TODO(), clientSet, TestNamespace, "deployment-reader", roleRef, subjects, &out); err != nil {
+		t.Fatal(err)
+	}
+	binding, err := clientSet.RbacV1().RoleBindings(TestNamespace).Get(context.TODO(), "deployment-reader", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.Subjects[0].Namespace != TestNamespace || binding.Subjects[1].Namespace != "kube-system" || binding.Subjects[2].Kind != rbac_v1.UserKind {
+		t.Errorf("subjects = %+v", binding.Subjects)
+	}
+	if err := createServiceAccount(context.TODO(), clientSet, TestNamespace, "ci", &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := createServiceAccount(context.TODO(), clientSet, TestNamespace, "ci", &out); err != nil {
+		t.Errorf("existing ServiceAccount: %v", err)
+	}
+}
+
+func TestWhoCan(t *testing.T) {
+	objects := &rbacObjects{
+		ClusterRoles: []rbac_v1.ClusterRole{
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "cluster-admin"}, Rules: []rbac_v1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}}},
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "view"}, Rules: []rbac_v1.PolicyRule{{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: readOnlyVerbs}}},
+		},
+		Roles: []rbac_v1.Role{
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "nginx-editor", Namespace: TestNamespace}, Rules: []rbac_v1.PolicyRule{
+				{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"update"}, ResourceNames: []string{"test-nginx"}},
+			}},
+		},
+		ClusterRoleBindings: []rbac_v1.ClusterRoleBinding{
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "admins"}, RoleRef: rbac_v1.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"},
+				Subjects: []rbac_v1.Subject{{Kind: rbac_v1.GroupKind, Name: "system:masters"}}},
+		},
+		RoleBindings: []rbac_v1.RoleBinding{
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "viewers", Namespace: TestNamespace}, RoleRef: rbac_v1.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects: []rbac_v1.Subject{{Kind: rbac_v1.ServiceAccountKind, Name: "ci"}}},
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "editors", Namespace: TestNamespace}, RoleRef: rbac_v1.RoleRef{Kind: "Role", Name: "nginx-editor"},
+				Subjects: []rbac_v1.Subject{{Kind: rbac_v1.UserKind, Name: "alice"}}},
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "viewers", Namespace: "other"}, RoleRef: rbac_v1.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects: []rbac_v1.Subject{{Kind: rbac_v1.UserKind, Name: "bob"}}},
+		},
+	}
+	names := func(grants []grant) string {
+		var s []string
+		for _, g := range grants {
+			s = append(s, g.Subject.Kind+":"+g.Subject.Namespace+"/"+g.Subject.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	tests := []struct {
+		name string
+		req  accessRequest
+		want string
+	}{
+		{
+			name: "list deployments",
+			req:  accessRequest{Verb: "list", Group: "apps", Resource: "deployments", Namespace: TestNamespace},
+			want: "Group:/system:masters,ServiceAccount:test-namespace/ci",
+		},
+		{
+			name: "update named deployment",
+			req:  accessRequest{Verb: "update", Group: "apps", Resource: "deployments", ResourceName: "test-nginx", Namespace: TestNamespace},
+			want: "Group:/system:masters,User:/alice",
+		},
+		{
+			name: "update any deployment",
+			req:  accessRequest{Verb: "update", Group: "apps", Resource: "deployments", Namespace: TestNamespace},
+			want: "Group:/system:masters",
+		},
+		{
+			name: "cluster scope ignores role bindings",
+			req:  accessRequest{Verb: "list", Group: "apps", Resource: "deployments"},
+			want: "Group:/system:masters",
+		},
+		{
+			name: "subresource needs its own rule",
+			req:  accessRequest{Verb: "get", Group: "apps", Resource: "deployments/scale", Namespace: TestNamespace},
+			want: "Group:/system:masters",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(whoCan(objects, tt.req)); got != tt.want {
+				t.Errorf("whoCan() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	printGrants(&out, accessRequest{Verb: "delete", Resource: "nodes"}, nil)
+	if out.String() != "没有主体可以delete nodes\n" {
+		t.Errorf("printGrants() = %q", out.String())
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	autoscaling_v1 "k8s.io/api/autoscaling/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"strings"
+	"time"
+)
+
+/*
+   与kubectl rollout restart使用相同的注解,修改Pod模板触发滚动更新
+*/
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+//等待发布完成时查询状态的间隔
+var rolloutPollInterval = 2 * time.Second
+
+/*
+   从参数中解析工作负载类型与名称,未指定名称时使用清单中的名称
+*/
+func parseWorkloadTarget(args []string) (string, string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", "", usageError("需要指定资源类型与可选的名称")
+	}
+	kind, ops, err := parseKind(args[:1])
+	if err != nil {
+		return "", "", err
+	}
+	if len(args) == 2 {
+		return kind, args[1], nil
+	}
+	name, err := manifestName(ops.manifest)
+	return kind, name, err
+}
+
+/*
+   通过scale子资源修改副本数,只修改副本数不会与其他字段的更新冲突
+*/
+func scaleWorkload(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, replicas int32, out io.Writer) error {
+	var get func() (*autoscaling_v1.Scale, error)
+	var update func(*autoscaling_v1.Scale) (*autoscaling_v1.Scale, error)
+	switch kind {
+	case "deployment":
+		client := clientSet.AppsV1().Deployments(namespace)
+		get = func() (*autoscaling_v1.Scale, error) {
+			return client.GetScale(ctx, name, meta_v1.GetOptions{})
+		}
+		update = func(scale *autoscaling_v1.Scale) (*autoscaling_v1.Scale, error) {
+			return client.UpdateScale(ctx, name, scale, meta_v1.UpdateOptions{})
+		}
+	case "statefulset":
+		client := clientSet.AppsV1().StatefulSets(namespace)
+		get = func() (*autoscaling_v1.Scale, error) {
+			return client.GetScale(ctx, name, meta_v1.GetOptions{})
+		}
+		update = func(scale *autoscaling_v1.Scale) (*autoscaling_v1.Scale, error) {
+			return client.UpdateScale(ctx, name, scale, meta_v1.UpdateOptions{})
+		}
+	default:
+		return usageError("%s 不支持伸缩", kind)
+	}
+	var previous int32
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		scale, err := get()
+		if err != nil {
+			return err
+		}
+		previous = scale.Spec.Replicas
+		scale.Spec.Replicas = replicas
+		_, err = update(scale)
+		return err
+	})
+	if err != nil {
+		return wrapError("修改副本数", err)
+	}
+	fmt.Fprintf(out, "%s %s/%s 副本数 %d -> %d\n", kind, namespace, name, previous, replicas)
+	return nil
+}
+
+/*
+   修改工作负载的Pod模板,冲突时重新获取后重试
+*/
+func updatePodTemplate(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, mutate func(*core_v1.PodTemplateSpec) error) error {
+	var err error
+	var action string
+	switch kind {
+	case "deployment":
+		action = "更新Deployment"
+		client := clientSet.AppsV1().Deployments(namespace)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := client.Get(ctx, name, meta_v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := mutate(&obj.Spec.Template); err != nil {
+				return err
+			}
+			_, err = client.Update(ctx, obj, meta_v1.UpdateOptions{})
+			return err
+		})
+	case "statefulset":
+		action = "更新StatefulSet"
+		client := clientSet.AppsV1().StatefulSets(namespace)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := client.Get(ctx, name, meta_v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := mutate(&obj.Spec.Template); err != nil {
+				return err
+			}
+			_, err = client.Update(ctx, obj, meta_v1.UpdateOptions{})
+			return err
+		})
+	case "daemonset":
+		action = "更新DaemonSet"
+		client := clientSet.AppsV1().DaemonSets(namespace)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := client.Get(ctx, name, meta_v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := mutate(&obj.Spec.Template); err != nil {
+				return err
+			}
+			_, err = client.Update(ctx, obj, meta_v1.UpdateOptions{})
+			return err
+		})
+	default:
+		return usageError("%s 没有可滚动更新的Pod模板", kind)
+	}
+	return wrapError(action, err)
+}
+
+/*
+   重启工作负载的所有Pod
+*/
+func restartWorkload(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, now time.Time, out io.Writer) error {
+	err := updatePodTemplate(ctx, clientSet, kind, namespace, name, func(template *core_v1.PodTemplateSpec) error {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[restartedAtAnnotation] = now.Format(time.RFC3339)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s %s/%s 已重启\n", kind, namespace, name)
+	return nil
+}
+
+/*
+   暂停或恢复Deployment的发布,暂停期间对Pod模板的修改不会触发滚动更新
+*/
+func setDeploymentPaused(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, paused bool, out io.Writer) error {
+	client := clientSet.AppsV1().Deployments(namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := client.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if deployment.Spec.Paused == paused {
+			return nil
+		}
+		deployment.Spec.Paused = paused
+		_, err = client.Update(ctx, deployment, meta_v1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return wrapError("更新Deployment", err)
+	}
+	if paused {
+		fmt.Fprintf(out, "deployment %s/%s 已暂停\n", namespace, name)
+	} else {
+		fmt.Fprintf(out, "deployment %s/%s 已恢复\n", namespace, name)
+	}
+	return nil
+}
+
+/*
+   解析 容器名=镜像 格式,容器名为*时修改所有容器
+*/
+func parseImageUpdates(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("需要指定 容器名=镜像")
+	}
+	images := map[string]string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("格式应为 容器名=镜像: %s", arg)
+		}
+		images[parts[0]] = parts[1]
+	}
+	return images, nil
+}
+
+/*
+   修改容器镜像,包括初始化容器
+*/
+func setImages(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, images map[string]string, out io.Writer) error {
+	var changes []string
+	err := updatePodTemplate(ctx, clientSet, kind, namespace, name, func(template *core_v1.PodTemplateSpec) error {
+		changes = nil
+		found := map[string]bool{}
+		update := func(containers []core_v1.Container) {
+			for i := range containers {
+				image, ok := images[containers[i].Name]
+				if !ok {
+					image, ok = images["*"]
+				}
+				if !ok {
+					continue
+				}
+				found[containers[i].Name] = true
+				if containers[i].Image != image {
+					changes = append(changes, fmt.Sprintf("%s: %s -> %s", containers[i].Name, containers[i].Image, image))
+					containers[i].Image = image
+				}
+			}
+		}
+		update(template.Spec.InitContainers)
+		update(template.Spec.Containers)
+		for container := range images {
+			if container != "*" && !found[container] {
+				return &clientError{Kind: ErrNotFound, Action: "修改镜像", Err: fmt.Errorf("%s %s/%s 中没有容器%s", kind, namespace, name, container)}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(out, "%s %s/%s 镜像未变化\n", kind, namespace, name)
+	}
+	for _, change := range changes {
+		fmt.Fprintf(out, "%s %s/%s 容器%s\n", kind, namespace, name, change)
+	}
+	return nil
+}
+
+/*
+   等待工作负载发布完成,每次状态变化时输出进度
+*/
+func waitForRollout(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, out io.Writer) error {
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		s, err := getWorkloadStatus(ctx, clientSet, kind, namespace, name)
+		if err != nil {
+			return err
+		}
+		if progress := s.state() + ", " + s.Summary; progress != last {
+			fmt.Fprintf(out, "%s %s/%s: %s\n", kind, namespace, name, progress)
+			last = progress
+		}
+		switch {
+		case s.Failed:
+			return &clientError{Kind: ErrUnknown, Action: "等待发布", Err: fmt.Errorf("%s %s/%s 发布失败: %s", kind, namespace, name, s.Summary)}
+		case s.Ready:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return wrapError("等待发布", fmt.Errorf("%s %s/%s 未完成发布: %w", kind, namespace, name, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+   -wait与-timeout参数,指定-wait时在操作完成后等待发布完成,wait为-wait的默认值
+*/
+type waitFlags struct {
+	wait    *bool
+	timeout *time.Duration
+}
+
+func addWaitFlags(fs *flag.FlagSet, wait bool) waitFlags {
+	return waitFlags{
+		wait:    fs.Bool("wait", wait, "等待发布完成"),
+		timeout: fs.Duration("timeout", 5*time.Minute, "等待超时时间"),
+	}
+}
+
+func (w waitFlags) run(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string, out io.Writer) error {
+	if !*w.wait {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, *w.timeout)
+	defer cancel()
+	return waitForRollout(ctx, clientSet, kind, namespace, name, out)
+}
+
+func runScale(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("scale", out)
+	replicas := fs.Int("replicas", -1, "副本数")
+	wait := addWaitFlags(fs, false)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *replicas < 0 {
+		return usageError("需要指定-replicas")
+	}
+	kind, name, err := parseWorkloadTarget(fs.Args())
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := scaleWorkload(ctx, clientSet, kind, activeProfile.Namespace, name, int32(*replicas), out); err != nil {
+		return err
+	}
+	return wait.run(ctx, clientSet, kind, activeProfile.Namespace, name, out)
+}
+
+/*
+   rollout <操作> <资源类型> [名称]
+*/
+func runRollout(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("用法: rollout restart|pause|resume|status|history|undo <资源类型> [名称]")
+	}
+	action := args[0]
+	fs := newFlagSet("rollout "+action, out)
+	var wait waitFlags
+	var toRevision *int64
+	switch action {
+	case "restart", "resume":
+		wait = addWaitFlags(fs, false)
+	case "undo":
+		wait = addWaitFlags(fs, true)
+		toRevision = fs.Int64("to-revision", 0, "回滚到的版本,默认为上一个版本")
+	case "pause", "status", "history":
+	default:
+		return usageError("未知的rollout操作: %s", action)
+	}
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	kind, name, err := parseWorkloadTarget(fs.Args())
+	if err != nil {
+		return err
+	}
+	if (action == "pause" || action == "resume" || action == "history" || action == "undo") && kind != "deployment" {
+		return usageError("只有deployment支持%s", action)
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "restart":
+		err = restartWorkload(ctx, clientSet, kind, activeProfile.Namespace, name, time.Now(), out)
+	case "pause":
+		return setDeploymentPaused(ctx, clientSet, activeProfile.Namespace, name, true, out)
+	case "resume":
+		err = setDeploymentPaused(ctx, clientSet, activeProfile.Namespace, name, false, out)
+	case "history":
+		return rolloutHistory(ctx, clientSet, activeProfile.Namespace, name, out)
+	case "undo":
+		changed, err := undoDeployment(ctx, clientSet, activeProfile.Namespace, name, *toRevision, out)
+		if err != nil || !changed {
+			return err
+		}
+	case "status":
+		s, err := getWorkloadStatus(ctx, clientSet, kind, activeProfile.Namespace, name)
+		if err != nil {
+			return err
+		}
+		printWorkloadStatus(out, kind, activeProfile.Namespace+"/"+name, s)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.run(ctx, clientSet, kind, activeProfile.Namespace, name, out)
+}
+
+/*
+   set image [-wait] <资源类型> [名称] 容器名=镜像...
+*/
+func runSet(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "image" {
+		return usageError("用法: set image <资源类型> [名称] 容器名=镜像...")
+	}
+	fs := newFlagSet("set image", out)
+	wait := addWaitFlags(fs, false)
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	var target, updates []string
+	for _, arg := range fs.Args() {
+		if strings.Contains(arg, "=") {
+			updates = append(updates, arg)
+		} else {
+			target = append(target, arg)
+		}
+	}
+	images, err := parseImageUpdates(updates)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	kind, name, err := parseWorkloadTarget(target)
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := setImages(ctx, clientSet, kind, activeProfile.Namespace, name, images, out); err != nil {
+		return err
+	}
+	return wait.run(ctx, clientSet, kind, activeProfile.Namespace, name, out)
+}
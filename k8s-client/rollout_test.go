@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v1 "k8s.io/api/autoscaling/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRolloutDeployment() *apps_v1.Deployment {
+	d := newTestDeployment(2, 2, 2, 2)
+	d.Spec.Template.Spec.InitContainers = []core_v1.Container{{Name: "init", Image: "busybox:1.35"}}
+	d.Spec.Template.Spec.Containers = []core_v1.Container{
+		{Name: "test-nginx-container", Image: "nginx:1.21"},
+		{Name: "sidecar", Image: "busybox:1.35"},
+	}
+	return d
+}
+
+/*
+   fake clientset的对象存储不支持scale子资源,用reactor模拟
+*/
+func scaleReactor(replicas *int32) k8s_testing.ReactionFunc {
+	return func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		if update, ok := action.(k8s_testing.UpdateAction); ok {
+			*replicas = update.GetObject().(*autoscaling_v1.Scale).Spec.Replicas
+		}
+		return true, &autoscaling_v1.Scale{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace},
+			Spec:       autoscaling_v1.ScaleSpec{Replicas: *replicas},
+		}, nil
+	}
+}
+
+func TestScaleWorkload(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	replicas := int32(2)
+	clientSet.PrependReactor("*", "deployments", scaleReactor(&replicas))
+	var out bytes.Buffer
+	if err := scaleWorkload(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx", 5, &out); err != nil {
+		t.Fatal(err)
+	}
+	if replicas != 5 || out.String() != "deployment test-namespace/test-nginx 副本数 2 -> 5\n" {
+		t.Errorf("replicas = %d, output %q", replicas, out.String())
+	}
+	if err := scaleWorkload(context.TODO(), clientSet, "daemonset", TestNamespace, "test-daemonset", 5, &out); errorKindOf(err) != ErrUsage {
+		t.Errorf("daemonset scale error = %v", err)
+	}
+}
+
+func TestRestartWorkload(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newRolloutDeployment())
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := restartWorkload(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx", now, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := clientSet.AppsV1().Deployments(TestNamespace).Get(context.TODO(), "test-nginx", meta_v1.GetOptions{})
+	if got := d.Spec.Template.Annotations[restartedAtAnnotation]; got != "2022-01-02T03:04:05Z" {
+		t.Errorf("restartedAt = %q", got)
+	}
+	if err := restartWorkload(context.TODO(), clientSet, "statefulset", TestNamespace, "missing", now, &bytes.Buffer{}); errorKindOf(err) != ErrNotFound {
+		t.Errorf("missing statefulset error = %v", err)
+	}
+}
+
+func TestSetDeploymentPaused(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newRolloutDeployment())
+	var out bytes.Buffer
+	if err := setDeploymentPaused(context.TODO(), clientSet, TestNamespace, "test-nginx", true, &out); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := clientSet.AppsV1().Deployments(TestNamespace).Get(context.TODO(), "test-nginx", meta_v1.GetOptions{})
+	if !d.Spec.Paused {
+		t.Error("deployment not paused")
+	}
+	if s := deploymentStatus(d); s.Ready || !strings.HasPrefix(s.Summary, "已暂停") {
+		t.Errorf("paused status = %s %q", s.state(), s.Summary)
+	}
+}
+
+func TestSetImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   map[string]string
+		want     []string
+		wantKind errorKind
+	}{
+		{
+			name:   "single container",
+			images: map[string]string{"test-nginx-container": "nginx:1.23"},
+			want:   []string{"busybox:1.35", "nginx:1.23", "busybox:1.35"},
+		},
+		{
+			name:   "all containers",
+			images: map[string]string{"*": "busybox:1.36"},
+			want:   []string{"busybox:1.36", "busybox:1.36", "busybox:1.36"},
+		},
+		{
+			name:     "unknown container",
+			images:   map[string]string{"web": "nginx:1.23"},
+			want:     []string{"busybox:1.35", "nginx:1.21", "busybox:1.35"},
+			wantKind: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(newRolloutDeployment())
+			err := setImages(context.TODO(), clientSet, "deployment", TestNamespace, "test-nginx", tt.images, &bytes.Buffer{})
+			if tt.wantKind != ErrUnknown {
+				if errorKindOf(err) != tt.wantKind {
+					t.Errorf("error = %v, want kind %v", err, tt.wantKind)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			d, _ := clientSet.AppsV1().Deployments(TestNamespace).Get(context.TODO(), "test-nginx", meta_v1.GetOptions{})
+			spec := d.Spec.Template.Spec
+			got := []string{spec.InitContainers[0].Image, spec.Containers[0].Image, spec.Containers[1].Image}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("images = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := parseImageUpdates([]string{"nginx"}); err == nil {
+		t.Error("expected error for missing image")
+	}
+}
+
+func TestWaitForRollout(t *testing.T) {
+	interval := rolloutPollInterval
+	rolloutPollInterval = time.Millisecond
+	defer func() { rolloutPollInterval = interval }()
+
+	/*
+	   每次查询返回states中的下一个状态
+	*/
+	sequence := func(states ...*apps_v1.Deployment) k8s_testing.ReactionFunc {
+		i := 0
+		return func(action k8s_testing.Action) (bool, runtime.Object, error) {
+			d := states[i]
+			if i < len(states)-1 {
+				i++
+			}
+			return true, d, nil
+		}
+	}
+	failed := newTestDeployment(2, 1, 1, 2)
+	failed.Status.Conditions = []apps_v1.DeploymentCondition{
+		{Type: apps_v1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded", Message: "ReplicaSet has timed out progressing."},
+	}
+	tests := []struct {
+		name     string
+		states   []*apps_v1.Deployment
+		timeout  time.Duration
+		wantErr  bool
+		wantKind errorKind
+		wantLine int
+	}{
+		{
+			name:     "completes",
+			states:   []*apps_v1.Deployment{newTestDeployment(2, 1, 1, 3), newTestDeployment(2, 2, 1, 3), newTestDeployment(2, 2, 2, 2)},
+			timeout:  time.Second,
+			wantLine: 3,
+		},
+		{
+			name:     "progress deadline exceeded",
+			states:   []*apps_v1.Deployment{newTestDeployment(2, 1, 1, 3), failed},
+			timeout:  time.Second,
+			wantErr:  true,
+			wantKind: ErrUnknown,
+			wantLine: 2,
+		},
+		{
+			name:     "timeout",
+			states:   []*apps_v1.Deployment{newTestDeployment(2, 1, 1, 3)},
+			timeout:  20 * time.Millisecond,
+			wantErr:  true,
+			wantKind: ErrTimeout,
+			wantLine: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset()
+			clientSet.PrependReactor("get", "deployments", sequence(tt.states...))
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			var out bytes.Buffer
+			err := waitForRollout(ctx, clientSet, "deployment", TestNamespace, "test-nginx", &out)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || errorKindOf(err) != tt.wantKind {
+				t.Errorf("error = %v, want kind %v", err, tt.wantKind)
+			}
+			if lines := strings.Count(out.String(), "\n"); lines != tt.wantLine {
+				t.Errorf("got %d progress lines, want %d:\n%s", lines, tt.wantLine, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"net"
+	"net/http"
+	sigs_yaml "sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//token配置文件默认路径,与kubeconfig一样位于当前目录
+const defaultTokenFile = "./tokens.yaml"
+
+//默认只监听本机地址
+const defaultServeAddress = "127.0.0.1:8080"
+
+//请求体的最大长度
+const maxRequestBody = 10 << 20
+
+//SSE连接的心跳间隔,防止代理因空闲断开连接,测试时修改
+var serveHeartbeat = 15 * time.Second
+
+/*
+   REST API支持的资源类型,Name与命令行中的资源类型一致,也是URL中的类型
+*/
+type apiKind struct {
+	Name       string
+	Kind       string
+	GVR        schema.GroupVersionResource
+	Namespaced bool
+}
+
+func (k apiKind) apiVersion() string {
+	return k.GVR.GroupVersion().String()
+}
+
+var apiKinds = map[string]apiKind{}
+
+func init() {
+	for _, k := range []apiKind{
+		{"namespace", "Namespace", schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, false},
+		{"configmap", "ConfigMap", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, true},
+		{"secret", "Secret", schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, true},
+		{"deployment", "Deployment", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+		{"statefulset", "StatefulSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, true},
+		{"daemonset", "DaemonSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, true},
+		{"job", "Job", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, true},
+		{"cronjob", "CronJob", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, true},
+		{"service", "Service", schema.GroupVersionResource{Version: "v1", Resource: "services"}, true},
+		{"ingress", "Ingress", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, true},
+		{"networkpolicy", "NetworkPolicy", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}, true},
+		{"hpa", "HorizontalPodAutoscaler", schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}, true},
+		{"storageclass", "StorageClass", schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}, false},
+		{"pv", "PersistentVolume", schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}, false},
+		{"pvc", "PersistentVolumeClaim", schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, true},
+	} {
+		apiKinds[k.Name] = k
+	}
+}
+
+func apiKindNames() []string {
+	names := make([]string, 0, len(apiKinds))
+	for name := range apiKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+   根据清单中的apiVersion与kind查找资源类型
+*/
+func apiKindFor(gvk schema.GroupVersionKind) (apiKind, bool) {
+	for _, k := range apiKinds {
+		if k.Kind == gvk.Kind && k.GVR.GroupVersion() == gvk.GroupVersion() {
+			return k, true
+		}
+	}
+	return apiKind{}, false
+}
+
+/*
+   访问令牌,Namespaces为允许访问的命名空间,*表示所有命名空间以及集群级资源
+*/
+type apiToken struct {
+	Name       string   `json:"name"`
+	Token      string   `json:"token"`
+	Namespaces []string `json:"namespaces"`
+}
+
+type tokenConfig struct {
+	Tokens []apiToken `json:"tokens"`
+}
+
+/*
+   namespace为空表示集群级资源或所有命名空间,只有*可以访问
+*/
+func (t apiToken) allows(namespace string) bool {
+	for _, ns := range t.Namespaces {
+		if ns == "*" || (namespace != "" && ns == namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadTokens(path string) ([]apiToken, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, manifestError(path, err)
+	}
+	var config tokenConfig
+	if err := sigs_yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, manifestError(path, err)
+	}
+	if len(config.Tokens) == 0 {
+		return nil, manifestError(path, fmt.Errorf("没有配置token"))
+	}
+	names := map[string]bool{}
+	for i, t := range config.Tokens {
+		switch {
+		case t.Name == "" || t.Token == "":
+			return nil, manifestError(path, fmt.Errorf("第%d个token缺少name或token", i+1))
+		case names[t.Name]:
+			return nil, manifestError(path, fmt.Errorf("token名称重复: %s", t.Name))
+		case len(t.Namespaces) == 0:
+			return nil, manifestError(path, fmt.Errorf("token %s 没有允许访问的命名空间", t.Name))
+		}
+		names[t.Name] = true
+	}
+	return config.Tokens, nil
+}
+
+//错误分类对应的HTTP状态码
+var httpStatuses = map[errorKind]int{
+	ErrUnknown:           http.StatusInternalServerError,
+	ErrNotFound:          http.StatusNotFound,
+	ErrAlreadyExists:     http.StatusConflict,
+	ErrConflict:          http.StatusConflict,
+	ErrForbidden:         http.StatusForbidden,
+	ErrInvalidManifest:   http.StatusUnprocessableEntity,
+	ErrServerUnreachable: http.StatusBadGateway,
+	ErrTimeout:           http.StatusGatewayTimeout,
+	ErrUsage:             http.StatusBadRequest,
+}
+
+/*
+   错误响应,Kind为错误分类,Causes为ApiServer返回的字段级别原因
+*/
+type apiError struct {
+	Code    int                   `json:"code"`
+	Kind    string                `json:"kind"`
+	Message string                `json:"message"`
+	Causes  []meta_v1.StatusCause `json:"causes,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	kind := errorKindOf(err)
+	e := apiError{Code: httpStatuses[kind], Kind: kind.String(), Message: err.Error()}
+	var ce *clientError
+	if errors.As(err, &ce) {
+		e.Causes = ce.Causes
+	}
+	writeJSON(w, e.Code, e)
+}
+
+func requestError(format string, a ...interface{}) error {
+	return &clientError{Kind: ErrUsage, Action: "解析请求", Err: fmt.Errorf(format, a...)}
+}
+
+func notFoundError(format string, a ...interface{}) error {
+	return &clientError{Kind: ErrNotFound, Action: "解析请求", Err: fmt.Errorf(format, a...)}
+}
+
+func forbiddenError(token apiToken, namespace string) error {
+	target := "命名空间" + namespace
+	if namespace == "" {
+		target = "集群级资源或所有命名空间"
+	}
+	return &clientError{Kind: ErrForbidden, Action: "鉴权", Err: fmt.Errorf("token %s 无权访问%s", token.Name, target)}
+}
+
+/*
+   REST API服务,所有请求通过动态客户端发送,经过profile的安全设置与审计日志
+*/
+type apiServer struct {
+	client    dynamic.Interface
+	clientSet kubernetes.Interface
+	tokens    []apiToken
+	dashboard *dashboard   //为nil时不提供页面
+	ui        http.Handler //页面的静态文件,与dashboard一起由enableDashboard设置
+}
+
+func newAPIServer(client dynamic.Interface, clientSet kubernetes.Interface, tokens []apiToken) *apiServer {
+	return &apiServer{client: client, clientSet: clientSet, tokens: tokens}
+}
+
+/*
+   启用页面,静态文件无法加载时返回错误,serve启动失败
+*/
+func (s *apiServer) enableDashboard(d *dashboard) error {
+	ui, err := dashboardHandler()
+	if err != nil {
+		return err
+	}
+	s.dashboard, s.ui = d, ui
+	return nil
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, openAPISpec())
+	})
+	mux.HandleFunc("/v1/", s.authenticate(s.serveAPI))
+	if s.dashboard != nil {
+		mux.Handle("/ui/", s.ui)
+		mux.Handle("/", http.RedirectHandler("/ui/", http.StatusFound))
+	}
+	return mux
+}
+
+type apiTokenKey struct{}
+
+/*
+   校验Authorization: Bearer <token>,通过后将token放入请求的ctx
+*/
+func (s *apiServer) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get("Authorization")
+		if strings.HasPrefix(value, "Bearer ") {
+			presented := []byte(strings.TrimPrefix(value, "Bearer "))
+			for _, t := range s.tokens {
+				if subtle.ConstantTimeCompare(presented, []byte(t.Token)) == 1 {
+					ctx := withAuditToken(context.WithValue(r.Context(), apiTokenKey{}, t), t.Name)
+					next(w, r.WithContext(ctx))
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="k8s-client"`)
+		writeJSON(w, http.StatusUnauthorized, apiError{Code: http.StatusUnauthorized, Kind: ErrForbidden.String(), Message: "未认证,需要有效的Bearer token"})
+	}
+}
+
+func tokenFrom(ctx context.Context) apiToken {
+	t, _ := ctx.Value(apiTokenKey{}).(apiToken)
+	return t
+}
+
+/*
+   从路径解析的请求目标: /v1/namespaces/{ns}/{类型}[/{名称}[/{操作}]] 或 /v1/{类型}[/{名称}]
+   命名空间级资源使用第二种路径时表示所有命名空间,只能list与watch
+*/
+type apiTarget struct {
+	Kind      apiKind
+	Namespace string
+	Name      string
+	Action    string
+}
+
+/*
+   工作负载支持的操作与资源类型,与scale、rollout restart命令一致
+*/
+var workloadActions = map[string]map[string]bool{
+	"scale":   {"deployment": true, "statefulset": true},
+	"restart": {"deployment": true, "statefulset": true, "daemonset": true},
+}
+
+func parseAPIPath(path string) (apiTarget, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/v1/"), "/"), "/")
+	var t apiTarget
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		t.Namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) == 0 || len(parts) > 3 || parts[0] == "" {
+		return t, notFoundError("路径不存在: %s", path)
+	}
+	kind, ok := apiKinds[parts[0]]
+	if !ok {
+		return t, notFoundError("不支持的资源类型: %s", parts[0])
+	}
+	if t.Namespace != "" && !kind.Namespaced {
+		return t, notFoundError("%s 是集群级资源,路径中不能包含命名空间", kind.Name)
+	}
+	t.Kind = kind
+	if len(parts) >= 2 {
+		t.Name = parts[1]
+	}
+	if len(parts) == 3 {
+		t.Action = parts[2]
+		if !workloadActions[t.Action][kind.Name] {
+			return t, notFoundError("%s 不支持操作: %s", kind.Name, t.Action)
+		}
+	}
+	return t, nil
+}
+
+func (s *apiServer) resource(t apiTarget) dynamic.ResourceInterface {
+	if t.Kind.Namespaced {
+		return s.client.Resource(t.Kind.GVR).Namespace(t.Namespace)
+	}
+	return s.client.Resource(t.Kind.GVR)
+}
+
+/*
+   server-side apply的选项,fieldManager为profile中的FieldManager
+*/
+func applyPatchOptions(dryRun []string, force bool) meta_v1.PatchOptions {
+	return meta_v1.PatchOptions{DryRun: dryRun, FieldManager: activeProfile.FieldManager, Force: &force}
+}
+
+func queryOrDefault(r *http.Request, name, value string) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		return v
+	}
+	return value
+}
+
+func dryRunOption(r *http.Request) []string {
+	if r.URL.Query().Get("dryRun") == "true" {
+		return []string{meta_v1.DryRunAll}
+	}
+	return nil
+}
+
+func (s *apiServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	token := tokenFrom(r.Context())
+	switch r.URL.Path {
+	case "/v1/kinds":
+		s.listKinds(w, r)
+		return
+	case "/v1/dashboard":
+		s.serveDashboard(w, r, token)
+		return
+	case "/v1/apply":
+		if r.Method != http.MethodPost {
+			writeError(w, requestError("apply只支持POST"))
+			return
+		}
+		s.apply(w, r, token)
+		return
+	}
+	t, err := parseAPIPath(r.URL.Path)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !token.allows(t.Namespace) {
+		writeError(w, forbiddenError(token, t.Namespace))
+		return
+	}
+	if t.Kind.Namespaced && t.Namespace == "" && (t.Name != "" || r.Method != http.MethodGet) {
+		writeError(w, requestError("%s 是命名空间级资源,路径中需要包含命名空间", t.Kind.Name))
+		return
+	}
+	switch {
+	case t.Action != "" && r.Method == http.MethodPost:
+		s.workloadAction(w, r, t)
+	case t.Action != "":
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Code: http.StatusMethodNotAllowed, Kind: ErrUsage.String(), Message: "不支持的方法: " + r.Method})
+	case t.Name == "" && r.Method == http.MethodGet && r.URL.Query().Get("watch") == "true":
+		s.watch(w, r, t)
+	case t.Name == "" && r.Method == http.MethodGet:
+		s.list(w, r, t)
+	case t.Name == "" && r.Method == http.MethodPost:
+		s.create(w, r, t)
+	case t.Name != "" && r.Method == http.MethodGet:
+		s.get(w, r, t)
+	case t.Name != "" && r.Method == http.MethodPut:
+		s.update(w, r, t)
+	case t.Name != "" && r.Method == http.MethodDelete:
+		s.delete(w, r, t)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Code: http.StatusMethodNotAllowed, Kind: ErrUsage.String(), Message: "不支持的方法: " + r.Method})
+	}
+}
+
+func (s *apiServer) listKinds(w http.ResponseWriter, r *http.Request) {
+	type kindInfo struct {
+		Name       string `json:"name"`
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Resource   string `json:"resource"`
+		Namespaced bool   `json:"namespaced"`
+	}
+	var kinds []kindInfo
+	for _, name := range apiKindNames() {
+		k := apiKinds[name]
+		kinds = append(kinds, kindInfo{Name: k.Name, APIVersion: k.apiVersion(), Kind: k.Kind, Resource: k.GVR.Resource, Namespaced: k.Namespaced})
+	}
+	writeJSON(w, http.StatusOK, kinds)
+}
+
+func (s *apiServer) list(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	list, err := s.resource(t).List(r.Context(), meta_v1.ListOptions{LabelSelector: r.URL.Query().Get("labelSelector")})
+	if err != nil {
+		writeError(w, wrapError("获取"+t.Kind.Kind+"列表", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *apiServer) get(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	obj, err := s.resource(t).Get(r.Context(), t.Name, meta_v1.GetOptions{})
+	if err != nil {
+		writeError(w, wrapError("获取"+t.Kind.Kind, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+/*
+   读取请求体中的单个对象,支持json与yaml,校验类型、命名空间与名称与路径一致
+*/
+func readRequestObject(w http.ResponseWriter, r *http.Request, t apiTarget) (*unstructured.Unstructured, error) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		return nil, requestError("读取请求体失败: %v", err)
+	}
+	jsonBytes, err := yaml2Json(data)
+	if err != nil {
+		return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析请求体", Err: err}
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(jsonBytes); err != nil {
+		return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析请求体", Err: err}
+	}
+	if obj.GetKind() != t.Kind.Kind || obj.GetAPIVersion() != t.Kind.apiVersion() {
+		return nil, requestError("请求体应为%s %s,实际为%s %s", t.Kind.apiVersion(), t.Kind.Kind, obj.GetAPIVersion(), obj.GetKind())
+	}
+	if t.Kind.Namespaced {
+		if ns := obj.GetNamespace(); ns != "" && ns != t.Namespace {
+			return nil, requestError("请求体中的命名空间%s与路径中的%s不一致", ns, t.Namespace)
+		}
+		obj.SetNamespace(t.Namespace)
+	}
+	if t.Name != "" && obj.GetName() != t.Name {
+		return nil, requestError("请求体中的名称%s与路径中的%s不一致", obj.GetName(), t.Name)
+	}
+	return obj, nil
+}
+
+func (s *apiServer) create(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	obj, err := readRequestObject(w, r, t)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	created, err := s.resource(t).Create(r.Context(), obj, meta_v1.CreateOptions{DryRun: dryRunOption(r)})
+	if err != nil {
+		writeError(w, wrapError("创建"+t.Kind.Kind, err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *apiServer) update(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	obj, err := readRequestObject(w, r, t)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	updated, err := s.resource(t).Update(r.Context(), obj, meta_v1.UpdateOptions{DryRun: dryRunOption(r)})
+	if err != nil {
+		writeError(w, wrapError("更新"+t.Kind.Kind, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+/*
+   propagationPolicy默认为Background,与kubectl delete一致,Foreground会让对象在所有依赖对象删除前一直处于删除中
+*/
+func (s *apiServer) delete(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	policy := meta_v1.DeletionPropagation(queryOrDefault(r, "propagationPolicy", string(meta_v1.DeletePropagationBackground)))
+	switch policy {
+	case meta_v1.DeletePropagationBackground, meta_v1.DeletePropagationForeground, meta_v1.DeletePropagationOrphan:
+	default:
+		writeError(w, requestError("propagationPolicy应为Background、Foreground或Orphan: %s", policy))
+		return
+	}
+	if err := s.resource(t).Delete(r.Context(), t.Name, meta_v1.DeleteOptions{DryRun: dryRunOption(r), PropagationPolicy: &policy}); err != nil {
+		writeError(w, wrapError("删除"+t.Kind.Kind, err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+   以Server-Sent Events推送对象变化,每个事件的event为ADDED/MODIFIED/DELETED/ERROR,data为对象的json
+   resourceVersion参数指定从哪个版本开始监听,客户端断开或ApiServer关闭watch时结束
+*/
+func (s *apiServer) watch(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &clientError{Kind: ErrUnknown, Action: "监听", Err: fmt.Errorf("不支持流式响应")})
+		return
+	}
+	query := r.URL.Query()
+	watcher, err := s.resource(t).Watch(r.Context(), meta_v1.ListOptions{LabelSelector: query.Get("labelSelector"), ResourceVersion: query.Get("resourceVersion")})
+	if err != nil {
+		writeError(w, wrapError("监听"+t.Kind.Kind, err))
+		return
+	}
+	defer watcher.Stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(serveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event watch.Event) error {
+	return writeSSE(w, string(event.Type), event.Object)
+}
+
+func writeSSE(w io.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+/*
+   工作负载操作的请求体与响应,scale需要replicas
+*/
+type workloadActionRequest struct {
+	Replicas *int32 `json:"replicas"`
+}
+
+type workloadActionResponse struct {
+	Message string `json:"message"`
+}
+
+/*
+   POST /v1/namespaces/{ns}/{类型}/{名称}/scale 与 /restart
+*/
+func (s *apiServer) workloadAction(w http.ResponseWriter, r *http.Request, t apiTarget) {
+	var request workloadActionRequest
+	if t.Action == "scale" {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			writeError(w, requestError("解析请求体失败: %v", err))
+			return
+		}
+		if request.Replicas == nil || *request.Replicas < 0 {
+			writeError(w, requestError("replicas需要为非负整数"))
+			return
+		}
+	}
+	var out bytes.Buffer
+	var err error
+	switch t.Action {
+	case "scale":
+		err = scaleWorkload(r.Context(), s.clientSet, t.Kind.Name, t.Namespace, t.Name, *request.Replicas, &out)
+	case "restart":
+		err = restartWorkload(r.Context(), s.clientSet, t.Kind.Name, t.Namespace, t.Name, time.Now(), &out)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workloadActionResponse{Message: strings.TrimSpace(out.String())})
+}
+
+/*
+   apply中单个对象的结果,Action为created、configured或unchanged,Diff为相对当前对象的逐行差异
+*/
+type applyResult struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Action    string   `json:"action"`
+	Diff      []string `json:"diff,omitempty"`
+}
+
+type applyResponse struct {
+	DryRun  bool          `json:"dryRun"`
+	Results []applyResult `json:"results"`
+}
+
+/*
+   解析请求体中的清单,支持以---分隔的多个yaml文档或json
+   未指定命名空间的命名空间级资源使用namespace
+*/
+func decodeApplyObjects(data []byte, namespace string) ([]*unstructured.Unstructured, error) {
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	var objects []*unstructured.Unstructured
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析清单", Err: err}
+		}
+		jsonBytes, err := yaml2Json(doc)
+		if err != nil {
+			return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析清单", Err: fmt.Errorf("第%d个文档: %w", i, err)}
+		}
+		if trimmed := bytes.TrimSpace(jsonBytes); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(jsonBytes); err != nil {
+			return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析清单", Err: fmt.Errorf("第%d个文档: %w", i, err)}
+		}
+		kind, ok := apiKindFor(obj.GroupVersionKind())
+		if !ok {
+			return nil, requestError("第%d个文档: 不支持的类型 %s %s", i, obj.GetAPIVersion(), obj.GetKind())
+		}
+		if kind.Namespaced && obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		if !kind.Namespaced {
+			obj.SetNamespace("")
+		}
+		objects = append(objects, obj)
+	}
+}
+
+/*
+   POST /v1/apply?dryRun=true&namespace=ns&force=false
+   先校验所有对象的类型与权限,再逐个通过server-side apply创建或更新,dryRun时ApiServer只计算结果不保存
+   字段属于其他manager(如HPA管理的spec.replicas)时返回409 Conflict,force=true时接管这些字段
+*/
+func (s *apiServer) apply(w http.ResponseWriter, r *http.Request, token apiToken) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeError(w, requestError("读取请求体失败: %v", err))
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = activeProfile.Namespace
+	}
+	objects, err := decodeApplyObjects(data, namespace)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(objects) == 0 {
+		writeError(w, requestError("清单中没有对象"))
+		return
+	}
+	for _, obj := range objects {
+		if !token.allows(obj.GetNamespace()) {
+			writeError(w, forbiddenError(token, obj.GetNamespace()))
+			return
+		}
+	}
+
+	force, err := strconv.ParseBool(queryOrDefault(r, "force", "false"))
+	if err != nil {
+		writeError(w, requestError("force应为true或false: %v", err))
+		return
+	}
+	dryRun := dryRunOption(r)
+	response := applyResponse{DryRun: dryRun != nil, Results: []applyResult{}}
+	for _, obj := range objects {
+		kind, _ := apiKindFor(obj.GroupVersionKind())
+		t := apiTarget{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		result := applyResult{Kind: kind.Kind, Namespace: t.Namespace, Name: t.Name}
+		current, err := s.resource(t).Get(r.Context(), t.Name, meta_v1.GetOptions{})
+		if err != nil && !k8s_errors.IsNotFound(err) {
+			writeError(w, wrapError("获取"+kind.Kind, err))
+			return
+		}
+		if err != nil {
+			current = nil
+		}
+		body, err := obj.MarshalJSON()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		applied, err := s.resource(t).Patch(r.Context(), t.Name, types.ApplyPatchType, body, applyPatchOptions(dryRun, force))
+		if err != nil {
+			writeError(w, wrapError("apply "+kind.Kind+" "+t.Name, err))
+			return
+		}
+		result.Diff = diffLines(objectLines(current), objectLines(applied))
+		switch {
+		case current == nil:
+			result.Action = "created"
+		case len(result.Diff) == 0:
+			result.Action = "unchanged"
+		default:
+			result.Action = "configured"
+		}
+		response.Results = append(response.Results, result)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+/*
+   监听地址与TLS证书,token以明文传输,非本机地址需要TLS或显式指定-insecure
+*/
+type serveOptions struct {
+	Address  string
+	TLSCert  string
+	TLSKey   string
+	Insecure bool
+}
+
+func (o serveOptions) tls() bool {
+	return o.TLSCert != ""
+}
+
+func (o serveOptions) validate() error {
+	if (o.TLSCert == "") != (o.TLSKey == "") {
+		return usageError("-tls-cert与-tls-key需要同时指定")
+	}
+	host, _, err := net.SplitHostPort(o.Address)
+	if err != nil {
+		return usageError("监听地址格式错误: %s", o.Address)
+	}
+	if !o.tls() && !o.Insecure && !isLoopbackHost(host) {
+		return usageError("监听非本机地址%s时需要-tls-cert与-tls-key,或指定-insecure允许以明文传输token", o.Address)
+	}
+	return nil
+}
+
+/*
+   空主机表示监听所有地址,不是本机地址
+*/
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+/*
+   serve [-address 127.0.0.1:8080] [-tls-cert f -tls-key f] [-insecure] [-tokens ./tokens.yaml] [-ui]
+*/
+func runServe(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("serve", out)
+	var opts serveOptions
+	fs.StringVar(&opts.Address, "address", defaultServeAddress, "监听地址")
+	fs.StringVar(&opts.TLSCert, "tls-cert", "", "TLS证书文件")
+	fs.StringVar(&opts.TLSKey, "tls-key", "", "TLS私钥文件")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "允许在非本机地址上不使用TLS")
+	tokenFile := fs.String("tokens", defaultTokenFile, "token配置文件")
+	ui := fs.Bool("ui", false, "在/ui/提供页面,通过informer实时更新")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError("多余的参数: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	tokens, err := loadTokens(*tokenFile)
+	if err != nil {
+		return err
+	}
+	client, err := initDynamicClient(ctx)
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	server := newAPIServer(client, clientSet, tokens)
+	if *ui {
+		d := newDashboard(clientSet)
+		if err := server.enableDashboard(d); err != nil {
+			return err
+		}
+		if err := d.start(ctx); err != nil {
+			return err
+		}
+	}
+	return serveHTTP(ctx, opts, server.handler(), out)
+}
+
+/*
+   启动HTTP服务,ctx结束时优雅关闭
+*/
+func serveHTTP(ctx context.Context, opts serveOptions, handler http.Handler, out io.Writer) error {
+	server := &http.Server{Addr: opts.Address, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		if opts.tls() {
+			errs <- server.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+	scheme := "http"
+	if opts.tls() {
+		scheme = "https"
+	}
+	fmt.Fprintf(out, "监听 %s://%s\n", scheme, opts.Address)
+	select {
+	case err := <-errs:
+		return &clientError{Kind: ErrUnknown, Action: "启动HTTP服务", Err: err}
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8s_testing "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokens = []apiToken{
+	{Name: "admin", Token: "admin-token", Namespaces: []string{"*"}},
+	{Name: "dev", Token: "dev-token", Namespaces: []string{TestNamespace}},
+}
+
+/*
+   fake动态客户端不支持server-side apply,用清单替换当前对象并保留resourceVersion与uid,不保存结果
+*/
+func newServeClient(objects ...runtime.Object) *fakedynamic.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, k := range apiKinds {
+		listKinds[k.GVR] = k.Kind + "List"
+	}
+	client := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	client.PrependReactor("patch", "*", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		patch := action.(k8s_testing.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		//模拟字段属于其他manager时的冲突
+		if obj.GetAnnotations()["test/conflict"] == "true" {
+			return true, nil, k8s_errors.NewConflict(patch.GetResource().GroupResource(), obj.GetName(), fmt.Errorf("Apply failed with 1 conflict: conflict with \"kube-controller-manager\": .spec.replicas"))
+		}
+		if current, err := client.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName()); err == nil {
+			accessor := current.(*unstructured.Unstructured)
+			obj.SetResourceVersion(accessor.GetResourceVersion())
+			obj.SetUID(accessor.GetUID())
+		}
+		return true, obj, nil
+	})
+	return client
+}
+
+func newServeConfigMap(namespace, name string, data map[string]interface{}) *unstructured.Unstructured {
+	obj := newUnstructured("v1", "ConfigMap", namespace, name)
+	obj.SetLabels(map[string]string{"app": "nginx"})
+	obj.Object["data"] = data
+	return obj
+}
+
+func serveRequest(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoadTokens(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "tokens.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tokens, err := loadTokens(write("tokens:\n- name: dev\n  token: dev-token\n  namespaces: [test-namespace]\n"))
+	if err != nil || len(tokens) != 1 || !tokens[0].allows(TestNamespace) || tokens[0].allows("") || tokens[0].allows("other") {
+		t.Errorf("tokens = %+v, err = %v", tokens, err)
+	}
+	for _, content := range []string{
+		"tokens: []\n",
+		"tokens:\n- name: dev\n  namespaces: [a]\n",
+		"tokens:\n- name: dev\n  token: a\n",
+		"tokens:\n- name: dev\n  token: a\n  namespaces: [a]\n- name: dev\n  token: b\n  namespaces: [b]\n",
+		"tokens:\n- name: dev\n  token: a\n  namespace: [a]\n",
+	} {
+		if _, err := loadTokens(write(content)); errorKindOf(err) != ErrInvalidManifest {
+			t.Errorf("loadTokens(%q) error = %v, want %s", content, err, ErrInvalidManifest)
+		}
+	}
+}
+
+func TestServeAPI(t *testing.T) {
+	client := newServeClient(newServeConfigMap("other", "other-config", map[string]interface{}{"a": "1"}))
+	handler := newAPIServer(client, fake.NewSimpleClientset(), testTokens).handler()
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+  labels:
+    app: nginx
+data:
+  key: value
+`
+	collection := "/v1/namespaces/" + TestNamespace + "/configmap"
+	for _, tc := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"GET", "/healthz", "", "", http.StatusOK},
+		{"GET", "/openapi.json", "", "", http.StatusOK},
+		{"GET", "/v1/kinds", "", "", http.StatusUnauthorized},
+		{"GET", "/v1/kinds", "wrong-token", "", http.StatusUnauthorized},
+		{"GET", "/v1/kinds", "dev-token", "", http.StatusOK},
+		{"GET", "/v1/namespaces/other/configmap", "dev-token", "", http.StatusForbidden},
+		{"GET", "/v1/configmap", "dev-token", "", http.StatusForbidden},
+		{"GET", "/v1/pv", "dev-token", "", http.StatusForbidden},
+		{"GET", "/v1/pv", "admin-token", "", http.StatusOK},
+		{"GET", "/v1/configmap", "admin-token", "", http.StatusOK},
+		{"GET", "/v1/pod", "admin-token", "", http.StatusNotFound},
+		{"GET", "/v1/namespaces/other/pv", "admin-token", "", http.StatusNotFound},
+		{"PUT", "/v1/configmap/test-config", "admin-token", configMap, http.StatusBadRequest},
+		{"POST", collection, "dev-token", "kind: [", http.StatusUnprocessableEntity},
+		{"POST", collection, "dev-token", strings.Replace(configMap, "ConfigMap", "Secret", 1), http.StatusBadRequest},
+		{"POST", collection, "dev-token", strings.Replace(configMap, "name: test-config", "name: test-config\n  namespace: other", 1), http.StatusBadRequest},
+		{"POST", collection, "dev-token", configMap, http.StatusCreated},
+		{"POST", collection, "dev-token", configMap, http.StatusConflict},
+		{"GET", collection + "/test-config", "dev-token", "", http.StatusOK},
+		{"PUT", collection + "/test-config", "dev-token", strings.Replace(configMap, "value", "changed", 1), http.StatusOK},
+		{"PUT", collection + "/other-config", "dev-token", configMap, http.StatusBadRequest},
+		{"PATCH", collection + "/test-config", "dev-token", "", http.StatusMethodNotAllowed},
+		{"DELETE", collection + "/test-config?propagationPolicy=Cascade", "dev-token", "", http.StatusBadRequest},
+		{"DELETE", collection + "/test-config", "dev-token", "", http.StatusNoContent},
+		{"GET", collection + "/test-config", "dev-token", "", http.StatusNotFound},
+	} {
+		w := serveRequest(handler, tc.method, tc.path, tc.token, tc.body)
+		if w.Code != tc.want {
+			t.Errorf("%s %s (%s) = %d, want %d: %s", tc.method, tc.path, tc.token, w.Code, tc.want, w.Body)
+		}
+		if tc.want >= 400 && tc.path != "/healthz" {
+			var e apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Code != tc.want || e.Message == "" {
+				t.Errorf("%s %s error body = %s", tc.method, tc.path, w.Body)
+			}
+		}
+	}
+
+	//标签选择器
+	if _, err := client.Resource(apiKinds["configmap"].GVR).Namespace(TestNamespace).Create(context.TODO(), newServeConfigMap(TestNamespace, "test-config", nil), meta_v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w := serveRequest(handler, "GET", collection+"?labelSelector=app%3Dnginx", "dev-token", "")
+	list := &unstructured.UnstructuredList{}
+	if err := list.UnmarshalJSON(w.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].GetName() != "test-config" {
+		t.Errorf("list = %s", w.Body)
+	}
+	if w := serveRequest(handler, "GET", collection+"?labelSelector=app%3Dredis", "dev-token", ""); strings.Contains(w.Body.String(), "test-config") {
+		t.Errorf("list = %s", w.Body)
+	}
+}
+
+func TestServeApply(t *testing.T) {
+	deployment := newUnstructured("apps/v1", "Deployment", TestNamespace, "test-nginx")
+	deployment.Object["spec"] = map[string]interface{}{"replicas": int64(2)}
+	client := newServeClient(newServeConfigMap(TestNamespace, "test-config", map[string]interface{}{"key": "value"}), deployment)
+	handler := newAPIServer(client, fake.NewSimpleClientset(), testTokens).handler()
+	manifest := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+  labels:
+    app: nginx
+data:
+  key: value
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test-nginx
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: test-service
+spec:
+  ports:
+  - port: 80
+`
+	w := serveRequest(handler, "POST", "/v1/apply?dryRun=true", "dev-token", manifest)
+	if w.Code != http.StatusOK {
+		t.Fatalf("apply = %d: %s", w.Code, w.Body)
+	}
+	assertGolden(t, "serve_apply.golden", w.Body.Bytes())
+
+	for _, tc := range []struct {
+		path, token, body string
+		want              int
+	}{
+		{"/v1/apply?namespace=other", "dev-token", manifest, http.StatusForbidden},
+		{"/v1/apply", "dev-token", "apiVersion: v1\nkind: PersistentVolume\nmetadata:\n  name: pv\n", http.StatusForbidden},
+		{"/v1/apply", "admin-token", "apiVersion: v1\nkind: Pod\nmetadata:\n  name: nginx\n", http.StatusBadRequest},
+		{"/v1/apply", "admin-token", "---\n", http.StatusBadRequest},
+		{"/v1/apply?force=maybe", "dev-token", manifest, http.StatusBadRequest},
+		{"/v1/apply", "dev-token", strings.Replace(manifest, "name: test-nginx", "name: test-nginx\n  annotations:\n    test/conflict: \"true\"", 1), http.StatusConflict},
+	} {
+		w := serveRequest(handler, "POST", tc.path, tc.token, tc.body)
+		if w.Code != tc.want {
+			t.Errorf("apply %s = %d, want %d: %s", tc.path, w.Code, tc.want, w.Body)
+		}
+		if tc.want == http.StatusConflict && !strings.Contains(w.Body.String(), `"kind":"Conflict"`) {
+			t.Errorf("apply conflict body = %s", w.Body)
+		}
+	}
+	//权限检查失败时不应apply任何对象
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" && action.GetNamespace() == "other" {
+			t.Errorf("unexpected action %v", action)
+		}
+	}
+}
+
+func TestServeWatch(t *testing.T) {
+	original := serveHeartbeat
+	serveHeartbeat = 10 * time.Millisecond
+	defer func() { serveHeartbeat = original }()
+
+	client := newServeClient()
+	server := httptest.NewServer(newAPIServer(client, fake.NewSimpleClientset(), testTokens).handler())
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/v1/namespaces/"+TestNamespace+"/configmap?watch=true", nil)
+	req.Header.Set("Authorization", "Bearer dev-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("watch = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resource := client.Resource(apiKinds["configmap"].GVR).Namespace(TestNamespace)
+	if _, err := resource.Create(ctx, newServeConfigMap(TestNamespace, "test-config", nil), meta_v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := resource.Delete(ctx, "test-config", meta_v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	heartbeat := false
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == ": ping":
+			heartbeat = true
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: ") && !strings.Contains(line, `"name":"test-config"`):
+			t.Errorf("data = %s", line)
+		}
+	}
+	if strings.Join(events, ",") != "ADDED,DELETED" {
+		t.Errorf("events = %v, err = %v", events, scanner.Err())
+	}
+	for !heartbeat && scanner.Scan() {
+		heartbeat = scanner.Text() == ": ping"
+	}
+	if !heartbeat {
+		t.Error("没有收到心跳")
+	}
+}
+
+/*
+   golden只记录结构: 每个操作一行,包含operationId、参数、请求体与成功响应的schema
+   描述文字与通用错误响应不进golden,错误响应单独检查
+*/
+func TestOpenAPISpec(t *testing.T) {
+	data, err := json.Marshal(openAPISpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		OpenAPI    string
+		Paths      map[string]map[string]openAPIOperation
+		Components struct {
+			SecuritySchemes map[string]interface{}
+			Schemas         map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != "3.0.3" || spec.Components.SecuritySchemes["bearerAuth"] == nil {
+		t.Errorf("openapi = %q, securitySchemes = %v", spec.OpenAPI, spec.Components.SecuritySchemes)
+	}
+	var lines []string
+	ids := map[string]string{}
+	for path, ops := range spec.Paths {
+		for method, op := range ops {
+			if other, ok := ids[op.OperationID]; ok {
+				t.Errorf("operationId %s 重复: %s, %s %s", op.OperationID, other, method, path)
+			}
+			ids[op.OperationID] = method + " " + path
+			lines = append(lines, op.summary(t, strings.ToUpper(method), path, spec.Components.Schemas))
+		}
+	}
+	sort.Strings(lines)
+	assertGolden(t, "openapi.golden", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+type openAPIOperation struct {
+	OperationID string
+	Parameters  []struct{ Name, In string }
+	RequestBody *struct {
+		Content map[string]struct{ Schema map[string]interface{} }
+	}
+	Responses map[string]struct {
+		Content map[string]struct{ Schema map[string]interface{} }
+	}
+	Security []interface{}
+}
+
+func (op openAPIOperation) summary(t *testing.T, method, path string, schemas map[string]interface{}) string {
+	ref := func(schema map[string]interface{}) string {
+		r, ok := schema["$ref"].(string)
+		if !ok {
+			return fmt.Sprint(schema["type"])
+		}
+		name := strings.TrimPrefix(r, "#/components/schemas/")
+		if schemas[name] == nil {
+			t.Errorf("%s %s 引用了不存在的schema %s", method, path, r)
+		}
+		return name
+	}
+	fields := []string{method, path, op.OperationID}
+	var params []string
+	for _, p := range op.Parameters {
+		params = append(params, p.In+":"+p.Name)
+	}
+	fields = append(fields, "params="+strings.Join(params, ","))
+	if op.RequestBody != nil {
+		for contentType, c := range op.RequestBody.Content {
+			fields = append(fields, "body="+contentType+":"+ref(c.Schema))
+		}
+	}
+	var codes []string
+	for code, r := range op.Responses {
+		if code >= "400" {
+			if ref(r.Content["application/json"].Schema) != "Error" {
+				t.Errorf("%s %s 的%s响应不是Error", method, path, code)
+			}
+			continue
+		}
+		var types []string
+		for contentType, c := range r.Content {
+			types = append(types, contentType+":"+ref(c.Schema))
+		}
+		sort.Strings(types)
+		codes = append(codes, code+"="+strings.Join(types, ","))
+	}
+	sort.Strings(codes)
+	fields = append(fields, "responses="+strings.Join(codes, ";"))
+	secured := len(op.Security) > 0
+	if _, ok := op.Responses["401"]; secured != ok {
+		t.Errorf("%s %s security = %v, 401响应 = %v", method, path, op.Security, ok)
+	}
+	if !secured {
+		fields = append(fields, "public")
+	}
+	return strings.Join(fields, " ")
+}
+
+func TestServeOptionsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		opts serveOptions
+		ok   bool
+	}{
+		{serveOptions{Address: defaultServeAddress}, true},
+		{serveOptions{Address: "localhost:8080"}, true},
+		{serveOptions{Address: "[::1]:8080"}, true},
+		{serveOptions{Address: ":8080"}, false},
+		{serveOptions{Address: "0.0.0.0:8080"}, false},
+		{serveOptions{Address: "10.0.0.5:8080"}, false},
+		{serveOptions{Address: ":8080", Insecure: true}, true},
+		{serveOptions{Address: ":8443", TLSCert: "tls.crt", TLSKey: "tls.key"}, true},
+		{serveOptions{Address: ":8443", TLSCert: "tls.crt"}, false},
+		{serveOptions{Address: "8080"}, false},
+	} {
+		if err := tc.opts.validate(); (err == nil) != tc.ok || (err != nil && errorKindOf(err) != ErrUsage) {
+			t.Errorf("validate(%+v) = %v, want ok %v", tc.opts, err, tc.ok)
+		}
+	}
+}
+
+func TestServeAuditToken(t *testing.T) {
+	server := newAPIServer(newServeClient(), fake.NewSimpleClientset(), testTokens)
+	var got string
+	handler := server.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		got = auditTokenFrom(r.Context())
+	})
+	req := httptest.NewRequest("DELETE", "/v1/namespaces/"+TestNamespace+"/configmap/test-config", nil)
+	req.Header.Set("Authorization", "Bearer dev-token")
+	handler(httptest.NewRecorder(), req)
+	if got != "dev" {
+		t.Errorf("audit token = %q, want dev", got)
+	}
+}
+
+func TestApplyPatchOptions(t *testing.T) {
+	if opts := applyPatchOptions(nil, false); opts.Force == nil || *opts.Force || opts.FieldManager != activeProfile.FieldManager {
+		t.Errorf("options = %+v", opts)
+	}
+	if opts := applyPatchOptions([]string{meta_v1.DryRunAll}, true); !*opts.Force || len(opts.DryRun) != 1 {
+		t.Errorf("options = %+v", opts)
+	}
+}
+
+func TestServeDeletePropagation(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+	}))
+	defer server.Close()
+	client, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newAPIServer(client, fake.NewSimpleClientset(), testTokens).handler()
+	path := "/v1/namespaces/" + TestNamespace + "/deployment/test-nginx"
+	for _, query := range []string{"", "?propagationPolicy=Foreground"} {
+		if w := serveRequest(handler, "DELETE", path+query, "dev-token", ""); w.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s = %d: %s", query, w.Code, w.Body)
+		}
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[0], `"propagationPolicy":"Background"`) || !strings.Contains(bodies[1], `"propagationPolicy":"Foreground"`) {
+		t.Errorf("delete bodies = %q", bodies)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+   工作负载的就绪状态
+   Failed表示不会再自动恢复,如Job超过重试次数、Deployment超过progressDeadlineSeconds
+*/
+type workloadStatus struct {
+	Ready   bool
+	Failed  bool
+	Summary string
+	Details []string
+}
+
+func (s workloadStatus) state() string {
+	switch {
+	case s.Failed:
+		return "失败"
+	case s.Ready:
+		return "就绪"
+	}
+	return "未就绪"
+}
+
+/*
+   Deployment的就绪判断与kubectl rollout status一致
+*/
+func deploymentStatus(d *apps_v1.Deployment) workloadStatus {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		return workloadStatus{Summary: "等待控制器处理最新的配置"}
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == apps_v1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return workloadStatus{Failed: true, Summary: "超过progressDeadlineSeconds: " + cond.Message}
+		}
+	}
+	status := d.Status
+	switch {
+	case d.Spec.Paused:
+		return workloadStatus{Summary: fmt.Sprintf("已暂停, %d/%d 个副本已更新", status.UpdatedReplicas, replicas)}
+	case status.UpdatedReplicas < replicas:
+		return workloadStatus{Summary: fmt.Sprintf("%d/%d 个副本已更新", status.UpdatedReplicas, replicas)}
+	case status.Replicas > status.UpdatedReplicas:
+		return workloadStatus{Summary: fmt.Sprintf("%d 个旧副本等待终止", status.Replicas-status.UpdatedReplicas)}
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		return workloadStatus{Summary: fmt.Sprintf("%d/%d 个更新后的副本可用", status.AvailableReplicas, status.UpdatedReplicas)}
+	}
+	return workloadStatus{Ready: true, Summary: fmt.Sprintf("%d/%d 个副本可用", status.AvailableReplicas, replicas)}
+}
+
+/*
+   StatefulSet按序号检查每个副本,pods为该StatefulSet管理的Pod
+*/
+func statefulSetStatus(sts *apps_v1.StatefulSet, pods []core_v1.Pod) workloadStatus {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	byName := map[string]*core_v1.Pod{}
+	for i := range pods {
+		byName[pods[i].Name] = &pods[i]
+	}
+	partition := int32(0)
+	if ru := sts.Spec.UpdateStrategy.RollingUpdate; sts.Spec.UpdateStrategy.Type != apps_v1.OnDeleteStatefulSetStrategyType && ru != nil && ru.Partition != nil {
+		partition = *ru.Partition
+	}
+
+	s := workloadStatus{Ready: true}
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		name := sts.Name + "-" + strconv.Itoa(int(ordinal))
+		pod := byName[name]
+		switch {
+		case pod == nil:
+			s.Ready = false
+			s.Details = append(s.Details, name+": 未创建")
+		case !isPodReady(pod):
+			s.Ready = false
+			s.Details = append(s.Details, fmt.Sprintf("%s: 未就绪(%s)", name, podPhaseReason(pod)))
+		case sts.Spec.UpdateStrategy.Type != apps_v1.OnDeleteStatefulSetStrategyType && ordinal >= partition &&
+			sts.Status.UpdateRevision != "" && pod.Labels[apps_v1.StatefulSetRevisionLabel] != sts.Status.UpdateRevision:
+			s.Ready = false
+			s.Details = append(s.Details, fmt.Sprintf("%s: 就绪, 等待更新到%s", name, sts.Status.UpdateRevision))
+		default:
+			s.Details = append(s.Details, fmt.Sprintf("%s: 就绪(%s)", name, pod.Labels[apps_v1.StatefulSetRevisionLabel]))
+		}
+	}
+	if sts.Generation > sts.Status.ObservedGeneration {
+		s.Ready = false
+	}
+	s.Summary = fmt.Sprintf("%d/%d 个副本就绪", sts.Status.ReadyReplicas, replicas)
+	return s
+}
+
+/*
+   DaemonSet所有应调度的节点上都有更新后且可用的副本时就绪
+*/
+func daemonSetStatus(ds *apps_v1.DaemonSet) workloadStatus {
+	status := ds.Status
+	s := workloadStatus{Summary: fmt.Sprintf("%d/%d 个节点可用, %d 个已更新", status.NumberAvailable, status.DesiredNumberScheduled, status.UpdatedNumberScheduled)}
+	if status.NumberMisscheduled > 0 {
+		s.Details = append(s.Details, fmt.Sprintf("%d 个节点不应运行但仍在运行", status.NumberMisscheduled))
+	}
+	s.Ready = ds.Generation <= status.ObservedGeneration &&
+		status.UpdatedNumberScheduled >= status.DesiredNumberScheduled &&
+		status.NumberAvailable >= status.DesiredNumberScheduled
+	return s
+}
+
+/*
+   Job完成全部completions时就绪,超过backoffLimit或activeDeadlineSeconds时失败
+*/
+func jobStatus(job *batch_v1.Job) workloadStatus {
+	completions := "1"
+	if job.Spec.Completions != nil {
+		completions = strconv.Itoa(int(*job.Spec.Completions))
+	} else if job.Spec.Parallelism != nil && *job.Spec.Parallelism > 1 {
+		completions = "任意"
+	}
+	backoffLimit := int32(6)
+	if job.Spec.BackoffLimit != nil {
+		backoffLimit = *job.Spec.BackoffLimit
+	}
+	s := workloadStatus{Summary: fmt.Sprintf("完成 %d/%s, 失败 %d(重试上限%d), 运行中 %d",
+		job.Status.Succeeded, completions, job.Status.Failed, backoffLimit, job.Status.Active)}
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != core_v1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batch_v1.JobComplete:
+			s.Ready = true
+		case batch_v1.JobFailed:
+			s.Failed = true
+			s.Details = append(s.Details, fmt.Sprintf("%s: %s", cond.Reason, cond.Message))
+		}
+	}
+	return s
+}
+
+/*
+   CronJob没有就绪的概念,这里以未暂停且最近一次Job未失败作为就绪,jobs为该CronJob创建的Job
+*/
+func cronJobStatus(cj *batch_v1.CronJob, jobs []batch_v1.Job) workloadStatus {
+	s := workloadStatus{Ready: true}
+	if cj.Spec.Suspend != nil && *cj.Spec.Suspend {
+		s.Ready = false
+		s.Details = append(s.Details, "已暂停调度")
+	}
+	last := "从未调度"
+	if cj.Status.LastScheduleTime != nil {
+		last = cj.Status.LastScheduleTime.Format(time.RFC3339)
+	}
+	lastSuccess := "无"
+	if cj.Status.LastSuccessfulTime != nil {
+		lastSuccess = cj.Status.LastSuccessfulTime.Format(time.RFC3339)
+	}
+	s.Summary = fmt.Sprintf("schedule %q, 运行中 %d, 最近调度 %s, 最近成功 %s", cj.Spec.Schedule, len(cj.Status.Active), last, lastSuccess)
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreationTimestamp.Before(&jobs[j].CreationTimestamp)
+	})
+	for i := range jobs {
+		js := jobStatus(&jobs[i])
+		s.Details = append(s.Details, fmt.Sprintf("Job %s: %s, %s", jobs[i].Name, js.state(), js.Summary))
+		if i == len(jobs)-1 && js.Failed {
+			s.Failed = true
+		}
+	}
+	return s
+}
+
+func isPodReady(pod *core_v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == core_v1.PodReady {
+			return cond.Status == core_v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+/*
+   Pod未就绪的原因,优先使用容器的等待原因,如ImagePullBackOff
+*/
+func podPhaseReason(pod *core_v1.Pod) string {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			return cs.State.Waiting.Reason
+		}
+	}
+	if pod.Status.Reason != "" {
+		return pod.Status.Reason
+	}
+	return string(pod.Status.Phase)
+}
+
+/*
+   获取工作负载的就绪状态,kind为resourceKinds中的名称
+*/
+func getWorkloadStatus(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (workloadStatus, error) {
+	switch kind {
+	case "deployment":
+		d, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取Deployment", err)
+		}
+		return deploymentStatus(d), nil
+	case "statefulset":
+		sts, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取StatefulSet", err)
+		}
+		selector, err := meta_v1.LabelSelectorAsSelector(sts.Spec.Selector)
+		if err != nil {
+			return workloadStatus{}, &clientError{Kind: ErrInvalidManifest, Action: "解析StatefulSet selector", Err: err}
+		}
+		pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, meta_v1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取Pod列表", err)
+		}
+		return statefulSetStatus(sts, pods.Items), nil
+	case "daemonset":
+		ds, err := clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取DaemonSet", err)
+		}
+		return daemonSetStatus(ds), nil
+	case "job":
+		job, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取Job", err)
+		}
+		return jobStatus(job), nil
+	case "cronjob":
+		cj, err := clientSet.BatchV1().CronJobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取CronJob", err)
+		}
+		jobList, err := clientSet.BatchV1().Jobs(namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			return workloadStatus{}, wrapError("获取Job列表", err)
+		}
+		var owned []batch_v1.Job
+		for _, job := range jobList.Items {
+			if ref := meta_v1.GetControllerOf(&job); ref != nil && ref.UID == cj.UID && ref.Kind == "CronJob" {
+				owned = append(owned, job)
+			}
+		}
+		return cronJobStatus(cj, owned), nil
+	}
+	return workloadStatus{}, usageError("%s 不支持查看状态", kind)
+}
+
+func runStatus(ctx context.Context, args []string, out io.Writer) error {
+	kind, name, err := parseWorkloadTarget(args)
+	if err != nil {
+		return err
+	}
+	clientSet, err := initClient(ctx)
+	if err != nil {
+		return err
+	}
+	s, err := getWorkloadStatus(ctx, clientSet, kind, activeProfile.Namespace, name)
+	if err != nil {
+		return err
+	}
+	printWorkloadStatus(out, kind, activeProfile.Namespace+"/"+name, s)
+	return nil
+}
+
+func printWorkloadStatus(out io.Writer, kind, name string, s workloadStatus) {
+	fmt.Fprintf(out, "%s %s: %s, %s\n", kind, name, s.state(), s.Summary)
+	for _, detail := range s.Details {
+		fmt.Fprintf(out, "  %s\n", detail)
+	}
+}
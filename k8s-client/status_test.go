@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	apps_v1 "k8s.io/api/apps/v1"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func newTestDeployment(replicas, updated, available, total int32) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-nginx", Namespace: TestNamespace, Generation: 2},
+		Spec:       apps_v1.DeploymentSpec{Replicas: int32Ptr(replicas)},
+		Status: apps_v1.DeploymentStatus{
+			ObservedGeneration: 2,
+			Replicas:           total,
+			UpdatedReplicas:    updated,
+			AvailableReplicas:  available,
+		},
+	}
+}
+
+func TestDeploymentStatus(t *testing.T) {
+	stale := newTestDeployment(2, 2, 2, 2)
+	stale.Status.ObservedGeneration = 1
+	deadline := newTestDeployment(2, 1, 1, 2)
+	deadline.Status.Conditions = []apps_v1.DeploymentCondition{
+		{Type: apps_v1.DeploymentProgressing, Status: core_v1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+	}
+	tests := []struct {
+		name       string
+		deployment *apps_v1.Deployment
+		wantState  string
+		wantText   string
+	}{
+		{name: "ready", deployment: newTestDeployment(2, 2, 2, 2), wantState: "就绪", wantText: "2/2 个副本可用"},
+		{name: "stale generation", deployment: stale, wantState: "未就绪", wantText: "等待控制器"},
+		{name: "updating", deployment: newTestDeployment(3, 1, 1, 3), wantState: "未就绪", wantText: "1/3 个副本已更新"},
+		{name: "old replicas", deployment: newTestDeployment(2, 2, 2, 3), wantState: "未就绪", wantText: "1 个旧副本等待终止"},
+		{name: "unavailable", deployment: newTestDeployment(2, 2, 1, 2), wantState: "未就绪", wantText: "1/2 个更新后的副本可用"},
+		{name: "deadline exceeded", deployment: deadline, wantState: "失败", wantText: "progressDeadlineSeconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := deploymentStatus(tt.deployment)
+			if s.state() != tt.wantState || !strings.Contains(s.Summary, tt.wantText) {
+				t.Errorf("deploymentStatus() = %s %q, want %s %q", s.state(), s.Summary, tt.wantState, tt.wantText)
+			}
+		})
+	}
+}
+
+func newTestStatefulSetPod(name, revision string, ready bool) core_v1.Pod {
+	status := core_v1.ConditionFalse
+	if ready {
+		status = core_v1.ConditionTrue
+	}
+	return core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      name,
+			Namespace: TestNamespace,
+			Labels:    map[string]string{"app": "test-statefulset", apps_v1.StatefulSetRevisionLabel: revision},
+		},
+		Status: core_v1.PodStatus{
+			Phase:      core_v1.PodRunning,
+			Conditions: []core_v1.PodCondition{{Type: core_v1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestStatefulSetStatus(t *testing.T) {
+	sts := &apps_v1.StatefulSet{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-statefulset", Namespace: TestNamespace},
+		Spec:       apps_v1.StatefulSetSpec{Replicas: int32Ptr(3)},
+		Status:     apps_v1.StatefulSetStatus{ReadyReplicas: 2, CurrentRevision: "v1", UpdateRevision: "v2"},
+	}
+	pods := []core_v1.Pod{
+		newTestStatefulSetPod("test-statefulset-0", "v2", true),
+		newTestStatefulSetPod("test-statefulset-1", "v1", true),
+	}
+	s := statefulSetStatus(sts, pods)
+	want := []string{
+		"test-statefulset-0: 就绪(v2)",
+		"test-statefulset-1: 就绪, 等待更新到v2",
+		"test-statefulset-2: 未创建",
+	}
+	if s.Ready || strings.Join(s.Details, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statefulSetStatus() ready = %v, details = %q", s.Ready, s.Details)
+	}
+
+	sts.Spec.UpdateStrategy.RollingUpdate = &apps_v1.RollingUpdateStatefulSetStrategy{Partition: int32Ptr(2)}
+	pods = append(pods, newTestStatefulSetPod("test-statefulset-2", "v2", true))
+	if s := statefulSetStatus(sts, pods); !s.Ready {
+		t.Errorf("ordinals below partition should not block readiness: %q", s.Details)
+	}
+}
+
+func TestDaemonSetStatus(t *testing.T) {
+	ds := &apps_v1.DaemonSet{Status: apps_v1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2}}
+	if daemonSetStatus(ds).Ready {
+		t.Error("daemonset with unavailable pods reported ready")
+	}
+	ds.Status.NumberAvailable = 3
+	if !daemonSetStatus(ds).Ready {
+		t.Error("daemonset with all pods available reported not ready")
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	job := &batch_v1.Job{
+		Spec:   batch_v1.JobSpec{Completions: int32Ptr(3), BackoffLimit: int32Ptr(4)},
+		Status: batch_v1.JobStatus{Succeeded: 1, Failed: 2, Active: 1},
+	}
+	s := jobStatus(job)
+	if s.state() != "未就绪" || s.Summary != "完成 1/3, 失败 2(重试上限4), 运行中 1" {
+		t.Errorf("jobStatus() = %s %q", s.state(), s.Summary)
+	}
+	job.Status.Conditions = []batch_v1.JobCondition{
+		{Type: batch_v1.JobFailed, Status: core_v1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
+	}
+	if s := jobStatus(job); !s.Failed || len(s.Details) != 1 || !strings.HasPrefix(s.Details[0], "BackoffLimitExceeded") {
+		t.Errorf("jobStatus() failed = %v, details = %q", s.Failed, s.Details)
+	}
+}
+
+func TestGetCronJobStatus(t *testing.T) {
+	cronJob := &batch_v1.CronJob{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "test-cronjob", Namespace: TestNamespace, UID: "cj-uid"},
+		Spec:       batch_v1.CronJobSpec{Schedule: "*/5 * * * *"},
+	}
+	isController := true
+	newJob := func(name string, uid types.UID, created time.Time, cond batch_v1.JobConditionType) *batch_v1.Job {
+		return &batch_v1.Job{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:              name,
+				Namespace:         TestNamespace,
+				CreationTimestamp: meta_v1.NewTime(created),
+				OwnerReferences:   []meta_v1.OwnerReference{{Kind: "CronJob", Name: "test-cronjob", UID: uid, Controller: &isController}},
+			},
+			Status: batch_v1.JobStatus{Conditions: []batch_v1.JobCondition{{Type: cond, Status: core_v1.ConditionTrue}}},
+		}
+	}
+	now := time.Now()
+	older := newJob("test-cronjob-1", "cj-uid", now.Add(-10*time.Minute), batch_v1.JobComplete)
+	latest := newJob("test-cronjob-2", "cj-uid", now.Add(-5*time.Minute), batch_v1.JobFailed)
+	other := newJob("other-1", "other", now, batch_v1.JobComplete)
+	clientSet := fake.NewSimpleClientset(cronJob, latest, older, other)
+
+	s, err := getWorkloadStatus(context.TODO(), clientSet, "cronjob", TestNamespace, "test-cronjob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Failed || len(s.Details) != 2 || !strings.HasPrefix(s.Details[0], "Job test-cronjob-1: 就绪") {
+		t.Errorf("getWorkloadStatus(context.TODO()) failed = %v, details = %q", s.Failed, s.Details)
+	}
+
+	if _, err := getWorkloadStatus(context.TODO(), clientSet, "pvc", TestNamespace, "test-pvc"); errorKindOf(err) != ErrUsage {
+		t.Errorf("unsupported kind error = %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+)
+
+/*
+   解析 资源类型/名称 格式,如 deployment/test-nginx、svc/test-nginx、pod/test-nginx-xxx
+   pod不在resourceKinds中,单独处理
+*/
+func parseObjectRef(s string) (string, string, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", usageError("格式应为 资源类型/名称: %s", s)
+	}
+	kind := strings.ToLower(parts[0])
+	switch kind {
+	case "pod", "pods", "po":
+		return "pod", parts[1], nil
+	}
+	kind, _, err := parseKind([]string{kind})
+	return kind, parts[1], err
+}
+
+/*
+   获取工作负载或Service选中Pod的标签选择器
+*/
+func podSelectorOf(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (labels.Selector, error) {
+	var selector *meta_v1.LabelSelector
+	switch kind {
+	case "deployment":
+		obj, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Deployment", err)
+		}
+		selector = obj.Spec.Selector
+	case "statefulset":
+		obj, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取StatefulSet", err)
+		}
+		selector = obj.Spec.Selector
+	case "daemonset":
+		obj, err := clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取DaemonSet", err)
+		}
+		selector = obj.Spec.Selector
+	case "job":
+		obj, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Job", err)
+		}
+		selector = obj.Spec.Selector
+	case "service":
+		obj, err := clientSet.CoreV1().Services(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Service", err)
+		}
+		if len(obj.Spec.Selector) == 0 {
+			return nil, &clientError{Kind: ErrNotFound, Action: "解析Service selector", Err: fmt.Errorf("Service %s/%s 没有selector", namespace, name)}
+		}
+		return labels.SelectorFromSet(obj.Spec.Selector), nil
+	default:
+		return nil, usageError("%s 没有对应的Pod", kind)
+	}
+	s, err := meta_v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, &clientError{Kind: ErrInvalidManifest, Action: "解析" + kind + " selector", Err: err}
+	}
+	return s, nil
+}
+
+/*
+   查询目标对应Pod的ListOptions,pod按名称查询
+*/
+func podListOptions(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) (meta_v1.ListOptions, error) {
+	if kind == "pod" {
+		return meta_v1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}, nil
+	}
+	selector, err := podSelectorOf(ctx, clientSet, kind, namespace, name)
+	if err != nil {
+		return meta_v1.ListOptions{}, err
+	}
+	return meta_v1.ListOptions{LabelSelector: selector.String()}, nil
+}
+
+/*
+   获取目标对应的Pod,按名称排序
+*/
+func targetPods(ctx context.Context, clientSet kubernetes.Interface, kind, namespace, name string) ([]core_v1.Pod, error) {
+	if kind == "pod" {
+		pod, err := clientSet.CoreV1().Pods(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, wrapError("获取Pod", err)
+		}
+		return []core_v1.Pod{*pod}, nil
+	}
+	opts, err := podListOptions(ctx, clientSet, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	list, err := clientSet.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, wrapError("获取Pod列表", err)
+	}
+	pods := list.Items
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods, nil
+}